@@ -0,0 +1,346 @@
+package vial
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math/rand"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+)
+
+// AccessLogFormat is the format access log entries are written in.
+type AccessLogFormat int
+
+const (
+    _ AccessLogFormat = iota
+    // StructuredAccessLog logs each entry as extras with the access logger
+    // (the server's logger by default) in whatever format it uses (ex:
+    // JSON).
+    StructuredAccessLog
+    // CommonLogFormat writes each entry as a line in the Common Log Format.
+    CommonLogFormat
+    // CombinedLogFormat writes each entry as a line in the Combined Log
+    // Format (the Common Log Format plus the referer and user agent).
+    CombinedLogFormat
+)
+
+// clfTimeFormat is the timestamp format used by the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// DefaultAccessLogExclusions are the paths that aren't access logged unless
+// other exclusions are set.
+var DefaultAccessLogExclusions = []string{"/health"}
+
+// AccessLogEntry is the record of a single request.
+type AccessLogEntry struct {
+    Time time.Time
+    Method string
+    // Path is the path requested and Route is the path of the route it
+    // matched (ex: /users/<integer:id>), if it matched one.
+    Path,
+    Route,
+    Query,
+    Protocol string
+    Status int
+    Duration time.Duration
+    // Size is the number of bytes in the response body.
+    Size int64
+    RemoteAddr,
+    User,
+    UserAgent,
+    Referer,
+    SequenceId string
+}
+
+type accessLogOptions struct {
+    format AccessLogFormat
+    writer io.Writer
+    logger *logging.Logger
+    sampleRate float64
+    exclusions map[string]bool
+    random func() float64
+}
+
+// AccessLogOption is an option for EnableAccessLog.
+type AccessLogOption func(*accessLogOptions)
+
+// WithAccessLogFormat sets the format entries are written in, the default is
+// StructuredAccessLog.
+func WithAccessLogFormat(format AccessLogFormat) AccessLogOption {
+    return func(options *accessLogOptions) {
+        options.format = format
+    }
+}
+
+// WithAccessLogWriter sets where Common and Combined Log Format lines are
+// written, the default is stdout.
+func WithAccessLogWriter(writer io.Writer) AccessLogOption {
+    return func(options *accessLogOptions) {
+        options.writer = writer
+    }
+}
+
+// WithAccessLogLogger sets the logger structured entries are logged with,
+// the default is the server's logger.
+func WithAccessLogLogger(logger *logging.Logger) AccessLogOption {
+    return func(options *accessLogOptions) {
+        options.logger = logger
+    }
+}
+
+// WithAccessLogSampling only logs a fraction (between 0 and 1) of requests.
+// Server errors are always logged.
+func WithAccessLogSampling(rate float64) AccessLogOption {
+    return func(options *accessLogOptions) {
+        options.sampleRate = rate
+    }
+}
+
+// WithAccessLogExclusions sets the request or route paths that aren't
+// logged, replacing DefaultAccessLogExclusions.
+func WithAccessLogExclusions(paths ...string) AccessLogOption {
+    return func(options *accessLogOptions) {
+        options.exclusions = make(map[string]bool, len(paths))
+        for _, path := range paths {
+            options.exclusions[path] = true
+        }
+    }
+}
+
+// EnableAccessLog logs every request once it's been responded to with its
+// status, duration and response size.
+func EnableAccessLog(options ...AccessLogOption) ServerOption {
+    return func(svOpts *serverOptions) error {
+        accessLogOpts := &accessLogOptions{
+            format: StructuredAccessLog,
+            writer: os.Stdout,
+            sampleRate: 1,
+            random: rand.Float64,
+        }
+        WithAccessLogExclusions(DefaultAccessLogExclusions...)(accessLogOpts)
+        for _, option := range options {
+            option(accessLogOpts)
+        }
+        if accessLogOpts.sampleRate < 0 || accessLogOpts.sampleRate > 1 {
+            return errors.Errorf(
+                "Access log sample rate must be between 0 and 1, got %v",
+                accessLogOpts.sampleRate,
+            )
+        }
+
+        svOpts.accessLog = accessLogOpts
+        return nil
+    }
+}
+
+// accessLogWriter records the status and size of a response as it's
+// written. It keeps the wrapped writer's ability to flush, be hijacked and
+// read from readers.
+type accessLogWriter struct {
+    http.ResponseWriter
+    status int
+    size int64
+}
+
+func (self *accessLogWriter) WriteHeader(status int) {
+    if self.status == 0 {
+        self.status = status
+    }
+    self.ResponseWriter.WriteHeader(status)
+}
+
+func (self *accessLogWriter) Write(data []byte) (int, error) {
+    if self.status == 0 {
+        self.status = http.StatusOK
+    }
+    written, err := self.ResponseWriter.Write(data)
+    self.size += int64(written)
+
+    return written, err
+}
+
+// Flush flushes the wrapped writer if it can be.
+func (self *accessLogWriter) Flush() {
+    if flusher, ok := self.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+// Hijack hijacks the wrapped writer's connection if it can be. Anything
+// written to the connection afterwards isn't counted.
+func (self *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    hijacker, ok := self.ResponseWriter.(http.Hijacker)
+    if !ok {
+        return nil, nil, errors.New("ResponseWriter can't be hijacked")
+    }
+    if self.status == 0 {
+        self.status = http.StatusSwitchingProtocols
+    }
+
+    return hijacker.Hijack()
+}
+
+// ReadFrom lets the wrapped writer read from the reader directly (ex: to use
+// sendfile) if it can.
+func (self *accessLogWriter) ReadFrom(reader io.Reader) (int64, error) {
+    if self.status == 0 {
+        self.status = http.StatusOK
+    }
+    var (
+        read int64
+        err error
+    )
+    if readerFrom, ok := self.ResponseWriter.(io.ReaderFrom); ok {
+        read, err = readerFrom.ReadFrom(reader)
+    } else {
+        // NOTE: Hiding our ReadFrom so io.Copy doesn't call back into it.
+        read, err = io.Copy(struct{ io.Writer }{self.ResponseWriter}, reader)
+    }
+    self.size += read
+
+    return read, err
+}
+
+// accessLogger writes access log entries.
+type accessLogger struct {
+    options *accessLogOptions
+    // mutex keeps lines from interleaving in the writer.
+    mutex sync.Mutex
+}
+
+func (self *accessLogger) excluded(entry AccessLogEntry) bool {
+    return self.options.exclusions[entry.Path] ||
+        (entry.Route != "" && self.options.exclusions[entry.Route])
+}
+
+func (self *accessLogger) sampled(entry AccessLogEntry) bool {
+    return entry.Status >= http.StatusInternalServerError ||
+        self.options.sampleRate >= 1 ||
+        self.options.random() < self.options.sampleRate
+}
+
+// orDash replaces empty Common Log Format fields with a dash.
+func orDash(value string) string {
+    if value == "" {
+        return "-"
+    }
+
+    return value
+}
+
+// commonLogLine formats the entry in the Common Log Format (or the Combined
+// Log Format if combined).
+func commonLogLine(entry AccessLogEntry, combined bool) string {
+    host := entry.RemoteAddr
+    if splitHost, _, err := net.SplitHostPort(host); err == nil {
+        host = splitHost
+    }
+    target := entry.Path
+    if entry.Query != "" {
+        target += "?" + entry.Query
+    }
+    size := "-"
+    if entry.Size != 0 {
+        size = strconv.FormatInt(entry.Size, 10)
+    }
+
+    line := fmt.Sprintf(
+        "%s - %s [%s] %q %d %s",
+        orDash(host),
+        orDash(entry.User),
+        entry.Time.Format(clfTimeFormat),
+        entry.Method + " " + target + " " + entry.Protocol,
+        entry.Status,
+        size,
+    )
+    if combined {
+        line += fmt.Sprintf(
+            " %q %q", orDash(entry.Referer), orDash(entry.UserAgent),
+        )
+    }
+
+    return line + "\n"
+}
+
+// log writes the entry if it isn't excluded and is sampled.
+func (self *accessLogger) log(fallback *logging.Logger, entry AccessLogEntry) {
+    if self.excluded(entry) || !self.sampled(entry) {
+        return
+    }
+
+    switch self.options.format {
+    case CommonLogFormat, CombinedLogFormat:
+        line := commonLogLine(
+            entry, self.options.format == CombinedLogFormat,
+        )
+        self.mutex.Lock()
+        defer self.mutex.Unlock()
+        _, err := io.WriteString(self.options.writer, line)
+        if err != nil {
+            fallback.Exception(err, "Error while writing access log.")
+        }
+    default:
+        logger := self.options.logger
+        if logger == nil {
+            logger = fallback
+        }
+        logger.Info("HTTP request handled.", logging.Extras{
+            "method": entry.Method,
+            "path": entry.Path,
+            "route": entry.Route,
+            "protocol": entry.Protocol,
+            "status": entry.Status,
+            "duration_ms": float64(entry.Duration) / float64(
+                time.Millisecond,
+            ),
+            "size": entry.Size,
+            "remote_addr": entry.RemoteAddr,
+            "user": entry.User,
+            "user_agent": entry.UserAgent,
+            "referer": entry.Referer,
+            "sequence_id": entry.SequenceId,
+        })
+    }
+}
+
+// logAccess logs the request once it's been responded to.
+func (self *Server) logAccess(
+    r *http.Request,
+    writer *accessLogWriter,
+    state *requestState,
+    start time.Time,
+    sequenceId string,
+) {
+    status := writer.status
+    if status == 0 {
+        status = http.StatusOK
+    }
+    entry := AccessLogEntry{
+        Time: start,
+        Method: r.Method,
+        Path: r.URL.Path,
+        Route: state.route,
+        Query: r.URL.RawQuery,
+        Protocol: r.Proto,
+        Status: status,
+        Duration: time.Since(start),
+        Size: writer.size,
+        RemoteAddr: r.RemoteAddr,
+        UserAgent: r.UserAgent(),
+        Referer: r.Referer(),
+        SequenceId: sequenceId,
+    }
+    if principal := state.requestPrincipal(); principal != nil {
+        entry.User = principal.Id
+    }
+
+    self.accessLog.log(self.Logger, entry)
+}
@@ -0,0 +1,239 @@
+package vial
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "math/rand"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/daihasso/slogging"
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+)
+
+func newAccessLogServer(
+    t *testing.T, g *gm.GomegaWithT, options ...AccessLogOption,
+) *Server {
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddDefaultHealthRoute(),
+        EnableAccessLog(options...),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/users/<integer:id>",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(
+                http.StatusOK,
+                responses.Body(map[string]string{"name": "alice"}),
+            )
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/broken",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(http.StatusBadGateway)
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/events",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.EventStream(func(
+                ctx context.Context, stream *EventStream,
+            ) error {
+                return stream.Send(Event{Data: "hi"})
+            })
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func accessLogRequest(server *Server, path string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("GET", path, nil)
+    req.RemoteAddr = "192.0.2.1:1234"
+    req.Header.Set("User-Agent", "tester/1.0")
+    req.Header.Set("Referer", "https://example.com/")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func TestAccessLogCombinedFormat(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    buffer := &bytes.Buffer{}
+    server := newAccessLogServer(
+        t, g,
+        WithAccessLogFormat(CombinedLogFormat),
+        WithAccessLogWriter(buffer),
+    )
+
+    rr := accessLogRequest(server, "/users/1?expand=true")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(buffer.String()).To(gm.MatchRegexp(
+        `^192\.0\.2\.1 - - \[[^\]]+\] "GET /users/1\?expand=true HTTP/1\.1" ` +
+            `200 16 "https://example.com/" "tester/1.0"\n$`,
+    ))
+
+    buffer.Reset()
+    accessLogRequest(server, "/users/alice")
+    g.Expect(buffer.String()).To(gm.ContainSubstring(`" 404 `))
+
+    buffer.Reset()
+    accessLogRequest(server, "/health")
+    g.Expect(buffer.String()).To(gm.BeEmpty())
+}
+
+func TestAccessLogStructured(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    buffer := &bytes.Buffer{}
+    logger, err := logging.NewLogger(
+        "access" + strconv.Itoa(rand.Int()), //#nosec G404
+        logging.WithFormat(logging.JSON),
+        logging.WithLogWriters(buffer),
+    )
+    g.Expect(err).To(gm.BeNil())
+    server := newAccessLogServer(t, g, WithAccessLogLogger(logger))
+
+    rr := accessLogRequest(server, "/users/7")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+
+    var entry map[string]interface{}
+    g.Expect(json.Unmarshal(buffer.Bytes(), &entry)).To(gm.BeNil())
+    g.Expect(entry["method"]).To(gm.Equal("GET"))
+    g.Expect(entry["path"]).To(gm.Equal("/users/7"))
+    g.Expect(entry["route"]).To(gm.Equal("/users/<integer:id>"))
+    g.Expect(entry["status"]).To(gm.Equal(float64(200)))
+    g.Expect(entry["size"]).To(gm.Equal(float64(16)))
+    g.Expect(entry["remote_addr"]).To(gm.Equal("192.0.2.1:1234"))
+    g.Expect(entry["user_agent"]).To(gm.Equal("tester/1.0"))
+    g.Expect(entry["sequence_id"]).To(
+        gm.Equal(rr.Header().Get(SequenceIdHeader)),
+    )
+    g.Expect(entry).To(gm.HaveKey("duration_ms"))
+}
+
+func TestAccessLogSamplingAndExclusions(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    buffer := &bytes.Buffer{}
+    server := newAccessLogServer(
+        t, g,
+        WithAccessLogFormat(CommonLogFormat),
+        WithAccessLogWriter(buffer),
+        WithAccessLogSampling(0.5),
+        WithAccessLogExclusions("/users/<integer:id>"),
+    )
+    random := 0.1
+    server.accessLog.options.random = func() float64 { return random }
+
+    accessLogRequest(server, "/health")
+    g.Expect(buffer.String()).To(gm.ContainSubstring("/health"))
+
+    buffer.Reset()
+    accessLogRequest(server, "/users/1")
+    g.Expect(buffer.String()).To(gm.BeEmpty())
+
+    random = 0.9
+    accessLogRequest(server, "/health")
+    g.Expect(buffer.String()).To(gm.BeEmpty())
+
+    // Server errors are always logged.
+    accessLogRequest(server, "/broken")
+    g.Expect(buffer.String()).To(
+        gm.ContainSubstring(`"GET /broken HTTP/1.1" 502`),
+    )
+
+    _, err := NewServer(EnableAccessLog(WithAccessLogSampling(2)))
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestAccessLogStreamedResponse(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    buffer := &bytes.Buffer{}
+    server := newAccessLogServer(
+        t, g,
+        WithAccessLogFormat(CommonLogFormat),
+        WithAccessLogWriter(buffer),
+    )
+
+    rr := accessLogRequest(server, "/events")
+    g.Expect(rr.Flushed).To(gm.BeTrue())
+    g.Expect(rr.Body.String()).To(gm.Equal("data: hi\n\n"))
+    g.Expect(strings.TrimSpace(buffer.String())).To(
+        gm.HaveSuffix(`" 200 10`),
+    )
+
+    writer := &accessLogWriter{ResponseWriter: httptest.NewRecorder()}
+    _, _, err := writer.Hijack()
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestAccessLogTimedOutRequest(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    buffer := &bytes.Buffer{}
+    logger, err := logging.NewLogger(
+        "access" + strconv.Itoa(rand.Int()), //#nosec G404
+        logging.WithFormat(logging.JSON),
+        logging.WithLogLevel(logging.INFO),
+        logging.WithLogWriters(buffer),
+    )
+    g.Expect(err).To(gm.BeNil())
+    server, err := NewServer(
+        AddCustomLogger(logger),
+        AddAuthenticator("apiKey", APIKeyAuthenticator{
+            Header: "X-API-Key",
+            Lookup: lookupTestAPIKey,
+        }),
+        EnableAccessLog(),
+    )
+    g.Expect(err).To(gm.BeNil())
+    release := make(chan struct{})
+    defer close(release)
+    err = server.AddController(
+        "/late",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            <-release
+            return transactor.Respond(http.StatusNoContent)
+        }),
+        WithTimeout(20 * time.Millisecond),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := authRequest(server, "/late", map[string]string{
+        "X-API-Key": "admin-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+
+    // Only the access log line is written at INFO and it has the principal
+    // even though the controller is still running.
+    var messages []string
+    var handled map[string]interface{}
+    decoder := json.NewDecoder(buffer)
+    for decoder.More() {
+        var entry map[string]interface{}
+        g.Expect(decoder.Decode(&entry)).To(gm.BeNil())
+        if entry["log_level"] == "INFO" {
+            messages = append(messages, entry["message"].(string))
+            handled = entry
+        }
+    }
+    g.Expect(messages).To(gm.Equal([]string{"HTTP request handled."}))
+    g.Expect(handled["user"]).To(gm.Equal("admin"))
+    g.Expect(handled["status"]).To(gm.Equal(float64(503)))
+}
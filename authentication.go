@@ -0,0 +1,373 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "sort"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// This is the key that the authenticated Principal is stored under in the
+// context.
+var PrincipalContextKey = ContextKey("principal")
+
+// Principal is the identity a request was authenticated as.
+type Principal struct {
+    // Id identifies the requestor (ex: a user ID or API key name).
+    Id string
+    // Scheme is the name the Authenticator that authenticated the request was
+    // registered with.
+    Scheme string
+    Roles []string
+    Scopes []string
+    // Attributes are any other information the Authenticator has about the
+    // requestor (ex: a token's claims).
+    Attributes map[string]interface{}
+}
+
+func containsAll(values []string, required ...string) bool {
+    present := make(map[string]bool, len(values))
+    for _, value := range values {
+        present[value] = true
+    }
+    for _, value := range required {
+        if !present[value] {
+            return false
+        }
+    }
+
+    return true
+}
+
+// HasRoles checks if the principal has all of the provided roles.
+func (self Principal) HasRoles(roles ...string) bool {
+    return containsAll(self.Roles, roles...)
+}
+
+// HasScopes checks if the principal has all of the provided scopes.
+func (self Principal) HasScopes(scopes ...string) bool {
+    return containsAll(self.Scopes, scopes...)
+}
+
+// Authenticator authenticates requests. Authenticate should return a nil
+// Principal (and no error) if the request doesn't have credentials for it and
+// an error if the request has credentials that aren't valid.
+// Returning a CodedError responds with it, any other error is responded to
+// with a neterr.InvalidCredentialsError.
+type Authenticator interface {
+    Authenticate(*Transactor) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function into an Authenticator.
+type AuthenticatorFunc func(*Transactor) (*Principal, error)
+
+// Authenticate calls the function.
+func (self AuthenticatorFunc) Authenticate(
+    transactor *Transactor,
+) (*Principal, error) {
+    return self(transactor)
+}
+
+// AuthenticationChallenger is implemented by Authenticators that send a
+// WWW-Authenticate challenge with 401s. The error is the one returned from
+// Authenticate (if the request had invalid credentials).
+type AuthenticationChallenger interface {
+    Challenge(err error) string
+}
+
+// SecuritySchemer is implemented by Authenticators that can describe
+// themselves as an OpenAPI security scheme object.
+type SecuritySchemer interface {
+    SecurityScheme() map[string]interface{}
+}
+
+// SecurityRequirement is an OpenAPI security requirement object mapping a
+// security scheme to the scopes (or roles) required.
+type SecurityRequirement map[string][]string
+
+type namedAuthenticator struct {
+    name string
+    authenticator Authenticator
+}
+
+// authenticationResult is the outcome of trying each Authenticator.
+type authenticationResult struct {
+    principal *Principal
+    // errs are the errors returned by each authenticator that rejected the
+    // request's credentials.
+    errs map[string]error
+}
+
+// authorizationRequirements are the requirements a route declared with
+// RequireAuthentication, RequireRoles or RequireScopes.
+type authorizationRequirements struct {
+    schemes []string
+    roles []string
+    scopes []string
+}
+
+func (self *routeOptions) requireAuthorization() *authorizationRequirements {
+    if self.authorization == nil {
+        self.authorization = &authorizationRequirements{}
+    }
+
+    return self.authorization
+}
+
+// RequireAuthentication requires requests to the route to be authenticated.
+// If schemes are provided only the Authenticators registered with those
+// names are accepted.
+func RequireAuthentication(schemes ...string) RouteOption {
+    return func(options *routeOptions) {
+        requirements := options.requireAuthorization()
+        requirements.schemes = append(requirements.schemes, schemes...)
+    }
+}
+
+// RequireRoles requires requests to the route to be authenticated as a
+// Principal with all of the provided roles.
+func RequireRoles(roles ...string) RouteOption {
+    return func(options *routeOptions) {
+        requirements := options.requireAuthorization()
+        requirements.roles = append(requirements.roles, roles...)
+    }
+}
+
+// RequireScopes requires requests to the route to be authenticated as a
+// Principal with all of the provided scopes.
+func RequireScopes(scopes ...string) RouteOption {
+    return func(options *routeOptions) {
+        requirements := options.requireAuthorization()
+        requirements.scopes = append(requirements.scopes, scopes...)
+    }
+}
+
+// ContextPrincipal retrieves the authenticated Principal stored in the
+// provided context if there is one.
+func ContextPrincipal(ctx context.Context) (*Principal, bool) {
+    principal, ok := ctx.Value(PrincipalContextKey).(*Principal)
+    return principal, ok
+}
+
+// Principal gets the Principal the request was authenticated as. It's nil if
+// the request wasn't authenticated.
+func (self Transactor) Principal() *Principal {
+    principal, _ := ContextPrincipal(self.Request.Context())
+    return principal
+}
+
+// acceptsScheme checks if an Authenticator registered with the name provided
+// is accepted, no schemes means every Authenticator is.
+func acceptsScheme(schemes []string, name string) bool {
+    return len(schemes) == 0 || containsAll(schemes, name)
+}
+
+// authenticate tries each of the server's Authenticators for the accepted
+// schemes in the order they were registered until one authenticates the
+// request.
+func (self Server) authenticate(
+    transactor *Transactor, schemes []string,
+) authenticationResult {
+    result := authenticationResult{errs: make(map[string]error)}
+    for _, named := range self.authenticators {
+        if !acceptsScheme(schemes, named.name) {
+            continue
+        }
+        principal, err := named.authenticator.Authenticate(transactor)
+        if err != nil {
+            transactor.Logger.Debug(
+                "Rejected credentials.",
+                logging.Extras{
+                    "scheme": named.name,
+                    "reason": err.Error(),
+                },
+            )
+            result.errs[named.name] = err
+            continue
+        }
+        if principal != nil {
+            principal.Scheme = named.name
+            result.principal = principal
+            break
+        }
+    }
+
+    return result
+}
+
+// unauthorized responds with a 401 and a challenge from each acceptable
+// Authenticator.
+func (self Server) unauthorized(
+    transactor *Transactor,
+    requirements *authorizationRequirements,
+    result authenticationResult,
+) responses.Data {
+    codedError := neterr.UnauthorizedError
+    for _, named := range self.authenticators {
+        if !acceptsScheme(requirements.schemes, named.name) {
+            continue
+        }
+        err := result.errs[named.name]
+        if err != nil {
+            if coded, ok := neterr.AsCodedError(err); ok {
+                codedError = coded
+            } else if codedError.Code() == neterr.UnauthorizedError.Code() {
+                codedError = neterr.InvalidCredentialsError
+            }
+        }
+        challenger, ok := named.authenticator.(AuthenticationChallenger)
+        if ok {
+            transactor.AddHeader(
+                WWWAuthenticateHeader, challenger.Challenge(err),
+            )
+        }
+    }
+
+    return transactor.Abort(http.StatusUnauthorized, codedError)
+}
+
+// authenticationMiddleware authenticates requests with the server's
+// Authenticators, storing the Principal in the context, and enforces the
+// route's requirements (if any).
+func (self Server) authenticationMiddleware(
+    requirements *authorizationRequirements,
+) Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            var schemes []string
+            if requirements != nil {
+                schemes = requirements.schemes
+            }
+            result := self.authenticate(transactor, schemes)
+            // NOTE: Authenticators may have changed the context.
+            ctx = transactor.Context()
+            if result.principal != nil {
+                ctx = context.WithValue(
+                    ctx, PrincipalContextKey, result.principal,
+                )
+                transactor.ChangeContext(ctx)
+                setRequestPrincipal(ctx, result.principal)
+            }
+
+            if requirements == nil {
+                return next(ctx, transactor)
+            }
+
+            principal := result.principal
+            if principal == nil {
+                return self.unauthorized(transactor, requirements, result)
+            }
+            if !principal.HasRoles(requirements.roles...) {
+                return transactor.Abort(
+                    http.StatusForbidden,
+                    neterr.ForbiddenError.WithDetail(
+                        "required_roles", requirements.roles,
+                    ),
+                )
+            }
+            if !principal.HasScopes(requirements.scopes...) {
+                return transactor.Abort(
+                    http.StatusForbidden,
+                    neterr.ForbiddenError.WithDetail(
+                        "required_scopes", requirements.scopes,
+                    ),
+                )
+            }
+
+            return next(ctx, transactor)
+        }
+    }
+}
+
+// checkAuthorizationRequirements makes sure a route's requirements can be
+// met by the server's Authenticators.
+func (self Server) checkAuthorizationRequirements(
+    requirements *authorizationRequirements,
+) error {
+    if requirements == nil {
+        return nil
+    }
+    if len(self.authenticators) == 0 {
+        return errors.New(
+            "Route requires authentication but the server has no " +
+                "Authenticators",
+        )
+    }
+    for _, scheme := range requirements.schemes {
+        found := false
+        for _, named := range self.authenticators {
+            found = found || named.name == scheme
+        }
+        if !found {
+            return errors.Errorf(
+                "Route requires unknown authentication scheme '%s'", scheme,
+            )
+        }
+    }
+
+    return nil
+}
+
+// OpenAPISecuritySchemes generates the OpenAPI security scheme objects
+// (components.securitySchemes) for the server's Authenticators that can
+// describe themselves.
+func (self Server) OpenAPISecuritySchemes() map[string]interface{} {
+    schemes := make(map[string]interface{})
+    for _, named := range self.authenticators {
+        if schemer, ok := named.authenticator.(SecuritySchemer); ok {
+            schemes[named.name] = schemer.SecurityScheme()
+        }
+    }
+
+    return schemes
+}
+
+// OpenAPISecurityRequirements generates the OpenAPI security requirements
+// for each route that requires authentication keyed by the route's path in
+// OpenAPI form (ex: /users/{id}). Each requirement lists the scopes and
+// roles the route requires.
+func (self Server) OpenAPISecurityRequirements(
+) map[string][]SecurityRequirement {
+    requirements := make(map[string][]SecurityRequirement)
+    var bases []string
+    for base := range self.pathRouteControllerHelpers {
+        bases = append(bases, base)
+    }
+    sort.Strings(bases)
+
+    for _, base := range bases {
+        for _, rch := range self.pathRouteControllerHelpers[base] {
+            routeRequirements := rch.options.authorization
+            if routeRequirements == nil {
+                continue
+            }
+            schemes := routeRequirements.schemes
+            if len(schemes) == 0 {
+                for _, named := range self.authenticators {
+                    schemes = append(schemes, named.name)
+                }
+            }
+            needed := append(
+                append([]string{}, routeRequirements.scopes...),
+                routeRequirements.roles...,
+            )
+
+            var alternatives []SecurityRequirement
+            for _, scheme := range schemes {
+                alternatives = append(
+                    alternatives, SecurityRequirement{scheme: needed},
+                )
+            }
+            requirements[rch.route.OpenAPIPath()] = alternatives
+        }
+    }
+
+    return requirements
+}
@@ -0,0 +1,337 @@
+package vial
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/jwt"
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+var testAPIKeys = map[string]*Principal{
+    "admin-key": {Id: "admin", Roles: []string{"admin"}},
+    "reader-key": {Id: "reader", Scopes: []string{"read"}},
+}
+
+func lookupTestAPIKey(key string) (*Principal, error) {
+    principal, ok := testAPIKeys[key]
+    if !ok {
+        return nil, nil
+    }
+    copied := *principal
+    return &copied, nil
+}
+
+func validateTestBasic(username, password string) (*Principal, error) {
+    if username == "alice" && password == "wonderland" {
+        return &Principal{Id: "alice", Roles: []string{"admin"}}, nil
+    }
+    return nil, nil
+}
+
+func principalController(transactor *Transactor) responses.Data {
+    principal := transactor.Principal()
+    if principal == nil {
+        return transactor.Respond(
+            http.StatusOK, responses.Body(map[string]string{}),
+        )
+    }
+    return transactor.Respond(
+        http.StatusOK,
+        responses.Body(map[string]string{
+            "id": principal.Id,
+            "scheme": principal.Scheme,
+        }),
+    )
+}
+
+func newAuthServer(t *testing.T, g *gm.GomegaWithT) *Server {
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddAuthenticator("apiKey", APIKeyAuthenticator{
+            Header: "X-API-Key",
+            QueryParameter: "api_key",
+            Lookup: lookupTestAPIKey,
+        }),
+        AddAuthenticator("basic", BasicAuthenticator{
+            Realm: "vial",
+            Validate: validateTestBasic,
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    err = server.AddController(
+        "/public", FuncHandler("get", principalController),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/admin/<integer:id>",
+        FuncHandler("get", principalController),
+        RequireRoles("admin"),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/documents",
+        FuncHandler("get", principalController),
+        RequireScopes("read"),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/basic-only",
+        FuncHandler("get", principalController),
+        RequireAuthentication("basic"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func authRequest(
+    server *Server, path string, headers map[string]string,
+) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("GET", path, nil)
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func TestAuthenticationRequired(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newAuthServer(t, g)
+
+    rr := authRequest(server, "/admin/1", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    challenges := rr.Header()[http.CanonicalHeaderKey(WWWAuthenticateHeader)]
+    g.Expect(challenges).To(gm.Equal(
+        []string{`Basic realm="vial", charset="UTF-8"`},
+    ))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.UnauthorizedError.Code()),
+    )
+
+    rr = authRequest(server, "/admin/1", map[string]string{
+        "X-API-Key": "unknown",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.InvalidCredentialsError.Code()),
+    )
+
+    rr = authRequest(server, "/admin/1", map[string]string{
+        "X-API-Key": "admin-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(
+        gm.Equal(`{"id":"admin","scheme":"apiKey"}`),
+    )
+
+    rr = authRequest(server, "/admin/1?api_key=admin-key", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+}
+
+func TestAuthorizationForbidden(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newAuthServer(t, g)
+
+    rr := authRequest(server, "/admin/1", map[string]string{
+        "X-API-Key": "reader-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusForbidden))
+    g.Expect(jwtErrorCode(g, rr)).To(gm.Equal(neterr.ForbiddenError.Code()))
+
+    rr = authRequest(server, "/documents", map[string]string{
+        "X-API-Key": "reader-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+
+    rr = authRequest(server, "/documents", map[string]string{
+        "X-API-Key": "admin-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusForbidden))
+}
+
+func TestAuthenticationSchemes(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newAuthServer(t, g)
+
+    rr := authRequest(server, "/basic-only", map[string]string{
+        "X-API-Key": "admin-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+
+    req, _ := http.NewRequest("GET", "/basic-only", nil)
+    req.SetBasicAuth("alice", "wonderland")
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(
+        gm.Equal(`{"id":"alice","scheme":"basic"}`),
+    )
+}
+
+func TestAuthenticationOptional(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newAuthServer(t, g)
+
+    rr := authRequest(server, "/public", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal(`{}`))
+
+    rr = authRequest(server, "/public", map[string]string{
+        "X-API-Key": "unknown",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal(`{}`))
+
+    rr = authRequest(server, "/public", map[string]string{
+        "X-API-Key": "reader-key",
+    })
+    g.Expect(rr.Body.String()).To(
+        gm.Equal(`{"id":"reader","scheme":"apiKey"}`),
+    )
+}
+
+func TestAuthenticationRequirementsValidated(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/admin", FuncHandler("get", principalController), RequireRoles("a"),
+    )
+    g.Expect(err).ToNot(gm.BeNil())
+
+    server = newAuthServer(t, g)
+    err = server.AddController(
+        "/other",
+        FuncHandler("get", principalController),
+        RequireAuthentication("unknown"),
+    )
+    g.Expect(err).ToNot(gm.BeNil())
+
+    _, err = NewServer(
+        AddAuthenticator("dupe", BasicAuthenticator{}),
+        AddAuthenticator("dupe", BasicAuthenticator{}),
+    )
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestJwtAuthenticator(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    verifier, err := jwt.NewVerifier(jwt.WithHmacKey([]byte(testJwtSecret)))
+    g.Expect(err).To(gm.BeNil())
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddAuthenticator("bearer", JwtAuthenticator{Verifier: verifier}),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/me",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            g.Expect(transactor.JwtClaims().Subject()).To(gm.Equal("user-1"))
+            return principalController(transactor)
+        }),
+        RequireRoles("admin"),
+        RequireScopes("profile:read"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := jwtRequest(server, signTestJwt(g, jwt.Claims{
+        jwt.SubjectClaim: "user-1",
+        jwt.ScopeClaim: "profile:read",
+        JwtRolesClaim: []string{"admin"},
+    }))
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(
+        gm.Equal(`{"id":"user-1","scheme":"bearer"}`),
+    )
+
+    rr = jwtRequest(server, "not-a-token")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    g.Expect(rr.Header().Get(WWWAuthenticateHeader)).To(
+        gm.Equal(`Bearer error="invalid_token"`),
+    )
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.InvalidTokenError.Code()),
+    )
+}
+
+func TestAuthenticationSchemesMultipleCredentials(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    verifier, err := jwt.NewVerifier(jwt.WithHmacKey([]byte(testJwtSecret)))
+    g.Expect(err).To(gm.BeNil())
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddAuthenticator("apiKey", APIKeyAuthenticator{
+            Header: "X-API-Key",
+            Lookup: lookupTestAPIKey,
+        }),
+        AddAuthenticator("bearer", JwtAuthenticator{Verifier: verifier}),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/bearer-only",
+        FuncHandler("get", principalController),
+        RequireAuthentication("bearer"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    token := signTestJwt(g, jwt.Claims{jwt.SubjectClaim: "user-1"})
+    rr := authRequest(server, "/bearer-only", map[string]string{
+        "X-API-Key": "admin-key",
+        "Authorization": "Bearer " + token,
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(
+        gm.Equal(`{"id":"user-1","scheme":"bearer"}`),
+    )
+}
+
+func TestOpenAPISecurity(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newAuthServer(t, g)
+
+    g.Expect(server.OpenAPISecuritySchemes()).To(gm.Equal(
+        map[string]interface{}{
+            "apiKey": map[string]interface{}{
+                "type": "apiKey",
+                "in": "header",
+                "name": "X-API-Key",
+            },
+            "basic": map[string]interface{}{
+                "type": "http",
+                "scheme": "basic",
+            },
+        },
+    ))
+    g.Expect(server.OpenAPISecurityRequirements()).To(gm.Equal(
+        map[string][]SecurityRequirement{
+            "/admin/{id}": {
+                {"apiKey": {"admin"}},
+                {"basic": {"admin"}},
+            },
+            "/documents": {
+                {"apiKey": {"read"}},
+                {"basic": {"read"}},
+            },
+            "/basic-only": {
+                {"basic": {}},
+            },
+        },
+    ))
+}
@@ -0,0 +1,115 @@
+package vial
+
+import (
+    "fmt"
+
+    "github.com/daihasso/vial/neterr"
+)
+
+// APIKeyLookup finds the Principal for an API key. It should return a nil
+// Principal (and no error) for keys it doesn't know about.
+type APIKeyLookup func(key string) (*Principal, error)
+
+// APIKeyAuthenticator authenticates requests with an API key sent in a header
+// or query parameter.
+type APIKeyAuthenticator struct {
+    // Header is the header the key is sent in (ex: X-API-Key).
+    Header string
+    // QueryParameter is the query parameter the key is sent in when it's not
+    // in the Header.
+    QueryParameter string
+    Lookup APIKeyLookup
+}
+
+// Authenticate looks up the key sent with the request.
+func (self APIKeyAuthenticator) Authenticate(
+    transactor *Transactor,
+) (*Principal, error) {
+    var key string
+    if self.Header != "" {
+        key = transactor.Request.Header.Get(self.Header)
+    }
+    if key == "" && self.QueryParameter != "" {
+        key = transactor.Request.URL.Query().Get(self.QueryParameter)
+    }
+    if key == "" {
+        return nil, nil
+    }
+
+    principal, err := self.Lookup(key)
+    if err != nil {
+        return nil, err
+    }
+    if principal == nil {
+        return nil, neterr.InvalidCredentialsError
+    }
+
+    return principal, nil
+}
+
+// SecurityScheme describes the API key as an OpenAPI apiKey scheme.
+func (self APIKeyAuthenticator) SecurityScheme() map[string]interface{} {
+    if self.Header == "" {
+        return map[string]interface{}{
+            "type": "apiKey",
+            "in": "query",
+            "name": self.QueryParameter,
+        }
+    }
+
+    return map[string]interface{}{
+        "type": "apiKey",
+        "in": "header",
+        "name": self.Header,
+    }
+}
+
+// BasicCredentialsValidator checks a username and password and returns the
+// Principal for them. It should return a nil Principal (and no error) if
+// they aren't valid.
+type BasicCredentialsValidator func(username, password string) (
+    *Principal, error,
+)
+
+// BasicAuthenticator authenticates requests with HTTP Basic authentication.
+type BasicAuthenticator struct {
+    Realm string
+    Validate BasicCredentialsValidator
+}
+
+// Authenticate validates the username and password sent with the request.
+func (self BasicAuthenticator) Authenticate(
+    transactor *Transactor,
+) (*Principal, error) {
+    if transactor.Request.Header.Get("Authorization") == "" {
+        return nil, nil
+    }
+    username, password, ok := transactor.Request.BasicAuth()
+    if !ok {
+        // NOTE: Another scheme's credentials (ex: a bearer token).
+        return nil, nil
+    }
+
+    principal, err := self.Validate(username, password)
+    if err != nil {
+        return nil, err
+    }
+    if principal == nil {
+        return nil, neterr.InvalidCredentialsError
+    }
+
+    return principal, nil
+}
+
+// Challenge asks for Basic credentials for the realm.
+func (self BasicAuthenticator) Challenge(err error) string {
+    return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, self.Realm)
+}
+
+// SecurityScheme describes HTTP Basic authentication as an OpenAPI scheme.
+func (self BasicAuthenticator) SecurityScheme() map[string]interface{} {
+    return map[string]interface{}{
+        "type": "http",
+        "scheme": "basic",
+    }
+}
@@ -0,0 +1,496 @@
+package vial
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "io"
+    "mime"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/pkg/errors"
+    "github.com/daihasso/slogging"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// ContentEncodingHeader is the header describing how a body is compressed.
+const ContentEncodingHeader = "Content-Encoding"
+
+// DefaultCompressionMinimumSize is the smallest body (in bytes) that's
+// compressed unless a minimum size is configured.
+var DefaultCompressionMinimumSize = 1024
+
+// DefaultMaxDecompressedSize is the largest a decompressed request body may
+// be unless a maximum is configured.
+var DefaultMaxDecompressedSize int64 = 32 << 20
+
+// DefaultCompressibleContentTypes are the content types that are compressed
+// unless a list is configured. A "type/*" entry matches any subtype.
+var DefaultCompressibleContentTypes = []string{
+    "text/*",
+    "application/json",
+    "application/problem+json",
+    "application/xml",
+    "application/yaml",
+    "application/javascript",
+    "image/svg+xml",
+}
+
+// Compressor creates a writer which compresses everything written to it at
+// the provided level. The level follows the compress/flate levels.
+type Compressor func(w io.Writer, level int) (io.WriteCloser, error)
+
+// Decompressor creates a reader which decompresses the provided reader.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var (
+    compressionLock sync.RWMutex
+    // compressorPreference is the content-codings in order of preference.
+    compressorPreference []string
+    compressors = make(map[string]Compressor)
+    decompressors = make(map[string]Decompressor)
+)
+
+// RegisterCompressor registers a Compressor for a content-coding (ex: "br").
+// Later registrations are preferred over earlier ones when the requestor
+// accepts both equally.
+func RegisterCompressor(coding string, compressor Compressor) {
+    compressionLock.Lock()
+    defer compressionLock.Unlock()
+
+    coding = strings.ToLower(coding)
+    preference := []string{coding}
+    for _, existing := range compressorPreference {
+        if existing != coding {
+            preference = append(preference, existing)
+        }
+    }
+    compressorPreference = preference
+    compressors[coding] = compressor
+}
+
+// RegisterDecompressor registers a Decompressor for request bodies with the
+// provided content-coding.
+func RegisterDecompressor(coding string, decompressor Decompressor) {
+    compressionLock.Lock()
+    defer compressionLock.Unlock()
+
+    decompressors[strings.ToLower(coding)] = decompressor
+}
+
+func compressorFor(coding string) (Compressor, bool) {
+    compressionLock.RLock()
+    defer compressionLock.RUnlock()
+
+    compressor, ok := compressors[coding]
+    return compressor, ok
+}
+
+func decompressorFor(coding string) (Decompressor, bool) {
+    compressionLock.RLock()
+    defer compressionLock.RUnlock()
+
+    decompressor, ok := decompressors[strings.ToLower(coding)]
+    return decompressor, ok
+}
+
+func supportedDecompressors() []string {
+    compressionLock.RLock()
+    defer compressionLock.RUnlock()
+
+    var codings []string
+    for coding := range decompressors {
+        codings = append(codings, coding)
+    }
+    sort.Strings(codings)
+
+    return codings
+}
+
+func init() {
+    RegisterCompressor("deflate", func(
+        w io.Writer, level int,
+    ) (io.WriteCloser, error) {
+        return zlib.NewWriterLevel(w, level)
+    })
+    RegisterCompressor("gzip", func(
+        w io.Writer, level int,
+    ) (io.WriteCloser, error) {
+        return gzip.NewWriterLevel(w, level)
+    })
+
+    gunzip := func(r io.Reader) (io.ReadCloser, error) {
+        return gzip.NewReader(r)
+    }
+    RegisterDecompressor("gzip", gunzip)
+    RegisterDecompressor("x-gzip", gunzip)
+    RegisterDecompressor("deflate", zlib.NewReader)
+}
+
+// negotiateContentCoding picks the most preferred registered content-coding
+// that the Accept-Encoding header allows. An empty string means the body
+// shouldn't be compressed.
+func negotiateContentCoding(acceptEncoding string) string {
+    if acceptEncoding == "" {
+        return ""
+    }
+
+    qualities := make(map[string]float64)
+    wildcard := -1.0
+    for _, part := range strings.Split(acceptEncoding, ",") {
+        params := strings.Split(part, ";")
+        coding := strings.ToLower(strings.TrimSpace(params[0]))
+        if coding == "" {
+            continue
+        }
+        quality := 1.0
+        for _, param := range params[1:] {
+            param = strings.TrimSpace(param)
+            if strings.HasPrefix(param, "q=") {
+                parsed, err := strconv.ParseFloat(param[2:], 64)
+                if err == nil {
+                    quality = parsed
+                }
+            }
+        }
+        if coding == "*" {
+            wildcard = quality
+        } else {
+            qualities[coding] = quality
+        }
+    }
+
+    compressionLock.RLock()
+    defer compressionLock.RUnlock()
+
+    best, bestQuality := "", 0.0
+    for _, coding := range compressorPreference {
+        quality, ok := qualities[coding]
+        if !ok {
+            quality = wildcard
+        }
+        if quality > bestQuality {
+            best, bestQuality = coding, quality
+        }
+    }
+
+    return best
+}
+
+type compressionSettings struct {
+    enabled bool
+    decompressRequests bool
+    // minimumSize and level are nil until set so that 0 (compress
+    // everything, flate.NoCompression) can be chosen explicitly.
+    minimumSize *int
+    level *int
+    contentTypes []string
+    maxDecompressedSize int64
+}
+
+func compressionSettingsFromConfig(config *Config) *compressionSettings {
+    settings := &compressionSettings{
+        enabled: config.Compression.Enabled,
+        decompressRequests: config.Compression.DecompressRequests,
+        minimumSize: config.Compression.MinimumSize,
+        level: config.Compression.Level,
+        contentTypes: config.Compression.ContentTypes,
+        maxDecompressedSize: config.Compression.MaxDecompressedSize,
+    }
+
+    return settings
+}
+
+// withDefaults fills in any unset values with the defaults.
+func (self compressionSettings) withDefaults() compressionSettings {
+    if self.minimumSize == nil {
+        minimumSize := DefaultCompressionMinimumSize
+        self.minimumSize = &minimumSize
+    }
+    if self.level == nil {
+        level := flate.DefaultCompression
+        self.level = &level
+    }
+    if self.contentTypes == nil {
+        self.contentTypes = DefaultCompressibleContentTypes
+    }
+    if self.maxDecompressedSize == 0 {
+        self.maxDecompressedSize = DefaultMaxDecompressedSize
+    }
+
+    return self
+}
+
+// CompressionOption is an option for response compression or request
+// decompression.
+type CompressionOption func(*compressionSettings)
+
+// WithCompressionMinimumSize sets the smallest body (in bytes) that will be
+// compressed, 0 compresses every body.
+func WithCompressionMinimumSize(size int) CompressionOption {
+    return func(settings *compressionSettings) {
+        settings.minimumSize = &size
+    }
+}
+
+// WithCompressionLevel sets the compression level using the compress/flate
+// levels, including flate.NoCompression (0).
+func WithCompressionLevel(level int) CompressionOption {
+    return func(settings *compressionSettings) {
+        settings.level = &level
+    }
+}
+
+// WithCompressibleContentTypes replaces the content types that will be
+// compressed. A "type/*" entry matches any subtype.
+func WithCompressibleContentTypes(contentTypes ...string) CompressionOption {
+    return func(settings *compressionSettings) {
+        settings.contentTypes = contentTypes
+    }
+}
+
+// WithMaxDecompressedSize sets the largest a decompressed request body may
+// be.
+func WithMaxDecompressedSize(size int64) CompressionOption {
+    return func(settings *compressionSettings) {
+        settings.maxDecompressedSize = size
+    }
+}
+
+// compressibleContentType checks if the content type matches the allowlist.
+func (self compressionSettings) compressibleContentType(
+    contentType string,
+) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return false
+    }
+    for _, allowed := range self.contentTypes {
+        allowed = strings.ToLower(allowed)
+        if allowed == mediaType {
+            return true
+        }
+        if strings.HasSuffix(allowed, "/*") &&
+            strings.HasPrefix(mediaType, allowed[:len(allowed)-1]) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// weakenETag marks a strong ETag as weak since the compressed representation
+// is no longer byte-for-byte what the ETag describes.
+func weakenETag(headers http.Header) {
+    etag := headers.Get(ETagHeader)
+    if etag != "" && !strings.HasPrefix(etag, "W/") {
+        headers.Set(ETagHeader, "W/" + etag)
+    }
+}
+
+// compressResponse compresses the response data if the requestor accepts a
+// registered content-coding and the response is eligible.
+func (self compressionSettings) compressResponse(
+    r *http.Request, data responses.Data, logger *logging.Logger,
+) responses.Data {
+    if data.Error() != nil || data.IsHijacked() || data.Headers == nil {
+        return data
+    }
+    switch data.StatusCode {
+    case http.StatusNoContent, http.StatusNotModified,
+        http.StatusPartialContent:
+        return data
+    }
+
+    headers := http.Header(data.Headers)
+    if headers.Get(ContentEncodingHeader) != "" ||
+        headers.Get("Content-Range") != "" ||
+        !self.compressibleContentType(headers.Get("Content-Type")) {
+        return data
+    }
+
+    // NOTE: Whether the body is compressed depends on Accept-Encoding so
+    //       caches need to know regardless of the outcome.
+    responses.AddVary(headers, "Accept-Encoding")
+
+    length := int64(len(data.Body))
+    if data.IsStream() {
+        length = -1
+        if contentLength := headers.Get("Content-Length"); contentLength != "" {
+            length, _ = strconv.ParseInt(contentLength, 10, 64)
+        }
+    }
+    if length >= 0 && length < int64(*self.minimumSize) {
+        return data
+    }
+
+    coding := negotiateContentCoding(r.Header.Get("Accept-Encoding"))
+    if coding == "" {
+        return data
+    }
+    compressor, ok := compressorFor(coding)
+    if !ok {
+        return data
+    }
+
+    if data.IsStream() {
+        level := *self.level
+        data.Stream = responses.WrapStream(
+            data.Stream,
+            func(w io.Writer) (io.WriteCloser, error) {
+                return compressor(w, level)
+            },
+        )
+        headers.Del("Content-Length")
+    } else {
+        var buf bytes.Buffer
+        writer, err := compressor(&buf, *self.level)
+        if err == nil {
+            _, err = writer.Write(data.Body)
+        }
+        if err == nil {
+            err = writer.Close()
+        }
+        if err != nil {
+            logger.Exception(
+                err, "Error while compressing response, sending it as-is.",
+            )
+            return data
+        }
+        data.Body = buf.Bytes()
+        headers.Set("Content-Length", strconv.Itoa(len(data.Body)))
+    }
+
+    headers.Set(ContentEncodingHeader, coding)
+    weakenETag(headers)
+
+    return data
+}
+
+// limitedReadCloser errors once more than the limit has been read.
+type limitedReadCloser struct {
+    io.ReadCloser
+    remaining int64
+}
+
+func (self *limitedReadCloser) Read(p []byte) (int, error) {
+    if self.remaining < 0 {
+        return 0, errors.New("Decompressed request body is too large")
+    }
+    if int64(len(p)) > self.remaining+1 {
+        p = p[:self.remaining+1]
+    }
+    n, err := self.ReadCloser.Read(p)
+    self.remaining -= int64(n)
+    if self.remaining < 0 {
+        return n, errors.New("Decompressed request body is too large")
+    }
+
+    return n, err
+}
+
+// bodyCloser closes both the decompressor and the original body.
+type bodyCloser struct {
+    io.Reader
+    closers []io.Closer
+}
+
+func (self bodyCloser) Close() error {
+    var err error
+    for _, closer := range self.closers {
+        if closeErr := closer.Close(); closeErr != nil && err == nil {
+            err = closeErr
+        }
+    }
+
+    return err
+}
+
+// decompressRequest replaces a compressed request body with a decompressing
+// reader. A 415 is returned if the content-coding isn't supported and a 400
+// if the body can't be decompressed.
+func (self compressionSettings) decompressRequest(
+    r *http.Request, defaultEncoding responses.EncodingType,
+) *responses.Data {
+    coding := strings.TrimSpace(r.Header.Get(ContentEncodingHeader))
+    if coding == "" || strings.EqualFold(coding, "identity") ||
+        r.Body == nil || r.Body == http.NoBody {
+        return nil
+    }
+
+    decompressor, ok := decompressorFor(coding)
+    if !ok {
+        return decompressionFailed(
+            r,
+            defaultEncoding,
+            http.StatusUnsupportedMediaType,
+            neterr.UnsupportedMediaTypeError,
+            responses.AddHeader(
+                "Accept-Encoding", strings.Join(supportedDecompressors(), ", "),
+            ),
+        )
+    }
+    reader, err := decompressor(r.Body)
+    if err != nil {
+        return decompressionFailed(
+            r,
+            defaultEncoding,
+            http.StatusBadRequest,
+            neterr.MalformedBodyError,
+        )
+    }
+
+    r.Body = bodyCloser{
+        Reader: &limitedReadCloser{
+            ReadCloser: reader,
+            remaining: self.maxDecompressedSize,
+        },
+        closers: []io.Closer{reader, r.Body},
+    }
+    r.Header.Del(ContentEncodingHeader)
+    r.Header.Del("Content-Length")
+    r.ContentLength = -1
+
+    return nil
+}
+
+// decompressionFailed generates the response for a request body that can't
+// be decompressed.
+func decompressionFailed(
+    r *http.Request,
+    defaultEncoding responses.EncodingType,
+    statusCode int,
+    codedError neterr.CodedError,
+    additionals ...responses.AdditionalAttribute,
+) *responses.Data {
+    sequenceId, err := ContextSequenceId(r.Context())
+    if err != nil {
+        data := responses.ErrorResponse(err)
+        return &data
+    }
+    builder, err := responses.NewBuilder(
+        r.Context(),
+        defaultEncoding,
+        append(
+            []responses.AdditionalAttribute{
+                responses.AddHeader(SequenceIdHeader, sequenceId.String()),
+                responses.Negotiate(r.Header.Get("Accept")),
+            },
+            additionals...,
+        )...,
+    )
+    if err != nil {
+        data := responses.ErrorResponse(err)
+        return &data
+    }
+    data := builder.Abort(statusCode, codedError)
+
+    return &data
+}
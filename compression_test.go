@@ -0,0 +1,285 @@
+package vial
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func gunzipString(g *gm.GomegaWithT, data []byte) string {
+    reader, err := gzip.NewReader(bytes.NewReader(data))
+    g.Expect(err).To(gm.BeNil())
+    contents, err := ioutil.ReadAll(reader)
+    g.Expect(err).To(gm.BeNil())
+
+    return string(contents)
+}
+
+func varyHeader(rr *httptest.ResponseRecorder) string {
+    return strings.Join(rr.Header()["Vary"], ", ")
+}
+
+func newCompressionServer(
+    t *testing.T, g *gm.GomegaWithT, options ...ServerOption,
+) *Server {
+    server := newTestServer(t, g, options...)
+
+    largeBody := strings.Repeat("compress me ", 200)
+    err := server.AddController(
+        "/large",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(
+                200, responses.Body(map[string]string{"text": largeBody}),
+            )
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/small",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(200, responses.Body(map[string]int{
+                "a": 1,
+            }))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/stream",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            transactor.Builder.SetContentType("text/plain")
+            return transactor.Respond(200, responses.BodyReader(
+                strings.NewReader(largeBody),
+            ))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/echo",
+        FuncHandler("post", func(transactor *Transactor) responses.Data {
+            body, err := transactor.RequestBodyString()
+            if err != nil {
+                return responses.ErrorResponse(err)
+            }
+            return transactor.Respond(200, responses.Body(body))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func TestNegotiateContentCoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    g.Expect(negotiateContentCoding("")).To(gm.Equal(""))
+    g.Expect(negotiateContentCoding("gzip, deflate")).To(gm.Equal("gzip"))
+    g.Expect(negotiateContentCoding("gzip;q=0.5, deflate")).To(
+        gm.Equal("deflate"),
+    )
+    g.Expect(negotiateContentCoding("gzip;q=0, *")).To(gm.Equal("deflate"))
+    g.Expect(negotiateContentCoding("br, identity")).To(gm.Equal(""))
+    g.Expect(negotiateContentCoding("*;q=0")).To(gm.Equal(""))
+}
+
+func TestServerCompression(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newCompressionServer(t, g, EnableCompression(), AddETags())
+
+    req, err := http.NewRequest("GET", "/large", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept-Encoding", "gzip")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Header().Get("Content-Encoding")).To(gm.Equal("gzip"))
+    g.Expect(varyHeader(rr)).To(gm.ContainSubstring(
+        "Accept-Encoding",
+    ))
+    g.Expect(rr.Header().Get("Content-Length")).To(
+        gm.Equal(strconv.Itoa(rr.Body.Len())),
+    )
+    g.Expect(rr.Header().Get(ETagHeader)).To(gm.HavePrefix(`W/"`))
+    g.Expect(gunzipString(g, rr.Body.Bytes())).To(
+        gm.ContainSubstring("compress me compress me"),
+    )
+
+    // Small bodies and requestors that don't accept compression are left
+    // alone but still vary on Accept-Encoding.
+    req, err = http.NewRequest("GET", "/small", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept-Encoding", "gzip")
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+    g.Expect(rr.Header().Get("Content-Encoding")).To(gm.BeEmpty())
+    g.Expect(rr.Body.String()).To(gm.Equal(`{"a":1}`))
+
+    req, err = http.NewRequest("GET", "/large", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+    g.Expect(rr.Header().Get("Content-Encoding")).To(gm.BeEmpty())
+    g.Expect(varyHeader(rr)).To(gm.ContainSubstring(
+        "Accept-Encoding",
+    ))
+}
+
+func TestServerCompressionZeroSettings(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newCompressionServer(
+        t,
+        g,
+        EnableCompression(
+            WithCompressionMinimumSize(0),
+            WithCompressionLevel(flate.NoCompression),
+        ),
+    )
+
+    req, err := http.NewRequest("GET", "/small", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept-Encoding", "gzip")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Header().Get("Content-Encoding")).To(gm.Equal("gzip"))
+    g.Expect(gunzipString(g, rr.Body.Bytes())).To(gm.Equal(`{"a":1}`))
+    // Without compression the body is stored as-is inside the gzip frame.
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring(`{"a":1}`))
+}
+
+func TestServerCompressionContentTypes(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newCompressionServer(
+        t,
+        g,
+        EnableCompression(WithCompressibleContentTypes("text/*")),
+    )
+
+    req, err := http.NewRequest("GET", "/large", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept-Encoding", "gzip")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Header().Get("Content-Encoding")).To(gm.BeEmpty())
+    g.Expect(varyHeader(rr)).ToNot(gm.ContainSubstring(
+        "Accept-Encoding",
+    ))
+}
+
+func TestServerCompressionStream(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    config := newConfig()
+    config.Compression.Enabled = true
+    server := newCompressionServer(t, g, AddConfig(config))
+
+    req, err := http.NewRequest("GET", "/stream", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.9")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Header().Get("Content-Encoding")).To(gm.Equal("deflate"))
+    g.Expect(rr.Header().Get("Content-Length")).To(gm.BeEmpty())
+
+    reader, err := zlib.NewReader(rr.Body)
+    g.Expect(err).To(gm.BeNil())
+    contents, err := ioutil.ReadAll(reader)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(string(contents)).To(
+        gm.Equal(strings.Repeat("compress me ", 200)),
+    )
+}
+
+func TestServerRequestDecompression(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newCompressionServer(t, g, EnableRequestDecompression())
+
+    var compressed bytes.Buffer
+    writer := gzip.NewWriter(&compressed)
+    _, err := writer.Write([]byte("hello there"))
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(writer.Close()).To(gm.BeNil())
+
+    req, err := http.NewRequest("POST", "/echo", &compressed)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Content-Encoding", "gzip")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal("hello there"))
+
+    req, err = http.NewRequest(
+        "POST", "/echo", strings.NewReader("whatever"),
+    )
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Content-Encoding", "compress")
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnsupportedMediaType))
+    g.Expect(rr.Header().Get("Accept-Encoding")).To(
+        gm.Equal("deflate, gzip, x-gzip"),
+    )
+}
+
+func TestServerRequestDecompressionMalformed(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newCompressionServer(t, g, EnableRequestDecompression())
+
+    req, err := http.NewRequest(
+        "POST", "/echo", strings.NewReader("not gzip at all"),
+    )
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Content-Encoding", "gzip")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusBadRequest))
+    g.Expect(rr.Header().Get("Accept-Encoding")).To(gm.BeEmpty())
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.MalformedBodyError.Code()),
+    )
+}
+
+func TestServerRequestDecompressionLimit(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newCompressionServer(
+        t, g, EnableRequestDecompression(WithMaxDecompressedSize(4)),
+    )
+
+    var compressed bytes.Buffer
+    writer := gzip.NewWriter(&compressed)
+    _, err := writer.Write([]byte("hello there"))
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(writer.Close()).To(gm.BeNil())
+
+    req, err := http.NewRequest("POST", "/echo", &compressed)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Content-Encoding", "gzip")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusInternalServerError))
+}
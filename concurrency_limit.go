@@ -0,0 +1,331 @@
+package vial
+
+import (
+    "context"
+    "math"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// DefaultShedRetryAfter is the Retry-After sent with shed requests unless
+// another is set.
+var DefaultShedRetryAfter = time.Second
+
+// Reasons a request can be shed.
+const (
+    shedQueueFull = "queue full"
+    shedQueueTimeout = "queue timeout"
+    shedCancelled = "cancelled while queued"
+)
+
+// These are how many samples the short and long term latency averages of an
+// adaptive limit roughly cover.
+const (
+    adaptiveShortSamples = 10
+    adaptiveLongSamples = 500
+)
+
+// AdaptiveConcurrency adjusts a concurrency limit by the gradient between
+// the short and long term average latency. When latency starts climbing the
+// limit is lowered (down to MinLimit) so excess requests are shed before
+// latency collapses and it's raised again (up to the limiter's maximum) as
+// latency recovers.
+type AdaptiveConcurrency struct {
+    // MinLimit defaults to 1.
+    MinLimit int
+    // Tolerance is how many times the long term latency the short term
+    // latency can be before the limit is lowered. It defaults to 1.5.
+    Tolerance float64
+    // Smoothing is how much of each adjustment is applied, from 0 to 1. It
+    // defaults to 0.2.
+    Smoothing float64
+}
+
+// ConcurrencyLimiter limits how many requests are handled at once. Requests
+// over the limit wait in a bounded queue and are shed with a
+// neterr.ServiceOverloadedError if the queue is full or they wait too long.
+type ConcurrencyLimiter struct {
+    mutex sync.Mutex
+    maxLimit int
+    limit float64
+    inFlight int
+    waiters []chan struct{}
+
+    queueSize int
+    queueTimeout time.Duration
+    retryAfter time.Duration
+    adaptive *AdaptiveConcurrency
+    shortLatency,
+    longLatency float64
+    now func() time.Time
+}
+
+// ConcurrencyOption is an option for a ConcurrencyLimiter.
+type ConcurrencyOption func(*ConcurrencyLimiter)
+
+// WithConcurrencyQueue lets up to size requests wait up to timeout for a
+// request to finish when the limit's been reached. By default requests over
+// the limit are shed immediately.
+func WithConcurrencyQueue(
+    size int, timeout time.Duration,
+) ConcurrencyOption {
+    return func(limiter *ConcurrencyLimiter) {
+        limiter.queueSize = size
+        limiter.queueTimeout = timeout
+    }
+}
+
+// WithShedRetryAfter sets the Retry-After sent with shed requests.
+func WithShedRetryAfter(retryAfter time.Duration) ConcurrencyOption {
+    return func(limiter *ConcurrencyLimiter) {
+        limiter.retryAfter = retryAfter
+    }
+}
+
+// WithAdaptiveConcurrency adjusts the limit based on latency, the limit
+// provided to the limiter becomes its maximum.
+func WithAdaptiveConcurrency(
+    adaptive AdaptiveConcurrency,
+) ConcurrencyOption {
+    return func(limiter *ConcurrencyLimiter) {
+        limiter.adaptive = &adaptive
+    }
+}
+
+// WithConcurrencyClock overrides how the current time is determined.
+func WithConcurrencyClock(now func() time.Time) ConcurrencyOption {
+    return func(limiter *ConcurrencyLimiter) {
+        limiter.now = now
+    }
+}
+
+// NewConcurrencyLimiter creates a new ConcurrencyLimiter allowing limit
+// requests at once. It panics if the limit isn't positive.
+func NewConcurrencyLimiter(
+    limit int, options ...ConcurrencyOption,
+) *ConcurrencyLimiter {
+    if limit <= 0 {
+        panic(errors.Errorf(
+            "Concurrency limit must be positive, got %d", limit,
+        ))
+    }
+
+    limiter := &ConcurrencyLimiter{
+        maxLimit: limit,
+        limit: float64(limit),
+        retryAfter: DefaultShedRetryAfter,
+        now: time.Now,
+    }
+    for _, option := range options {
+        option(limiter)
+    }
+    if adaptive := limiter.adaptive; adaptive != nil {
+        if adaptive.MinLimit <= 0 {
+            adaptive.MinLimit = 1
+        }
+        if adaptive.MinLimit > limit {
+            adaptive.MinLimit = limit
+        }
+        if adaptive.Tolerance <= 0 {
+            adaptive.Tolerance = 1.5
+        }
+        if adaptive.Smoothing <= 0 || adaptive.Smoothing > 1 {
+            adaptive.Smoothing = 0.2
+        }
+    }
+
+    return limiter
+}
+
+// Limit gets the current limit.
+func (self *ConcurrencyLimiter) Limit() int {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    return self.currentLimit()
+}
+
+// InFlight gets how many requests are currently being handled.
+func (self *ConcurrencyLimiter) InFlight() int {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    return self.inFlight
+}
+
+func (self *ConcurrencyLimiter) currentLimit() int {
+    return int(self.limit)
+}
+
+// dispatch hands free slots to queued requests in the order they arrived.
+// The mutex must be held.
+func (self *ConcurrencyLimiter) dispatch() {
+    for len(self.waiters) != 0 && self.inFlight < self.currentLimit() {
+        self.inFlight++
+        close(self.waiters[0])
+        self.waiters = self.waiters[1:]
+    }
+}
+
+// acquire takes a slot, waiting in the queue if there are none, returning
+// why the request should be shed if it couldn't get one.
+func (self *ConcurrencyLimiter) acquire(ctx context.Context) string {
+    self.mutex.Lock()
+    if self.inFlight < self.currentLimit() && len(self.waiters) == 0 {
+        self.inFlight++
+        self.mutex.Unlock()
+        return ""
+    }
+    if len(self.waiters) >= self.queueSize {
+        self.mutex.Unlock()
+        return shedQueueFull
+    }
+    ready := make(chan struct{})
+    self.waiters = append(self.waiters, ready)
+    self.mutex.Unlock()
+
+    timer := time.NewTimer(self.queueTimeout)
+    defer timer.Stop()
+    reason := shedQueueTimeout
+    select {
+    case <-ready:
+        return ""
+    case <-timer.C:
+    case <-ctx.Done():
+        reason = shedCancelled
+    }
+
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+    for i, waiter := range self.waiters {
+        if waiter == ready {
+            self.waiters = append(self.waiters[:i], self.waiters[i+1:]...)
+            return reason
+        }
+    }
+
+    // NOTE: A slot was handed over while we stopped waiting.
+    return ""
+}
+
+// release frees a slot taken by a request that took latency to handle.
+func (self *ConcurrencyLimiter) release(latency time.Duration) {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    if self.adaptive != nil {
+        self.adapt(latency)
+    }
+    self.inFlight--
+    self.dispatch()
+}
+
+// adapt moves the limit by the gradient between the short and long term
+// latency. The mutex must be held.
+func (self *ConcurrencyLimiter) adapt(latency time.Duration) {
+    sample := float64(latency)
+    if sample <= 0 {
+        return
+    }
+    if self.longLatency == 0 {
+        self.shortLatency = sample
+        self.longLatency = sample
+    } else {
+        self.shortLatency += (sample - self.shortLatency) * 2 /
+            (adaptiveShortSamples + 1)
+        self.longLatency += (sample - self.longLatency) * 2 /
+            (adaptiveLongSamples + 1)
+    }
+    // NOTE: After latency drops for a while the long term average is decayed
+    //       towards it so the limit doesn't stay pinned at the maximum.
+    if self.longLatency / self.shortLatency > 2 {
+        self.longLatency *= 0.95
+    }
+
+    adaptive := self.adaptive
+    gradient := math.Max(0.5, math.Min(
+        1, adaptive.Tolerance * self.longLatency / self.shortLatency,
+    ))
+    target := self.limit * gradient + math.Sqrt(self.limit)
+    limit := self.limit * (1 - adaptive.Smoothing) +
+        target * adaptive.Smoothing
+    self.limit = math.Max(
+        float64(adaptive.MinLimit), math.Min(float64(self.maxLimit), limit),
+    )
+}
+
+// Middleware creates middleware that enforces the limit, responding to shed
+// requests with a 503 and a Retry-After.
+func (self *ConcurrencyLimiter) Middleware() Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            if reason := self.acquire(ctx); reason != "" {
+                transactor.Logger.Warn(
+                    "Shed request.",
+                    logging.Extras{
+                        "path": transactor.Request.URL.Path,
+                        "method": transactor.Request.Method,
+                        "sequence_id": transactor.SequenceId(),
+                        "reason": reason,
+                        "limit": self.Limit(),
+                    },
+                )
+                transactor.Builder.SetHeader(
+                    RetryAfterHeader, durationSeconds(self.retryAfter),
+                )
+                return transactor.Abort(
+                    http.StatusServiceUnavailable,
+                    neterr.ServiceOverloadedError.WithDetail(
+                        "reason", reason,
+                    ),
+                )
+            }
+
+            // NOTE: A controller still running past its deadline keeps its
+            //       slot until it returns.
+            start := self.now()
+            defer afterController(ctx, func() {
+                self.release(self.now().Sub(start))
+            })
+
+            return next(ctx, transactor)
+        }
+    }
+}
+
+// EnableConcurrencyLimit limits how many requests the whole server handles
+// at once.
+func EnableConcurrencyLimit(
+    limit int, options ...ConcurrencyOption,
+) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.middleware = append(
+            svOpts.middleware,
+            NewConcurrencyLimiter(limit, options...).Middleware(),
+        )
+
+        return nil
+    }
+}
+
+// WithConcurrencyLimit limits how many requests to the route are handled at
+// once.
+func WithConcurrencyLimit(
+    limit int, options ...ConcurrencyOption,
+) RouteOption {
+    return func(routeOpts *routeOptions) {
+        routeOpts.middleware = append(
+            routeOpts.middleware,
+            NewConcurrencyLimiter(limit, options...).Middleware(),
+        )
+    }
+}
@@ -0,0 +1,136 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func newConcurrencyServer(
+    t *testing.T, g *gm.GomegaWithT, limiter *ConcurrencyLimiter,
+) (*Server, chan struct{}) {
+    release := make(chan struct{})
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/slow",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            <-release
+            return transactor.Respond(http.StatusNoContent)
+        }),
+        WithMiddleware(limiter.Middleware()),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server, release
+}
+
+func goRequest(server *Server) chan *httptest.ResponseRecorder {
+    done := make(chan *httptest.ResponseRecorder, 1)
+    go func() {
+        done <- authRequest(server, "/slow", nil)
+    }()
+
+    return done
+}
+
+func TestConcurrencyLimitSheds(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    limiter := NewConcurrencyLimiter(1, WithShedRetryAfter(5 * time.Second))
+    server, release := newConcurrencyServer(t, g, limiter)
+
+    first := goRequest(server)
+    g.Eventually(limiter.InFlight).Should(gm.Equal(1))
+
+    rr := authRequest(server, "/slow", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(rr.Header().Get(RetryAfterHeader)).To(gm.Equal("5"))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.ServiceOverloadedError.Code()),
+    )
+
+    close(release)
+    g.Expect((<-first).Code).To(gm.Equal(http.StatusNoContent))
+    g.Expect(limiter.InFlight()).To(gm.Equal(0))
+    rr = authRequest(server, "/slow", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    limiter := NewConcurrencyLimiter(
+        1, WithConcurrencyQueue(1, time.Minute),
+    )
+    server, release := newConcurrencyServer(t, g, limiter)
+
+    first := goRequest(server)
+    g.Eventually(limiter.InFlight).Should(gm.Equal(1))
+    queued := goRequest(server)
+    g.Eventually(func() int {
+        limiter.mutex.Lock()
+        defer limiter.mutex.Unlock()
+        return len(limiter.waiters)
+    }).Should(gm.Equal(1))
+
+    rr := authRequest(server, "/slow", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring(shedQueueFull))
+
+    release <- struct{}{}
+    g.Expect((<-first).Code).To(gm.Equal(http.StatusNoContent))
+    g.Expect(limiter.InFlight()).To(gm.Equal(1))
+    close(release)
+    g.Expect((<-queued).Code).To(gm.Equal(http.StatusNoContent))
+}
+
+func TestConcurrencyLimitQueueTimeout(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    limiter := NewConcurrencyLimiter(
+        1, WithConcurrencyQueue(1, 10 * time.Millisecond),
+    )
+    server, release := newConcurrencyServer(t, g, limiter)
+    defer close(release)
+
+    goRequest(server)
+    g.Eventually(limiter.InFlight).Should(gm.Equal(1))
+
+    rr := authRequest(server, "/slow", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring(shedQueueTimeout))
+    g.Expect(limiter.waiters).To(gm.BeEmpty())
+}
+
+func TestConcurrencyLimitAdaptive(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    limiter := NewConcurrencyLimiter(
+        20, WithAdaptiveConcurrency(AdaptiveConcurrency{MinLimit: 2}),
+    )
+    sample := func(latency time.Duration, times int) {
+        for i := 0; i < times; i++ {
+            g.Expect(limiter.acquire(context.Background())).To(gm.Equal(""))
+            limiter.release(latency)
+        }
+    }
+
+    sample(10 * time.Millisecond, 100)
+    g.Expect(limiter.Limit()).To(gm.Equal(20))
+
+    // Latency climbing lowers the limit well before it's been saturated.
+    sample(50 * time.Millisecond, 20)
+    g.Expect(limiter.Limit()).To(gm.BeNumerically("<", 10))
+    g.Expect(limiter.Limit()).To(gm.BeNumerically(">=", 2))
+
+    sample(10 * time.Millisecond, 200)
+    g.Expect(limiter.Limit()).To(gm.Equal(20))
+}
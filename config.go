@@ -1,6 +1,7 @@
 package vial
 
 import (
+    "compress/flate"
 )
 
 type Config struct {
@@ -9,6 +10,7 @@ type Config struct {
     }
     Host string
     Port int
+    Debug bool
     Tls struct {
         CertPath,
         KeyPath string
@@ -16,7 +18,32 @@ type Config struct {
     }
     Jwt struct {
         EncryptionKey,
-        HmacKey string
+        HmacKey,
+        JwksPath,
+        SigningKeyPath,
+        SigningKeyId,
+        SigningAlgorithm,
+        Issuer string
+        Audience []string
+        ClockSkewSeconds,
+        LifetimeSeconds,
+        RefreshLifetimeSeconds int
+    }
+    Compression struct {
+        Enabled,
+        DecompressRequests bool
+        // MinimumSize and Level use the defaults when nil.
+        MinimumSize,
+        Level *int
+        ContentTypes []string
+        MaxDecompressedSize int64
+    }
+    Timeouts struct {
+        ReadSeconds,
+        ReadHeaderSeconds,
+        WriteSeconds,
+        IdleSeconds,
+        HandlerSeconds int
     }
 }
 
@@ -34,6 +61,12 @@ func newConfig() *Config {
             Enabled: false,
         },
     }
+    // NOTE: These are set so they can be read from a config file or the
+    //       environment, nil (from a Config built by hand) means the default.
+    minimumSize := DefaultCompressionMinimumSize
+    level := flate.DefaultCompression
+    config.Compression.MinimumSize = &minimumSize
+    config.Compression.Level = &level
 
     return config
 }
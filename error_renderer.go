@@ -0,0 +1,23 @@
+package vial
+
+import (
+    "context"
+
+    "github.com/daihasso/vial/responses"
+)
+
+// ProblemDetailsRenderer creates an RFC 7807 ErrorRenderer which uses the
+// request's Sequence ID as the problem's instance. Set TypeURI and/or
+// Extensions on the result to customize it further.
+func ProblemDetailsRenderer() responses.ProblemRenderer {
+    return responses.ProblemRenderer{
+        Instance: func(ctx context.Context) string {
+            sequenceId, err := ContextSequenceId(ctx)
+            if err != nil {
+                return ""
+            }
+
+            return sequenceId.String()
+        },
+    }
+}
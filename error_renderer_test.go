@@ -0,0 +1,77 @@
+package vial
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func serveProblem(
+    g *gm.GomegaWithT, server *Server, method, path string,
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+    req, err := http.NewRequest(method, path, nil)
+    g.Expect(err).To(gm.BeNil())
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Header().Get("Content-Type")).To(
+        gm.Equal(responses.ProblemJSONContentType),
+    )
+    var problem map[string]interface{}
+    g.Expect(json.Unmarshal(rr.Body.Bytes(), &problem)).To(gm.BeNil())
+
+    return rr, problem
+}
+
+func TestUseProblemDetails(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)), UseProblemDetails(),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Abort(
+                http.StatusConflict, neterr.NewCodedError(42, "Taken"),
+            )
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/things/<integer:id>",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(http.StatusOK)
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr, problem := serveProblem(g, server, "GET", "/thing")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusConflict))
+    g.Expect(problem).To(gm.And(
+        gm.HaveKeyWithValue("title", "Conflict"),
+        gm.HaveKeyWithValue("detail", "Taken"),
+        gm.HaveKeyWithValue("code", float64(42)),
+        gm.HaveKeyWithValue(
+            "instance", rr.Header().Get(SequenceIdHeader),
+        ),
+    ))
+
+    rr, problem = serveProblem(g, server, "POST", "/thing")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusMethodNotAllowed))
+    g.Expect(problem).To(gm.HaveKeyWithValue(
+        "code", float64(neterr.MethodNotAllowedError.Code()),
+    ))
+    g.Expect(problem["instance"]).ToNot(gm.BeEmpty())
+
+    rr, problem = serveProblem(g, server, "GET", "/things/abc")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotFound))
+    g.Expect(problem).To(gm.HaveKeyWithValue("title", "Not Found"))
+}
@@ -0,0 +1,266 @@
+package vial
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// ETagHeader is the header an entity tag is sent in.
+const ETagHeader = "ETag"
+
+// etagHashLength is how many bytes of the body's hash are used for a
+// generated ETag.
+const etagHashLength = 16
+
+// ResourceVersion is the current version of a resource used to evaluate
+// conditional requests. Either field may be left empty.
+type ResourceVersion struct {
+    ETag string
+    ModTime time.Time
+}
+
+// VersionLookup looks up the current version of the resource a request is
+// for. A nil version means the resource doesn't exist.
+type VersionLookup func(context.Context, *Transactor) (
+    *ResourceVersion, error,
+)
+
+// FormatETag quotes a value as an entity tag, marking it weak if requested.
+func FormatETag(value string, weak bool) string {
+    etag := `"` + strings.Trim(value, `"`) + `"`
+    if weak {
+        etag = "W/" + etag
+    }
+
+    return etag
+}
+
+// SetETag sets the ETag for the response. The ETag middleware uses it instead
+// of generating one from the body.
+func (self *Transactor) SetETag(value string, weak bool) {
+    self.Builder.SetHeader(ETagHeader, FormatETag(value, weak))
+}
+
+// etagMatches checks if an ETag matches any in the list provided in a
+// conditional header. Weak comparison is used unless strong is true.
+func etagMatches(list, etag string, strong bool) bool {
+    if etag == "" {
+        return false
+    }
+    for _, candidate := range strings.Split(list, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" {
+            return true
+        }
+        if strong {
+            if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+                return true
+            }
+        } else if strings.TrimPrefix(candidate, "W/") ==
+            strings.TrimPrefix(etag, "W/") {
+            return true
+        }
+    }
+
+    return false
+}
+
+// isSafeMethod checks if a method is one that doesn't modify the resource.
+func isSafeMethod(method string) bool {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions,
+        http.MethodTrace:
+        return true
+    }
+
+    return false
+}
+
+// modifiedSince checks if modTime is after the HTTP date provided. Invalid
+// dates are treated as modified.
+func modifiedSince(modTime time.Time, date string) bool {
+    dateTime, err := http.ParseTime(date)
+    if err != nil {
+        return true
+    }
+
+    return modTime.Truncate(time.Second).After(dateTime)
+}
+
+// notModified checks if the requestor's cached copy is still current for a
+// resource with the provided ETag and modification time.
+func notModified(header http.Header, etag string, modTime time.Time) bool {
+    if ifNoneMatch := header.Get("If-None-Match"); ifNoneMatch != "" {
+        return etagMatches(ifNoneMatch, etag, false)
+    }
+    if since := header.Get("If-Modified-Since"); since != "" &&
+        !modTime.IsZero() {
+        return !modifiedSince(modTime, since)
+    }
+
+    return false
+}
+
+// preconditionFailed checks If-Match and If-Unmodified-Since against the
+// current version of a resource.
+func preconditionFailed(header http.Header, version *ResourceVersion) bool {
+    if ifMatch := header.Get("If-Match"); ifMatch != "" {
+        if version == nil {
+            return true
+        }
+        if strings.TrimSpace(ifMatch) == "*" {
+            return false
+        }
+        return !etagMatches(ifMatch, version.ETag, true)
+    }
+    if since := header.Get("If-Unmodified-Since"); since != "" {
+        if version == nil || version.ModTime.IsZero() {
+            return false
+        }
+        return modifiedSince(version.ModTime, since)
+    }
+
+    return false
+}
+
+// notModifiedResponse converts a response into a 304 keeping only the headers
+// that describe the resource rather than the body.
+func notModifiedResponse(data responses.Data) responses.Data {
+    headers := make(map[string][]string, len(data.Headers))
+    for key, value := range data.Headers {
+        switch http.CanonicalHeaderKey(key) {
+        case "Content-Type", "Content-Length", "Content-Encoding",
+            "Content-Range", "Content-Disposition":
+            continue
+        }
+        headers[key] = value
+    }
+
+    return responses.Data{
+        Headers: headers,
+        StatusCode: http.StatusNotModified,
+    }
+}
+
+// checkVersionPreconditions evaluates the conditional request headers against
+// the route's version lookup. A response is returned if the request shouldn't
+// continue to the controller.
+func checkVersionPreconditions(
+    lookup VersionLookup, transactor *Transactor,
+) *responses.Data {
+    version, err := lookup(transactor.Context(), transactor)
+    if err != nil {
+        transactor.Logger.Exception(err, "Error while looking up version.")
+        data := responses.ErrorResponse(err)
+        return &data
+    }
+
+    if version != nil {
+        if version.ETag != "" {
+            transactor.Builder.SetHeader(ETagHeader, version.ETag)
+        }
+        if !version.ModTime.IsZero() {
+            transactor.Builder.SetHeader(
+                "Last-Modified",
+                version.ModTime.UTC().Format(http.TimeFormat),
+            )
+        }
+    }
+
+    header := transactor.Request.Header
+    if preconditionFailed(header, version) {
+        data := transactor.Abort(
+            http.StatusPreconditionFailed, neterr.PreconditionFailedError,
+        )
+        return &data
+    }
+
+    method := transactor.Request.Method
+    if version != nil && (method == http.MethodGet ||
+        method == http.MethodHead) &&
+        notModified(header, version.ETag, version.ModTime) {
+        data := notModifiedResponse(transactor.Respond(
+            http.StatusNotModified, responses.Body([]byte(nil)),
+        ))
+        return &data
+    }
+
+    return nil
+}
+
+type etagOptions struct {
+    weak bool
+}
+
+// ETagOption is an option for the ETag middleware.
+type ETagOption func(*etagOptions)
+
+// WithWeakETags generates weak ETags instead of strong ones. Weak ETags are
+// appropriate when the body may change in ways that don't matter (ex:
+// formatting) or is transformed along the way.
+func WithWeakETags() ETagOption {
+    return func(options *etagOptions) {
+        options.weak = true
+    }
+}
+
+// ETagMiddleware generates a post-action middleware which adds an ETag to
+// successful GET and HEAD responses and answers If-None-Match (and
+// If-Modified-Since if a Last-Modified header is set) with a 304. An ETag
+// set by the controller is used as-is, otherwise one is generated from the
+// body. Streamed responses and responses without headers are left alone.
+// The response is only replaced when answering with a 304 so any later
+// post-action middleware still run.
+func ETagMiddleware(options ...ETagOption) PostMiddleWare {
+    etagOpts := &etagOptions{}
+    for _, option := range options {
+        option(etagOpts)
+    }
+
+    return func(
+        _ context.Context, transactor *Transactor, data responses.Data,
+    ) (*responses.Data, error) {
+        method := transactor.Request.Method
+        if method != http.MethodGet && method != http.MethodHead {
+            return nil, nil
+        }
+        if data.StatusCode != http.StatusOK || data.IsStream() ||
+            data.IsHijacked() || data.Error() != nil ||
+            data.Headers == nil {
+            return nil, nil
+        }
+
+        header := http.Header(data.Headers)
+        etag := header.Get(ETagHeader)
+        if etag == "" {
+            sum := sha256.Sum256(data.Body)
+            etag = FormatETag(
+                hex.EncodeToString(sum[:etagHashLength]), etagOpts.weak,
+            )
+            header.Set(ETagHeader, etag)
+        }
+
+        var modTime time.Time
+        if lastModified := header.Get("Last-Modified"); lastModified != "" {
+            modTime, _ = http.ParseTime(lastModified)
+        }
+        if notModified(transactor.Request.Header, etag, modTime) {
+            notModifiedData := notModifiedResponse(data)
+            return &notModifiedData, nil
+        }
+
+        return nil, nil
+    }
+}
+
+// AddETags adds the ETag middleware to the server.
+func AddETags(options ...ETagOption) ServerOption {
+    return AddPostActionMiddleware(ETagMiddleware(options...))
+}
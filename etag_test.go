@@ -0,0 +1,204 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+)
+
+func TestETagMatches(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    g.Expect(etagMatches(`"a", "b"`, `"b"`, true)).To(gm.BeTrue())
+    g.Expect(etagMatches(`W/"b"`, `"b"`, true)).To(gm.BeFalse())
+    g.Expect(etagMatches(`W/"b"`, `"b"`, false)).To(gm.BeTrue())
+    g.Expect(etagMatches(`*`, `"b"`, true)).To(gm.BeTrue())
+    g.Expect(etagMatches(`*`, ``, true)).To(gm.BeFalse())
+    g.Expect(FormatETag("abc", true)).To(gm.Equal(`W/"abc"`))
+    g.Expect(FormatETag(`"abc"`, false)).To(gm.Equal(`"abc"`))
+}
+
+func serveETagRequest(
+    g *gm.GomegaWithT, server *Server, method string,
+    headers map[string]string,
+) *httptest.ResponseRecorder {
+    req, err := http.NewRequest(method, "/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func TestETagMiddleware(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newTestServer(t, g, AddETags())
+    err := server.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(200, responses.Body(map[string]int{
+                "a": 1,
+            }))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := serveETagRequest(g, server, "GET", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    etag := rr.Header().Get(ETagHeader)
+    g.Expect(etag).To(gm.MatchRegexp(`^"[0-9a-f]{32}"$`))
+
+    rr = serveETagRequest(
+        g, server, "GET", map[string]string{"If-None-Match": etag},
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotModified))
+    g.Expect(rr.Body.Len()).To(gm.BeZero())
+    g.Expect(rr.Header().Get(ETagHeader)).To(gm.Equal(etag))
+    g.Expect(rr.Header().Get("Content-Type")).To(gm.BeEmpty())
+    g.Expect(rr.Header().Get(SequenceIdHeader)).ToNot(gm.BeEmpty())
+
+    rr = serveETagRequest(
+        g, server, "GET", map[string]string{"If-None-Match": `"other"`},
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+}
+
+func TestETagMiddlewareControllerSupplied(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newTestServer(t, g, AddETags(WithWeakETags()))
+    err := server.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            transactor.SetETag("v7", false)
+            return transactor.Respond(200, responses.Body("hello"))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := serveETagRequest(g, server, "GET", nil)
+    g.Expect(rr.Header().Get(ETagHeader)).To(gm.Equal(`"v7"`))
+
+    rr = serveETagRequest(
+        g, server, "GET", map[string]string{"If-None-Match": `W/"v7"`},
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotModified))
+}
+
+func TestETagMiddlewareChained(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var seenETags []string
+    server := newTestServer(
+        t, g,
+        AddETags(),
+        AddPostActionMiddleware(func(
+            _ context.Context, _ *Transactor, data responses.Data,
+        ) (*responses.Data, error) {
+            seenETags = append(
+                seenETags, http.Header(data.Headers).Get(ETagHeader),
+            )
+            return nil, nil
+        }),
+    )
+    err := server.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(200, responses.Body("hello"))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/bare",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return responses.Data{StatusCode: 200, Body: []byte("bare")}
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := serveETagRequest(g, server, "GET", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(seenETags).To(gm.Equal([]string{rr.Header().Get(ETagHeader)}))
+    g.Expect(seenETags[0]).ToNot(gm.BeEmpty())
+
+    req, err := http.NewRequest("GET", "/bare", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal("bare"))
+    g.Expect(seenETags).To(gm.HaveLen(2))
+}
+
+func TestVersionLookup(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    modTime := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+    controllerCalls := 0
+    server := newTestServer(t, g)
+    err := server.AddController(
+        "/thing",
+        FuncHandlerMulti(
+            func(transactor *Transactor) responses.Data {
+                controllerCalls++
+                return transactor.Respond(200, responses.Body("thing"))
+            },
+            "get",
+            "put",
+        ),
+        WithVersionLookup(func(
+            _ context.Context, _ *Transactor,
+        ) (*ResourceVersion, error) {
+            return &ResourceVersion{ETag: `"v2"`, ModTime: modTime}, nil
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := serveETagRequest(
+        g, server, "PUT", map[string]string{"If-Match": `"v1"`},
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusPreconditionFailed))
+    g.Expect(controllerCalls).To(gm.Equal(0))
+
+    rr = serveETagRequest(
+        g, server, "PUT", map[string]string{"If-Match": `"v1", "v2"`},
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(controllerCalls).To(gm.Equal(1))
+
+    rr = serveETagRequest(
+        g,
+        server,
+        "PUT",
+        map[string]string{
+            "If-Unmodified-Since": modTime.Add(-time.Hour).Format(
+                http.TimeFormat,
+            ),
+        },
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusPreconditionFailed))
+
+    rr = serveETagRequest(
+        g, server, "GET", map[string]string{"If-None-Match": `"v2"`},
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotModified))
+    g.Expect(rr.Header().Get("Last-Modified")).To(
+        gm.Equal("Fri, 01 Mar 2019 12:00:00 GMT"),
+    )
+    g.Expect(controllerCalls).To(gm.Equal(1))
+
+    rr = serveETagRequest(g, server, "GET", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Header().Get(ETagHeader)).To(gm.Equal(`"v2"`))
+    g.Expect(controllerCalls).To(gm.Equal(2))
+}
@@ -0,0 +1,224 @@
+package vial
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/responses"
+)
+
+// LastEventIdHeader is the header a reconnecting EventSource sends with the
+// id of the last event it received.
+const LastEventIdHeader = "Last-Event-ID"
+
+// EventStreamContentType is the content type for server-sent events.
+const EventStreamContentType = "text/event-stream"
+
+// Event is a single server-sent event. Data that is a string or []byte is
+// sent as-is, anything else is marshalled to JSON.
+type Event struct {
+    Id string
+    Event string
+    Data interface{}
+    Retry time.Duration
+}
+
+// EventStreamHandler pushes events to the requestor until it returns or the
+// context is done (ex: the requestor disconnected).
+type EventStreamHandler func(context.Context, *EventStream) error
+
+type eventStreamOptions struct {
+    heartbeat time.Duration
+    retry time.Duration
+}
+
+// EventStreamOption is an option for an EventStream.
+type EventStreamOption func(*eventStreamOptions)
+
+// WithHeartbeat sends a comment on the provided interval to keep idle
+// connections (and any proxies in between) from timing out.
+func WithHeartbeat(interval time.Duration) EventStreamOption {
+    return func(options *eventStreamOptions) {
+        options.heartbeat = interval
+    }
+}
+
+// WithRetry tells the requestor how long to wait before reconnecting if the
+// stream is interrupted.
+func WithRetry(retry time.Duration) EventStreamOption {
+    return func(options *eventStreamOptions) {
+        options.retry = retry
+    }
+}
+
+// EventStream is a helper for sending server-sent events to a requestor.
+type EventStream struct {
+    ctx context.Context
+    writer *responses.StreamWriter
+    lastEventId string
+    lock sync.Mutex
+}
+
+// Context returns the request context; it is done when the requestor
+// disconnects.
+func (self *EventStream) Context() context.Context {
+    return self.ctx
+}
+
+// LastEventId returns the id of the last event the requestor received before
+// reconnecting or an empty string if it's a new stream.
+func (self *EventStream) LastEventId() string {
+    return self.lastEventId
+}
+
+func sanitizeEventField(value string) string {
+    return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+func (self *EventStream) write(message string) error {
+    if err := self.ctx.Err(); err != nil {
+        return err
+    }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    _, err := self.writer.Write([]byte(message))
+    if err != nil {
+        return errors.Wrap(err, "Error while writing to event stream")
+    }
+
+    return nil
+}
+
+// Send sends an event to the requestor. An error is returned if the requestor
+// has disconnected.
+func (self *EventStream) Send(event Event) error {
+    var data string
+    switch v := event.Data.(type) {
+    case nil:
+    case string:
+        data = v
+    case []byte:
+        data = string(v)
+    default:
+        marshalled, err := json.Marshal(v)
+        if err != nil {
+            return errors.Wrap(err, "Error while marshalling event data")
+        }
+        data = string(marshalled)
+    }
+
+    var message strings.Builder
+    if event.Id != "" {
+        fmt.Fprintf(&message, "id: %s\n", sanitizeEventField(event.Id))
+    }
+    if event.Event != "" {
+        fmt.Fprintf(&message, "event: %s\n", sanitizeEventField(event.Event))
+    }
+    if event.Retry > 0 {
+        fmt.Fprintf(&message, "retry: %d\n", event.Retry.Milliseconds())
+    }
+    data = strings.Replace(data, "\r\n", "\n", -1)
+    for _, line := range strings.Split(data, "\n") {
+        fmt.Fprintf(&message, "data: %s\n", line)
+    }
+    message.WriteString("\n")
+
+    return self.write(message.String())
+}
+
+// Comment sends a comment which is ignored by the requestor.
+func (self *EventStream) Comment(comment string) error {
+    return self.write(fmt.Sprintf(": %s\n\n", sanitizeEventField(comment)))
+}
+
+func (self *EventStream) heartbeat(interval time.Duration, done chan struct{}) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            if err := self.Comment("heartbeat"); err != nil {
+                return
+            }
+        case <-done:
+            return
+        case <-self.ctx.Done():
+            return
+        }
+    }
+}
+
+// EventStream responds to the request with a stream of server-sent events
+// produced by the handler. The response is flushed after every event.
+func (self *Transactor) EventStream(
+    handler EventStreamHandler, options ...EventStreamOption,
+) responses.Data {
+    streamOptions := &eventStreamOptions{}
+    for _, option := range options {
+        option(streamOptions)
+    }
+
+    ctx := self.Context()
+    lastEventId := self.Request.Header.Get(LastEventIdHeader)
+    logger := self.Logger
+
+    self.Builder.SetHeader(responses.ContentTypeHeader, EventStreamContentType)
+    self.Builder.SetHeader("Cache-Control", "no-cache")
+    // NOTE: This prevents nginx from buffering the events.
+    self.Builder.SetHeader("X-Accel-Buffering", "no")
+
+    return self.Respond(
+        http.StatusOK,
+        responses.FlushInterval(responses.FlushImmediately),
+        responses.BodyStream(func(w *responses.StreamWriter) error {
+            stream := &EventStream{
+                ctx: ctx,
+                writer: w,
+                lastEventId: lastEventId,
+            }
+            logger.Debug("Event stream opened.", logging.Extras{
+                "last_event_id": lastEventId,
+            })
+
+            if streamOptions.retry > 0 {
+                err := stream.write(fmt.Sprintf(
+                    "retry: %d\n\n", streamOptions.retry.Milliseconds(),
+                ))
+                if err != nil {
+                    return err
+                }
+            }
+            if streamOptions.heartbeat > 0 {
+                done := make(chan struct{})
+                var stopped sync.WaitGroup
+                stopped.Add(1)
+                go func() {
+                    defer stopped.Done()
+                    stream.heartbeat(streamOptions.heartbeat, done)
+                }()
+                defer stopped.Wait()
+                defer close(done)
+            }
+
+            err := handler(ctx, stream)
+            if ctx.Err() != nil {
+                logger.Debug("Event stream requestor disconnected.")
+                return nil
+            }
+            logger.Debug("Event stream closed.")
+
+            return err
+        }),
+    )
+}
@@ -0,0 +1,140 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+)
+
+func newEventStreamServer(
+    t *testing.T, g *gm.GomegaWithT, handler EventStreamHandler,
+    options ...EventStreamOption,
+) *Server {
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+
+    err = server.AddController(
+        "/events",
+        FuncHandler(
+            "get",
+            func(transactor *Transactor) responses.Data {
+                return transactor.EventStream(handler, options...)
+            },
+        ),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func TestEventStream(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newEventStreamServer(
+        t,
+        g,
+        func(_ context.Context, stream *EventStream) error {
+            err := stream.Send(Event{
+                Id: "1",
+                Event: "greeting",
+                Data: "hello\nworld",
+            })
+            if err != nil {
+                return err
+            }
+            err = stream.Comment("still here")
+            if err != nil {
+                return err
+            }
+            return stream.Send(Event{
+                Id: "2",
+                Data: map[string]int{"count": 2},
+                Retry: 5 * time.Second,
+            })
+        },
+        WithRetry(time.Second),
+    )
+
+    req, err := http.NewRequest("GET", "/events", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr := httptest.NewRecorder()
+
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Header().Get("Content-Type")).To(
+        gm.Equal(EventStreamContentType),
+    )
+    g.Expect(rr.Header().Get("Cache-Control")).To(gm.Equal("no-cache"))
+    g.Expect(rr.Header().Get("Sequence-Id")).ToNot(gm.BeEmpty())
+    g.Expect(rr.Flushed).To(gm.BeTrue())
+    g.Expect(rr.Body.String()).To(gm.Equal(
+        "retry: 1000\n\n" +
+            "id: 1\nevent: greeting\ndata: hello\ndata: world\n\n" +
+            ": still here\n\n" +
+            "id: 2\nretry: 5000\ndata: {\"count\":2}\n\n",
+    ))
+}
+
+func TestEventStreamLastEventId(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var lastEventId string
+    server := newEventStreamServer(
+        t,
+        g,
+        func(_ context.Context, stream *EventStream) error {
+            lastEventId = stream.LastEventId()
+            return nil
+        },
+    )
+
+    req, err := http.NewRequest("GET", "/events", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set(LastEventIdHeader, "42")
+    rr := httptest.NewRecorder()
+
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(lastEventId).To(gm.Equal("42"))
+}
+
+func TestEventStreamHeartbeatAndDisconnect(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var sendErr error
+    server := newEventStreamServer(
+        t,
+        g,
+        func(ctx context.Context, stream *EventStream) error {
+            <-ctx.Done()
+            sendErr = stream.Send(Event{Data: "too late"})
+            return sendErr
+        },
+        WithHeartbeat(5 * time.Millisecond),
+    )
+
+    ctx, cancel := context.WithCancel(context.Background())
+    req, err := http.NewRequest("GET", "/events", nil)
+    g.Expect(err).To(gm.BeNil())
+    req = req.WithContext(ctx)
+    rr := httptest.NewRecorder()
+
+    go func() {
+        time.Sleep(30 * time.Millisecond)
+        cancel()
+    }()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(sendErr).To(gm.Equal(context.Canceled))
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring(": heartbeat\n\n"))
+    g.Expect(rr.Body.String()).ToNot(gm.ContainSubstring("too late"))
+}
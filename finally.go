@@ -0,0 +1,194 @@
+package vial
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "sync"
+
+    "github.com/pkg/errors"
+    logging "github.com/daihasso/slogging"
+
+    "github.com/daihasso/vial/responses"
+)
+
+// FinallyMiddleWare is a function that's always run once a request has been
+// responded to, no matter how the response came about (ex: a short-circuiting
+// pre-action middleware, a 404/405, an error or a panic).
+// It's given the final response and any error that occurred while handling
+// or writing it. The Transactor is nil if the request didn't get far enough
+// to have one (ex: a 404).
+type FinallyMiddleWare func(
+    *http.Request, *Transactor, responses.Data, error,
+)
+
+var requestStateContextKey = ContextKey("request_state")
+
+// requestState tracks what was created while handling a request so that it
+// can be cleaned up afterwards.
+type requestState struct {
+    transactor *Transactor
+    // route is the path of the route the request matched (if any).
+    route string
+    // principal is who the request was authenticated as (if anyone). It's
+    // guarded by the lock since it's set from the controller's goroutine.
+    principal *Principal
+    // cleanups are run once the request has been responded to (ex:
+    // cancelling contexts or closing files). They're guarded by the lock
+    // since a controller that timed out may still be adding them.
+    cleanups []func()
+    finished bool
+    // lateController is closed once a controller that was still running at
+    // its deadline returns.
+    lateController chan struct{}
+    lock sync.Mutex
+}
+
+func contextWithRequestState(
+    ctx context.Context, state *requestState,
+) context.Context {
+    return context.WithValue(ctx, requestStateContextKey, state)
+}
+
+// setRequestTransactor records the Transactor for the request in the context
+// (if it's tracking one).
+func setRequestTransactor(ctx context.Context, transactor *Transactor) {
+    if state, ok := ctx.Value(requestStateContextKey).(*requestState); ok {
+        state.transactor = transactor
+    }
+}
+
+// setRequestRoute records the path of the route the request matched in the
+// context (if it's tracking one).
+func setRequestRoute(ctx context.Context, route string) {
+    if state, ok := ctx.Value(requestStateContextKey).(*requestState); ok {
+        state.route = route
+    }
+}
+
+// setRequestPrincipal records who the request was authenticated as in the
+// context (if it's tracking one).
+func setRequestPrincipal(ctx context.Context, principal *Principal) {
+    if state, ok := ctx.Value(requestStateContextKey).(*requestState); ok {
+        state.lock.Lock()
+        state.principal = principal
+        state.lock.Unlock()
+    }
+}
+
+// requestPrincipal gets who the request was authenticated as (if anyone).
+func (self *requestState) requestPrincipal() *Principal {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    return self.principal
+}
+
+// cleanupWithRequest defers the cleanup (ex: cancelling a context) until the
+// request has been responded to (any streamed body included). If it already
+// has been the cleanup is run immediately. It reports false if the request
+// isn't being tracked.
+func cleanupWithRequest(ctx context.Context, cleanup func()) bool {
+    state, ok := ctx.Value(requestStateContextKey).(*requestState)
+    if !ok {
+        return false
+    }
+
+    state.lock.Lock()
+    finished := state.finished
+    if !finished {
+        state.cleanups = append(state.cleanups, cleanup)
+    }
+    state.lock.Unlock()
+    if finished {
+        cleanup()
+    }
+
+    return true
+}
+
+// startLateController records that the request's controller is still running
+// past its deadline. The function returned must be called once it returns.
+func startLateController(ctx context.Context) func() {
+    done := make(chan struct{})
+    if state, ok := ctx.Value(requestStateContextKey).(*requestState); ok {
+        state.lock.Lock()
+        state.lateController = done
+        state.lock.Unlock()
+    }
+
+    return func() {
+        close(done)
+    }
+}
+
+// afterController runs the function once the controller has returned. That's
+// immediately unless it's still running past its deadline.
+func (self *requestState) afterController(run func()) {
+    self.lock.Lock()
+    late := self.lateController
+    self.lock.Unlock()
+    if late == nil {
+        run()
+        return
+    }
+
+    go func() {
+        <-late
+        run()
+    }()
+}
+
+// afterController runs the function once the request's controller has
+// returned (see requestState.afterController). It's run immediately if the
+// request isn't being tracked.
+func afterController(ctx context.Context, run func()) {
+    state, ok := ctx.Value(requestStateContextKey).(*requestState)
+    if !ok {
+        run()
+        return
+    }
+
+    state.afterController(run)
+}
+
+// runFinally runs the finally middleware and then any cleanups for the
+// request and closes the Transactor's logger. A panic in one finally
+// middleware doesn't stop the others. The cleanups and closing the logger
+// wait for a controller that's still running past its deadline.
+func (self *Server) runFinally(
+    r *http.Request, state *requestState, data responses.Data, err error,
+) {
+    for _, middleware := range self.finallyMiddleware {
+        func() {
+            defer func() {
+                if rawErr := recover(); rawErr != nil {
+                    self.Logger.Exception(
+                        errors.New(fmt.Sprintf("%+v", rawErr)),
+                        "Panic in finally middleware.",
+                        logging.Extras{
+                            "path": r.URL.Path,
+                            "method": r.Method,
+                        },
+                    )
+                }
+            }()
+
+            middleware(r, state.transactor, data, err)
+        }()
+    }
+
+    state.afterController(func() {
+        state.lock.Lock()
+        state.finished = true
+        cleanups := state.cleanups
+        state.cleanups = nil
+        state.lock.Unlock()
+        for _, cleanup := range cleanups {
+            cleanup()
+        }
+        if state.transactor != nil {
+            state.transactor.Logger.Close()
+        }
+    })
+}
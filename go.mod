@@ -7,7 +7,8 @@ require (
 	github.com/daihasso/tote v0.1.0
 	github.com/google/uuid v1.1.0
 	github.com/onsi/gomega v1.4.3
-	github.com/pkg/errors v0.8.1
+	github.com/pkg/errors v0.9.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	gopkg.in/yaml.v2 v2.2.2
 )
 
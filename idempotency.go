@@ -0,0 +1,247 @@
+package vial
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// Headers used for idempotent requests.
+const (
+    IdempotencyKeyHeader = "Idempotency-Key"
+    // IdempotentReplayedHeader is set on responses that are replays of the
+    // response to the first request made with the key.
+    IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// DefaultIdempotencyTTL is how long responses are kept for replaying and
+// DefaultIdempotencyLockTimeout is how long a key stays claimed by a request
+// that never finishes (ex: the server crashed) unless others are set.
+var (
+    DefaultIdempotencyTTL = 24 * time.Hour
+    DefaultIdempotencyLockTimeout = time.Minute
+)
+
+type idempotencyOptions struct {
+    store IdempotencyStore
+    ttl,
+    lockTimeout time.Duration
+    methods []string
+    scope func(*Transactor) string
+}
+
+// IdempotencyOption is an option for Idempotency.
+type IdempotencyOption func(*idempotencyOptions)
+
+// WithIdempotencyStore sets where responses are kept. Use a shared store
+// when running several servers; the default is a new MemoryIdempotencyStore.
+func WithIdempotencyStore(store IdempotencyStore) IdempotencyOption {
+    return func(options *idempotencyOptions) {
+        options.store = store
+    }
+}
+
+// WithIdempotencyTTL sets how long responses are kept for replaying.
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+    return func(options *idempotencyOptions) {
+        options.ttl = ttl
+    }
+}
+
+// WithIdempotencyLockTimeout sets how long a key stays claimed by a request
+// that never finishes.
+func WithIdempotencyLockTimeout(timeout time.Duration) IdempotencyOption {
+    return func(options *idempotencyOptions) {
+        options.lockTimeout = timeout
+    }
+}
+
+// WithIdempotentMethods sets which methods Idempotency-Keys are honoured for,
+// the default is POST and PATCH.
+func WithIdempotentMethods(methods ...string) IdempotencyOption {
+    return func(options *idempotencyOptions) {
+        options.methods = nil
+        for _, method := range methods {
+            options.methods = append(
+                options.methods, strings.ToUpper(method),
+            )
+        }
+    }
+}
+
+// WithIdempotencyScope namespaces keys (ex: by the requestor's account) so
+// different requestors can't collide or see each other's responses.
+func WithIdempotencyScope(scope func(*Transactor) string) IdempotencyOption {
+    return func(options *idempotencyOptions) {
+        options.scope = scope
+    }
+}
+
+// idempotencyFingerprint identifies a request by its method, path, query and
+// body.
+func idempotencyFingerprint(transactor *Transactor) (string, error) {
+    body, err := transactor.RequestBody()
+    if err != nil {
+        return "", err
+    }
+
+    request := transactor.Request
+    hash := sha256.New()
+    fmt.Fprintf(
+        hash,
+        "%s\n%s\n%s\n",
+        request.Method,
+        request.URL.Path,
+        request.URL.RawQuery,
+    )
+    hash.Write(body)
+
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// storedResponse converts a response into one that can be stored. The
+// Sequence-Id is left out since it belongs to the original request.
+func storedResponse(data responses.Data) IdempotentResponse {
+    headers := make(map[string][]string, len(data.Headers))
+    for key, values := range data.Headers {
+        if key != SequenceIdHeader {
+            headers[key] = values
+        }
+    }
+
+    return IdempotentResponse{
+        StatusCode: data.StatusCode,
+        Headers: headers,
+        Body: data.Body,
+    }
+}
+
+// replayResponse converts a stored response back into a response for the
+// current request.
+func replayResponse(
+    transactor *Transactor, response IdempotentResponse,
+) responses.Data {
+    headers := make(map[string][]string, len(response.Headers) + 2)
+    for key, values := range response.Headers {
+        headers[key] = values
+    }
+    if sequenceId := transactor.SequenceId(); sequenceId != nil {
+        headers[SequenceIdHeader] = []string{sequenceId.String()}
+    }
+    headers[IdempotentReplayedHeader] = []string{"true"}
+
+    return responses.Data{
+        Headers: headers,
+        Body: response.Body,
+        StatusCode: response.StatusCode,
+    }
+}
+
+// Idempotency creates middleware that makes retries of unsafe requests sent
+// with an Idempotency-Key safe. The first response for a key is stored and
+// replayed for any retries with the same key. A retry made while the first
+// request is still being handled gets a neterr.IdempotencyConflictError and
+// reusing a key for a different request (by method, path, query and body)
+// gets a neterr.IdempotencyKeyReusedError.
+// Server errors, streamed and hijacked responses aren't stored so they can
+// be retried.
+func Idempotency(options ...IdempotencyOption) Middleware {
+    idempotencyOpts := &idempotencyOptions{
+        ttl: DefaultIdempotencyTTL,
+        lockTimeout: DefaultIdempotencyLockTimeout,
+        methods: []string{http.MethodPost, http.MethodPatch},
+    }
+    for _, option := range options {
+        option(idempotencyOpts)
+    }
+    if idempotencyOpts.store == nil {
+        idempotencyOpts.store = NewMemoryIdempotencyStore()
+    }
+    store := idempotencyOpts.store
+
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            key := transactor.Request.Header.Get(IdempotencyKeyHeader)
+            method := transactor.Request.Method
+            if key == "" || !containsAll(idempotencyOpts.methods, method) {
+                return next(ctx, transactor)
+            }
+            if idempotencyOpts.scope != nil {
+                key = idempotencyOpts.scope(transactor) + "|" + key
+            }
+
+            fingerprint, err := idempotencyFingerprint(transactor)
+            if err != nil {
+                return responses.ErrorResponse(err)
+            }
+            record, err := store.Start(
+                key, fingerprint, idempotencyOpts.lockTimeout,
+            )
+            if err != nil {
+                return responses.ErrorResponse(errors.Wrapf(
+                    err, "Error while claiming Idempotency-Key '%s'", key,
+                ))
+            }
+            if record != nil {
+                if record.Fingerprint != fingerprint {
+                    return transactor.Abort(
+                        http.StatusUnprocessableEntity,
+                        neterr.IdempotencyKeyReusedError,
+                    )
+                }
+                if record.Response == nil {
+                    return transactor.Abort(
+                        http.StatusConflict, neterr.IdempotencyConflictError,
+                    )
+                }
+                transactor.Logger.Debug(
+                    "Replaying idempotent response.",
+                    logging.Extras{"idempotency_key": key},
+                )
+                return replayResponse(transactor, *record.Response)
+            }
+
+            completed := false
+            defer func() {
+                if completed {
+                    return
+                }
+                if err := store.Release(key); err != nil {
+                    transactor.Logger.Exception(
+                        err, "Error while releasing Idempotency-Key.",
+                    )
+                }
+            }()
+
+            data := next(ctx, transactor)
+            if data.Error() != nil || data.IsStream() || data.IsHijacked() ||
+                data.StatusCode >= http.StatusInternalServerError {
+                return data
+            }
+            err = store.Complete(
+                key, fingerprint, storedResponse(data), idempotencyOpts.ttl,
+            )
+            if err != nil {
+                transactor.Logger.Exception(
+                    err, "Error while storing idempotent response.",
+                )
+                return data
+            }
+            completed = true
+
+            return data
+        }
+    }
+}
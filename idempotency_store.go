@@ -0,0 +1,138 @@
+package vial
+
+import (
+    "sync"
+    "time"
+)
+
+// idempotencySweepInterval is how often a MemoryIdempotencyStore forgets
+// about expired keys.
+var idempotencySweepInterval = time.Minute
+
+// IdempotentResponse is a response stored to be replayed for retries.
+type IdempotentResponse struct {
+    StatusCode int `json:"status_code"`
+    Headers map[string][]string `json:"headers,omitempty"`
+    Body []byte `json:"body,omitempty"`
+}
+
+// IdempotencyRecord is what's stored for an Idempotency-Key.
+type IdempotencyRecord struct {
+    // Fingerprint identifies the request the key was first used for.
+    Fingerprint string `json:"fingerprint"`
+    // Response is nil while the first request is still being handled.
+    Response *IdempotentResponse `json:"response,omitempty"`
+}
+
+// IdempotencyStore keeps the responses to requests made with an
+// Idempotency-Key. Implement it to share keys between servers (ex: with
+// Redis or a database).
+//
+// Start must atomically claim the key for a request if it isn't already,
+// storing a pending record (without a Response) that's forgotten after ttl
+// unless it's completed first; if the key is already claimed the existing
+// record is returned instead and nothing changes. Complete stores the
+// response along with the request's fingerprint, keeping it for ttl; the
+// claim may have expired by then so it must not rely on the pending record
+// still being there, but it must not replace a record for a different
+// fingerprint. Release forgets a claimed key so the request can be retried.
+type IdempotencyStore interface {
+    Start(
+        key, fingerprint string, ttl time.Duration,
+    ) (*IdempotencyRecord, error)
+    Complete(
+        key, fingerprint string,
+        response IdempotentResponse,
+        ttl time.Duration,
+    ) error
+    Release(key string) error
+}
+
+type memoryIdempotencyEntry struct {
+    record IdempotencyRecord
+    expiresAt time.Time
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that lives in memory. It's
+// meant for tests and single instance deployments since responses are
+// neither shared nor persisted.
+type MemoryIdempotencyStore struct {
+    mutex sync.Mutex
+    entries map[string]memoryIdempotencyEntry
+    lastSweep time.Time
+    now func() time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new, empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+    return &MemoryIdempotencyStore{
+        entries: make(map[string]memoryIdempotencyEntry),
+        now: time.Now,
+    }
+}
+
+// Start claims the key unless it's already claimed.
+func (self *MemoryIdempotencyStore) Start(
+    key, fingerprint string, ttl time.Duration,
+) (*IdempotencyRecord, error) {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    now := self.now()
+    if now.Sub(self.lastSweep) >= idempotencySweepInterval {
+        for entryKey, entry := range self.entries {
+            if !now.Before(entry.expiresAt) {
+                delete(self.entries, entryKey)
+            }
+        }
+        self.lastSweep = now
+    }
+
+    if entry, ok := self.entries[key]; ok && now.Before(entry.expiresAt) {
+        record := entry.record
+        return &record, nil
+    }
+    self.entries[key] = memoryIdempotencyEntry{
+        record: IdempotencyRecord{Fingerprint: fingerprint},
+        expiresAt: now.Add(ttl),
+    }
+
+    return nil, nil
+}
+
+// Complete stores the response for the key unless the key has since been
+// claimed by a different request.
+func (self *MemoryIdempotencyStore) Complete(
+    key, fingerprint string,
+    response IdempotentResponse,
+    ttl time.Duration,
+) error {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    now := self.now()
+    entry, ok := self.entries[key]
+    if ok && now.Before(entry.expiresAt) &&
+        entry.record.Fingerprint != fingerprint {
+        return nil
+    }
+    self.entries[key] = memoryIdempotencyEntry{
+        record: IdempotencyRecord{
+            Fingerprint: fingerprint,
+            Response: &response,
+        },
+        expiresAt: now.Add(ttl),
+    }
+
+    return nil
+}
+
+// Release forgets the key.
+func (self *MemoryIdempotencyStore) Release(key string) error {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    delete(self.entries, key)
+
+    return nil
+}
@@ -0,0 +1,179 @@
+package vial
+
+import (
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func idempotentRequest(
+    server *Server, method, key, body string,
+) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest(method, "/charges", bytes.NewBufferString(body))
+    if key != "" {
+        req.Header.Set(IdempotencyKeyHeader, key)
+    }
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var charges int32
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddMiddleware(Idempotency()),
+    )
+    g.Expect(err).To(gm.BeNil())
+    charge := func(transactor *Transactor) responses.Data {
+        count := atomic.AddInt32(&charges, 1)
+        transactor.Builder.SetHeader("Location", "/charges/1")
+        return transactor.Respond(
+            http.StatusCreated,
+            responses.Body(map[string]int32{"charge": count}),
+        )
+    }
+    err = server.AddController(
+        "/charges", FuncHandler("post", charge), FuncHandler("get", charge),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    first := idempotentRequest(server, "POST", "key-1", `{"amount":5}`)
+    g.Expect(first.Code).To(gm.Equal(http.StatusCreated))
+    g.Expect(first.Header().Get(IdempotentReplayedHeader)).To(gm.Equal(""))
+
+    retry := idempotentRequest(server, "POST", "key-1", `{"amount":5}`)
+    g.Expect(retry.Code).To(gm.Equal(http.StatusCreated))
+    g.Expect(retry.Body.String()).To(gm.Equal(first.Body.String()))
+    g.Expect(retry.Header().Get("Location")).To(gm.Equal("/charges/1"))
+    g.Expect(retry.Header().Get(IdempotentReplayedHeader)).To(
+        gm.Equal("true"),
+    )
+    g.Expect(retry.Header().Get(SequenceIdHeader)).ToNot(gm.Equal(
+        first.Header().Get(SequenceIdHeader),
+    ))
+    g.Expect(atomic.LoadInt32(&charges)).To(gm.Equal(int32(1)))
+
+    rr := idempotentRequest(server, "POST", "key-1", `{"amount":6}`)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnprocessableEntity))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.IdempotencyKeyReusedError.Code()),
+    )
+
+    // Other keys, requests without a key and safe methods aren't replayed.
+    idempotentRequest(server, "POST", "key-2", `{"amount":5}`)
+    idempotentRequest(server, "POST", "", `{"amount":5}`)
+    idempotentRequest(server, "GET", "key-1", "")
+    g.Expect(atomic.LoadInt32(&charges)).To(gm.Equal(int32(4)))
+}
+
+func TestIdempotencyConcurrentDuplicate(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    started := make(chan struct{})
+    release := make(chan struct{})
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/charges",
+        FuncHandler("post", func(transactor *Transactor) responses.Data {
+            close(started)
+            <-release
+            return transactor.Respond(http.StatusCreated)
+        }),
+        WithMiddleware(Idempotency()),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    done := make(chan *httptest.ResponseRecorder, 1)
+    go func() {
+        done <- idempotentRequest(server, "POST", "key", "")
+    }()
+    <-started
+
+    rr := idempotentRequest(server, "POST", "key", "")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusConflict))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.IdempotencyConflictError.Code()),
+    )
+
+    close(release)
+    g.Expect((<-done).Code).To(gm.Equal(http.StatusCreated))
+    rr = idempotentRequest(server, "POST", "key", "")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusCreated))
+    g.Expect(rr.Header().Get(IdempotentReplayedHeader)).To(gm.Equal("true"))
+}
+
+func TestIdempotencyServerErrorsRetried(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var attempts int32
+    store := NewMemoryIdempotencyStore()
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/charges",
+        FuncHandler("post", func(transactor *Transactor) responses.Data {
+            if atomic.AddInt32(&attempts, 1) == 1 {
+                return transactor.Respond(http.StatusBadGateway)
+            }
+            return transactor.Respond(http.StatusCreated)
+        }),
+        WithMiddleware(Idempotency(WithIdempotencyStore(store))),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := idempotentRequest(server, "POST", "key", "")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusBadGateway))
+    g.Expect(store.entries).To(gm.BeEmpty())
+
+    rr = idempotentRequest(server, "POST", "key", "")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusCreated))
+    rr = idempotentRequest(server, "POST", "key", "")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusCreated))
+    g.Expect(atomic.LoadInt32(&attempts)).To(gm.Equal(int32(2)))
+}
+
+func TestMemoryIdempotencyStoreCompleteAfterExpiry(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    now := time.Unix(1600000000, 0)
+    store := NewMemoryIdempotencyStore()
+    store.now = func() time.Time { return now }
+    response := IdempotentResponse{StatusCode: http.StatusCreated}
+
+    record, err := store.Start("a", "first", time.Second)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(record).To(gm.BeNil())
+
+    // The claim expires and is swept while the request is still handled.
+    now = now.Add(idempotencySweepInterval)
+    _, err = store.Start("b", "other", time.Second)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(store.entries).ToNot(gm.HaveKey("a"))
+
+    err = store.Complete("a", "first", response, time.Hour)
+    g.Expect(err).To(gm.BeNil())
+    record, err = store.Start("a", "first", time.Second)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(record).ToNot(gm.BeNil())
+    g.Expect(record.Fingerprint).To(gm.Equal("first"))
+    g.Expect(record.Response).To(gm.Equal(&response))
+
+    // A key claimed by a different request in the meantime is left alone.
+    err = store.Complete("b", "first", response, time.Hour)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(store.entries["b"].record.Fingerprint).To(gm.Equal("other"))
+    g.Expect(store.entries["b"].record.Response).To(gm.BeNil())
+}
@@ -0,0 +1,226 @@
+package jwt
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/rsa"
+    _ "crypto/sha256"
+    _ "crypto/sha512"
+    "crypto/x509"
+    "encoding/pem"
+    "math/big"
+    "strings"
+
+    "github.com/pkg/errors"
+)
+
+// Supported signing algorithms.
+const (
+    HS256 = "HS256"
+    HS384 = "HS384"
+    HS512 = "HS512"
+    RS256 = "RS256"
+    RS384 = "RS384"
+    RS512 = "RS512"
+    ES256 = "ES256"
+    ES384 = "ES384"
+    ES512 = "ES512"
+)
+
+var algorithmHashes = map[string]crypto.Hash{
+    HS256: crypto.SHA256,
+    HS384: crypto.SHA384,
+    HS512: crypto.SHA512,
+    RS256: crypto.SHA256,
+    RS384: crypto.SHA384,
+    RS512: crypto.SHA512,
+    ES256: crypto.SHA256,
+    ES384: crypto.SHA384,
+    ES512: crypto.SHA512,
+}
+
+// ecdsaCurveBits is the size of the curve each ECDSA algorithm uses.
+var ecdsaCurveBits = map[string]int{
+    ES256: 256,
+    ES384: 384,
+    ES512: 521,
+}
+
+// isHmacAlgorithm checks if the algorithm uses a shared secret.
+func isHmacAlgorithm(algorithm string) bool {
+    return strings.HasPrefix(algorithm, "HS")
+}
+
+// ecdsaKeySize is the size in bytes of each of the two integers in an ECDSA
+// signature for the curve.
+func ecdsaKeySize(key *ecdsa.PublicKey) int {
+    return (key.Curve.Params().BitSize + 7) / 8
+}
+
+// fillBytes writes the integer into the buffer as big-endian, zero padding
+// the front.
+func fillBytes(value *big.Int, buffer []byte) {
+    data := value.Bytes()
+    copy(buffer[len(buffer) - len(data):], data)
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+    hasher := hash.New()
+    hasher.Write(data)
+    return hasher.Sum(nil)
+}
+
+// verifySignature verifies the signature for the signing input with the
+// provided key which is either a []byte secret or a public key.
+func verifySignature(
+    algorithm string, key interface{}, signingInput string, signature []byte,
+) error {
+    hash, ok := algorithmHashes[algorithm]
+    if !ok {
+        return errors.Wrapf(ErrUnsupportedAlgorithm, "'%s'", algorithm)
+    }
+
+    switch typedKey := key.(type) {
+        case []byte:
+        if !isHmacAlgorithm(algorithm) {
+            break
+        }
+        mac := hmac.New(hash.New, typedKey)
+        mac.Write([]byte(signingInput))
+        if !hmac.Equal(mac.Sum(nil), signature) {
+            return ErrInvalidSignature
+        }
+        return nil
+        case *rsa.PublicKey:
+        if !strings.HasPrefix(algorithm, "RS") {
+            break
+        }
+        err := rsa.VerifyPKCS1v15(
+            typedKey, hash, digest(hash, []byte(signingInput)), signature,
+        )
+        if err != nil {
+            return ErrInvalidSignature
+        }
+        return nil
+        case *ecdsa.PublicKey:
+        if typedKey.Curve.Params().BitSize != ecdsaCurveBits[algorithm] {
+            break
+        }
+        size := ecdsaKeySize(typedKey)
+        if len(signature) != 2 * size {
+            return ErrInvalidSignature
+        }
+        r := new(big.Int).SetBytes(signature[:size])
+        s := new(big.Int).SetBytes(signature[size:])
+        if !ecdsa.Verify(
+            typedKey, digest(hash, []byte(signingInput)), r, s,
+        ) {
+            return ErrInvalidSignature
+        }
+        return nil
+    }
+
+    return errors.Wrapf(
+        ErrKeyNotFound, "Key of type %T can't verify '%s'", key, algorithm,
+    )
+}
+
+// sign signs the signing input with the provided key which is either a
+// []byte secret or a private key.
+func sign(
+    algorithm string, key interface{}, signingInput string,
+) ([]byte, error) {
+    hash, ok := algorithmHashes[algorithm]
+    if !ok {
+        return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "'%s'", algorithm)
+    }
+
+    switch typedKey := key.(type) {
+        case []byte:
+        if !isHmacAlgorithm(algorithm) {
+            break
+        }
+        mac := hmac.New(hash.New, typedKey)
+        mac.Write([]byte(signingInput))
+        return mac.Sum(nil), nil
+        case *rsa.PrivateKey:
+        if !strings.HasPrefix(algorithm, "RS") {
+            break
+        }
+        return rsa.SignPKCS1v15(
+            rand.Reader,
+            typedKey,
+            hash,
+            digest(hash, []byte(signingInput)),
+        )
+        case *ecdsa.PrivateKey:
+        if typedKey.Curve.Params().BitSize != ecdsaCurveBits[algorithm] {
+            break
+        }
+        r, s, err := ecdsa.Sign(
+            rand.Reader, typedKey, digest(hash, []byte(signingInput)),
+        )
+        if err != nil {
+            return nil, err
+        }
+        size := ecdsaKeySize(&typedKey.PublicKey)
+        signature := make([]byte, 2 * size)
+        fillBytes(r, signature[:size])
+        fillBytes(s, signature[size:])
+        return signature, nil
+    }
+
+    return nil, errors.Errorf(
+        "Key of type %T can't sign '%s'", key, algorithm,
+    )
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or EC private key (PKCS #1,
+// PKCS #8 or SEC 1) for signing tokens.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("Private key is not PEM encoded")
+    }
+
+    switch block.Type {
+        case "RSA PRIVATE KEY":
+        return x509.ParsePKCS1PrivateKey(block.Bytes)
+        case "EC PRIVATE KEY":
+        return x509.ParseECPrivateKey(block.Bytes)
+        case "PRIVATE KEY":
+        key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+        if err != nil {
+            return nil, err
+        }
+        switch typedKey := key.(type) {
+            case *rsa.PrivateKey:
+            return typedKey, nil
+            case *ecdsa.PrivateKey:
+            return typedKey, nil
+        }
+        return nil, errors.Errorf("Unsupported private key type %T", key)
+    }
+
+    return nil, errors.Errorf("Unsupported PEM block type '%s'", block.Type)
+}
+
+// defaultAlgorithm picks the algorithm for a key when none is specified.
+func defaultAlgorithm(key interface{}) string {
+    switch typedKey := key.(type) {
+        case *rsa.PrivateKey:
+        return RS256
+        case *ecdsa.PrivateKey:
+        switch typedKey.Curve.Params().BitSize {
+            case 384:
+            return ES384
+            case 521:
+            return ES512
+        }
+        return ES256
+    }
+
+    return HS256
+}
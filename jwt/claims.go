@@ -0,0 +1,159 @@
+// Package jwt verifies (and issues) JSON Web Tokens. Signed tokens (JWS) are
+// supported with the HS256/384/512, RS256/384/512 and ES256/384/512
+// algorithms and encrypted tokens (JWE) with direct AES-GCM encryption
+// wrapping a signed token.
+package jwt
+
+import (
+    "encoding/json"
+    "strings"
+    "time"
+)
+
+// Registered claim names.
+const (
+    IssuerClaim = "iss"
+    SubjectClaim = "sub"
+    AudienceClaim = "aud"
+    ExpiresAtClaim = "exp"
+    NotBeforeClaim = "nbf"
+    IssuedAtClaim = "iat"
+    IdClaim = "jti"
+    ScopeClaim = "scope"
+    // TokenUseClaim marks refresh tokens so they can't be used as access
+    // tokens.
+    TokenUseClaim = "token_use"
+    // RefreshFamilyClaim ties rotated refresh tokens to the token they were
+    // originally issued with.
+    RefreshFamilyClaim = "rfid"
+)
+
+// Values of the TokenUseClaim.
+const (
+    AccessTokenUse = "access"
+    RefreshTokenUse = "refresh"
+)
+
+// Claims are the claims in a token's payload.
+type Claims map[string]interface{}
+
+// Copy creates a shallow copy of the claims.
+func (self Claims) Copy() Claims {
+    claims := make(Claims, len(self))
+    for key, value := range self {
+        claims[key] = value
+    }
+
+    return claims
+}
+
+// String gets a claim as a string or an empty string if it isn't one.
+func (self Claims) String(key string) string {
+    value, _ := self[key].(string)
+    return value
+}
+
+// Strings gets a claim that's either a string or an array of strings as a
+// slice of strings.
+func (self Claims) Strings(key string) []string {
+    switch value := self[key].(type) {
+        case string:
+        return []string{value}
+        case []string:
+        return value
+        case []interface{}:
+        var values []string
+        for _, item := range value {
+            if itemString, ok := item.(string); ok {
+                values = append(values, itemString)
+            }
+        }
+        return values
+    }
+
+    return nil
+}
+
+// Time gets a NumericDate claim as a time.
+func (self Claims) Time(key string) (time.Time, bool) {
+    var seconds float64
+    switch value := self[key].(type) {
+        case float64:
+        seconds = value
+        case int64:
+        seconds = float64(value)
+        case int:
+        seconds = float64(value)
+        case json.Number:
+        parsed, err := value.Float64()
+        if err != nil {
+            return time.Time{}, false
+        }
+        seconds = parsed
+        default:
+        return time.Time{}, false
+    }
+
+    whole := int64(seconds)
+    return time.Unix(whole, int64((seconds - float64(whole)) * 1e9)), true
+}
+
+// Issuer gets the iss claim.
+func (self Claims) Issuer() string {
+    return self.String(IssuerClaim)
+}
+
+// Subject gets the sub claim.
+func (self Claims) Subject() string {
+    return self.String(SubjectClaim)
+}
+
+// Audience gets the aud claim.
+func (self Claims) Audience() []string {
+    return self.Strings(AudienceClaim)
+}
+
+// Id gets the jti claim.
+func (self Claims) Id() string {
+    return self.String(IdClaim)
+}
+
+// ExpiresAt gets the exp claim.
+func (self Claims) ExpiresAt() (time.Time, bool) {
+    return self.Time(ExpiresAtClaim)
+}
+
+// NotBefore gets the nbf claim.
+func (self Claims) NotBefore() (time.Time, bool) {
+    return self.Time(NotBeforeClaim)
+}
+
+// IssuedAt gets the iat claim.
+func (self Claims) IssuedAt() (time.Time, bool) {
+    return self.Time(IssuedAtClaim)
+}
+
+// Scopes gets the scopes granted by the token from the space-separated scope
+// claim (or the scp array some providers use instead).
+func (self Claims) Scopes() []string {
+    if scope := self.String(ScopeClaim); scope != "" {
+        return strings.Fields(scope)
+    }
+
+    return self.Strings("scp")
+}
+
+// HasScopes checks if the token grants all of the provided scopes.
+func (self Claims) HasScopes(scopes ...string) bool {
+    granted := make(map[string]bool)
+    for _, scope := range self.Scopes() {
+        granted[scope] = true
+    }
+    for _, scope := range scopes {
+        if !granted[scope] {
+            return false
+        }
+    }
+
+    return true
+}
@@ -0,0 +1,21 @@
+package jwt
+
+import (
+    "github.com/pkg/errors"
+)
+
+// Errors returned (wrapped) when a token can't be verified. Use errors.Is to
+// check for them.
+var (
+    ErrMalformed = errors.New("Token is malformed")
+    ErrUnsupportedAlgorithm = errors.New("Token algorithm is not supported")
+    ErrKeyNotFound = errors.New("No key found to verify token")
+    ErrInvalidSignature = errors.New("Token signature is invalid")
+    ErrDecryption = errors.New("Token could not be decrypted")
+    ErrExpired = errors.New("Token is expired")
+    ErrNotYetValid = errors.New("Token is not valid yet")
+    ErrInvalidIssuer = errors.New("Token issuer is not accepted")
+    ErrInvalidAudience = errors.New("Token audience is not accepted")
+    ErrWrongTokenUse = errors.New("Token can't be used for this purpose")
+    ErrRevoked = errors.New("Token has been revoked")
+)
@@ -0,0 +1,188 @@
+package jwt
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "strings"
+
+    "github.com/pkg/errors"
+)
+
+// Supported JWE key management algorithm and content encryptions.
+const (
+    DirectEncryption = "dir"
+    A128GCM = "A128GCM"
+    A192GCM = "A192GCM"
+    A256GCM = "A256GCM"
+)
+
+var contentEncryptionKeySizes = map[string]int{
+    A128GCM: 16,
+    A192GCM: 24,
+    A256GCM: 32,
+}
+
+// Header is the JOSE header of a token.
+type Header struct {
+    Algorithm string `json:"alg"`
+    Type string `json:"typ,omitempty"`
+    KeyId string `json:"kid,omitempty"`
+    ContentType string `json:"cty,omitempty"`
+    Encryption string `json:"enc,omitempty"`
+    Compression string `json:"zip,omitempty"`
+}
+
+func decodeHeader(encoded string) (Header, error) {
+    var header Header
+    data, err := base64.RawURLEncoding.DecodeString(encoded)
+    if err != nil {
+        return header, errors.Wrap(ErrMalformed, "Header is not base64url")
+    }
+    err = json.Unmarshal(data, &header)
+    if err != nil {
+        return header, errors.Wrap(ErrMalformed, "Header is not JSON")
+    }
+
+    return header, nil
+}
+
+// IsEncrypted checks if a compact serialized token is an encrypted (JWE)
+// token rather than a signed one.
+func IsEncrypted(token string) bool {
+    return strings.Count(token, ".") == 4
+}
+
+// decrypt decrypts a compact JWE token using direct encryption with the
+// provided key and returns the header and plaintext.
+func decrypt(token string, key []byte) (Header, []byte, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 5 {
+        return Header{}, nil, errors.Wrap(
+            ErrMalformed, "Encrypted token must have 5 parts",
+        )
+    }
+
+    header, err := decodeHeader(parts[0])
+    if err != nil {
+        return header, nil, err
+    }
+    if header.Algorithm != DirectEncryption {
+        return header, nil, errors.Wrapf(
+            ErrUnsupportedAlgorithm, "Key management '%s'", header.Algorithm,
+        )
+    }
+    keySize, ok := contentEncryptionKeySizes[header.Encryption]
+    if !ok {
+        return header, nil, errors.Wrapf(
+            ErrUnsupportedAlgorithm, "Content encryption '%s'",
+            header.Encryption,
+        )
+    }
+    if header.Compression != "" {
+        return header, nil, errors.Wrapf(
+            ErrUnsupportedAlgorithm, "Compression '%s'", header.Compression,
+        )
+    }
+    if len(key) != keySize {
+        return header, nil, errors.Wrapf(
+            ErrKeyNotFound,
+            "%s requires a %d byte key", header.Encryption, keySize,
+        )
+    }
+    if parts[1] != "" {
+        return header, nil, errors.Wrap(
+            ErrMalformed, "Direct encryption must not have an encrypted key",
+        )
+    }
+
+    var decoded [3][]byte
+    for i, part := range parts[2:] {
+        decoded[i], err = base64.RawURLEncoding.DecodeString(part)
+        if err != nil {
+            return header, nil, errors.Wrap(
+                ErrMalformed, "Encrypted token part is not base64url",
+            )
+        }
+    }
+    iv, ciphertext, tag := decoded[0], decoded[1], decoded[2]
+
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return header, nil, errors.Wrap(err, "Error while creating cipher")
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return header, nil, errors.Wrap(err, "Error while creating GCM")
+    }
+    if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+        return header, nil, errors.Wrap(
+            ErrMalformed, "Encrypted token has an invalid IV or tag",
+        )
+    }
+
+    plaintext, err := gcm.Open(
+        nil, iv, append(ciphertext, tag...), []byte(parts[0]),
+    )
+    if err != nil {
+        return header, nil, ErrDecryption
+    }
+
+    return header, plaintext, nil
+}
+
+// Encrypt encrypts a compact serialized token using direct encryption with
+// the provided key. The content encryption (A128GCM, A192GCM or A256GCM) is
+// picked based on the size of the key.
+func Encrypt(token string, key []byte) (string, error) {
+    var encryption string
+    for candidate, size := range contentEncryptionKeySizes {
+        if len(key) == size {
+            encryption = candidate
+        }
+    }
+    if encryption == "" {
+        return "", errors.Wrapf(
+            ErrKeyNotFound,
+            "A %d byte key can't be used for encryption", len(key),
+        )
+    }
+
+    headerData, err := json.Marshal(Header{
+        Algorithm: DirectEncryption,
+        Encryption: encryption,
+        ContentType: "JWT",
+    })
+    if err != nil {
+        return "", errors.Wrap(err, "Error while encoding header")
+    }
+    protected := base64.RawURLEncoding.EncodeToString(headerData)
+
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return "", errors.Wrap(err, "Error while creating cipher")
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return "", errors.Wrap(err, "Error while creating GCM")
+    }
+    iv := make([]byte, gcm.NonceSize())
+    _, err = rand.Read(iv)
+    if err != nil {
+        return "", errors.Wrap(err, "Error while generating IV")
+    }
+
+    sealed := gcm.Seal(nil, iv, []byte(token), []byte(protected))
+    tagStart := len(sealed) - gcm.Overhead()
+
+    encode := base64.RawURLEncoding.EncodeToString
+    return strings.Join([]string{
+        protected,
+        "",
+        encode(iv),
+        encode(sealed[:tagStart]),
+        encode(sealed[tagStart:]),
+    }, "."), nil
+}
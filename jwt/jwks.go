@@ -0,0 +1,166 @@
+package jwt
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+
+    "github.com/pkg/errors"
+)
+
+// Key is a public key from a JSON Web Key Set.
+type Key struct {
+    Id string
+    Algorithm string
+    Use string
+    PublicKey crypto.PublicKey
+}
+
+// KeySet is a set of public keys used to verify RS* and ES* tokens.
+type KeySet struct {
+    keys []Key
+}
+
+type jsonWebKey struct {
+    KeyType string `json:"kty"`
+    Id string `json:"kid"`
+    Algorithm string `json:"alg"`
+    Use string `json:"use"`
+    N string `json:"n"`
+    E string `json:"e"`
+    Curve string `json:"crv"`
+    X string `json:"x"`
+    Y string `json:"y"`
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+    data, err := base64.RawURLEncoding.DecodeString(value)
+    if err != nil {
+        return nil, err
+    }
+    if len(data) == 0 {
+        return nil, errors.New("Value is empty")
+    }
+
+    return new(big.Int).SetBytes(data), nil
+}
+
+func (self jsonWebKey) publicKey() (crypto.PublicKey, error) {
+    switch self.KeyType {
+        case "RSA":
+        n, err := decodeBigInt(self.N)
+        if err != nil {
+            return nil, errors.Wrap(err, "Invalid RSA modulus")
+        }
+        e, err := decodeBigInt(self.E)
+        if err != nil {
+            return nil, errors.Wrap(err, "Invalid RSA exponent")
+        }
+        if !e.IsInt64() || e.Int64() > 1 << 31 - 1 {
+            return nil, errors.New("RSA exponent is too large")
+        }
+        return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+        case "EC":
+        var curve elliptic.Curve
+        switch self.Curve {
+            case "P-256":
+            curve = elliptic.P256()
+            case "P-384":
+            curve = elliptic.P384()
+            case "P-521":
+            curve = elliptic.P521()
+            default:
+            return nil, errors.Errorf("Unsupported curve '%s'", self.Curve)
+        }
+        x, err := decodeBigInt(self.X)
+        if err != nil {
+            return nil, errors.Wrap(err, "Invalid EC x coordinate")
+        }
+        y, err := decodeBigInt(self.Y)
+        if err != nil {
+            return nil, errors.Wrap(err, "Invalid EC y coordinate")
+        }
+        if !curve.IsOnCurve(x, y) {
+            return nil, errors.New("EC point is not on the curve")
+        }
+        return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+    }
+
+    return nil, errors.Errorf("Unsupported key type '%s'", self.KeyType)
+}
+
+// ParseJwks parses a JSON Web Key Set document. Keys that aren't RSA or EC
+// signing keys (ex: encryption keys or symmetric keys) are skipped.
+func ParseJwks(data []byte) (*KeySet, error) {
+    var document struct {
+        Keys []jsonWebKey `json:"keys"`
+    }
+    err := json.Unmarshal(data, &document)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while parsing JWKS")
+    }
+
+    keySet := &KeySet{}
+    for i, webKey := range document.Keys {
+        if webKey.Use != "" && webKey.Use != "sig" {
+            continue
+        }
+        if webKey.KeyType != "RSA" && webKey.KeyType != "EC" {
+            continue
+        }
+        publicKey, err := webKey.publicKey()
+        if err != nil {
+            return nil, errors.Wrapf(
+                err, "Error while parsing key #%d ('%s') in JWKS",
+                i,
+                webKey.Id,
+            )
+        }
+        keySet.keys = append(keySet.keys, Key{
+            Id: webKey.Id,
+            Algorithm: webKey.Algorithm,
+            Use: webKey.Use,
+            PublicKey: publicKey,
+        })
+    }
+
+    return keySet, nil
+}
+
+// NewKeySet creates a KeySet from the provided keys.
+func NewKeySet(keys ...Key) *KeySet {
+    return &KeySet{keys: keys}
+}
+
+// Keys returns the keys in the set.
+func (self KeySet) Keys() []Key {
+    return self.keys
+}
+
+// Lookup finds the key for the provided key ID and algorithm. If the key ID
+// is empty and there is only one key that could be used for the algorithm
+// it's returned.
+func (self KeySet) Lookup(keyId, algorithm string) (Key, bool) {
+    var candidates []Key
+    for _, key := range self.keys {
+        if key.Algorithm != "" && key.Algorithm != algorithm {
+            continue
+        }
+        if keyId != "" {
+            if key.Id == keyId {
+                return key, true
+            }
+            continue
+        }
+        candidates = append(candidates, key)
+    }
+    if len(candidates) == 1 {
+        return candidates[0], true
+    }
+
+    return Key{}, false
+}
@@ -0,0 +1,87 @@
+package jwt
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+)
+
+func encodeBigInt(value *big.Int) string {
+    return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func TestParseJwks(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    g.Expect(err).To(gm.BeNil())
+    ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+    g.Expect(err).To(gm.BeNil())
+
+    document, err := json.Marshal(map[string]interface{}{
+        "keys": []map[string]string{
+            {
+                "kty": "RSA",
+                "kid": "rsa-1",
+                "alg": RS256,
+                "use": "sig",
+                "n": encodeBigInt(rsaKey.N),
+                "e": encodeBigInt(big.NewInt(int64(rsaKey.E))),
+            },
+            {
+                "kty": "EC",
+                "kid": "ec-1",
+                "crv": "P-384",
+                "x": encodeBigInt(ecKey.X),
+                "y": encodeBigInt(ecKey.Y),
+            },
+            {
+                "kty": "RSA",
+                "kid": "rsa-enc",
+                "use": "enc",
+                "n": encodeBigInt(rsaKey.N),
+                "e": encodeBigInt(big.NewInt(int64(rsaKey.E))),
+            },
+            {
+                "kty": "oct",
+                "kid": "secret",
+                "k": "c2VjcmV0",
+            },
+        },
+    })
+    g.Expect(err).To(gm.BeNil())
+
+    keySet, err := ParseJwks(document)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(keySet.Keys()).To(gm.HaveLen(2))
+
+    key, ok := keySet.Lookup("rsa-1", RS256)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(key.PublicKey.(*rsa.PublicKey).N).To(gm.Equal(rsaKey.N))
+
+    key, ok = keySet.Lookup("", ES384)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(key.Id).To(gm.Equal("ec-1"))
+
+    _, ok = keySet.Lookup("rsa-1", RS512)
+    g.Expect(ok).To(gm.BeFalse())
+}
+
+func TestParseJwksInvalidKey(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    _, err := ParseJwks([]byte(
+        `{"keys":[{"kty":"EC","kid":"bad","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+    ))
+    g.Expect(err).ToNot(gm.BeNil())
+
+    _, err = ParseJwks([]byte(`not json`))
+    g.Expect(err).ToNot(gm.BeNil())
+}
@@ -0,0 +1,241 @@
+package jwt
+
+import (
+    "sync"
+    "time"
+
+    "github.com/pkg/errors"
+)
+
+// DefaultRefreshLifetime is how long refresh tokens are valid for unless
+// another lifetime is set.
+var DefaultRefreshLifetime = 30 * 24 * time.Hour
+
+// RevocationStore keeps track of revoked token and refresh family IDs. The
+// expiry is when the revoked token would have expired anyways, after which
+// the store is free to forget about it.
+type RevocationStore interface {
+    Revoke(id string, expiresAt time.Time) error
+    IsRevoked(id string) (bool, error)
+}
+
+// MemoryRevocationStore is a RevocationStore that lives in memory. It's
+// meant for tests and single instance deployments since revocations are
+// neither shared nor persisted.
+type MemoryRevocationStore struct {
+    mutex sync.Mutex
+    revoked map[string]time.Time
+    now func() time.Time
+}
+
+// NewMemoryRevocationStore creates a new, empty MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+    return &MemoryRevocationStore{
+        revoked: make(map[string]time.Time),
+        now: time.Now,
+    }
+}
+
+// Revoke revokes the ID until it expires.
+func (self *MemoryRevocationStore) Revoke(
+    id string, expiresAt time.Time,
+) error {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    now := self.now()
+    for revokedId, revokedUntil := range self.revoked {
+        if !now.Before(revokedUntil) {
+            delete(self.revoked, revokedId)
+        }
+    }
+    self.revoked[id] = expiresAt
+
+    return nil
+}
+
+// IsRevoked checks if the ID has been revoked.
+func (self *MemoryRevocationStore) IsRevoked(id string) (bool, error) {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    revokedUntil, ok := self.revoked[id]
+    return ok && self.now().Before(revokedUntil), nil
+}
+
+// TokenPair is an access token along with the refresh token that can be
+// exchanged for the next pair.
+type TokenPair struct {
+    AccessToken string `json:"access_token"`
+    RefreshToken string `json:"refresh_token"`
+    TokenType string `json:"token_type"`
+    ExpiresIn int64 `json:"expires_in"`
+}
+
+// RefreshManager issues access and refresh token pairs and rotates refresh
+// tokens; every refresh token can only be exchanged once. If a refresh token
+// is used again (a sign it was stolen) every token in its family is revoked.
+type RefreshManager struct {
+    signer *Signer
+    verifier *Verifier
+    store RevocationStore
+    refreshLifetime time.Duration
+}
+
+// RefreshOption is an option for a RefreshManager.
+type RefreshOption func(*RefreshManager)
+
+// WithRefreshLifetime sets how long refresh tokens are valid for.
+func WithRefreshLifetime(lifetime time.Duration) RefreshOption {
+    return func(manager *RefreshManager) {
+        manager.refreshLifetime = lifetime
+    }
+}
+
+// NewRefreshManager creates a RefreshManager that issues tokens with the
+// signer, verifies refresh tokens with the verifier and records rotated
+// refresh tokens in the store.
+func NewRefreshManager(
+    signer *Signer,
+    verifier *Verifier,
+    store RevocationStore,
+    options ...RefreshOption,
+) *RefreshManager {
+    manager := &RefreshManager{
+        signer: signer,
+        verifier: verifier,
+        store: store,
+        refreshLifetime: DefaultRefreshLifetime,
+    }
+    for _, option := range options {
+        option(manager)
+    }
+
+    return manager
+}
+
+// registeredClaims are the claims that are generated for each token rather
+// than carried over when a refresh token is exchanged.
+var registeredClaims = []string{
+    IssuedAtClaim,
+    ExpiresAtClaim,
+    NotBeforeClaim,
+    IdClaim,
+    TokenUseClaim,
+    RefreshFamilyClaim,
+}
+
+func (self RefreshManager) issue(
+    claims Claims, familyId string,
+) (*TokenPair, error) {
+    claims = claims.Copy()
+    for _, claim := range registeredClaims {
+        delete(claims, claim)
+    }
+
+    accessToken, err := self.signer.Sign(claims)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while issuing access token")
+    }
+
+    claims[TokenUseClaim] = RefreshTokenUse
+    claims[RefreshFamilyClaim] = familyId
+    refreshToken, err := self.signer.SignWithLifetime(
+        claims, self.refreshLifetime,
+    )
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while issuing refresh token")
+    }
+
+    return &TokenPair{
+        AccessToken: accessToken,
+        RefreshToken: refreshToken,
+        TokenType: "Bearer",
+        ExpiresIn: int64(self.signer.Lifetime() / time.Second),
+    }, nil
+}
+
+// Issue issues a new access and refresh token pair with the provided claims
+// starting a new refresh family.
+func (self RefreshManager) Issue(claims Claims) (*TokenPair, error) {
+    familyId, err := newTokenId()
+    if err != nil {
+        return nil, err
+    }
+
+    return self.issue(claims, familyId)
+}
+
+// revocationExpiry is how long a token needs to stay revoked.
+func (self RefreshManager) revocationExpiry(claims Claims) time.Time {
+    if expiresAt, ok := claims.ExpiresAt(); ok {
+        return expiresAt
+    }
+
+    return self.signer.now().Add(self.refreshLifetime)
+}
+
+// Refresh exchanges a refresh token for a new token pair with the same
+// claims. The refresh token is revoked in the process and reusing it revokes
+// the whole family, including the pair issued in exchange for it.
+func (self RefreshManager) Refresh(refreshToken string) (*TokenPair, error) {
+    token, err := self.verifier.VerifyRefresh(refreshToken)
+    if err != nil {
+        return nil, err
+    }
+    claims := token.Claims
+    tokenId := claims.String(IdClaim)
+    familyId := claims.String(RefreshFamilyClaim)
+    if tokenId == "" || familyId == "" {
+        return nil, errors.Wrap(
+            ErrMalformed, "Refresh token is missing its jti or family",
+        )
+    }
+
+    familyRevoked, err := self.store.IsRevoked(familyId)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while checking revocation")
+    }
+    if familyRevoked {
+        return nil, ErrRevoked
+    }
+    tokenRevoked, err := self.store.IsRevoked(tokenId)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while checking revocation")
+    }
+    if tokenRevoked {
+        err = self.store.Revoke(
+            familyId, self.signer.now().Add(self.refreshLifetime),
+        )
+        if err != nil {
+            return nil, errors.Wrap(err, "Error while revoking family")
+        }
+        return nil, errors.Wrap(
+            ErrRevoked, "Refresh token was reused so its family is revoked",
+        )
+    }
+
+    err = self.store.Revoke(tokenId, self.revocationExpiry(claims))
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while revoking refresh token")
+    }
+
+    return self.issue(claims, familyId)
+}
+
+// Revoke revokes a refresh token's whole family (ex: when logging out) so
+// none of the tokens in it can be exchanged anymore.
+func (self RefreshManager) Revoke(refreshToken string) error {
+    token, err := self.verifier.VerifyRefresh(refreshToken)
+    if err != nil {
+        return err
+    }
+    familyId := token.Claims.String(RefreshFamilyClaim)
+    if familyId == "" {
+        return errors.Wrap(ErrMalformed, "Refresh token is missing its family")
+    }
+
+    return self.store.Revoke(
+        familyId, self.signer.now().Add(self.refreshLifetime),
+    )
+}
@@ -0,0 +1,115 @@
+package jwt
+
+import (
+    "testing"
+    "time"
+
+    "github.com/pkg/errors"
+    gm "github.com/onsi/gomega"
+)
+
+func newTestRefreshManager(
+    g *gm.GomegaWithT,
+) (*RefreshManager, *Verifier) {
+    secret := []byte("super-secret")
+    signer, err := NewSigner(secret, WithLifetime(5 * time.Minute))
+    g.Expect(err).To(gm.BeNil())
+    verifier, err := NewVerifier(WithHmacKey(secret))
+    g.Expect(err).To(gm.BeNil())
+
+    return NewRefreshManager(
+        signer,
+        verifier,
+        NewMemoryRevocationStore(),
+        WithRefreshLifetime(time.Hour),
+    ), verifier
+}
+
+func TestRefreshRotation(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    manager, verifier := newTestRefreshManager(g)
+
+    pair, err := manager.Issue(Claims{SubjectClaim: "user-1", "role": "admin"})
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(pair.TokenType).To(gm.Equal("Bearer"))
+    g.Expect(pair.ExpiresIn).To(gm.Equal(int64(300)))
+
+    token, err := verifier.Verify(pair.AccessToken)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(token.Claims.String("role")).To(gm.Equal("admin"))
+
+    // Refresh tokens can't be used as access tokens or vice versa.
+    _, err = verifier.Verify(pair.RefreshToken)
+    g.Expect(errors.Is(err, ErrWrongTokenUse)).To(gm.BeTrue())
+    _, err = manager.Refresh(pair.AccessToken)
+    g.Expect(errors.Is(err, ErrWrongTokenUse)).To(gm.BeTrue())
+
+    next, err := manager.Refresh(pair.RefreshToken)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(next.RefreshToken).ToNot(gm.Equal(pair.RefreshToken))
+
+    token, err = verifier.Verify(next.AccessToken)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(token.Claims.Subject()).To(gm.Equal("user-1"))
+    g.Expect(token.Claims.String("role")).To(gm.Equal("admin"))
+    g.Expect(token.Claims.String(RefreshFamilyClaim)).To(gm.BeEmpty())
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    manager, _ := newTestRefreshManager(g)
+
+    pair, err := manager.Issue(Claims{SubjectClaim: "user-1"})
+    g.Expect(err).To(gm.BeNil())
+    next, err := manager.Refresh(pair.RefreshToken)
+    g.Expect(err).To(gm.BeNil())
+
+    _, err = manager.Refresh(pair.RefreshToken)
+    g.Expect(errors.Is(err, ErrRevoked)).To(gm.BeTrue())
+
+    // The legitimately rotated token is revoked along with its family.
+    _, err = manager.Refresh(next.RefreshToken)
+    g.Expect(errors.Is(err, ErrRevoked)).To(gm.BeTrue())
+
+    // Other families are unaffected.
+    other, err := manager.Issue(Claims{SubjectClaim: "user-1"})
+    g.Expect(err).To(gm.BeNil())
+    _, err = manager.Refresh(other.RefreshToken)
+    g.Expect(err).To(gm.BeNil())
+}
+
+func TestRefreshRevoke(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    manager, _ := newTestRefreshManager(g)
+
+    pair, err := manager.Issue(Claims{SubjectClaim: "user-1"})
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(manager.Revoke(pair.RefreshToken)).To(gm.BeNil())
+
+    _, err = manager.Refresh(pair.RefreshToken)
+    g.Expect(errors.Is(err, ErrRevoked)).To(gm.BeTrue())
+}
+
+func TestMemoryRevocationStoreExpiry(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    now := testNow
+    store := NewMemoryRevocationStore()
+    store.now = func() time.Time { return now }
+
+    g.Expect(store.Revoke("a", now.Add(time.Minute))).To(gm.BeNil())
+    revoked, err := store.IsRevoked("a")
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(revoked).To(gm.BeTrue())
+
+    now = now.Add(2 * time.Minute)
+    revoked, err = store.IsRevoked("a")
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(revoked).To(gm.BeFalse())
+
+    g.Expect(store.Revoke("b", now.Add(time.Minute))).To(gm.BeNil())
+    g.Expect(store.revoked).To(gm.HaveLen(1))
+}
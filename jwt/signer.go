@@ -0,0 +1,204 @@
+package jwt
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "time"
+
+    "github.com/pkg/errors"
+)
+
+// DefaultLifetime is how long tokens issued by a Signer are valid for unless
+// another lifetime is set.
+var DefaultLifetime = 15 * time.Minute
+
+// Signer issues signed (and optionally encrypted) tokens.
+type Signer struct {
+    algorithm string
+    key interface{}
+    keyId string
+    encryptionKey []byte
+    issuer string
+    audiences []string
+    lifetime time.Duration
+    now func() time.Time
+}
+
+// SignerOption is an option for a Signer.
+type SignerOption func(*Signer)
+
+// WithKeyId sets the kid header of issued tokens so verifiers can pick the
+// right key while keys are being rotated.
+func WithKeyId(keyId string) SignerOption {
+    return func(signer *Signer) {
+        signer.keyId = keyId
+    }
+}
+
+// WithAlgorithm sets the signing algorithm. By default HS256 is used for
+// secrets, RS256 for RSA keys and the ES algorithm matching the curve for EC
+// keys.
+func WithAlgorithm(algorithm string) SignerOption {
+    return func(signer *Signer) {
+        signer.algorithm = algorithm
+    }
+}
+
+// WithEncryptionKey encrypts issued tokens with the provided key.
+func WithEncryptionKey(key []byte) SignerOption {
+    return func(signer *Signer) {
+        signer.encryptionKey = key
+    }
+}
+
+// WithIssuer sets the iss claim of issued tokens that don't already have
+// one.
+func WithIssuer(issuer string) SignerOption {
+    return func(signer *Signer) {
+        signer.issuer = issuer
+    }
+}
+
+// WithAudience sets the aud claim of issued tokens that don't already have
+// one.
+func WithAudience(audiences ...string) SignerOption {
+    return func(signer *Signer) {
+        signer.audiences = append(signer.audiences, audiences...)
+    }
+}
+
+// WithLifetime sets how long issued tokens are valid for. A lifetime of zero
+// or less issues tokens without an expiry.
+func WithLifetime(lifetime time.Duration) SignerOption {
+    return func(signer *Signer) {
+        signer.lifetime = lifetime
+    }
+}
+
+// WithSignerClock overrides how the current time is determined.
+func WithSignerClock(now func() time.Time) SignerOption {
+    return func(signer *Signer) {
+        signer.now = now
+    }
+}
+
+// NewSigner creates a new Signer which signs with the provided key which is
+// either a []byte secret, an *rsa.PrivateKey or an *ecdsa.PrivateKey.
+func NewSigner(key interface{}, options ...SignerOption) (*Signer, error) {
+    signer := &Signer{
+        key: key,
+        lifetime: DefaultLifetime,
+        now: time.Now,
+    }
+    for _, option := range options {
+        option(signer)
+    }
+    if signer.algorithm == "" {
+        signer.algorithm = defaultAlgorithm(key)
+    }
+    if secret, ok := key.([]byte); ok && len(secret) == 0 {
+        return nil, errors.New("A signing secret can't be empty")
+    }
+
+    // NOTE: Sign a throwaway input so a key that can't be used with the
+    //       algorithm fails now rather than when the first token is issued.
+    _, err := sign(signer.algorithm, key, "")
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while creating signer")
+    }
+    if len(signer.encryptionKey) != 0 {
+        _, err = Encrypt("", signer.encryptionKey)
+        if err != nil {
+            return nil, errors.Wrap(err, "Error while creating signer")
+        }
+    }
+
+    return signer, nil
+}
+
+// Algorithm is the algorithm tokens are signed with.
+func (self Signer) Algorithm() string {
+    return self.algorithm
+}
+
+// Lifetime is how long issued tokens are valid for.
+func (self Signer) Lifetime() time.Duration {
+    return self.lifetime
+}
+
+// newTokenId generates a random jti.
+func newTokenId() (string, error) {
+    id := make([]byte, 16)
+    _, err := rand.Read(id)
+    if err != nil {
+        return "", errors.Wrap(err, "Error while generating token ID")
+    }
+
+    return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// Sign issues a token with the provided claims. The iat and jti claims are
+// always set and the iss, aud and exp claims are set from the signer unless
+// they're already present.
+func (self Signer) Sign(claims Claims) (string, error) {
+    return self.SignWithLifetime(claims, self.lifetime)
+}
+
+// SignWithLifetime is Sign with a lifetime other than the signer's.
+func (self Signer) SignWithLifetime(
+    claims Claims, lifetime time.Duration,
+) (string, error) {
+    now := self.now()
+    claims = claims.Copy()
+
+    claims[IssuedAtClaim] = now.Unix()
+    if _, ok := claims[IdClaim]; !ok {
+        tokenId, err := newTokenId()
+        if err != nil {
+            return "", err
+        }
+        claims[IdClaim] = tokenId
+    }
+    if _, ok := claims[ExpiresAtClaim]; !ok && lifetime > 0 {
+        claims[ExpiresAtClaim] = now.Add(lifetime).Unix()
+    }
+    if _, ok := claims[IssuerClaim]; !ok && self.issuer != "" {
+        claims[IssuerClaim] = self.issuer
+    }
+    if _, ok := claims[AudienceClaim]; !ok && len(self.audiences) != 0 {
+        if len(self.audiences) == 1 {
+            claims[AudienceClaim] = self.audiences[0]
+        } else {
+            claims[AudienceClaim] = self.audiences
+        }
+    }
+
+    headerData, err := json.Marshal(Header{
+        Algorithm: self.algorithm,
+        Type: "JWT",
+        KeyId: self.keyId,
+    })
+    if err != nil {
+        return "", errors.Wrap(err, "Error while encoding header")
+    }
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", errors.Wrap(err, "Error while encoding claims")
+    }
+
+    signingInput := base64.RawURLEncoding.EncodeToString(headerData) + "." +
+        base64.RawURLEncoding.EncodeToString(payload)
+    signature, err := sign(self.algorithm, self.key, signingInput)
+    if err != nil {
+        return "", errors.Wrap(err, "Error while signing token")
+    }
+    token := signingInput + "." +
+        base64.RawURLEncoding.EncodeToString(signature)
+
+    if len(self.encryptionKey) != 0 {
+        return Encrypt(token, self.encryptionKey)
+    }
+
+    return token, nil
+}
@@ -0,0 +1,181 @@
+package jwt
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/pem"
+    "testing"
+    "time"
+
+    "github.com/pkg/errors"
+    gm "github.com/onsi/gomega"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    g.Expect(err).To(gm.BeNil())
+    ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+    g.Expect(err).To(gm.BeNil())
+    secret := []byte("super-secret")
+
+    keySet := NewKeySet(
+        Key{Id: "rsa-2", PublicKey: &rsaKey.PublicKey},
+        Key{Id: "ec-1", PublicKey: &ecKey.PublicKey},
+    )
+    verifier, err := NewVerifier(
+        WithHmacKey(secret), WithKeySet(keySet), WithClock(testClock),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    signers := []struct{
+        key interface{}
+        options []SignerOption
+        algorithm string
+    }{
+        {secret, nil, HS256},
+        {secret, []SignerOption{WithAlgorithm(HS512)}, HS512},
+        {rsaKey, []SignerOption{WithKeyId("rsa-2")}, RS256},
+        {ecKey, []SignerOption{WithKeyId("ec-1")}, ES384},
+    }
+    for _, signerCase := range signers {
+        signer, err := NewSigner(signerCase.key, append(
+            signerCase.options, WithSignerClock(testClock),
+        )...)
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(signer.Algorithm()).To(gm.Equal(signerCase.algorithm))
+
+        raw, err := signer.Sign(Claims{SubjectClaim: "user-1", "role": "admin"})
+        g.Expect(err).To(gm.BeNil())
+
+        token, err := verifier.Verify(raw)
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(token.Header.Algorithm).To(gm.Equal(signerCase.algorithm))
+        g.Expect(token.Claims.Subject()).To(gm.Equal("user-1"))
+        g.Expect(token.Claims.String("role")).To(gm.Equal("admin"))
+        g.Expect(token.Claims.Id()).ToNot(gm.BeEmpty())
+    }
+}
+
+func TestSignerClaims(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    secret := []byte("super-secret")
+    signer, err := NewSigner(
+        secret,
+        WithSignerClock(testClock),
+        WithLifetime(time.Hour),
+        WithIssuer("https://issuer.example.com"),
+        WithAudience("api"),
+    )
+    g.Expect(err).To(gm.BeNil())
+    verifier, err := NewVerifier(
+        WithHmacKey(secret),
+        WithClock(testClock),
+        WithIssuers("https://issuer.example.com"),
+        WithAudiences("api"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    raw, err := signer.Sign(Claims{SubjectClaim: "user-1"})
+    g.Expect(err).To(gm.BeNil())
+    token, err := verifier.Verify(raw)
+    g.Expect(err).To(gm.BeNil())
+
+    issuedAt, _ := token.Claims.IssuedAt()
+    g.Expect(issuedAt).To(gm.Equal(testNow))
+    expiresAt, _ := token.Claims.ExpiresAt()
+    g.Expect(expiresAt).To(gm.Equal(testNow.Add(time.Hour)))
+
+    // Claims that are provided aren't overridden.
+    raw, err = signer.Sign(Claims{
+        ExpiresAtClaim: testNow.Add(time.Minute).Unix(),
+        AudienceClaim: "other",
+    })
+    g.Expect(err).To(gm.BeNil())
+    _, err = verifier.Verify(raw)
+    g.Expect(errors.Is(err, ErrInvalidAudience)).To(gm.BeTrue())
+
+    raw, err = signer.SignWithLifetime(Claims{}, 0)
+    g.Expect(err).To(gm.BeNil())
+    token, err = verifier.Verify(raw)
+    g.Expect(err).To(gm.BeNil())
+    _, ok := token.Claims.ExpiresAt()
+    g.Expect(ok).To(gm.BeFalse())
+}
+
+func TestSignerEncryption(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    secret := []byte("super-secret")
+    for _, size := range []int{16, 24, 32} {
+        encryptionKey := make([]byte, size)
+        _, err := rand.Read(encryptionKey)
+        g.Expect(err).To(gm.BeNil())
+
+        signer, err := NewSigner(secret, WithEncryptionKey(encryptionKey))
+        g.Expect(err).To(gm.BeNil())
+        verifier, err := NewVerifier(
+            WithHmacKey(secret), WithDecryptionKey(encryptionKey),
+        )
+        g.Expect(err).To(gm.BeNil())
+
+        raw, err := signer.Sign(Claims{SubjectClaim: "user-1"})
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(IsEncrypted(raw)).To(gm.BeTrue())
+
+        token, err := verifier.Verify(raw)
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(token.Encrypted).To(gm.BeTrue())
+        g.Expect(token.Claims.Subject()).To(gm.Equal("user-1"))
+    }
+
+    _, err := NewSigner(secret, WithEncryptionKey([]byte("short")))
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestNewSignerInvalidKey(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    g.Expect(err).To(gm.BeNil())
+
+    _, err = NewSigner([]byte{})
+    g.Expect(err).ToNot(gm.BeNil())
+    _, err = NewSigner(rsaKey, WithAlgorithm(ES256))
+    g.Expect(err).ToNot(gm.BeNil())
+    _, err = NewSigner("not a key")
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestParsePrivateKey(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    g.Expect(err).To(gm.BeNil())
+    ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    g.Expect(err).To(gm.BeNil())
+
+    pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+    g.Expect(err).To(gm.BeNil())
+    sec1, err := x509.MarshalECPrivateKey(ecKey)
+    g.Expect(err).To(gm.BeNil())
+
+    blocks := []*pem.Block{
+        {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+        {Type: "PRIVATE KEY", Bytes: pkcs8},
+        {Type: "EC PRIVATE KEY", Bytes: sec1},
+    }
+    for _, block := range blocks {
+        key, err := ParsePrivateKey(pem.EncodeToMemory(block))
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(key).ToNot(gm.BeNil())
+    }
+
+    _, err = ParsePrivateKey([]byte("not pem"))
+    g.Expect(err).ToNot(gm.BeNil())
+}
@@ -0,0 +1,321 @@
+package jwt
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "strings"
+    "time"
+
+    "github.com/pkg/errors"
+)
+
+// DefaultClockSkew is how much leeway is given when checking the exp and nbf
+// claims to account for clocks that are slightly out of sync.
+var DefaultClockSkew = time.Minute
+
+// Token is a verified token.
+type Token struct {
+    Header Header
+    Claims Claims
+    // Encrypted is true if the token was a JWE wrapping a signed token.
+    Encrypted bool
+    Raw string
+}
+
+// Verifier verifies tokens and validates their claims.
+type Verifier struct {
+    hmacKey []byte
+    keySet *KeySet
+    decryptionKey []byte
+    issuers []string
+    audiences []string
+    clockSkew time.Duration
+    algorithms map[string]bool
+    requireEncryption bool
+    now func() time.Time
+}
+
+// VerifierOption is an option for a Verifier.
+type VerifierOption func(*Verifier)
+
+// WithHmacKey verifies HS256/384/512 tokens with the provided secret.
+func WithHmacKey(key []byte) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.hmacKey = key
+    }
+}
+
+// WithKeySet verifies RS* and ES* tokens with the keys in the provided set.
+func WithKeySet(keySet *KeySet) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.keySet = keySet
+    }
+}
+
+// WithDecryptionKey decrypts encrypted (JWE) tokens with the provided key.
+// Without one encrypted tokens are rejected.
+func WithDecryptionKey(key []byte) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.decryptionKey = key
+    }
+}
+
+// RequireEncryption rejects tokens that aren't encrypted.
+func RequireEncryption() VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.requireEncryption = true
+    }
+}
+
+// WithIssuers only accepts tokens issued by one of the provided issuers.
+func WithIssuers(issuers ...string) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.issuers = append(verifier.issuers, issuers...)
+    }
+}
+
+// WithAudiences only accepts tokens intended for at least one of the
+// provided audiences.
+func WithAudiences(audiences ...string) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.audiences = append(verifier.audiences, audiences...)
+    }
+}
+
+// WithClockSkew sets how much leeway is given when checking exp and nbf.
+func WithClockSkew(skew time.Duration) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.clockSkew = skew
+    }
+}
+
+// WithAlgorithms restricts the signing algorithms that are accepted. By
+// default any algorithm there is a key for is accepted.
+func WithAlgorithms(algorithms ...string) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.algorithms = make(map[string]bool, len(algorithms))
+        for _, algorithm := range algorithms {
+            verifier.algorithms[algorithm] = true
+        }
+    }
+}
+
+// WithClock overrides how the current time is determined.
+func WithClock(now func() time.Time) VerifierOption {
+    return func(verifier *Verifier) {
+        verifier.now = now
+    }
+}
+
+// NewVerifier creates a new Verifier. At least one of an HMAC key or a key
+// set must be provided.
+func NewVerifier(options ...VerifierOption) (*Verifier, error) {
+    verifier := &Verifier{
+        clockSkew: DefaultClockSkew,
+        now: time.Now,
+    }
+    for _, option := range options {
+        option(verifier)
+    }
+
+    if len(verifier.hmacKey) == 0 && verifier.keySet == nil {
+        return nil, errors.New(
+            "A HMAC key or key set is required to verify tokens",
+        )
+    }
+    if verifier.requireEncryption && len(verifier.decryptionKey) == 0 {
+        return nil, errors.New(
+            "A decryption key is required when requiring encryption",
+        )
+    }
+
+    return verifier, nil
+}
+
+// Verify decrypts (if needed) and verifies a compact serialized access token
+// and validates its claims. Refresh tokens are rejected.
+func (self Verifier) Verify(token string) (*Token, error) {
+    return self.verify(token, AccessTokenUse)
+}
+
+// VerifyRefresh is Verify for refresh tokens. Only tokens issued as refresh
+// tokens are accepted.
+func (self Verifier) VerifyRefresh(token string) (*Token, error) {
+    return self.verify(token, RefreshTokenUse)
+}
+
+func (self Verifier) verify(token, use string) (*Token, error) {
+    encrypted := IsEncrypted(token)
+    signed := token
+    if encrypted {
+        if len(self.decryptionKey) == 0 {
+            return nil, errors.Wrap(
+                ErrKeyNotFound, "No key to decrypt encrypted tokens",
+            )
+        }
+        header, plaintext, err := decrypt(token, self.decryptionKey)
+        if err != nil {
+            return nil, err
+        }
+        if header.ContentType != "" &&
+            !strings.EqualFold(header.ContentType, "JWT") {
+            return nil, errors.Wrapf(
+                ErrMalformed,
+                "Encrypted token content type '%s' is not a JWT",
+                header.ContentType,
+            )
+        }
+        signed = string(plaintext)
+    } else if self.requireEncryption {
+        return nil, errors.Wrap(ErrMalformed, "Token must be encrypted")
+    }
+
+    header, claims, err := self.verifySignature(signed)
+    if err != nil {
+        return nil, err
+    }
+
+    err = self.validateClaims(claims)
+    if err != nil {
+        return nil, err
+    }
+    tokenUse := claims.String(TokenUseClaim)
+    if tokenUse == "" {
+        tokenUse = AccessTokenUse
+    }
+    if tokenUse != use {
+        return nil, errors.Wrapf(
+            ErrWrongTokenUse, "Expected %s token but got %s", use, tokenUse,
+        )
+    }
+
+    return &Token{
+        Header: header,
+        Claims: claims,
+        Encrypted: encrypted,
+        Raw: token,
+    }, nil
+}
+
+// verifySignature verifies a compact JWS and decodes its claims.
+func (self Verifier) verifySignature(token string) (Header, Claims, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return Header{}, nil, errors.Wrap(
+            ErrMalformed, "Signed token must have 3 parts",
+        )
+    }
+
+    header, err := decodeHeader(parts[0])
+    if err != nil {
+        return header, nil, err
+    }
+    if self.algorithms != nil && !self.algorithms[header.Algorithm] {
+        return header, nil, errors.Wrapf(
+            ErrUnsupportedAlgorithm, "'%s'", header.Algorithm,
+        )
+    }
+
+    var key interface{}
+    if isHmacAlgorithm(header.Algorithm) {
+        if len(self.hmacKey) == 0 {
+            return header, nil, errors.Wrapf(
+                ErrKeyNotFound, "No HMAC key for '%s'", header.Algorithm,
+            )
+        }
+        key = self.hmacKey
+    } else if self.keySet != nil {
+        found, ok := self.keySet.Lookup(header.KeyId, header.Algorithm)
+        if !ok {
+            return header, nil, errors.Wrapf(
+                ErrKeyNotFound,
+                "No key '%s' for '%s'",
+                header.KeyId,
+                header.Algorithm,
+            )
+        }
+        key = found.PublicKey
+    } else {
+        return header, nil, errors.Wrapf(
+            ErrKeyNotFound, "No key set for '%s'", header.Algorithm,
+        )
+    }
+
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return header, nil, errors.Wrap(
+            ErrMalformed, "Signature is not base64url",
+        )
+    }
+    err = verifySignature(
+        header.Algorithm, key, parts[0] + "." + parts[1], signature,
+    )
+    if err != nil {
+        return header, nil, err
+    }
+
+    payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil {
+        return header, nil, errors.Wrap(
+            ErrMalformed, "Payload is not base64url",
+        )
+    }
+    var claims Claims
+    decoder := json.NewDecoder(bytes.NewReader(payload))
+    decoder.UseNumber()
+    err = decoder.Decode(&claims)
+    if err != nil || claims == nil {
+        return header, nil, errors.Wrap(
+            ErrMalformed, "Payload is not a JSON object",
+        )
+    }
+
+    return header, claims, nil
+}
+
+func containsAny(values, accepted []string) bool {
+    for _, value := range values {
+        for _, acceptedValue := range accepted {
+            if value == acceptedValue {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+// validateClaims validates the exp, nbf, iss and aud claims.
+func (self Verifier) validateClaims(claims Claims) error {
+    now := self.now()
+
+    if _, ok := claims[ExpiresAtClaim]; ok {
+        expiresAt, ok := claims.ExpiresAt()
+        if !ok {
+            return errors.Wrap(ErrMalformed, "exp is not a NumericDate")
+        }
+        if !now.Before(expiresAt.Add(self.clockSkew)) {
+            return ErrExpired
+        }
+    }
+    if _, ok := claims[NotBeforeClaim]; ok {
+        notBefore, ok := claims.NotBefore()
+        if !ok {
+            return errors.Wrap(ErrMalformed, "nbf is not a NumericDate")
+        }
+        if now.Add(self.clockSkew).Before(notBefore) {
+            return ErrNotYetValid
+        }
+    }
+    if len(self.issuers) != 0 &&
+        !containsAny([]string{claims.Issuer()}, self.issuers) {
+        return errors.Wrapf(ErrInvalidIssuer, "'%s'", claims.Issuer())
+    }
+    if len(self.audiences) != 0 &&
+        !containsAny(claims.Audience(), self.audiences) {
+        return ErrInvalidAudience
+    }
+
+    return nil
+}
@@ -0,0 +1,287 @@
+package jwt
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/pkg/errors"
+    gm "github.com/onsi/gomega"
+)
+
+var testNow = time.Unix(1600000000, 0)
+
+func testClock() time.Time {
+    return testNow
+}
+
+func encodeSegment(g *gm.GomegaWithT, value interface{}) string {
+    data, err := json.Marshal(value)
+    g.Expect(err).To(gm.BeNil())
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signTestToken(
+    g *gm.GomegaWithT, header Header, claims Claims, key interface{},
+) string {
+    signingInput := encodeSegment(g, header) + "." + encodeSegment(g, claims)
+    hash := algorithmHashes[header.Algorithm]
+
+    var signature []byte
+    switch typedKey := key.(type) {
+    case []byte:
+        mac := hmac.New(hash.New, typedKey)
+        mac.Write([]byte(signingInput))
+        signature = mac.Sum(nil)
+    case *rsa.PrivateKey:
+        var err error
+        signature, err = rsa.SignPKCS1v15(
+            rand.Reader, typedKey, hash, digest(hash, []byte(signingInput)),
+        )
+        g.Expect(err).To(gm.BeNil())
+    case *ecdsa.PrivateKey:
+        r, s, err := ecdsa.Sign(
+            rand.Reader, typedKey, digest(hash, []byte(signingInput)),
+        )
+        g.Expect(err).To(gm.BeNil())
+        size := ecdsaKeySize(&typedKey.PublicKey)
+        signature = make([]byte, 2 * size)
+        fillBytes(r, signature[:size])
+        fillBytes(s, signature[size:])
+    }
+
+    return signingInput + "." +
+        base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encryptTestToken(
+    g *gm.GomegaWithT, encryption string, key []byte, plaintext string,
+) string {
+    protected := encodeSegment(g, Header{
+        Algorithm: DirectEncryption,
+        Encryption: encryption,
+        ContentType: "JWT",
+    })
+    block, err := aes.NewCipher(key)
+    g.Expect(err).To(gm.BeNil())
+    gcm, err := cipher.NewGCM(block)
+    g.Expect(err).To(gm.BeNil())
+    iv := make([]byte, gcm.NonceSize())
+    _, err = rand.Read(iv)
+    g.Expect(err).To(gm.BeNil())
+
+    sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(protected))
+    ciphertext := sealed[:len(sealed) - gcm.Overhead()]
+    tag := sealed[len(sealed) - gcm.Overhead():]
+
+    encode := base64.RawURLEncoding.EncodeToString
+    return protected + ".." + encode(iv) + "." + encode(ciphertext) + "." +
+        encode(tag)
+}
+
+func validClaims() Claims {
+    return Claims{
+        IssuerClaim: "https://issuer.example.com",
+        SubjectClaim: "user-1",
+        AudienceClaim: []string{"api"},
+        ExpiresAtClaim: testNow.Add(time.Hour).Unix(),
+        NotBeforeClaim: testNow.Add(-time.Hour).Unix(),
+        ScopeClaim: "read write",
+    }
+}
+
+func TestVerifyHmac(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    secret := []byte("super-secret")
+    verifier, err := NewVerifier(WithHmacKey(secret), WithClock(testClock))
+    g.Expect(err).To(gm.BeNil())
+
+    for _, algorithm := range []string{HS256, HS384, HS512} {
+        raw := signTestToken(
+            g, Header{Algorithm: algorithm}, validClaims(), secret,
+        )
+        token, err := verifier.Verify(raw)
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(token.Header.Algorithm).To(gm.Equal(algorithm))
+        g.Expect(token.Claims.Subject()).To(gm.Equal("user-1"))
+        g.Expect(token.Claims.Scopes()).To(gm.Equal(
+            []string{"read", "write"},
+        ))
+        g.Expect(token.Encrypted).To(gm.BeFalse())
+    }
+
+    raw := signTestToken(
+        g, Header{Algorithm: HS256}, validClaims(), []byte("wrong-secret"),
+    )
+    _, err = verifier.Verify(raw)
+    g.Expect(errors.Is(err, ErrInvalidSignature)).To(gm.BeTrue())
+}
+
+func TestVerifyRejectsNone(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    verifier, err := NewVerifier(WithHmacKey([]byte("secret")))
+    g.Expect(err).To(gm.BeNil())
+
+    raw := encodeSegment(g, Header{Algorithm: "none"}) + "." +
+        encodeSegment(g, validClaims()) + "."
+    _, err = verifier.Verify(raw)
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestVerifyKeySet(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    g.Expect(err).To(gm.BeNil())
+    ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    g.Expect(err).To(gm.BeNil())
+
+    keySet := NewKeySet(
+        Key{Id: "rsa", Algorithm: RS256, PublicKey: &rsaKey.PublicKey},
+        Key{Id: "ec", Algorithm: ES256, PublicKey: &ecKey.PublicKey},
+    )
+    verifier, err := NewVerifier(WithKeySet(keySet), WithClock(testClock))
+    g.Expect(err).To(gm.BeNil())
+
+    raw := signTestToken(
+        g, Header{Algorithm: RS256, KeyId: "rsa"}, validClaims(), rsaKey,
+    )
+    _, err = verifier.Verify(raw)
+    g.Expect(err).To(gm.BeNil())
+
+    raw = signTestToken(
+        g, Header{Algorithm: ES256, KeyId: "ec"}, validClaims(), ecKey,
+    )
+    _, err = verifier.Verify(raw)
+    g.Expect(err).To(gm.BeNil())
+
+    // NOTE: Only one key can be used for ES256 so no kid is needed.
+    raw = signTestToken(g, Header{Algorithm: ES256}, validClaims(), ecKey)
+    _, err = verifier.Verify(raw)
+    g.Expect(err).To(gm.BeNil())
+
+    raw = signTestToken(
+        g, Header{Algorithm: RS256, KeyId: "unknown"}, validClaims(), rsaKey,
+    )
+    _, err = verifier.Verify(raw)
+    g.Expect(errors.Is(err, ErrKeyNotFound)).To(gm.BeTrue())
+
+    // An HMAC token can't be verified with a key set's public keys.
+    raw = signTestToken(
+        g, Header{Algorithm: HS256, KeyId: "rsa"}, validClaims(),
+        []byte("secret"),
+    )
+    _, err = verifier.Verify(raw)
+    g.Expect(errors.Is(err, ErrKeyNotFound)).To(gm.BeTrue())
+}
+
+func TestVerifyClaims(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    secret := []byte("super-secret")
+    verifier, err := NewVerifier(
+        WithHmacKey(secret),
+        WithClock(testClock),
+        WithClockSkew(30 * time.Second),
+        WithIssuers("https://issuer.example.com"),
+        WithAudiences("api", "other-api"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    verify := func(modify func(Claims)) error {
+        claims := validClaims()
+        modify(claims)
+        _, err := verifier.Verify(
+            signTestToken(g, Header{Algorithm: HS256}, claims, secret),
+        )
+        return err
+    }
+
+    g.Expect(verify(func(claims Claims) {
+        claims[ExpiresAtClaim] = testNow.Add(-10 * time.Second).Unix()
+    })).To(gm.BeNil())
+    g.Expect(errors.Is(verify(func(claims Claims) {
+        claims[ExpiresAtClaim] = testNow.Add(-time.Minute).Unix()
+    }), ErrExpired)).To(gm.BeTrue())
+
+    g.Expect(verify(func(claims Claims) {
+        claims[NotBeforeClaim] = testNow.Add(10 * time.Second).Unix()
+    })).To(gm.BeNil())
+    g.Expect(errors.Is(verify(func(claims Claims) {
+        claims[NotBeforeClaim] = testNow.Add(time.Minute).Unix()
+    }), ErrNotYetValid)).To(gm.BeTrue())
+
+    g.Expect(errors.Is(verify(func(claims Claims) {
+        claims[IssuerClaim] = "https://evil.example.com"
+    }), ErrInvalidIssuer)).To(gm.BeTrue())
+
+    g.Expect(verify(func(claims Claims) {
+        claims[AudienceClaim] = "other-api"
+    })).To(gm.BeNil())
+    g.Expect(errors.Is(verify(func(claims Claims) {
+        claims[AudienceClaim] = []string{"unrelated"}
+    }), ErrInvalidAudience)).To(gm.BeTrue())
+}
+
+func TestVerifyEncrypted(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    secret := []byte("super-secret")
+    encryptionKey := make([]byte, 32)
+    _, err := rand.Read(encryptionKey)
+    g.Expect(err).To(gm.BeNil())
+
+    signed := signTestToken(g, Header{Algorithm: HS256}, validClaims(), secret)
+    encrypted := encryptTestToken(g, A256GCM, encryptionKey, signed)
+    g.Expect(IsEncrypted(encrypted)).To(gm.BeTrue())
+
+    verifier, err := NewVerifier(
+        WithHmacKey(secret),
+        WithDecryptionKey(encryptionKey),
+        WithClock(testClock),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    token, err := verifier.Verify(encrypted)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(token.Encrypted).To(gm.BeTrue())
+    g.Expect(token.Claims.Subject()).To(gm.Equal("user-1"))
+
+    wrongKey := make([]byte, 32)
+    _, err = verifier.Verify(
+        encryptTestToken(g, A256GCM, wrongKey, signed),
+    )
+    g.Expect(errors.Is(err, ErrDecryption)).To(gm.BeTrue())
+
+    noDecryption, err := NewVerifier(WithHmacKey(secret))
+    g.Expect(err).To(gm.BeNil())
+    _, err = noDecryption.Verify(encrypted)
+    g.Expect(errors.Is(err, ErrKeyNotFound)).To(gm.BeTrue())
+
+    requireEncryption, err := NewVerifier(
+        WithHmacKey(secret),
+        WithDecryptionKey(encryptionKey),
+        WithClock(testClock),
+        RequireEncryption(),
+    )
+    g.Expect(err).To(gm.BeNil())
+    _, err = requireEncryption.Verify(signed)
+    g.Expect(errors.Is(err, ErrMalformed)).To(gm.BeTrue())
+}
+
+func TestNewVerifierRequiresKey(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    _, err := NewVerifier()
+    g.Expect(err).ToNot(gm.BeNil())
+}
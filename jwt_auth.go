@@ -0,0 +1,522 @@
+package vial
+
+import (
+    "context"
+    "crypto"
+    "encoding/base64"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/daihasso/peechee"
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/jwt"
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// WWWAuthenticateHeader is the header describing how to authenticate that's
+// sent with 401 and 403 responses.
+const WWWAuthenticateHeader = "WWW-Authenticate"
+
+// This is the key that a verified JWT is stored under in the context.
+var JwtTokenContextKey = ContextKey("jwt.token")
+
+type jwtAuthOptions struct {
+    realm string
+    scopes []string
+    optional bool
+    verifierOptions []jwt.VerifierOption
+}
+
+// JwtAuthOption is an option for JWT authentication.
+type JwtAuthOption func(*jwtAuthOptions)
+
+// JwtRealm sets the realm sent in the WWW-Authenticate header.
+func JwtRealm(realm string) JwtAuthOption {
+    return func(options *jwtAuthOptions) {
+        options.realm = realm
+    }
+}
+
+// RequireJwtScopes requires the token to have all of the provided scopes.
+// Tokens without them are responded to with a 403.
+func RequireJwtScopes(scopes ...string) JwtAuthOption {
+    return func(options *jwtAuthOptions) {
+        options.scopes = append(options.scopes, scopes...)
+    }
+}
+
+// JwtOptional lets requests without a bearer token through. Requests with an
+// invalid token are still rejected.
+func JwtOptional() JwtAuthOption {
+    return func(options *jwtAuthOptions) {
+        options.optional = true
+    }
+}
+
+// JwtVerifierOptions adds options to the verifier that's created from the
+// config by EnableJwtAuthentication.
+func JwtVerifierOptions(verifierOptions ...jwt.VerifierOption) JwtAuthOption {
+    return func(options *jwtAuthOptions) {
+        options.verifierOptions = append(
+            options.verifierOptions, verifierOptions...,
+        )
+    }
+}
+
+// jwtEncryptionKey gets the key for decrypting JWE tokens from the config
+// value which is either base64 encoded or the raw key.
+func jwtEncryptionKey(value string) ([]byte, error) {
+    validSize := func(key []byte) bool {
+        return len(key) == 16 || len(key) == 24 || len(key) == 32
+    }
+    encodings := []*base64.Encoding{
+        base64.StdEncoding,
+        base64.RawStdEncoding,
+        base64.URLEncoding,
+        base64.RawURLEncoding,
+    }
+    for _, encoding := range encodings {
+        key, err := encoding.DecodeString(value)
+        if err == nil && validSize(key) {
+            return key, nil
+        }
+    }
+    if validSize([]byte(value)) {
+        return []byte(value), nil
+    }
+
+    return nil, errors.New(
+        "Jwt.EncryptionKey must be a 128, 192 or 256 bit key (optionally " +
+            "base64 encoded)",
+    )
+}
+
+// readJwtFile reads a file referenced by the Jwt section of the config.
+func readJwtFile(
+    pathReader *peechee.PathReader, path, description string,
+) ([]byte, error) {
+    reader, err := pathReader.Read(path)
+    if err != nil {
+        return nil, errors.Wrapf(
+            err, "Error while reading %s at '%s'", description, path,
+        )
+    }
+    data, err := ioutil.ReadAll(reader)
+    if err != nil {
+        return nil, errors.Wrapf(
+            err, "Error while reading %s at '%s'", description, path,
+        )
+    }
+
+    return data, nil
+}
+
+// jwtSigningKey reads the private key at Jwt.SigningKeyPath.
+func jwtSigningKey(
+    config *Config, pathReader *peechee.PathReader,
+) (crypto.Signer, error) {
+    data, err := readJwtFile(
+        pathReader, config.Jwt.SigningKeyPath, "signing key",
+    )
+    if err != nil {
+        return nil, err
+    }
+    key, err := jwt.ParsePrivateKey(data)
+    if err != nil {
+        return nil, errors.Wrapf(
+            err,
+            "Error while parsing signing key at '%s'",
+            config.Jwt.SigningKeyPath,
+        )
+    }
+
+    return key, nil
+}
+
+// NewJwtVerifier creates a verifier from the Jwt section of the config. The
+// JWKS file (if any) is read using the provided PathReader and the public
+// half of the signing key (if any) is trusted as well. Any options provided
+// are applied after the config.
+func NewJwtVerifier(
+    config *Config,
+    pathReader *peechee.PathReader,
+    options ...jwt.VerifierOption,
+) (*jwt.Verifier, error) {
+    var configOptions []jwt.VerifierOption
+    if config.Jwt.HmacKey != "" {
+        configOptions = append(
+            configOptions, jwt.WithHmacKey([]byte(config.Jwt.HmacKey)),
+        )
+    }
+    var keys []jwt.Key
+    if config.Jwt.JwksPath != "" {
+        data, err := readJwtFile(pathReader, config.Jwt.JwksPath, "JWKS")
+        if err != nil {
+            return nil, err
+        }
+        keySet, err := jwt.ParseJwks(data)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, keySet.Keys()...)
+    }
+    if config.Jwt.SigningKeyPath != "" {
+        signingKey, err := jwtSigningKey(config, pathReader)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, jwt.Key{
+            Id: config.Jwt.SigningKeyId,
+            Algorithm: config.Jwt.SigningAlgorithm,
+            PublicKey: signingKey.Public(),
+        })
+    }
+    if config.Jwt.JwksPath != "" || len(keys) != 0 {
+        configOptions = append(
+            configOptions, jwt.WithKeySet(jwt.NewKeySet(keys...)),
+        )
+    }
+    if config.Jwt.EncryptionKey != "" {
+        key, err := jwtEncryptionKey(config.Jwt.EncryptionKey)
+        if err != nil {
+            return nil, err
+        }
+        configOptions = append(configOptions, jwt.WithDecryptionKey(key))
+    }
+    if config.Jwt.Issuer != "" {
+        configOptions = append(
+            configOptions, jwt.WithIssuers(config.Jwt.Issuer),
+        )
+    }
+    if len(config.Jwt.Audience) != 0 {
+        configOptions = append(
+            configOptions, jwt.WithAudiences(config.Jwt.Audience...),
+        )
+    }
+    if config.Jwt.ClockSkewSeconds != 0 {
+        configOptions = append(configOptions, jwt.WithClockSkew(
+            time.Duration(config.Jwt.ClockSkewSeconds) * time.Second,
+        ))
+    }
+
+    verifier, err := jwt.NewVerifier(append(configOptions, options...)...)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while creating JWT verifier")
+    }
+
+    return verifier, nil
+}
+
+// NewJwtSigner creates a signer from the Jwt section of the config so tokens
+// can be issued with the same keys the server verifies them with. The
+// private key at Jwt.SigningKeyPath is used if there is one, otherwise the
+// HMAC key. Tokens are encrypted if there's an encryption key. Any options
+// provided are applied after the config.
+func NewJwtSigner(
+    config *Config,
+    pathReader *peechee.PathReader,
+    options ...jwt.SignerOption,
+) (*jwt.Signer, error) {
+    var key interface{}
+    if config.Jwt.SigningKeyPath != "" {
+        signingKey, err := jwtSigningKey(config, pathReader)
+        if err != nil {
+            return nil, err
+        }
+        key = signingKey
+    } else if config.Jwt.HmacKey != "" {
+        key = []byte(config.Jwt.HmacKey)
+    } else {
+        return nil, errors.New(
+            "Jwt.SigningKeyPath or Jwt.HmacKey is required to issue tokens",
+        )
+    }
+
+    var configOptions []jwt.SignerOption
+    if config.Jwt.SigningAlgorithm != "" {
+        configOptions = append(
+            configOptions, jwt.WithAlgorithm(config.Jwt.SigningAlgorithm),
+        )
+    }
+    if config.Jwt.SigningKeyId != "" {
+        configOptions = append(
+            configOptions, jwt.WithKeyId(config.Jwt.SigningKeyId),
+        )
+    }
+    if config.Jwt.EncryptionKey != "" {
+        encryptionKey, err := jwtEncryptionKey(config.Jwt.EncryptionKey)
+        if err != nil {
+            return nil, err
+        }
+        configOptions = append(
+            configOptions, jwt.WithEncryptionKey(encryptionKey),
+        )
+    }
+    if config.Jwt.Issuer != "" {
+        configOptions = append(
+            configOptions, jwt.WithIssuer(config.Jwt.Issuer),
+        )
+    }
+    if len(config.Jwt.Audience) != 0 {
+        configOptions = append(
+            configOptions, jwt.WithAudience(config.Jwt.Audience...),
+        )
+    }
+    if config.Jwt.LifetimeSeconds != 0 {
+        configOptions = append(configOptions, jwt.WithLifetime(
+            time.Duration(config.Jwt.LifetimeSeconds) * time.Second,
+        ))
+    }
+
+    signer, err := jwt.NewSigner(key, append(configOptions, options...)...)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while creating JWT signer")
+    }
+
+    return signer, nil
+}
+
+// NewJwtRefreshManager creates a refresh manager that issues and rotates
+// token pairs using the signer and verifier from the Jwt section of the
+// config. Rotated refresh tokens are recorded in the provided store.
+func NewJwtRefreshManager(
+    config *Config,
+    pathReader *peechee.PathReader,
+    store jwt.RevocationStore,
+) (*jwt.RefreshManager, error) {
+    signer, err := NewJwtSigner(config, pathReader)
+    if err != nil {
+        return nil, err
+    }
+    verifier, err := NewJwtVerifier(config, pathReader)
+    if err != nil {
+        return nil, err
+    }
+
+    var options []jwt.RefreshOption
+    if config.Jwt.RefreshLifetimeSeconds != 0 {
+        options = append(options, jwt.WithRefreshLifetime(
+            time.Duration(config.Jwt.RefreshLifetimeSeconds) * time.Second,
+        ))
+    }
+
+    return jwt.NewRefreshManager(signer, verifier, store, options...), nil
+}
+
+// bearerToken gets the token from a request's Authorization header.
+func bearerToken(request *http.Request) (string, bool) {
+    authorization := request.Header.Get("Authorization")
+    if len(authorization) < 7 ||
+        !strings.EqualFold(authorization[:7], "Bearer ") {
+        return "", false
+    }
+
+    token := strings.TrimSpace(authorization[7:])
+    return token, token != ""
+}
+
+// bearerChallenge builds a WWW-Authenticate challenge for the Bearer scheme.
+func bearerChallenge(realm string, params ...string) string {
+    var attributes []string
+    if realm != "" {
+        attributes = append(attributes, fmt.Sprintf("realm=%q", realm))
+    }
+    for i := 0; i + 1 < len(params); i += 2 {
+        attributes = append(
+            attributes, fmt.Sprintf("%s=%q", params[i], params[i + 1]),
+        )
+    }
+    if len(attributes) == 0 {
+        return "Bearer"
+    }
+
+    return "Bearer " + strings.Join(attributes, ", ")
+}
+
+// JwtAuthentication creates middleware that requires requests to have a
+// bearer token verified by the provided verifier. Requests without a token
+// or with an invalid one are responded to with a 401 and the verified token
+// is available through Transactor.JwtToken.
+func JwtAuthentication(
+    verifier *jwt.Verifier, options ...JwtAuthOption,
+) Middleware {
+    authOptions := &jwtAuthOptions{}
+    for _, option := range options {
+        option(authOptions)
+    }
+
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            rawToken, ok := bearerToken(&transactor.Request.Request)
+            if !ok {
+                if authOptions.optional {
+                    return next(ctx, transactor)
+                }
+                transactor.SetHeader(
+                    WWWAuthenticateHeader, bearerChallenge(authOptions.realm),
+                )
+                return transactor.Abort(
+                    http.StatusUnauthorized, neterr.UnauthorizedError,
+                )
+            }
+
+            token, err := verifier.Verify(rawToken)
+            if err != nil {
+                transactor.Logger.Debug(
+                    "Rejected bearer token.",
+                    logging.Extras{"reason": err.Error()},
+                )
+                transactor.SetHeader(
+                    WWWAuthenticateHeader,
+                    bearerChallenge(
+                        authOptions.realm, "error", "invalid_token",
+                    ),
+                )
+                return transactor.Abort(
+                    http.StatusUnauthorized, neterr.InvalidTokenError,
+                )
+            }
+
+            if !token.Claims.HasScopes(authOptions.scopes...) {
+                scope := strings.Join(authOptions.scopes, " ")
+                transactor.SetHeader(
+                    WWWAuthenticateHeader,
+                    bearerChallenge(
+                        authOptions.realm,
+                        "error", "insufficient_scope",
+                        "scope", scope,
+                    ),
+                )
+                return transactor.Abort(
+                    http.StatusForbidden,
+                    neterr.ForbiddenError.WithDetail(
+                        "required_scopes", authOptions.scopes,
+                    ),
+                )
+            }
+
+            ctx = context.WithValue(ctx, JwtTokenContextKey, token)
+            transactor.ChangeContext(ctx)
+
+            return next(ctx, transactor)
+        }
+    }
+}
+
+// JwtRolesClaim is the claim JwtAuthenticator reads a Principal's roles
+// from.
+var JwtRolesClaim = "roles"
+
+// JwtAuthenticator is an Authenticator for bearer JWTs. The Principal's ID is
+// the token's subject, its scopes are the token's scopes, its roles are read
+// from the JwtRolesClaim and its attributes are the token's claims. The
+// verified token is also available through Transactor.JwtToken.
+type JwtAuthenticator struct {
+    Verifier *jwt.Verifier
+    Realm string
+}
+
+// Authenticate verifies the bearer token sent with the request.
+func (self JwtAuthenticator) Authenticate(
+    transactor *Transactor,
+) (*Principal, error) {
+    rawToken, ok := bearerToken(&transactor.Request.Request)
+    if !ok {
+        return nil, nil
+    }
+    token, err := self.Verifier.Verify(rawToken)
+    if err != nil {
+        return nil, neterr.InvalidTokenError.Wrap(err)
+    }
+    transactor.ChangeContext(context.WithValue(
+        transactor.Context(), JwtTokenContextKey, token,
+    ))
+
+    return &Principal{
+        Id: token.Claims.Subject(),
+        Roles: token.Claims.Strings(JwtRolesClaim),
+        Scopes: token.Claims.Scopes(),
+        Attributes: token.Claims,
+    }, nil
+}
+
+// Challenge asks for a bearer token, noting if the one provided was invalid.
+func (self JwtAuthenticator) Challenge(err error) string {
+    if err != nil {
+        return bearerChallenge(self.Realm, "error", "invalid_token")
+    }
+
+    return bearerChallenge(self.Realm)
+}
+
+// SecurityScheme describes bearer JWTs as an OpenAPI scheme.
+func (self JwtAuthenticator) SecurityScheme() map[string]interface{} {
+    return map[string]interface{}{
+        "type": "http",
+        "scheme": "bearer",
+        "bearerFormat": "JWT",
+    }
+}
+
+// EnableJwtAuthentication requires a valid bearer token for every route on
+// the server using a verifier created from the Jwt section of the config.
+// To only protect some routes use JwtAuthentication with a RouteGroup or
+// WithMiddleware instead.
+func EnableJwtAuthentication(options ...JwtAuthOption) ServerOption {
+    return func(svOpts *serverOptions) error {
+        authOptions := &jwtAuthOptions{}
+        for _, option := range options {
+            option(authOptions)
+        }
+
+        svOpts.serverMods = append(
+            svOpts.serverMods, func(server *Server) error {
+                verifier, err := NewJwtVerifier(
+                    server.config,
+                    server.PathReader,
+                    authOptions.verifierOptions...,
+                )
+                if err != nil {
+                    return err
+                }
+                server.AddMiddleware(JwtAuthentication(verifier, options...))
+
+                return nil
+            },
+        )
+
+        return nil
+    }
+}
+
+// ContextJwtToken retrieves the verified JWT stored in the provided context
+// if there is one.
+func ContextJwtToken(ctx context.Context) (*jwt.Token, bool) {
+    token, ok := ctx.Value(JwtTokenContextKey).(*jwt.Token)
+    return token, ok
+}
+
+// JwtToken gets the verified JWT for the request (if there is one).
+func (self Transactor) JwtToken() *jwt.Token {
+    token, _ := ContextJwtToken(self.Request.Context())
+    return token
+}
+
+// JwtClaims gets the claims of the verified JWT for the request. It's nil if
+// the request didn't have a verified token.
+func (self Transactor) JwtClaims() jwt.Claims {
+    token := self.JwtToken()
+    if token == nil {
+        return nil
+    }
+
+    return token.Claims
+}
@@ -0,0 +1,304 @@
+package vial
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+    "github.com/daihasso/peechee"
+
+    "github.com/daihasso/vial/jwt"
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+var testJwtSecret = "jwt-test-secret"
+
+func signTestJwt(g *gm.GomegaWithT, claims jwt.Claims) string {
+    encode := func(value interface{}) string {
+        data, err := json.Marshal(value)
+        g.Expect(err).To(gm.BeNil())
+        return base64.RawURLEncoding.EncodeToString(data)
+    }
+    signingInput := encode(jwt.Header{Algorithm: jwt.HS256, Type: "JWT"}) +
+        "." + encode(claims)
+    mac := hmac.New(sha256.New, []byte(testJwtSecret))
+    mac.Write([]byte(signingInput))
+
+    return signingInput + "." +
+        base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newJwtServer(
+    t *testing.T, g *gm.GomegaWithT, options ...JwtAuthOption,
+) *Server {
+    config := newConfig()
+    config.Jwt.HmacKey = testJwtSecret
+    config.Jwt.Issuer = "https://issuer.example.com"
+
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddConfig(config),
+        EnableJwtAuthentication(options...),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/me",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Respond(
+                http.StatusOK,
+                responses.Body(map[string]interface{}{
+                    "subject": transactor.JwtClaims().Subject(),
+                }),
+            )
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func jwtRequest(server *Server, token string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("GET", "/me", nil)
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer " + token)
+    }
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func jwtErrorCode(g *gm.GomegaWithT, rr *httptest.ResponseRecorder) int {
+    var body struct {
+        Errors []struct {
+            Code int
+        }
+    }
+    g.Expect(json.Unmarshal(rr.Body.Bytes(), &body)).To(gm.BeNil())
+    g.Expect(body.Errors).To(gm.HaveLen(1))
+
+    return body.Errors[0].Code
+}
+
+func TestJwtAuthenticationValidToken(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newJwtServer(t, g)
+    rr := jwtRequest(server, signTestJwt(g, jwt.Claims{
+        jwt.IssuerClaim: "https://issuer.example.com",
+        jwt.SubjectClaim: "user-1",
+        jwt.ExpiresAtClaim: time.Now().Add(time.Hour).Unix(),
+    }))
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal(`{"subject":"user-1"}`))
+}
+
+func TestJwtAuthenticationMissingToken(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newJwtServer(t, g, JwtRealm("vial"))
+    rr := jwtRequest(server, "")
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    g.Expect(rr.Header().Get(WWWAuthenticateHeader)).To(
+        gm.Equal(`Bearer realm="vial"`),
+    )
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.UnauthorizedError.Code()),
+    )
+}
+
+func TestJwtAuthenticationInvalidToken(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newJwtServer(t, g)
+    tokens := []string{
+        "not-a-token",
+        signTestJwt(g, jwt.Claims{
+            jwt.IssuerClaim: "https://issuer.example.com",
+            jwt.ExpiresAtClaim: time.Now().Add(-time.Hour).Unix(),
+        }),
+        signTestJwt(g, jwt.Claims{
+            jwt.IssuerClaim: "https://evil.example.com",
+        }),
+    }
+    for _, token := range tokens {
+        rr := jwtRequest(server, token)
+
+        g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+        g.Expect(rr.Header().Get(WWWAuthenticateHeader)).To(
+            gm.Equal(`Bearer error="invalid_token"`),
+        )
+        g.Expect(jwtErrorCode(g, rr)).To(
+            gm.Equal(neterr.InvalidTokenError.Code()),
+        )
+    }
+}
+
+func TestJwtAuthenticationScopes(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newJwtServer(t, g, RequireJwtScopes("profile:read"))
+
+    rr := jwtRequest(server, signTestJwt(g, jwt.Claims{
+        jwt.IssuerClaim: "https://issuer.example.com",
+        jwt.ScopeClaim: "other",
+    }))
+    g.Expect(rr.Code).To(gm.Equal(http.StatusForbidden))
+    g.Expect(rr.Header().Get(WWWAuthenticateHeader)).To(gm.Equal(
+        `Bearer error="insufficient_scope", scope="profile:read"`,
+    ))
+    g.Expect(jwtErrorCode(g, rr)).To(gm.Equal(neterr.ForbiddenError.Code()))
+
+    rr = jwtRequest(server, signTestJwt(g, jwt.Claims{
+        jwt.IssuerClaim: "https://issuer.example.com",
+        jwt.SubjectClaim: "user-1",
+        jwt.ScopeClaim: "other profile:read",
+    }))
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+}
+
+func TestJwtAuthenticationOptional(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+    verifier, err := jwt.NewVerifier(jwt.WithHmacKey([]byte(testJwtSecret)))
+    g.Expect(err).To(gm.BeNil())
+
+    err = server.Group("/", JwtAuthentication(verifier, JwtOptional())).
+        AddController(
+            "/me",
+            FuncHandler("get", func(transactor *Transactor) responses.Data {
+                g.Expect(transactor.JwtToken()).To(gm.BeNil())
+                g.Expect(transactor.JwtClaims()).To(gm.BeNil())
+                return transactor.Respond(http.StatusNoContent)
+            }),
+        )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := jwtRequest(server, "")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+
+    rr = jwtRequest(server, "not-a-token")
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+}
+
+func TestNewJwtVerifierFromConfig(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    directory, err := ioutil.TempDir("", "vial-jwks")
+    g.Expect(err).To(gm.BeNil())
+    defer os.RemoveAll(directory)
+    jwksPath := filepath.Join(directory, "jwks.json")
+    err = ioutil.WriteFile(jwksPath, []byte(`{"keys":[]}`), 0600)
+    g.Expect(err).To(gm.BeNil())
+
+    pathReader := peechee.NewPathReader(peechee.WithFilesystem())
+
+    config := newConfig()
+    config.Jwt.JwksPath = jwksPath
+    config.Jwt.EncryptionKey = base64.StdEncoding.EncodeToString(
+        make([]byte, 32),
+    )
+    _, err = NewJwtVerifier(config, pathReader)
+    g.Expect(err).To(gm.BeNil())
+
+    config.Jwt.EncryptionKey = "too-short"
+    _, err = NewJwtVerifier(config, pathReader)
+    g.Expect(err).ToNot(gm.BeNil())
+
+    config.Jwt.EncryptionKey = ""
+    config.Jwt.JwksPath = filepath.Join(directory, "missing.json")
+    _, err = NewJwtVerifier(config, pathReader)
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestNewJwtSignerFromConfig(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    directory, err := ioutil.TempDir("", "vial-jwt-signing")
+    g.Expect(err).To(gm.BeNil())
+    defer os.RemoveAll(directory)
+
+    ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    g.Expect(err).To(gm.BeNil())
+    keyData, err := x509.MarshalECPrivateKey(ecKey)
+    g.Expect(err).To(gm.BeNil())
+    keyPath := filepath.Join(directory, "signing.pem")
+    err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+        Type: "EC PRIVATE KEY", Bytes: keyData,
+    }), 0600)
+    g.Expect(err).To(gm.BeNil())
+
+    pathReader := peechee.NewPathReader(peechee.WithFilesystem())
+
+    config := newConfig()
+    config.Jwt.SigningKeyPath = keyPath
+    config.Jwt.SigningKeyId = "2020-09"
+    config.Jwt.EncryptionKey = base64.StdEncoding.EncodeToString(
+        make([]byte, 16),
+    )
+    config.Jwt.Issuer = "https://issuer.example.com"
+    config.Jwt.LifetimeSeconds = 60
+
+    signer, err := NewJwtSigner(config, pathReader)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(signer.Algorithm()).To(gm.Equal(jwt.ES256))
+    g.Expect(signer.Lifetime()).To(gm.Equal(time.Minute))
+    verifier, err := NewJwtVerifier(config, pathReader)
+    g.Expect(err).To(gm.BeNil())
+
+    raw, err := signer.Sign(jwt.Claims{jwt.SubjectClaim: "user-1"})
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(jwt.IsEncrypted(raw)).To(gm.BeTrue())
+
+    token, err := verifier.Verify(raw)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(token.Header.KeyId).To(gm.Equal("2020-09"))
+    g.Expect(token.Claims.Issuer()).To(gm.Equal("https://issuer.example.com"))
+
+    _, err = NewJwtSigner(newConfig(), pathReader)
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestNewJwtRefreshManagerFromConfig(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newJwtServer(t, g)
+    manager, err := NewJwtRefreshManager(
+        server.config, server.PathReader, jwt.NewMemoryRevocationStore(),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    pair, err := manager.Issue(jwt.Claims{jwt.SubjectClaim: "user-1"})
+    g.Expect(err).To(gm.BeNil())
+
+    rr := jwtRequest(server, pair.AccessToken)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+
+    // Refresh tokens can't be used to authenticate requests.
+    rr = jwtRequest(server, pair.RefreshToken)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+
+    pair, err = manager.Refresh(pair.RefreshToken)
+    g.Expect(err).To(gm.BeNil())
+    rr = jwtRequest(server, pair.AccessToken)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+}
@@ -6,6 +6,7 @@ import (
 
     "github.com/pkg/errors"
 
+    "github.com/daihasso/vial/neterr"
     "github.com/daihasso/vial/responses"
 )
 
@@ -25,6 +26,96 @@ type PostMiddleWare func(
     context.Context, *Transactor, responses.Data,
 ) (*responses.Data, error)
 
+// Middleware wraps a controller (and any middleware inside of it) with its own
+// logic. It can act before and after calling next, change the context passed
+// to it, replace its response or skip calling it entirely.
+// Middleware can be added for the whole server, a RouteGroup or a single
+// route; server middleware wraps group middleware which wraps route
+// middleware.
+type Middleware func(next RouteControllerCaller) RouteControllerCaller
+
+// chainMiddleware wraps the caller with the provided middleware, the first
+// middleware being the outermost.
+func chainMiddleware(
+    caller RouteControllerCaller, middlewares ...Middleware,
+) RouteControllerCaller {
+    for i := len(middlewares) - 1; i >= 0; i-- {
+        caller = middlewares[i](caller)
+    }
+
+    return caller
+}
+
+// PreActionMiddleware adapts a series of PreMiddleWare into a Middleware. The
+// PreMiddleWare are run in order and the first to return a response (or
+// error) short-circuits the rest of the chain.
+func PreActionMiddleware(middlewares ...PreMiddleWare) Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            for _, middleware := range middlewares {
+                data, newCtx, err := middleware(ctx, transactor)
+                if err != nil {
+                    // NOTE: Coded errors are logged when they're responded
+                    //       with.
+                    if _, ok := neterr.AsCodedError(err); !ok {
+                        transactor.Logger.Exception(
+                            err, "Error in pre-action middleware.",
+                        )
+                    }
+                    return responses.ErrorResponse(err)
+                }
+                if data != nil {
+                    // If we have data from our middleware return early with
+                    // it.
+                    return *data
+                }
+                if newCtx != nil {
+                    transactor.ChangeContext(*newCtx)
+                    ctx = *newCtx
+                }
+            }
+
+            return next(ctx, transactor)
+        }
+    }
+}
+
+// PostActionMiddleware adapts a series of PostMiddleWare into a Middleware.
+// Each PostMiddleWare is given the response from the inner chain and the
+// first to return a response (or error) replaces it.
+func PostActionMiddleware(middlewares ...PostMiddleWare) Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            response := next(ctx, transactor)
+            for _, middleware := range middlewares {
+                data, err := middleware(
+                    transactor.Context(), transactor, response,
+                )
+                if err != nil {
+                    // NOTE: Coded errors are logged when they're responded
+                    //       with.
+                    if _, ok := neterr.AsCodedError(err); !ok {
+                        transactor.Logger.Exception(
+                            err, "Error in post-action middleware.",
+                        )
+                    }
+                    return responses.ErrorResponse(err)
+                }
+                if data != nil {
+                    // If we have data to return early with it.
+                    return *data
+                }
+            }
+
+            return response
+        }
+    }
+}
+
 func DefaultEncryptionHeadersMiddleware() PreMiddleWare {
     return func(
         _ context.Context, transactor *Transactor,
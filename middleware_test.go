@@ -0,0 +1,218 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+)
+
+func recordingMiddleware(name string, calls *[]string) Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            *calls = append(*calls, name + ":before")
+            response := next(ctx, transactor)
+            *calls = append(*calls, name + ":after")
+            return response
+        }
+    }
+}
+
+func TestJoinRoutePath(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    g.Expect(joinRoutePath("", "api")).To(gm.Equal("/api"))
+    g.Expect(joinRoutePath("/api/", "/users/")).To(gm.Equal("/api/users/"))
+    g.Expect(joinRoutePath("/api", "")).To(gm.Equal("/api"))
+    g.Expect(joinRoutePath("", "")).To(gm.Equal("/"))
+}
+
+func TestServerMiddlewareOrder(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var calls []string
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddMiddleware(recordingMiddleware("server", &calls)),
+        AddPreActionMiddleware(func(
+            _ context.Context, transactor *Transactor,
+        ) (*responses.Data, *context.Context, error) {
+            calls = append(calls, "pre")
+            if transactor.Request.Header.Get("Authorization") == "" {
+                data := transactor.Respond(http.StatusUnauthorized)
+                return &data, nil, nil
+            }
+            return nil, nil, nil
+        }),
+        AddPostActionMiddleware(func(
+            _ context.Context, _ *Transactor, _ responses.Data,
+        ) (*responses.Data, error) {
+            calls = append(calls, "post")
+            return nil, nil
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    api := server.Group("/api", recordingMiddleware("group", &calls))
+    v1 := api.Group("v1", recordingMiddleware("subgroup", &calls))
+    err = v1.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            calls = append(calls, "controller")
+            return transactor.Respond(http.StatusOK)
+        }),
+        WithMiddleware(recordingMiddleware("route", &calls)),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    req, err := http.NewRequest("GET", "/api/v1/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Authorization", "yes")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(strings.Join(calls, " ")).To(gm.Equal(
+        "server:before pre group:before subgroup:before route:before " +
+            "controller route:after subgroup:after group:after post " +
+            "server:after",
+    ))
+
+    // A short-circuiting pre-action middleware still passes back through
+    // the server's middleware.
+    calls = nil
+    req, err = http.NewRequest("GET", "/api/v1/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    g.Expect(strings.Join(calls, " ")).To(gm.Equal(
+        "server:before pre server:after",
+    ))
+}
+
+func TestMiddlewareReplacesResponse(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+
+    retries := 0
+    retry := func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            response := next(ctx, transactor)
+            for response.StatusCode == http.StatusServiceUnavailable &&
+                retries < 2 {
+                retries++
+                response = next(ctx, transactor)
+            }
+            return response
+        }
+    }
+    attempts := 0
+    err = server.AddController(
+        "/flaky",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            attempts++
+            if attempts < 3 {
+                return transactor.Respond(http.StatusServiceUnavailable)
+            }
+            return transactor.Respond(http.StatusOK)
+        }),
+        WithMiddleware(retry),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    req, err := http.NewRequest("GET", "/flaky", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(attempts).To(gm.Equal(3))
+}
+
+type finallyCall struct {
+    hadTransactor bool
+    status int
+    err error
+}
+
+func TestFinallyMiddleware(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var calls []finallyCall
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddPreActionMiddleware(func(
+            _ context.Context, transactor *Transactor,
+        ) (*responses.Data, *context.Context, error) {
+            if transactor.Request.URL.Query().Get("deny") != "" {
+                data := transactor.Respond(http.StatusForbidden)
+                return &data, nil, nil
+            }
+            return nil, nil, nil
+        }),
+        AddFinallyMiddleware(
+            func(
+                _ *http.Request, _ *Transactor, _ responses.Data, _ error,
+            ) {
+                panic("finally middleware panics don't stop the others")
+            },
+            func(
+                _ *http.Request,
+                transactor *Transactor,
+                data responses.Data,
+                err error,
+            ) {
+                calls = append(calls, finallyCall{
+                    hadTransactor: transactor != nil,
+                    status: data.StatusCode,
+                    err: err,
+                })
+            },
+        ),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/things/<integer:id>",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            id, _ := transactor.Request.PathInt("id")
+            if id == 0 {
+                panic("zero")
+            }
+            return transactor.Respond(http.StatusOK)
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    for _, path := range []string{
+        "/things/1", "/things/1?deny=1", "/things/nope", "/things/0",
+    } {
+        req, err := http.NewRequest("GET", path, nil)
+        g.Expect(err).To(gm.BeNil())
+        server.muxer.ServeHTTP(httptest.NewRecorder(), req)
+    }
+
+    g.Expect(calls).To(gm.HaveLen(4))
+    g.Expect(calls[0]).To(gm.Equal(finallyCall{true, http.StatusOK, nil}))
+    g.Expect(calls[1]).To(gm.Equal(
+        finallyCall{true, http.StatusForbidden, nil},
+    ))
+    g.Expect(calls[2]).To(gm.Equal(
+        finallyCall{false, http.StatusNotFound, nil},
+    ))
+    g.Expect(calls[3].hadTransactor).To(gm.BeTrue())
+    g.Expect(calls[3].status).To(gm.Equal(http.StatusInternalServerError))
+    g.Expect(calls[3].err).To(gm.MatchError(gm.ContainSubstring("zero")))
+}
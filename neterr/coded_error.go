@@ -5,50 +5,103 @@ package neterr
 
 import (
     "encoding/json"
+    "errors"
+    "fmt"
 )
 
 // CodedError is an error with a code and a message. It is used to standardize
 // error responses for a uniform API consumer experience.
 // Some special errors are Vial-specific errors which are noted by having a
 // true return value from the IsVialError method call.
+// A CodedError can optionally carry the HTTP status it should be responded
+// with, extra details for the requestor and the error that caused it.
+// A message key can be attached for localizing the message (see
+// Translations); the message is then only used as a fallback.
 type CodedError struct {
     code int
     message string
+    messageKey string
     isVialError bool
+    status int
+    // extra is kept behind a pointer so CodedErrors stay comparable (ex: when
+    // compared as errors) even though details and causes aren't.
+    extra *codedErrorExtra
+}
+
+// codedErrorExtra holds the parts of a CodedError that can't be compared.
+type codedErrorExtra struct {
+    details map[string]interface{}
+    cause error
+}
+
+// withExtra returns a copy of the CodedError with the extra provided, an
+// empty extra is dropped entirely.
+func (self CodedError) withExtra(extra codedErrorExtra) CodedError {
+    if extra.details == nil && extra.cause == nil {
+        self.extra = nil
+    } else {
+        self.extra = &extra
+    }
+
+    return self
+}
+
+// copyExtra returns a copy of the CodedError's extra that can be modified.
+func (self CodedError) copyExtra() codedErrorExtra {
+    if self.extra == nil {
+        return codedErrorExtra{}
+    }
+
+    return *self.extra
+}
+
+// cause returns the error that caused the CodedError, if any.
+func (self CodedError) cause() error {
+    if self.extra == nil {
+        return nil
+    }
+
+    return self.extra.cause
 }
 
 type marshalableCodedError struct{
     Code int `json:"code"`
     Message string `json:"message"`
     IsVialError bool `json:"vial_error,omitempty"`
+    Details map[string]interface{} `json:"details,omitempty"`
 }
 
-func (self *CodedError) UnmarshalJSON(data []byte) error {
-    tempStruct := marshalableCodedError{
+func (self CodedError) marshalable() marshalableCodedError {
+    return marshalableCodedError{
         Code: self.code,
         Message: self.message,
         IsVialError: self.isVialError,
+        Details: self.Details(),
     }
+}
+
+func (self *CodedError) fromMarshalable(marshalable marshalableCodedError) {
+    self.code = marshalable.Code
+    self.message = marshalable.Message
+    self.isVialError = marshalable.IsVialError
+    extra := self.copyExtra()
+    extra.details = marshalable.Details
+    *self = self.withExtra(extra)
+}
+
+func (self *CodedError) UnmarshalJSON(data []byte) error {
+    tempStruct := self.marshalable()
     err := json.Unmarshal(data, &tempStruct)
     if err != nil {
         return err
     }
-
-    self.code = tempStruct.Code
-    self.message = tempStruct.Message
-    self.isVialError = tempStruct.IsVialError
+    self.fromMarshalable(tempStruct)
 
     return nil
 }
 
 func (self CodedError) MarshalJSON() ([]byte, error) {
-    return json.Marshal(
-        marshalableCodedError{
-            Code: self.code,
-            Message: self.message,
-            IsVialError: self.isVialError,
-        },
-    )
+    return json.Marshal(self.marshalable())
 }
 
 // Message returns the message attached to the CodedError.
@@ -67,6 +120,114 @@ func (self *CodedError) IsVialError() bool {
     return self.isVialError
 }
 
+// Status returns the HTTP status the CodedError should be responded with or 0
+// if it doesn't have one.
+func (self CodedError) Status() int {
+    return self.status
+}
+
+// Details returns the extra details attached to the CodedError.
+func (self CodedError) Details() map[string]interface{} {
+    if self.extra == nil {
+        return nil
+    }
+
+    return self.extra.details
+}
+
+// Error implements the error interface. The cause, if any, is included.
+func (self CodedError) Error() string {
+    message := fmt.Sprintf("[%d] %s", self.code, self.message)
+    if cause := self.cause(); cause != nil && cause.Error() != self.message {
+        message += ": " + cause.Error()
+    }
+
+    return message
+}
+
+// Unwrap returns the error that caused the CodedError, if any.
+func (self CodedError) Unwrap() error {
+    return self.cause()
+}
+
+// Is reports whether the target is a CodedError with the same code. This
+// allows for checking errors against sentinel CodedErrors with errors.Is
+// regardless of their status, details or cause.
+func (self CodedError) Is(target error) bool {
+    switch other := target.(type) {
+    case CodedError:
+        return self.code == other.code &&
+            self.isVialError == other.isVialError
+    case *CodedError:
+        return other != nil && self.code == other.code &&
+            self.isVialError == other.isVialError
+    }
+
+    return false
+}
+
+// WithStatus returns a copy of the CodedError that is responded with the
+// provided HTTP status.
+func (self CodedError) WithStatus(status int) CodedError {
+    self.status = status
+    return self
+}
+
+// WithDetails returns a copy of the CodedError with the provided details
+// merged into its existing details.
+func (self CodedError) WithDetails(details map[string]interface{}) CodedError {
+    extra := self.copyExtra()
+    merged := make(map[string]interface{}, len(extra.details) + len(details))
+    for key, value := range extra.details {
+        merged[key] = value
+    }
+    for key, value := range details {
+        merged[key] = value
+    }
+    extra.details = merged
+
+    return self.withExtra(extra)
+}
+
+// WithDetail returns a copy of the CodedError with the provided detail added.
+func (self CodedError) WithDetail(key string, value interface{}) CodedError {
+    return self.WithDetails(map[string]interface{}{key: value})
+}
+
+// MessageKey returns the key used to localize the CodedError's message.
+func (self CodedError) MessageKey() string {
+    return self.messageKey
+}
+
+// WithMessageKey returns a copy of the CodedError whose message is localized
+// using the provided key. Its current message is used when there is no
+// translation.
+func (self CodedError) WithMessageKey(key string) CodedError {
+    self.messageKey = key
+    return self
+}
+
+// Wrap returns a copy of the CodedError caused by the provided error.
+func (self CodedError) Wrap(err error) CodedError {
+    extra := self.copyExtra()
+    extra.cause = err
+
+    return self.withExtra(extra)
+}
+
+// AsCodedError finds the first CodedError in the provided error's chain.
+func AsCodedError(err error) (CodedError, bool) {
+    var codedError CodedError
+    if errors.As(err, &codedError) {
+        return codedError, true
+    }
+    var codedErrorPtr *CodedError
+    if errors.As(err, &codedErrorPtr) && codedErrorPtr != nil {
+        return *codedErrorPtr, true
+    }
+
+    return CodedError{}, false
+}
 
 // NewCodedError creates a new CodedError.
 func NewCodedError(code int, message string) CodedError {
@@ -80,9 +241,5 @@ func NewCodedError(code int, message string) CodedError {
 // CodedErrorFromError is a helper that takes a go error and formats it into a
 // proper CodedError.
 func CodedErrorFromError(code int, err error) CodedError {
-    return CodedError{
-        code: code,
-        message: err.Error(),
-        isVialError: false,
-    }
+    return NewCodedError(code, err.Error()).Wrap(err)
 }
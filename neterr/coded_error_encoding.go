@@ -0,0 +1,81 @@
+package neterr
+
+import (
+    "encoding/xml"
+
+    "github.com/vmihailenco/msgpack/v5"
+)
+
+// xmlCodedError is how a CodedError is represented in XML.
+// NOTE: Details are left out since encoding/xml can't marshal maps.
+type xmlCodedError struct{
+    Code int `xml:"code"`
+    Message string `xml:"message"`
+    IsVialError bool `xml:"vial_error,omitempty"`
+    Details map[string]interface{} `xml:"-"`
+}
+
+// yamlCodedError is how a CodedError is represented in YAML.
+type yamlCodedError struct{
+    Code int `yaml:"code"`
+    Message string `yaml:"message"`
+    IsVialError bool `yaml:"vial_error,omitempty"`
+    Details map[string]interface{} `yaml:"details,omitempty"`
+}
+
+// msgpackCodedError is how a CodedError is represented in MessagePack.
+type msgpackCodedError struct{
+    Code int `msgpack:"code"`
+    Message string `msgpack:"message"`
+    IsVialError bool `msgpack:"vial_error,omitempty"`
+    Details map[string]interface{} `msgpack:"details,omitempty"`
+}
+
+func (self *CodedError) UnmarshalXML(
+    decoder *xml.Decoder, start xml.StartElement,
+) error {
+    tempStruct := xmlCodedError(self.marshalable())
+    err := decoder.DecodeElement(&tempStruct, &start)
+    if err != nil {
+        return err
+    }
+    self.fromMarshalable(marshalableCodedError(tempStruct))
+
+    return nil
+}
+
+func (self CodedError) MarshalXML(
+    encoder *xml.Encoder, start xml.StartElement,
+) error {
+    return encoder.EncodeElement(xmlCodedError(self.marshalable()), start)
+}
+
+func (self *CodedError) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    tempStruct := yamlCodedError(self.marshalable())
+    err := unmarshal(&tempStruct)
+    if err != nil {
+        return err
+    }
+    self.fromMarshalable(marshalableCodedError(tempStruct))
+
+    return nil
+}
+
+func (self CodedError) MarshalYAML() (interface{}, error) {
+    return yamlCodedError(self.marshalable()), nil
+}
+
+func (self *CodedError) DecodeMsgpack(decoder *msgpack.Decoder) error {
+    tempStruct := msgpackCodedError(self.marshalable())
+    err := decoder.Decode(&tempStruct)
+    if err != nil {
+        return err
+    }
+    self.fromMarshalable(marshalableCodedError(tempStruct))
+
+    return nil
+}
+
+func (self CodedError) EncodeMsgpack(encoder *msgpack.Encoder) error {
+    return encoder.Encode(msgpackCodedError(self.marshalable()))
+}
@@ -4,8 +4,12 @@ import (
     "testing"
     "errors"
     "encoding/json"
+    "encoding/xml"
+    "fmt"
 
     gm "github.com/onsi/gomega"
+    pkgerrors "github.com/pkg/errors"
+    "gopkg.in/yaml.v2"
 )
 
 func TestNewCodedError(t *testing.T) {
@@ -93,3 +97,115 @@ func TestCodedErrorAccessors(t *testing.T) {
     g.Expect(codedError.Message()).To(gm.Equal("My custom error"))
     g.Expect(codedError.IsVialError()).To(gm.BeFalse())
 }
+
+func TestCodedErrorMarshalXML(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    codedError := NewCodedError(1, "My custom error")
+    marshaled, err := xml.Marshal(codedError)
+    g.Expect(err).To(gm.BeNil())
+
+    g.Expect(marshaled).To(gm.BeEquivalentTo(
+        `<CodedError><code>1</code><message>My custom error</message>` +
+            `</CodedError>`,
+    ))
+
+    unmarshaled := CodedError{}
+    err = xml.Unmarshal(marshaled, &unmarshaled)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(unmarshaled).To(gm.Equal(codedError))
+}
+
+func TestCodedErrorMarshalYAML(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    codedError := newVialError(1, "Framework error")
+    marshaled, err := yaml.Marshal(codedError)
+    g.Expect(err).To(gm.BeNil())
+
+    g.Expect(marshaled).To(gm.BeEquivalentTo(
+        "code: 1\nmessage: Framework error\nvial_error: true\n",
+    ))
+
+    unmarshaled := CodedError{}
+    err = yaml.Unmarshal(marshaled, &unmarshaled)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(unmarshaled).To(gm.Equal(codedError))
+}
+
+func TestCodedErrorIsError(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    sentinel := NewCodedError(7, "Thing not found")
+    cause := errors.New("no rows")
+    var err error = sentinel.WithStatus(404).Wrap(cause)
+    wrapped := fmt.Errorf("Error while getting thing: %w", err)
+
+    g.Expect(err.Error()).To(gm.Equal("[7] Thing not found: no rows"))
+    g.Expect(errors.Is(wrapped, sentinel)).To(gm.BeTrue())
+    g.Expect(errors.Is(wrapped, &sentinel)).To(gm.BeTrue())
+    g.Expect(errors.Is(wrapped, cause)).To(gm.BeTrue())
+    g.Expect(errors.Is(wrapped, NewCodedError(8, "Other"))).To(gm.BeFalse())
+    g.Expect(errors.Is(wrapped, newVialError(7, "Vial"))).To(gm.BeFalse())
+
+    var codedError CodedError
+    g.Expect(errors.As(wrapped, &codedError)).To(gm.BeTrue())
+    g.Expect(codedError.Status()).To(gm.Equal(404))
+
+    found, ok := AsCodedError(pkgerrors.Wrap(err, "Context"))
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(found.Code()).To(gm.Equal(7))
+
+    _, ok = AsCodedError(cause)
+    g.Expect(ok).To(gm.BeFalse())
+
+    fromError := CodedErrorFromError(2, cause)
+    g.Expect(fromError.Error()).To(gm.Equal("[2] no rows"))
+    g.Expect(errors.Unwrap(fromError)).To(gm.Equal(cause))
+}
+
+func TestCodedErrorComparable(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    sentinel := NewCodedError(7, "Thing not found")
+    var detailed error = sentinel.WithDetail("id", 1).Wrap(
+        errors.New("no rows"),
+    )
+    var other error = sentinel.WithDetail("id", 1)
+
+    g.Expect(func() {
+        g.Expect(detailed == other).To(gm.BeFalse())
+        g.Expect(detailed == detailed).To(gm.BeTrue())
+        g.Expect(error(sentinel) == error(NewCodedError(
+            7, "Thing not found",
+        ))).To(gm.BeTrue())
+    }).ToNot(gm.Panic())
+}
+
+func TestCodedErrorDetails(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    original := NewCodedError(1, "Invalid field").WithDetail("field", "name")
+    codedError := original.WithDetails(map[string]interface{}{
+        "reason": "too long",
+    })
+    g.Expect(original.Details()).To(gm.HaveLen(1))
+    g.Expect(codedError.Details()).To(gm.Equal(map[string]interface{}{
+        "field": "name",
+        "reason": "too long",
+    }))
+
+    marshaled, err := json.Marshal(codedError)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(marshaled).To(gm.BeEquivalentTo(
+        `{"code":1,"message":"Invalid field",` +
+            `"details":{"field":"name","reason":"too long"}}`,
+    ))
+
+    marshaled, err = xml.Marshal(codedError)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(marshaled).To(gm.BeEquivalentTo(
+        `<CodedError><code>1</code><message>Invalid field</message>` +
+            `</CodedError>`,
+    ))
+}
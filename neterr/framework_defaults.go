@@ -1,6 +1,7 @@
 package neterr
 
 import (
+    "net/http"
 )
 
 func newVialError(code int, message string) CodedError {
@@ -11,30 +12,200 @@ func newVialError(code int, message string) CodedError {
     }
 }
 
+// defineVialError creates a framework error and registers it in the vial
+// namespace of the DefaultRegistry.
+func defineVialError(code, status int, message string) CodedError {
+    return DefaultRegistry.vial.MustRegister(
+        newVialError(code, message).WithStatus(status),
+    )
+}
+
+// MalformedBodyError is sent when the request body is malformed (ex: its
+// compression is corrupt).
+var MalformedBodyError = defineVialError(
+    24,
+    http.StatusBadRequest,
+    "The request body is malformed.",
+)
+
+// IdempotencyKeyReusedError is sent when an Idempotency-Key is reused for a
+// request that's different from the one it was first used for.
+var IdempotencyKeyReusedError = defineVialError(
+    23,
+    http.StatusUnprocessableEntity,
+    "The Idempotency-Key was already used for a different request.",
+)
+
+// IdempotencyConflictError is sent when a request is made while another
+// request with the same Idempotency-Key is still being handled.
+var IdempotencyConflictError = defineVialError(
+    22,
+    http.StatusConflict,
+    "A request with the same Idempotency-Key is still being handled.",
+)
+
+// DeadlineExceededError is sent when the deadline the requestor sent passes
+// before the request is handled.
+var DeadlineExceededError = defineVialError(
+    21,
+    http.StatusGatewayTimeout,
+    "The request's deadline was exceeded.",
+)
+
+// HandlerTimeoutError is sent when a request takes longer to handle than the
+// route's timeout.
+var HandlerTimeoutError = defineVialError(
+    20,
+    http.StatusServiceUnavailable,
+    "The request took too long to handle.",
+)
+
+// ServiceOverloadedError is sent when a request is shed because the server
+// is handling too many requests.
+var ServiceOverloadedError = defineVialError(
+    19,
+    http.StatusServiceUnavailable,
+    "The service is overloaded, try again later.",
+)
+
+// TooManyRequestsError is sent when a requestor has exceeded their rate
+// limit.
+var TooManyRequestsError = defineVialError(
+    18,
+    http.StatusTooManyRequests,
+    "Too many requests have been made, try again later.",
+)
+
+// InvalidSignatureError is sent when a signed request's (ex: a webhook's)
+// signature is missing, doesn't match or is too old.
+var InvalidSignatureError = defineVialError(
+    17,
+    http.StatusUnauthorized,
+    "The request signature is invalid.",
+)
+
+// InvalidCredentialsError is sent when the credentials provided with a
+// request (ex: an API key or username and password) aren't valid.
+var InvalidCredentialsError = defineVialError(
+    16,
+    http.StatusUnauthorized,
+    "The provided credentials are invalid.",
+)
+
+// ForbiddenError is sent when an authenticated requestor isn't allowed to
+// access a resource (ex: their token is missing a required scope).
+var ForbiddenError = defineVialError(
+    15,
+    http.StatusForbidden,
+    "You are not allowed to access this resource.",
+)
+
+// InvalidTokenError is sent when the bearer token provided with a request
+// can't be verified (ex: its signature is wrong or it has expired).
+var InvalidTokenError = defineVialError(
+    14,
+    http.StatusUnauthorized,
+    "The provided token is invalid.",
+)
+
+// UnauthorizedError is sent when a request requires authentication but none
+// was provided.
+var UnauthorizedError = defineVialError(
+    13,
+    http.StatusUnauthorized,
+    "Authentication is required to access this resource.",
+)
+
+// PanicError is sent when a panic occurs while handling a request.
+var PanicError = defineVialError(
+    12,
+    http.StatusInternalServerError,
+    "An unexpected error occurred while handling the request.",
+)
+
+// PreconditionFailedError is sent when a conditional request's preconditions
+// don't match the current version of the resource.
+var PreconditionFailedError = defineVialError(
+    11,
+    http.StatusPreconditionFailed,
+    "The resource does not match the request's preconditions.",
+)
+
+// RangeNotSatisfiableError is sent when none of the byte ranges requested
+// overlap the file being sent.
+var RangeNotSatisfiableError = defineVialError(
+    10,
+    http.StatusRequestedRangeNotSatisfiable,
+    "None of the requested ranges can be satisfied.",
+)
+
+// FileNotFoundError is sent when a file being sent doesn't exist.
+var FileNotFoundError = defineVialError(
+    9,
+    http.StatusNotFound,
+    "The requested file could not be found.",
+)
+
+// WebSocketOriginNotAllowedError is sent when a WebSocket upgrade is
+// requested from an origin that isn't allowed.
+var WebSocketOriginNotAllowedError = defineVialError(
+    8,
+    http.StatusForbidden,
+    "The origin of the WebSocket upgrade request is not allowed.",
+)
+
+// WebSocketUpgradeError is sent when a request to a WebSocket route is not a
+// valid WebSocket upgrade.
+var WebSocketUpgradeError = defineVialError(
+    7,
+    http.StatusBadRequest,
+    "The request is not a valid WebSocket upgrade.",
+)
+
+// UnsupportedMediaTypeError can be sent when the request body is in a format
+// that can't be decoded.
+var UnsupportedMediaTypeError = defineVialError(
+    6,
+    http.StatusUnsupportedMediaType,
+    "The content type of the request body is not supported.",
+)
+
+// NotAcceptableError is sent when none of the content types the requestor
+// will accept can be produced.
+var NotAcceptableError = defineVialError(
+    5,
+    http.StatusNotAcceptable,
+    "None of the requested content types can be produced.",
+)
+
 // DefaultOptionsHeaderSetError occurs when there is a problem setting the
 // headers in the DefaultOptions route.
-var DefaultOptionsHeaderSetError = newVialError(
+var DefaultOptionsHeaderSetError = defineVialError(
     4,
+    http.StatusInternalServerError,
     "Error while settings headers in options route.",
 )
 
 // SwaggerNotFoundError is an error that occurs when the swagger does not
 // exist.
-var SwaggerNotFoundError = newVialError(
+var SwaggerNotFoundError = defineVialError(
     3,
+    http.StatusNotFound,
     "Couldn't find swagger file.",
 )
 
 // RouteNotSetupError is an error that occurs when a route was called that
 // hasn't been setup.
-var RouteNotSetupError = newVialError(
+var RouteNotSetupError = defineVialError(
     2,
+    http.StatusNotFound,
     "Route specified has not been setup.",
 )
 
 // MethodNotAllowedErrror is send when a method that is not setup is called on
 // a route that exists.
-var MethodNotAllowedError = newVialError(
+var MethodNotAllowedError = defineVialError(
     1,
+    http.StatusMethodNotAllowed,
     "Method not allowed.",
 )
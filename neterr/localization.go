@@ -0,0 +1,182 @@
+package neterr
+
+import (
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "text/template"
+
+    "github.com/pkg/errors"
+)
+
+// languageRange is a single parsed entry from an Accept-Language header.
+type languageRange struct {
+    tag string
+    quality float64
+}
+
+// normalizeLocale lowercases a locale and uses dashes as the separator so
+// "pt_BR" and "pt-br" are the same locale.
+func normalizeLocale(locale string) string {
+    return strings.ToLower(strings.Replace(
+        strings.TrimSpace(locale), "_", "-", -1,
+    ))
+}
+
+// parseAcceptLanguage parses an Accept-Language header into its language
+// ranges ordered by preference. Ranges with a quality of 0 are dropped.
+func parseAcceptLanguage(header string) []languageRange {
+    var ranges []languageRange
+    for _, rawRange := range strings.Split(header, ",") {
+        parts := strings.Split(rawRange, ";")
+        tag := normalizeLocale(parts[0])
+        if tag == "" {
+            continue
+        }
+
+        quality := 1.0
+        for _, param := range parts[1:] {
+            param = strings.TrimSpace(param)
+            if !strings.HasPrefix(param, "q=") {
+                continue
+            }
+            parsed, err := strconv.ParseFloat(param[2:], 64)
+            if err != nil || parsed < 0 || parsed > 1 {
+                parsed = 0
+            }
+            quality = parsed
+        }
+        if quality == 0 {
+            continue
+        }
+
+        ranges = append(ranges, languageRange{tag: tag, quality: quality})
+    }
+    sort.SliceStable(ranges, func(i, j int) bool {
+        return ranges[i].quality > ranges[j].quality
+    })
+
+    return ranges
+}
+
+// Translations holds per-locale message templates for CodedErrors with a
+// message key. Templates use text/template syntax and are executed against
+// the CodedError's details, ex: "User {{.id}} not found.".
+type Translations struct {
+    lock sync.RWMutex
+    defaultLocale string
+    catalogs map[string]map[string]*template.Template
+}
+
+// NewTranslations creates an empty set of translations which falls back to
+// the provided locale when none of the requestor's locales are available.
+func NewTranslations(defaultLocale string) *Translations {
+    return &Translations{
+        defaultLocale: normalizeLocale(defaultLocale),
+        catalogs: make(map[string]map[string]*template.Template),
+    }
+}
+
+// DefaultLocale returns the locale used as a fallback.
+func (self *Translations) DefaultLocale() string {
+    return self.defaultLocale
+}
+
+// Add adds the provided messages (a map of message key to template) to the
+// catalog for a locale. Existing messages for the same keys are replaced.
+func (self *Translations) Add(locale string, messages map[string]string) error {
+    locale = normalizeLocale(locale)
+    parsed := make(map[string]*template.Template, len(messages))
+    for key, message := range messages {
+        // NOTE: Missing details fail rendering so the next locale (or the
+        //       default message) is used instead of "<no value>".
+        messageTemplate, err := template.New(key).Option(
+            "missingkey=error",
+        ).Parse(message)
+        if err != nil {
+            return errors.Wrapf(
+                err,
+                "Error while parsing message '%s' for locale '%s'",
+                key,
+                locale,
+            )
+        }
+        parsed[key] = messageTemplate
+    }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    catalog, ok := self.catalogs[locale]
+    if !ok {
+        catalog = make(map[string]*template.Template, len(parsed))
+        self.catalogs[locale] = catalog
+    }
+    for key, messageTemplate := range parsed {
+        catalog[key] = messageTemplate
+    }
+
+    return nil
+}
+
+// lookup finds the template for a key in a locale, falling back to the
+// locale's base language (ex: "pt" for "pt-br").
+func (self *Translations) lookup(locale, key string) (
+    *template.Template, string, bool,
+) {
+    if messageTemplate, ok := self.catalogs[locale][key]; ok {
+        return messageTemplate, locale, true
+    }
+    if dash := strings.Index(locale, "-"); dash != -1 {
+        base := locale[:dash]
+        if messageTemplate, ok := self.catalogs[base][key]; ok {
+            return messageTemplate, base, true
+        }
+    }
+
+    return nil, "", false
+}
+
+// Localize resolves the CodedError's message for the most preferred locale
+// in the provided Accept-Language header that has a translation, falling back
+// to the default locale. The localized CodedError and the locale used are
+// returned; if the CodedError has no message key or no translation could be
+// found it's returned as-is with an empty locale. The code is never changed.
+func (self *Translations) Localize(
+    codedError CodedError, acceptLanguage string,
+) (CodedError, string) {
+    key := codedError.MessageKey()
+    if key == "" {
+        return codedError, ""
+    }
+
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    locales := make([]string, 0)
+    for _, languageRange := range parseAcceptLanguage(acceptLanguage) {
+        if languageRange.tag != "*" {
+            locales = append(locales, languageRange.tag)
+        }
+    }
+    locales = append(locales, self.defaultLocale)
+
+    for _, locale := range locales {
+        messageTemplate, found, ok := self.lookup(locale, key)
+        if !ok {
+            continue
+        }
+
+        var message strings.Builder
+        err := messageTemplate.Execute(&message, codedError.Details())
+        if err != nil {
+            continue
+        }
+        codedError.message = message.String()
+
+        return codedError, found
+    }
+
+    return codedError, ""
+}
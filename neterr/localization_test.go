@@ -0,0 +1,83 @@
+package neterr
+
+import (
+    "testing"
+
+    gm "github.com/onsi/gomega"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    g.Expect(parseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0, *")).To(
+        gm.Equal([]languageRange{
+            {tag: "fr-ch", quality: 1},
+            {tag: "*", quality: 1},
+            {tag: "fr", quality: 0.9},
+            {tag: "en", quality: 0.8},
+        }),
+    )
+    g.Expect(parseAcceptLanguage("")).To(gm.BeEmpty())
+}
+
+func TestTranslationsLocalize(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    translations := NewTranslations("en")
+    g.Expect(translations.Add("en", map[string]string{
+        "user.not_found": "User {{.id}} was not found.",
+        "user.banned": "User is banned.",
+    })).To(gm.BeNil())
+    g.Expect(translations.Add("pt", map[string]string{
+        "user.not_found": "Usuário {{.id}} não encontrado.",
+    })).To(gm.BeNil())
+    g.Expect(translations.Add("pt_BR", map[string]string{
+        "user.banned": "Usuário banido.",
+    })).To(gm.BeNil())
+    g.Expect(translations.Add("de", map[string]string{
+        "broken": "{{.id",
+    })).ToNot(gm.BeNil())
+
+    notFound := NewCodedError(1001, "User not found.").WithMessageKey(
+        "user.not_found",
+    ).WithDetail("id", 5)
+
+    localized, locale := translations.Localize(notFound, "pt-BR, en;q=0.5")
+    g.Expect(localized.Message()).To(gm.Equal("Usuário 5 não encontrado."))
+    g.Expect(localized.Code()).To(gm.Equal(1001))
+    g.Expect(locale).To(gm.Equal("pt"))
+
+    banned := NewCodedError(1002, "Banned.").WithMessageKey("user.banned")
+    localized, locale = translations.Localize(banned, "pt-BR")
+    g.Expect(localized.Message()).To(gm.Equal("Usuário banido."))
+    g.Expect(locale).To(gm.Equal("pt-br"))
+
+    localized, locale = translations.Localize(notFound, "ja, fr;q=0.2")
+    g.Expect(localized.Message()).To(gm.Equal("User 5 was not found."))
+    g.Expect(locale).To(gm.Equal("en"))
+
+    unknown := NewCodedError(1003, "Fallback.").WithMessageKey("unknown")
+    localized, locale = translations.Localize(unknown, "pt")
+    g.Expect(localized.Message()).To(gm.Equal("Fallback."))
+    g.Expect(locale).To(gm.BeEmpty())
+
+    // Translations missing a detail fall back to the next locale and then
+    // the message itself.
+    g.Expect(translations.Add("fr", map[string]string{
+        "user.not_found": "Utilisateur {{.id}} ({{.name}}) introuvable.",
+    })).To(gm.BeNil())
+    localized, locale = translations.Localize(notFound, "fr, pt;q=0.5")
+    g.Expect(localized.Message()).To(gm.Equal("Usuário 5 não encontrado."))
+    g.Expect(locale).To(gm.Equal("pt"))
+
+    noDetails := NewCodedError(1001, "User not found.").WithMessageKey(
+        "user.not_found",
+    )
+    localized, locale = translations.Localize(noDetails, "en")
+    g.Expect(localized.Message()).To(gm.Equal("User not found."))
+    g.Expect(locale).To(gm.BeEmpty())
+
+    plain := NewCodedError(1004, "Plain.")
+    localized, _ = translations.Localize(plain, "pt")
+    g.Expect(localized).To(gm.Equal(plain))
+}
@@ -0,0 +1,428 @@
+package neterr
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/pkg/errors"
+)
+
+const (
+    // VialCodeMin is the start of the code range reserved for framework
+    // errors.
+    VialCodeMin = 1
+    // VialCodeMax is the end of the code range reserved for framework
+    // errors.
+    VialCodeMax = 999
+
+    vialNamespaceName = "vial"
+)
+
+// CatalogEntry describes a single registered CodedError.
+type CatalogEntry struct {
+    Namespace string `json:"namespace"`
+    Code int `json:"code"`
+    Message string `json:"message"`
+    MessageKey string `json:"message_key,omitempty"`
+    Status int `json:"status,omitempty"`
+    IsVialError bool `json:"vial_error,omitempty"`
+}
+
+// NamespaceInfo describes a registered namespace and its code range.
+type NamespaceInfo struct {
+    Name string `json:"name"`
+    Min int `json:"min"`
+    Max int `json:"max"`
+}
+
+// Namespace is a named range of codes in a Registry. CodedErrors are
+// registered through the namespace their code belongs to.
+type Namespace struct {
+    registry *Registry
+    name string
+    min,
+    max int
+}
+
+// Name returns the name of the namespace.
+func (self Namespace) Name() string {
+    return self.name
+}
+
+// Range returns the first and last code in the namespace.
+func (self Namespace) Range() (int, int) {
+    return self.min, self.max
+}
+
+func (self Namespace) contains(code int) bool {
+    return code >= self.min && code <= self.max
+}
+
+// Register adds the provided CodedError to the catalog. An error is returned
+// if its code is outside of the namespace or already registered.
+func (self *Namespace) Register(codedError CodedError) (CodedError, error) {
+    code := codedError.Code()
+    if !self.contains(code) {
+        return codedError, errors.Errorf(
+            "Code %d is outside of namespace '%s' (%d-%d)",
+            code,
+            self.name,
+            self.min,
+            self.max,
+        )
+    }
+    isVialNamespace := self.name == vialNamespaceName
+    if codedError.IsVialError() != isVialNamespace {
+        return codedError, errors.Errorf(
+            "Code %d can't be registered in namespace '%s', only vial " +
+                "errors belong in the vial namespace",
+            code,
+            self.name,
+        )
+    }
+
+    registry := self.registry
+    registry.lock.Lock()
+    defer registry.lock.Unlock()
+
+    if existing, ok := registry.entries[code]; ok {
+        return codedError, errors.Errorf(
+            "Code %d is already registered in namespace '%s' as '%s'",
+            code,
+            existing.Namespace,
+            existing.Message,
+        )
+    }
+    registry.entries[code] = CatalogEntry{
+        Namespace: self.name,
+        Code: code,
+        Message: codedError.Message(),
+        MessageKey: codedError.MessageKey(),
+        Status: codedError.Status(),
+        IsVialError: codedError.IsVialError(),
+    }
+    registry.codedErrors[code] = codedError.Wrap(nil)
+
+    return codedError, nil
+}
+
+// MustRegister is like Register but panics if the CodedError can't be
+// registered. It's meant for declaring package-level errors so that
+// duplicates are caught at init:
+//     var ThingNotFound = api.MustRegister(
+//         neterr.NewCodedError(1001, "Thing not found.").WithStatus(404),
+//     )
+func (self *Namespace) MustRegister(codedError CodedError) CodedError {
+    codedError, err := self.Register(codedError)
+    if err != nil {
+        panic(err)
+    }
+
+    return codedError
+}
+
+// Registry is a catalog of CodedErrors grouped into namespaced code ranges.
+// The range VialCodeMin-VialCodeMax is always reserved for vial itself.
+type Registry struct {
+    lock sync.RWMutex
+    namespaces []*Namespace
+    entries map[int]CatalogEntry
+    codedErrors map[int]CodedError
+    vial *Namespace
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+    registry := &Registry{
+        entries: make(map[int]CatalogEntry),
+        codedErrors: make(map[int]CodedError),
+    }
+    registry.vial = registry.MustNamespace(
+        vialNamespaceName, VialCodeMin, VialCodeMax,
+    )
+
+    return registry
+}
+
+// Namespace adds a new namespace covering the codes from min to max
+// (inclusive). The name must be unique and the range can't overlap with any
+// other namespace.
+func (self *Registry) Namespace(name string, min, max int) (
+    *Namespace, error,
+) {
+    if name == "" {
+        return nil, errors.New("Namespace name can't be empty")
+    }
+    if min > max {
+        return nil, errors.Errorf(
+            "Namespace '%s' has an invalid range (%d-%d)", name, min, max,
+        )
+    }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    for _, existing := range self.namespaces {
+        if existing.name == name {
+            return nil, errors.Errorf(
+                "Namespace '%s' is already registered", name,
+            )
+        }
+        if min <= existing.max && existing.min <= max {
+            return nil, errors.Errorf(
+                "Namespace '%s' (%d-%d) overlaps with namespace '%s' (%d-%d)",
+                name,
+                min,
+                max,
+                existing.name,
+                existing.min,
+                existing.max,
+            )
+        }
+    }
+
+    namespace := &Namespace{
+        registry: self,
+        name: name,
+        min: min,
+        max: max,
+    }
+    self.namespaces = append(self.namespaces, namespace)
+
+    return namespace, nil
+}
+
+// MustNamespace is like Namespace but panics if the namespace can't be
+// added.
+func (self *Registry) MustNamespace(name string, min, max int) *Namespace {
+    namespace, err := self.Namespace(name, min, max)
+    if err != nil {
+        panic(err)
+    }
+
+    return namespace
+}
+
+// Lookup finds the registered CodedError with the provided code.
+func (self *Registry) Lookup(code int) (CodedError, bool) {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    codedError, ok := self.codedErrors[code]
+    return codedError, ok
+}
+
+// Namespaces lists the registered namespaces ordered by their range.
+func (self *Registry) Namespaces() []NamespaceInfo {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    infos := make([]NamespaceInfo, 0, len(self.namespaces))
+    for _, namespace := range self.namespaces {
+        infos = append(infos, NamespaceInfo{
+            Name: namespace.name,
+            Min: namespace.min,
+            Max: namespace.max,
+        })
+    }
+    sort.Slice(infos, func(i, j int) bool {
+        return infos[i].Min < infos[j].Min
+    })
+
+    return infos
+}
+
+// Catalog lists all the registered errors ordered by code.
+func (self *Registry) Catalog() []CatalogEntry {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    catalog := make([]CatalogEntry, 0, len(self.entries))
+    for _, entry := range self.entries {
+        catalog = append(catalog, entry)
+    }
+    sort.Slice(catalog, func(i, j int) bool {
+        return catalog[i].Code < catalog[j].Code
+    })
+
+    return catalog
+}
+
+// ExportJSON writes the namespaces and catalog as JSON.
+func (self *Registry) ExportJSON(w io.Writer) error {
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "    ")
+    err := encoder.Encode(struct{
+        Namespaces []NamespaceInfo `json:"namespaces"`
+        Errors []CatalogEntry `json:"errors"`
+    }{
+        Namespaces: self.Namespaces(),
+        Errors: self.Catalog(),
+    })
+    if err != nil {
+        return errors.Wrap(err, "Error while exporting catalog as JSON")
+    }
+
+    return nil
+}
+
+// ExportMarkdown writes the catalog as a Markdown document with a table for
+// each namespace.
+func (self *Registry) ExportMarkdown(w io.Writer) error {
+    catalog := self.Catalog()
+
+    var builder strings.Builder
+    builder.WriteString("# Error Catalog\n")
+    for _, namespace := range self.Namespaces() {
+        fmt.Fprintf(
+            &builder,
+            "\n## %s (%d-%d)\n\n| Code | Status | Message |\n" +
+                "| ---- | ------ | ------- |\n",
+            namespace.Name,
+            namespace.Min,
+            namespace.Max,
+        )
+        for _, entry := range catalog {
+            if entry.Namespace != namespace.Name {
+                continue
+            }
+            status := ""
+            if entry.Status != 0 {
+                status = fmt.Sprintf(
+                    "%d %s", entry.Status, http.StatusText(entry.Status),
+                )
+            }
+            fmt.Fprintf(
+                &builder,
+                "| %d | %s | %s |\n",
+                entry.Code,
+                status,
+                strings.Replace(entry.Message, "|", `\|`, -1),
+            )
+        }
+    }
+
+    _, err := io.WriteString(w, builder.String())
+    if err != nil {
+        return errors.Wrap(err, "Error while exporting catalog as Markdown")
+    }
+
+    return nil
+}
+
+// OpenAPISchemas generates OpenAPI component schemas for the error formats
+// vial responds with: CodedError, ErrorList (the default error body) and
+// Problem (RFC 7807). The code of a CodedError is an enum of all the
+// registered codes with their messages as x-enum-descriptions.
+func (self *Registry) OpenAPISchemas() map[string]interface{} {
+    catalog := self.Catalog()
+    codes := make([]int, 0, len(catalog))
+    descriptions := make([]string, 0, len(catalog))
+    for _, entry := range catalog {
+        codes = append(codes, entry.Code)
+        descriptions = append(descriptions, entry.Message)
+    }
+
+    codeSchema := map[string]interface{}{
+        "type": "integer",
+        "enum": codes,
+        "x-enum-descriptions": descriptions,
+    }
+
+    return map[string]interface{}{
+        "CodedError": map[string]interface{}{
+            "type": "object",
+            "required": []string{"code", "message"},
+            "properties": map[string]interface{}{
+                "code": codeSchema,
+                "message": map[string]interface{}{"type": "string"},
+                "vial_error": map[string]interface{}{"type": "boolean"},
+                "details": map[string]interface{}{
+                    "type": "object",
+                    "additionalProperties": true,
+                },
+            },
+        },
+        "ErrorList": map[string]interface{}{
+            "type": "object",
+            "required": []string{"errors"},
+            "properties": map[string]interface{}{
+                "errors": map[string]interface{}{
+                    "type": "array",
+                    "items": map[string]interface{}{
+                        "$ref": "#/components/schemas/CodedError",
+                    },
+                },
+            },
+        },
+        "Problem": map[string]interface{}{
+            "type": "object",
+            "properties": map[string]interface{}{
+                "type": map[string]interface{}{
+                    "type": "string",
+                    "format": "uri-reference",
+                },
+                "title": map[string]interface{}{"type": "string"},
+                "status": map[string]interface{}{"type": "integer"},
+                "detail": map[string]interface{}{"type": "string"},
+                "instance": map[string]interface{}{
+                    "type": "string",
+                    "format": "uri-reference",
+                },
+                "code": codeSchema,
+                "errors": map[string]interface{}{
+                    "type": "array",
+                    "items": map[string]interface{}{
+                        "$ref": "#/components/schemas/CodedError",
+                    },
+                },
+            },
+        },
+    }
+}
+
+// DefaultRegistry is the registry vial's own errors are registered in and
+// the one used by the package-level registry functions.
+var DefaultRegistry = NewRegistry()
+
+// RegisterNamespace adds a namespace to the DefaultRegistry.
+func RegisterNamespace(name string, min, max int) (*Namespace, error) {
+    return DefaultRegistry.Namespace(name, min, max)
+}
+
+// MustRegisterNamespace adds a namespace to the DefaultRegistry and panics
+// if it can't be added.
+func MustRegisterNamespace(name string, min, max int) *Namespace {
+    return DefaultRegistry.MustNamespace(name, min, max)
+}
+
+// Lookup finds the CodedError with the provided code in the DefaultRegistry.
+func Lookup(code int) (CodedError, bool) {
+    return DefaultRegistry.Lookup(code)
+}
+
+// Catalog lists all the errors in the DefaultRegistry ordered by code.
+func Catalog() []CatalogEntry {
+    return DefaultRegistry.Catalog()
+}
+
+// ExportCatalogJSON writes the DefaultRegistry's catalog as JSON.
+func ExportCatalogJSON(w io.Writer) error {
+    return DefaultRegistry.ExportJSON(w)
+}
+
+// ExportCatalogMarkdown writes the DefaultRegistry's catalog as Markdown.
+func ExportCatalogMarkdown(w io.Writer) error {
+    return DefaultRegistry.ExportMarkdown(w)
+}
+
+// OpenAPIErrorSchemas generates OpenAPI component schemas for the errors in
+// the DefaultRegistry.
+func OpenAPIErrorSchemas() map[string]interface{} {
+    return DefaultRegistry.OpenAPISchemas()
+}
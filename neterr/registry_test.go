@@ -0,0 +1,113 @@
+package neterr
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+)
+
+func TestDefaultRegistryFrameworkErrors(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    codedError, ok := Lookup(MethodNotAllowedError.Code())
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(codedError).To(gm.Equal(MethodNotAllowedError))
+    g.Expect(codedError.Status()).To(gm.Equal(http.StatusMethodNotAllowed))
+
+    for _, entry := range Catalog() {
+        if entry.Code <= VialCodeMax {
+            g.Expect(entry.Namespace).To(gm.Equal("vial"))
+            g.Expect(entry.IsVialError).To(gm.BeTrue())
+        }
+    }
+
+    _, err := RegisterNamespace("mine", 1, 10)
+    g.Expect(err).ToNot(gm.BeNil())
+}
+
+func TestRegistryNamespaces(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    registry := NewRegistry()
+    users, err := registry.Namespace("users", 1000, 1999)
+    g.Expect(err).To(gm.BeNil())
+
+    _, err = registry.Namespace("users", 5000, 5999)
+    g.Expect(err).ToNot(gm.BeNil())
+    _, err = registry.Namespace("orders", 1500, 2500)
+    g.Expect(err).ToNot(gm.BeNil())
+    _, err = registry.Namespace("orders", 3000, 2000)
+    g.Expect(err).ToNot(gm.BeNil())
+
+    userNotFound := users.MustRegister(
+        NewCodedError(1001, "User not found.").WithStatus(404),
+    )
+    _, err = users.Register(NewCodedError(1001, "Another."))
+    g.Expect(err).ToNot(gm.BeNil())
+    _, err = users.Register(NewCodedError(2001, "Out of range."))
+    g.Expect(err).ToNot(gm.BeNil())
+    _, err = registry.vial.Register(NewCodedError(5, "Not a vial error."))
+    g.Expect(err).ToNot(gm.BeNil())
+    g.Expect(func() {
+        users.MustRegister(NewCodedError(1001, "Duplicate."))
+    }).To(gm.Panic())
+
+    found, ok := registry.Lookup(1001)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(found).To(gm.Equal(userNotFound))
+    _, ok = registry.Lookup(1002)
+    g.Expect(ok).To(gm.BeFalse())
+
+    g.Expect(registry.Catalog()).To(gm.Equal([]CatalogEntry{
+        {
+            Namespace: "users",
+            Code: 1001,
+            Message: "User not found.",
+            Status: 404,
+        },
+    }))
+}
+
+func TestRegistryExport(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    registry := NewRegistry()
+    registry.vial.MustRegister(newVialError(1, "Framework | error"))
+    users := registry.MustNamespace("users", 1000, 1999)
+    users.MustRegister(NewCodedError(1001, "User not found.").WithStatus(404))
+
+    var buffer bytes.Buffer
+    g.Expect(registry.ExportJSON(&buffer)).To(gm.BeNil())
+    var exported struct {
+        Namespaces []NamespaceInfo
+        Errors []CatalogEntry
+    }
+    g.Expect(json.Unmarshal(buffer.Bytes(), &exported)).To(gm.BeNil())
+    g.Expect(exported.Namespaces).To(gm.Equal([]NamespaceInfo{
+        {Name: "vial", Min: VialCodeMin, Max: VialCodeMax},
+        {Name: "users", Min: 1000, Max: 1999},
+    }))
+    g.Expect(exported.Errors).To(gm.HaveLen(2))
+
+    buffer.Reset()
+    g.Expect(registry.ExportMarkdown(&buffer)).To(gm.BeNil())
+    g.Expect(buffer.String()).To(gm.Equal(
+        "# Error Catalog\n" +
+            "\n## vial (1-999)\n\n| Code | Status | Message |\n" +
+            "| ---- | ------ | ------- |\n" +
+            "| 1 |  | Framework \\| error |\n" +
+            "\n## users (1000-1999)\n\n| Code | Status | Message |\n" +
+            "| ---- | ------ | ------- |\n" +
+            "| 1001 | 404 Not Found | User not found. |\n",
+    ))
+
+    schemas := registry.OpenAPISchemas()
+    g.Expect(schemas).To(gm.HaveKey("ErrorList"))
+    g.Expect(schemas).To(gm.HaveKey("Problem"))
+    codedErrorSchema := schemas["CodedError"].(map[string]interface{})
+    properties := codedErrorSchema["properties"].(map[string]interface{})
+    g.Expect(properties["code"]).To(gm.HaveKeyWithValue("enum", []int{1, 1001}))
+}
@@ -0,0 +1,466 @@
+package vial
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// Headers describing a requestor's rate limit.
+const (
+    RateLimitLimitHeader = "RateLimit-Limit"
+    RateLimitRemainingHeader = "RateLimit-Remaining"
+    RateLimitResetHeader = "RateLimit-Reset"
+    RateLimitPolicyHeader = "RateLimit-Policy"
+    RetryAfterHeader = "Retry-After"
+)
+
+// RateLimitAlgorithm is how requests are counted against a RateLimit.
+type RateLimitAlgorithm int
+
+const (
+    _ RateLimitAlgorithm = iota
+    // TokenBucket allows bursts of up to Burst requests and refills at Limit
+    // requests per Window.
+    TokenBucket
+    // SlidingWindow allows Limit requests in any Window by weighting the
+    // previous fixed window's count by how much of it overlaps.
+    SlidingWindow
+)
+
+// RateLimit is how many requests a requestor can make.
+type RateLimit struct {
+    Limit int
+    Window time.Duration
+    // Algorithm defaults to TokenBucket.
+    Algorithm RateLimitAlgorithm
+    // Burst is the size of a TokenBucket, it defaults to Limit.
+    Burst int
+}
+
+// PerSecond creates a token bucket RateLimit of limit requests a second.
+func PerSecond(limit int) RateLimit {
+    return RateLimit{Limit: limit, Window: time.Second}
+}
+
+// PerMinute creates a token bucket RateLimit of limit requests a minute.
+func PerMinute(limit int) RateLimit {
+    return RateLimit{Limit: limit, Window: time.Minute}
+}
+
+// PerHour creates a token bucket RateLimit of limit requests an hour.
+func PerHour(limit int) RateLimit {
+    return RateLimit{Limit: limit, Window: time.Hour}
+}
+
+func (self RateLimit) burst() int {
+    if self.Burst > 0 {
+        return self.Burst
+    }
+
+    return self.Limit
+}
+
+// ttl is how long a key's state is needed for after its last request.
+func (self RateLimit) ttl() time.Duration {
+    if self.Algorithm == SlidingWindow {
+        return 2 * self.Window
+    }
+
+    return time.Duration(
+        float64(self.Window) * float64(self.burst()) / float64(self.Limit),
+    )
+}
+
+// policy describes the limit for the RateLimit-Policy header.
+func (self RateLimit) policy() string {
+    window := int64(math.Ceil(self.Window.Seconds()))
+    if self.Algorithm == SlidingWindow {
+        return fmt.Sprintf("%d;w=%d", self.Limit, window)
+    }
+
+    return fmt.Sprintf("%d;w=%d;burst=%d", self.Limit, window, self.burst())
+}
+
+func (self RateLimit) validate() error {
+    if self.Limit <= 0 || self.Window <= 0 {
+        return errors.Errorf(
+            "Rate limit must have a positive limit and window, got %d per %s",
+            self.Limit,
+            self.Window,
+        )
+    }
+    if self.Algorithm != TokenBucket && self.Algorithm != SlidingWindow {
+        return errors.Errorf(
+            "Unknown rate limit algorithm %d", self.Algorithm,
+        )
+    }
+
+    return nil
+}
+
+// rateLimitDecision is the outcome of counting a request against a limit.
+type rateLimitDecision struct {
+    allowed bool
+    remaining int64
+    // reset is how long until the limit is fully replenished (or the current
+    // window ends).
+    reset time.Duration
+    // retryAfter is how long until a rejected request would be allowed.
+    retryAfter time.Duration
+}
+
+// take counts a request made at now against the state.
+func (self RateLimit) take(
+    state RateLimitState, now time.Time,
+) (RateLimitState, rateLimitDecision) {
+    if self.Algorithm == SlidingWindow {
+        return self.takeSlidingWindow(state, now)
+    }
+
+    return self.takeTokenBucket(state, now)
+}
+
+func (self RateLimit) takeTokenBucket(
+    state RateLimitState, now time.Time,
+) (RateLimitState, rateLimitDecision) {
+    capacity := float64(self.burst())
+    perSecond := float64(self.Limit) / self.Window.Seconds()
+    seconds := func(tokens float64) time.Duration {
+        return time.Duration(tokens / perSecond * float64(time.Second))
+    }
+
+    tokens := capacity
+    if !state.Updated.IsZero() {
+        elapsed := math.Max(now.Sub(state.Updated).Seconds(), 0)
+        tokens = math.Min(capacity, state.Tokens + elapsed * perSecond)
+    }
+
+    decision := rateLimitDecision{}
+    if tokens >= 1 {
+        tokens--
+        decision.allowed = true
+    } else {
+        decision.retryAfter = seconds(1 - tokens)
+    }
+    decision.remaining = int64(tokens)
+    decision.reset = seconds(capacity - tokens)
+
+    return RateLimitState{Tokens: tokens, Updated: now}, decision
+}
+
+func (self RateLimit) takeSlidingWindow(
+    state RateLimitState, now time.Time,
+) (RateLimitState, rateLimitDecision) {
+    windowStart := now.Truncate(self.Window)
+    count, previous := state.Count, state.PreviousCount
+    if !state.Updated.Equal(windowStart) {
+        if state.Updated.Equal(windowStart.Add(-self.Window)) {
+            previous = count
+        } else {
+            previous = 0
+        }
+        count = 0
+    }
+
+    elapsed := now.Sub(windowStart)
+    window := float64(self.Window)
+    limit := float64(self.Limit)
+    estimate := float64(previous) * (1 - float64(elapsed) / window) +
+        float64(count)
+
+    decision := rateLimitDecision{reset: self.Window - elapsed}
+    if estimate + 1 <= limit {
+        count++
+        estimate++
+        decision.allowed = true
+    } else if previous > 0 && float64(count) + 1 <= limit {
+        // NOTE: Allowed once enough of the previous window has slid out.
+        overlap := (limit - float64(count) - 1) / float64(previous)
+        decision.retryAfter = time.Duration((1 - overlap) * window) - elapsed
+    } else {
+        // NOTE: This window is full; allowed once enough of it has slid out
+        //       of the next one.
+        overlap := (limit - 1) / float64(count)
+        decision.retryAfter = decision.reset +
+            time.Duration((1 - overlap) * window)
+    }
+    decision.remaining = int64(math.Max(limit - estimate, 0))
+
+    return RateLimitState{
+        Count: count,
+        PreviousCount: previous,
+        Updated: windowStart,
+    }, decision
+}
+
+// RateLimitKeyFunc identifies who a request is counted against. Requests
+// with an empty key aren't rate limited.
+type RateLimitKeyFunc func(*Transactor) string
+
+// RateLimitByIP counts requests against the address they were sent from.
+// Forwarding headers aren't trusted so behind a proxy use RateLimitByHeader
+// with the header the proxy sets instead.
+func RateLimitByIP() RateLimitKeyFunc {
+    return func(transactor *Transactor) string {
+        address := transactor.Request.RemoteAddr
+        if host, _, err := net.SplitHostPort(address); err == nil {
+            address = host
+        }
+        if address == "" {
+            return ""
+        }
+
+        return "ip:" + address
+    }
+}
+
+// RateLimitByHeader counts requests against the value of a header (ex: an
+// API key).
+func RateLimitByHeader(header string) RateLimitKeyFunc {
+    return func(transactor *Transactor) string {
+        value := transactor.Request.Header.Get(header)
+        if value == "" {
+            return ""
+        }
+
+        return "header:" + header + ":" + value
+    }
+}
+
+// RateLimitByPrincipal counts requests against the Principal they were
+// authenticated as. The server's Authenticators run after server wide
+// middleware so this is only useful for routes' rate limits.
+func RateLimitByPrincipal() RateLimitKeyFunc {
+    return func(transactor *Transactor) string {
+        principal := transactor.Principal()
+        if principal == nil || principal.Id == "" {
+            return ""
+        }
+
+        return "principal:" + principal.Scheme + ":" + principal.Id
+    }
+}
+
+// RateLimitByJwtSubject counts requests against the subject of their bearer
+// token. The token must have already been verified by JwtAuthentication.
+func RateLimitByJwtSubject() RateLimitKeyFunc {
+    return func(transactor *Transactor) string {
+        subject := transactor.JwtClaims().Subject()
+        if subject == "" {
+            return ""
+        }
+
+        return "sub:" + subject
+    }
+}
+
+// RateLimitByFirst uses the first of the provided keys that's present (ex:
+// the Principal falling back to the IP).
+func RateLimitByFirst(keyFuncs ...RateLimitKeyFunc) RateLimitKeyFunc {
+    return func(transactor *Transactor) string {
+        for _, keyFunc := range keyFuncs {
+            if key := keyFunc(transactor); key != "" {
+                return key
+            }
+        }
+
+        return ""
+    }
+}
+
+// RateLimiter counts requests against a RateLimit keeping its counts in a
+// RateLimitStore.
+type RateLimiter struct {
+    limit RateLimit
+    store RateLimitStore
+    keyFunc RateLimitKeyFunc
+    name string
+    now func() time.Time
+}
+
+// RateLimiterOption is an option for a RateLimiter.
+type RateLimiterOption func(*RateLimiter)
+
+// WithRateLimitStore sets where counts are kept. Use a shared store to
+// enforce a limit across several servers; the default is a new
+// MemoryRateLimitStore.
+func WithRateLimitStore(store RateLimitStore) RateLimiterOption {
+    return func(limiter *RateLimiter) {
+        limiter.store = store
+    }
+}
+
+// WithRateLimitKey sets who requests are counted against, the default is
+// RateLimitByIP.
+func WithRateLimitKey(keyFunc RateLimitKeyFunc) RateLimiterOption {
+    return func(limiter *RateLimiter) {
+        limiter.keyFunc = keyFunc
+    }
+}
+
+// WithRateLimitName namespaces the limiter's keys so limiters sharing a
+// store are counted separately. Routes' limiters default to the route's path.
+func WithRateLimitName(name string) RateLimiterOption {
+    return func(limiter *RateLimiter) {
+        limiter.name = name
+    }
+}
+
+// WithRateLimitClock overrides how the current time is determined.
+func WithRateLimitClock(now func() time.Time) RateLimiterOption {
+    return func(limiter *RateLimiter) {
+        limiter.now = now
+    }
+}
+
+// NewRateLimiter creates a new RateLimiter for the limit. It panics if the
+// limit isn't valid.
+func NewRateLimiter(
+    limit RateLimit, options ...RateLimiterOption,
+) *RateLimiter {
+    if limit.Algorithm == 0 {
+        limit.Algorithm = TokenBucket
+    }
+    if err := limit.validate(); err != nil {
+        panic(err)
+    }
+
+    limiter := &RateLimiter{
+        limit: limit,
+        keyFunc: RateLimitByIP(),
+        now: time.Now,
+    }
+    for _, option := range options {
+        option(limiter)
+    }
+    if limiter.store == nil {
+        limiter.store = NewMemoryRateLimitStore()
+    }
+
+    return limiter
+}
+
+// take counts a request against the key.
+func (self RateLimiter) take(key string) (rateLimitDecision, error) {
+    if self.name != "" {
+        key = self.name + "|" + key
+    }
+    now := self.now()
+
+    var decision rateLimitDecision
+    err := self.store.Update(
+        key, self.limit.ttl(), func(state RateLimitState) RateLimitState {
+            state, decision = self.limit.take(state, now)
+            return state
+        },
+    )
+    if err != nil {
+        return decision, errors.Wrapf(
+            err, "Error while updating rate limit for '%s'", key,
+        )
+    }
+
+    return decision, nil
+}
+
+// durationSeconds rounds a duration up to whole seconds for a header.
+func durationSeconds(duration time.Duration) string {
+    return strconv.FormatInt(int64(math.Ceil(duration.Seconds())), 10)
+}
+
+// Middleware creates middleware that responds to requests over the limit
+// with a neterr.TooManyRequestsError. Every limited response describes the
+// requestor's limit in the RateLimit headers. If the store fails requests
+// are allowed through.
+func (self *RateLimiter) Middleware() Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            key := self.keyFunc(transactor)
+            if key == "" {
+                return next(ctx, transactor)
+            }
+
+            decision, err := self.take(key)
+            if err != nil {
+                transactor.Logger.Exception(
+                    err, "Error while checking rate limit, allowing request.",
+                )
+                return next(ctx, transactor)
+            }
+
+            builder := transactor.Builder
+            builder.SetHeader(
+                RateLimitLimitHeader, strconv.Itoa(self.limit.Limit),
+            )
+            builder.SetHeader(
+                RateLimitRemainingHeader,
+                strconv.FormatInt(decision.remaining, 10),
+            )
+            builder.SetHeader(
+                RateLimitResetHeader, durationSeconds(decision.reset),
+            )
+            builder.SetHeader(RateLimitPolicyHeader, self.limit.policy())
+            if decision.allowed {
+                return next(ctx, transactor)
+            }
+
+            retryAfter := durationSeconds(decision.retryAfter)
+            if decision.retryAfter < time.Second {
+                retryAfter = "1"
+            }
+            builder.SetHeader(RetryAfterHeader, retryAfter)
+            transactor.Logger.Debug(
+                "Rate limited request.",
+                logging.Extras{"key": key, "retry_after": retryAfter},
+            )
+            return transactor.Abort(
+                http.StatusTooManyRequests,
+                neterr.TooManyRequestsError.WithDetail(
+                    "retry_after", retryAfter,
+                ),
+            )
+        }
+    }
+}
+
+// EnableRateLimit rate limits every request to the server. Server wide
+// middleware runs before authentication so key requests by IP or a header.
+func EnableRateLimit(
+    limit RateLimit, options ...RateLimiterOption,
+) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.middleware = append(
+            svOpts.middleware, NewRateLimiter(limit, options...).Middleware(),
+        )
+
+        return nil
+    }
+}
+
+// WithRateLimit rate limits requests to the route separately from other
+// routes. It runs after authentication so requests can be keyed by
+// RateLimitByPrincipal.
+func WithRateLimit(
+    limit RateLimit, options ...RateLimiterOption,
+) RouteOption {
+    return func(routeOpts *routeOptions) {
+        limiter := NewRateLimiter(limit, options...)
+        routeOpts.rateLimiters = append(routeOpts.rateLimiters, limiter)
+        routeOpts.middleware = append(
+            routeOpts.middleware, limiter.Middleware(),
+        )
+    }
+}
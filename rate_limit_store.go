@@ -0,0 +1,95 @@
+package vial
+
+import (
+    "sync"
+    "time"
+)
+
+// rateLimitSweepInterval is how often a MemoryRateLimitStore forgets about
+// expired keys.
+var rateLimitSweepInterval = time.Minute
+
+// RateLimitState is what's kept for each rate limited key.
+type RateLimitState struct {
+    // Tokens is how many requests are left in a TokenBucket.
+    Tokens float64 `json:"tokens,omitempty"`
+    // Count is how many requests were made in a SlidingWindow's current
+    // window.
+    Count int64 `json:"count,omitempty"`
+    // PreviousCount is how many requests were made in a SlidingWindow's
+    // previous window.
+    PreviousCount int64 `json:"previous_count,omitempty"`
+    // Updated is when a TokenBucket was last refilled or when a
+    // SlidingWindow's current window started.
+    Updated time.Time `json:"updated"`
+}
+
+// RateLimitStore keeps the state of each rate limited key. Implement it to
+// share limits between servers (ex: with Redis or a database).
+//
+// Update must atomically replace the key's state with the result of calling
+// update on it (the zero state if the key has none). The state can be
+// forgotten once ttl has passed without an update. update may be called
+// more than once (ex: when retrying after a conflicting write), only the
+// last result should be stored.
+type RateLimitStore interface {
+    Update(
+        key string,
+        ttl time.Duration,
+        update func(RateLimitState) RateLimitState,
+    ) error
+}
+
+type memoryRateLimitEntry struct {
+    state RateLimitState
+    expiresAt time.Time
+}
+
+// MemoryRateLimitStore is a RateLimitStore that lives in memory. It's meant
+// for tests and single instance deployments since counts are neither shared
+// nor persisted.
+type MemoryRateLimitStore struct {
+    mutex sync.Mutex
+    entries map[string]memoryRateLimitEntry
+    lastSweep time.Time
+    now func() time.Time
+}
+
+// NewMemoryRateLimitStore creates a new, empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+    return &MemoryRateLimitStore{
+        entries: make(map[string]memoryRateLimitEntry),
+        now: time.Now,
+    }
+}
+
+// Update updates the key's state.
+func (self *MemoryRateLimitStore) Update(
+    key string,
+    ttl time.Duration,
+    update func(RateLimitState) RateLimitState,
+) error {
+    self.mutex.Lock()
+    defer self.mutex.Unlock()
+
+    now := self.now()
+    if now.Sub(self.lastSweep) >= rateLimitSweepInterval {
+        for entryKey, entry := range self.entries {
+            if !now.Before(entry.expiresAt) {
+                delete(self.entries, entryKey)
+            }
+        }
+        self.lastSweep = now
+    }
+
+    var state RateLimitState
+    if entry, ok := self.entries[key]; ok && now.Before(entry.expiresAt) {
+        state = entry.state
+    }
+    self.entries[key] = memoryRateLimitEntry{
+        state: update(state),
+        expiresAt: now.Add(ttl),
+    }
+
+    return nil
+}
@@ -0,0 +1,192 @@
+package vial
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func rateLimitController(transactor *Transactor) responses.Data {
+    return transactor.Respond(http.StatusNoContent)
+}
+
+func TestRateLimitTokenBucket(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    now := time.Unix(1600000000, 0)
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        EnableRateLimit(
+            RateLimit{Limit: 1, Window: 2 * time.Second, Burst: 2},
+            WithRateLimitKey(RateLimitByHeader("X-API-Key")),
+            WithRateLimitClock(func() time.Time { return now }),
+        ),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/limited", FuncHandler("get", rateLimitController),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    key := map[string]string{"X-API-Key": "a"}
+    rr := authRequest(server, "/limited", key)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    g.Expect(rr.Header().Get(RateLimitLimitHeader)).To(gm.Equal("1"))
+    g.Expect(rr.Header().Get(RateLimitRemainingHeader)).To(gm.Equal("1"))
+    g.Expect(rr.Header().Get(RateLimitResetHeader)).To(gm.Equal("2"))
+    g.Expect(rr.Header().Get(RateLimitPolicyHeader)).To(
+        gm.Equal("1;w=2;burst=2"),
+    )
+
+    rr = authRequest(server, "/limited", key)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    g.Expect(rr.Header().Get(RateLimitRemainingHeader)).To(gm.Equal("0"))
+
+    rr = authRequest(server, "/limited", key)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusTooManyRequests))
+    g.Expect(rr.Header().Get(RetryAfterHeader)).To(gm.Equal("2"))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.TooManyRequestsError.Code()),
+    )
+
+    // Other keys have their own limit and requests without one aren't
+    // limited.
+    rr = authRequest(server, "/limited", map[string]string{"X-API-Key": "b"})
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    rr = authRequest(server, "/limited", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    g.Expect(rr.Header().Get(RateLimitLimitHeader)).To(gm.Equal(""))
+
+    now = now.Add(2 * time.Second)
+    rr = authRequest(server, "/limited", key)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    g.Expect(rr.Header().Get(RateLimitRemainingHeader)).To(gm.Equal("0"))
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    limit := RateLimit{
+        Limit: 4, Window: 10 * time.Second, Algorithm: SlidingWindow,
+    }
+    start := time.Unix(1600000000, 0)
+    state := RateLimitState{}
+    var decision rateLimitDecision
+    for i := 0; i < 4; i++ {
+        state, decision = limit.take(state, start.Add(8 * time.Second))
+        g.Expect(decision.allowed).To(gm.BeTrue())
+    }
+    state, decision = limit.take(state, start.Add(9 * time.Second))
+    g.Expect(decision.allowed).To(gm.BeFalse())
+    g.Expect(decision.reset).To(gm.Equal(time.Second))
+    // NOTE: The next window starts with all 4 requests counted, 1 has to
+    //       slide out (a quarter of the window) before there's room.
+    g.Expect(decision.retryAfter).To(gm.Equal(3500 * time.Millisecond))
+
+    state, decision = limit.take(state, start.Add(12 * time.Second))
+    g.Expect(decision.allowed).To(gm.BeFalse())
+    state, decision = limit.take(state, start.Add(13 * time.Second))
+    g.Expect(decision.allowed).To(gm.BeTrue())
+    g.Expect(decision.remaining).To(gm.Equal(int64(0)))
+    g.Expect(state.Count).To(gm.Equal(int64(1)))
+    g.Expect(state.PreviousCount).To(gm.Equal(int64(4)))
+
+    // Windows that are long gone don't count.
+    _, decision = limit.take(state, start.Add(time.Minute))
+    g.Expect(decision.allowed).To(gm.BeTrue())
+    g.Expect(decision.remaining).To(gm.Equal(int64(3)))
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    store := NewMemoryRateLimitStore()
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddAuthenticator("apiKey", APIKeyAuthenticator{
+            Header: "X-API-Key",
+            Lookup: lookupTestAPIKey,
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    for _, path := range []string{"/first", "/second"} {
+        err = server.AddController(
+            path,
+            FuncHandler("get", rateLimitController),
+            WithRateLimit(
+                PerMinute(1),
+                WithRateLimitStore(store),
+                WithRateLimitKey(RateLimitByFirst(
+                    RateLimitByPrincipal(), RateLimitByIP(),
+                )),
+            ),
+        )
+        g.Expect(err).To(gm.BeNil())
+    }
+
+    admin := map[string]string{"X-API-Key": "admin-key"}
+    rr := authRequest(server, "/first", admin)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    rr = authRequest(server, "/first", admin)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusTooManyRequests))
+    g.Expect(rr.Header().Get(RetryAfterHeader)).To(gm.Equal("60"))
+
+    rr = authRequest(server, "/second", admin)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    rr = authRequest(server, "/first", map[string]string{
+        "X-API-Key": "reader-key",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+
+    // Anonymous requests fall back to their IP.
+    anonymousRequest := func() *httptest.ResponseRecorder {
+        req, _ := http.NewRequest("GET", "/first", nil)
+        req.RemoteAddr = "192.0.2.1:1234"
+        rr := httptest.NewRecorder()
+        server.muxer.ServeHTTP(rr, req)
+        return rr
+    }
+    rr = anonymousRequest()
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+    rr = anonymousRequest()
+    g.Expect(rr.Code).To(gm.Equal(http.StatusTooManyRequests))
+}
+
+func TestMemoryRateLimitStoreExpires(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    now := time.Unix(1600000000, 0)
+    store := NewMemoryRateLimitStore()
+    store.now = func() time.Time { return now }
+    increment := func(state RateLimitState) RateLimitState {
+        state.Count++
+        return state
+    }
+
+    g.Expect(store.Update("a", time.Second, increment)).To(gm.BeNil())
+    g.Expect(store.Update("a", time.Second, increment)).To(gm.BeNil())
+    g.Expect(store.entries["a"].state.Count).To(gm.Equal(int64(2)))
+
+    now = now.Add(time.Second)
+    g.Expect(store.Update("a", time.Second, increment)).To(gm.BeNil())
+    g.Expect(store.entries["a"].state.Count).To(gm.Equal(int64(1)))
+
+    now = now.Add(rateLimitSweepInterval)
+    g.Expect(store.Update("b", time.Second, increment)).To(gm.BeNil())
+    g.Expect(store.entries).To(gm.HaveLen(1))
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    g.Expect(func() { NewRateLimiter(RateLimit{}) }).To(gm.Panic())
+    g.Expect(func() {
+        NewRateLimiter(RateLimit{Limit: 1, Window: time.Second, Algorithm: 9})
+    }).To(gm.Panic())
+}
@@ -0,0 +1,113 @@
+package vial
+
+import (
+    "fmt"
+    "net/http"
+    "runtime/debug"
+    "strings"
+
+    "github.com/pkg/errors"
+    logging "github.com/daihasso/slogging"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// recoverPanic logs a panic that occurred while handling a request and
+// responds with a PanicError in the server's default encoding (unless the
+// response had already started being written). In debug mode the panic and
+// its stack are included in the error's details.
+// The response written (if any) and the panic as an error are returned.
+func (self *Server) recoverPanic(
+    w http.ResponseWriter,
+    r *http.Request,
+    sequenceId string,
+    rawErr interface{},
+    responseStarted bool,
+) (responses.Data, error) {
+    stack := debug.Stack()
+    if panicked, ok := rawErr.(handlerPanic); ok {
+        rawErr, stack = panicked.value, panicked.stack
+    }
+    panicErr := errors.New(fmt.Sprintf("%+v", rawErr))
+    if err, ok := rawErr.(error); ok {
+        panicErr = errors.WithStack(err)
+    }
+    self.Logger.Exception(
+        panicErr,
+        "Panic while handling controller.",
+        logging.Extras{
+            "sequence_id": sequenceId,
+            "path": r.URL.Path,
+            "method": r.Method,
+            "requestor": r.RemoteAddr,
+            "response_started": responseStarted,
+        },
+    )
+
+    if responseStarted {
+        // NOTE: The status (and maybe part of the body) has already been
+        //       sent so there's nothing more we can tell the requestor.
+        return responses.Data{}, panicErr
+    }
+
+    codedError := neterr.PanicError
+    if self.debug {
+        codedError = codedError.WithDetails(map[string]interface{}{
+            "panic": fmt.Sprintf("%v", rawErr),
+            "stack": strings.Split(strings.TrimSpace(string(stack)), "\n"),
+        })
+    }
+
+    additionals := []responses.AdditionalAttribute{
+        responses.Negotiate(r.Header.Get("Accept")),
+    }
+    if sequenceId != "" {
+        additionals = append(
+            additionals, responses.AddHeader(SequenceIdHeader, sequenceId),
+        )
+    }
+    builder, err := responses.NewBuilder(
+        r.Context(), self.defaultEncoding, additionals...,
+    )
+    if err == nil {
+        data := builder.Abort(http.StatusInternalServerError, codedError)
+        if err = data.Error(); err == nil {
+            err = data.Write(w)
+            if err != nil {
+                self.Logger.Exception(
+                    err,
+                    "Error while writing panic response.",
+                    logging.Extras{
+                        "sequence_id": sequenceId,
+                    },
+                )
+            }
+            return data, panicErr
+        }
+    }
+
+    self.Logger.Exception(
+        err,
+        "Error while generating panic response.",
+        logging.Extras{
+            "sequence_id": sequenceId,
+        },
+    )
+    data := internalServerErrorData()
+    if sequenceId != "" {
+        data.Headers[SequenceIdHeader] = []string{sequenceId}
+    }
+    err = data.Write(w)
+    if err != nil {
+        self.Logger.Exception(
+            err,
+            "Error while writing panic response.",
+            logging.Extras{
+                "sequence_id": sequenceId,
+            },
+        )
+    }
+
+    return data, panicErr
+}
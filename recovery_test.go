@@ -0,0 +1,81 @@
+package vial
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func newPanicServer(
+    t *testing.T, g *gm.GomegaWithT, options ...ServerOption,
+) *Server {
+    server := newTestServer(t, g, options...)
+    err := server.AddController(
+        "/panic",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            panic("something went very wrong")
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func TestServerPanicRecovery(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newPanicServer(t, g)
+
+    req, err := http.NewRequest("GET", "/panic", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusInternalServerError))
+    g.Expect(rr.Header().Get(SequenceIdHeader)).ToNot(gm.BeEmpty())
+    g.Expect(rr.Header().Get("Content-Type")).To(
+        gm.Equal(responses.JSONContentType),
+    )
+    g.Expect(rr.Body.String()).To(gm.Equal(
+        `{"errors":[{"code":12,"message":"An unexpected error occurred ` +
+            `while handling the request.","vial_error":true}]}`,
+    ))
+}
+
+func TestServerPanicRecoveryDebug(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newPanicServer(t, g, EnableDebugMode())
+
+    req, err := http.NewRequest("GET", "/panic", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusInternalServerError))
+
+    var body struct {
+        Errors []struct {
+            Code int
+            Details struct {
+                Panic string
+                Stack []string
+            }
+        }
+    }
+    g.Expect(json.Unmarshal(rr.Body.Bytes(), &body)).To(gm.BeNil())
+    g.Expect(body.Errors).To(gm.HaveLen(1))
+    g.Expect(body.Errors[0].Code).To(gm.Equal(neterr.PanicError.Code()))
+    g.Expect(body.Errors[0].Details.Panic).To(
+        gm.Equal("something went very wrong"),
+    )
+    g.Expect(body.Errors[0].Details.Stack).To(gm.ContainElement(
+        gm.ContainSubstring("recovery_test.go"),
+    ))
+}
@@ -0,0 +1,279 @@
+package vial
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/hex"
+    "hash"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// Default headers signed requests are expected to have.
+const (
+    DefaultSignatureHeader = "X-Signature"
+    DefaultSignatureTimestampHeader = "X-Signature-Timestamp"
+    DefaultSignatureKeyIdHeader = "X-Signature-Key-Id"
+)
+
+// DefaultSignatureReplayWindow is how far a signed request's timestamp can be
+// from the current time before it's rejected.
+var DefaultSignatureReplayWindow = 5 * time.Minute
+
+// This is the key that the ID of the key a request was signed with is stored
+// under in the context.
+var SignatureKeyIdContextKey = ContextKey("signature.key_id")
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+    "sha1": sha1.New,
+    "sha256": sha256.New,
+    "sha512": sha512.New,
+}
+
+// SignatureSecretLookup finds the secret for the key ID a request was signed
+// with. The key ID is empty if the request didn't specify one. It should
+// return a nil secret (and no error) for keys it doesn't know about.
+type SignatureSecretLookup func(keyId string) ([]byte, error)
+
+// StaticSignatureSecret is a SignatureSecretLookup for a single secret.
+func StaticSignatureSecret(secret []byte) SignatureSecretLookup {
+    return func(string) ([]byte, error) {
+        return secret, nil
+    }
+}
+
+// SignedPayloadFunc builds the payload that's signed from the request's
+// timestamp (empty if there isn't one) and body.
+type SignedPayloadFunc func(timestamp string, body []byte) []byte
+
+// DefaultSignedPayload signs "<timestamp>.<body>" or just the body when there
+// is no timestamp.
+func DefaultSignedPayload(timestamp string, body []byte) []byte {
+    if timestamp == "" {
+        return body
+    }
+
+    return append([]byte(timestamp + "."), body...)
+}
+
+type signatureOptions struct {
+    signatureHeader,
+    timestampHeader,
+    keyIdHeader,
+    algorithm string
+    replayWindow time.Duration
+    signedPayload SignedPayloadFunc
+    now func() time.Time
+}
+
+// SignatureOption is an option for VerifyRequestSignatures.
+type SignatureOption func(*signatureOptions)
+
+// WithSignatureHeader sets the header the signature is sent in. Its value is
+// "<algorithm>=<hex or base64 signature>", several comma separated
+// signatures can be sent while secrets are being rotated.
+func WithSignatureHeader(header string) SignatureOption {
+    return func(options *signatureOptions) {
+        options.signatureHeader = header
+    }
+}
+
+// WithSignatureTimestampHeader sets the header the timestamp (in Unix
+// seconds) the request was signed at is sent in.
+func WithSignatureTimestampHeader(header string) SignatureOption {
+    return func(options *signatureOptions) {
+        options.timestampHeader = header
+    }
+}
+
+// WithSignatureKeyIdHeader sets the header the ID of the key the request was
+// signed with is sent in.
+func WithSignatureKeyIdHeader(header string) SignatureOption {
+    return func(options *signatureOptions) {
+        options.keyIdHeader = header
+    }
+}
+
+// WithSignatureAlgorithm sets the HMAC hash (sha1, sha256 or sha512) that
+// requests must be signed with. The default is sha256; any other algorithm
+// makes VerifyRequestSignatures panic.
+func WithSignatureAlgorithm(algorithm string) SignatureOption {
+    return func(options *signatureOptions) {
+        options.algorithm = strings.ToLower(algorithm)
+    }
+}
+
+// WithSignatureReplayWindow sets how far a request's timestamp can be from
+// the current time. A window of zero or less doesn't require (or check)
+// timestamps.
+func WithSignatureReplayWindow(window time.Duration) SignatureOption {
+    return func(options *signatureOptions) {
+        options.replayWindow = window
+    }
+}
+
+// WithSignedPayload overrides how the signed payload is built from the
+// timestamp and body.
+func WithSignedPayload(signedPayload SignedPayloadFunc) SignatureOption {
+    return func(options *signatureOptions) {
+        options.signedPayload = signedPayload
+    }
+}
+
+// WithSignatureClock overrides how the current time is determined.
+func WithSignatureClock(now func() time.Time) SignatureOption {
+    return func(options *signatureOptions) {
+        options.now = now
+    }
+}
+
+// decodeSignature decodes a hex or base64 encoded signature.
+func decodeSignature(encoded string) ([]byte, bool) {
+    if decoded, err := hex.DecodeString(encoded); err == nil {
+        return decoded, true
+    }
+    if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+        return decoded, true
+    }
+
+    return nil, false
+}
+
+// verifyRequestSignature checks the request's signature returning the
+// reason it's invalid (if it is).
+func (self signatureOptions) verifyRequestSignature(
+    transactor *Transactor, lookup SignatureSecretLookup,
+) (string, error) {
+    header := transactor.Request.Header
+    signatures := header.Get(self.signatureHeader)
+    if signatures == "" {
+        return "missing signature", nil
+    }
+
+    timestamp := header.Get(self.timestampHeader)
+    if self.replayWindow > 0 {
+        if timestamp == "" {
+            return "missing timestamp", nil
+        }
+        seconds, err := strconv.ParseInt(timestamp, 10, 64)
+        if err != nil {
+            return "invalid timestamp", nil
+        }
+        age := self.now().Sub(time.Unix(seconds, 0))
+        if age > self.replayWindow || age < -self.replayWindow {
+            return "timestamp outside of replay window", nil
+        }
+    }
+
+    keyId := header.Get(self.keyIdHeader)
+    secret, err := lookup(keyId)
+    if err != nil {
+        return "", errors.Wrapf(
+            err, "Error while looking up signature secret '%s'", keyId,
+        )
+    }
+    if len(secret) == 0 {
+        return "unknown key", nil
+    }
+
+    body, err := transactor.RequestBody()
+    if err != nil {
+        return "", err
+    }
+    mac := hmac.New(signatureAlgorithms[self.algorithm], secret)
+    mac.Write(self.signedPayload(timestamp, body))
+    expected := mac.Sum(nil)
+
+    for _, signature := range strings.Split(signatures, ",") {
+        parts := strings.SplitN(strings.TrimSpace(signature), "=", 2)
+        if len(parts) != 2 || strings.ToLower(parts[0]) != self.algorithm {
+            continue
+        }
+        decoded, ok := decodeSignature(parts[1])
+        if ok && hmac.Equal(decoded, expected) {
+            transactor.ChangeContext(context.WithValue(
+                transactor.Context(), SignatureKeyIdContextKey, keyId,
+            ))
+            return "", nil
+        }
+    }
+
+    return "signature mismatch", nil
+}
+
+// VerifyRequestSignatures creates middleware that requires requests (ex:
+// webhooks) to have a valid HMAC signature of their body. The secret is found
+// by the key ID the request was signed with. Requests with a missing,
+// mismatched or stale signature are responded to with a
+// neterr.InvalidSignatureError. The body can still be read (or bound) by the
+// controller afterwards.
+func VerifyRequestSignatures(
+    lookup SignatureSecretLookup, options ...SignatureOption,
+) Middleware {
+    signatureOpts := &signatureOptions{
+        signatureHeader: DefaultSignatureHeader,
+        timestampHeader: DefaultSignatureTimestampHeader,
+        keyIdHeader: DefaultSignatureKeyIdHeader,
+        algorithm: "sha256",
+        replayWindow: DefaultSignatureReplayWindow,
+        signedPayload: DefaultSignedPayload,
+        now: time.Now,
+    }
+    for _, option := range options {
+        option(signatureOpts)
+    }
+    if _, ok := signatureAlgorithms[signatureOpts.algorithm]; !ok {
+        panic(errors.Errorf(
+            "Unsupported signature algorithm '%s'", signatureOpts.algorithm,
+        ))
+    }
+
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            reason, err := signatureOpts.verifyRequestSignature(
+                transactor, lookup,
+            )
+            if err != nil {
+                transactor.Logger.Exception(
+                    err, "Error while verifying request signature.",
+                )
+                return responses.ErrorResponse(err)
+            }
+            if reason != "" {
+                transactor.Logger.Debug(
+                    "Rejected request signature.",
+                    logging.Extras{"reason": reason},
+                )
+                return transactor.Abort(
+                    http.StatusUnauthorized,
+                    neterr.InvalidSignatureError.WithDetail("reason", reason),
+                )
+            }
+
+            return next(transactor.Context(), transactor)
+        }
+    }
+}
+
+// SignatureKeyId gets the ID of the key the request's signature was verified
+// with. It's empty if the request wasn't signed or didn't specify a key.
+func (self Transactor) SignatureKeyId() string {
+    keyId, _ := self.Request.Context().Value(
+        SignatureKeyIdContextKey,
+    ).(string)
+    return keyId
+}
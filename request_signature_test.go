@@ -0,0 +1,248 @@
+package vial
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+var testSignatureNow = time.Unix(1600000000, 0)
+
+var testSignatureSecrets = map[string][]byte{
+    "partner-1": []byte("partner-1-secret"),
+    "partner-2": []byte("partner-2-secret"),
+}
+
+func lookupTestSignatureSecret(keyId string) ([]byte, error) {
+    return testSignatureSecrets[keyId], nil
+}
+
+func signTestPayload(secret []byte, timestamp string, body string) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(timestamp + "." + body))
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookServer(
+    t *testing.T, g *gm.GomegaWithT, options ...SignatureOption,
+) *Server {
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+
+    options = append(
+        []SignatureOption{WithSignatureClock(func() time.Time {
+            return testSignatureNow
+        })},
+        options...,
+    )
+    err = server.AddController(
+        "/webhook",
+        FuncHandler("post", func(transactor *Transactor) responses.Data {
+            var payload struct {
+                Event string
+            }
+            err := transactor.Bind(&payload)
+            g.Expect(err).To(gm.BeNil())
+            return transactor.Respond(
+                http.StatusOK,
+                responses.Body(map[string]string{
+                    "event": payload.Event,
+                    "key_id": transactor.SignatureKeyId(),
+                }),
+            )
+        }),
+        WithMiddleware(VerifyRequestSignatures(
+            lookupTestSignatureSecret, options...,
+        )),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func webhookRequest(
+    server *Server, body string, headers map[string]string,
+) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("POST", "/webhook", bytes.NewBufferString(body))
+    req.Header.Set("Content-Type", "application/json")
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func signatureRejection(
+    g *gm.GomegaWithT, rr *httptest.ResponseRecorder,
+) string {
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    var body struct {
+        Errors []struct {
+            Code int
+            Details struct {
+                Reason string
+            }
+        }
+    }
+    g.Expect(json.Unmarshal(rr.Body.Bytes(), &body)).To(gm.BeNil())
+    g.Expect(body.Errors).To(gm.HaveLen(1))
+    g.Expect(body.Errors[0].Code).To(
+        gm.Equal(neterr.InvalidSignatureError.Code()),
+    )
+
+    return body.Errors[0].Details.Reason
+}
+
+func TestRequestSignatureValid(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newWebhookServer(t, g)
+    body := `{"event":"invoice.paid"}`
+    timestamp := strconv.FormatInt(testSignatureNow.Unix(), 10)
+
+    rr := webhookRequest(server, body, map[string]string{
+        DefaultSignatureKeyIdHeader: "partner-2",
+        DefaultSignatureTimestampHeader: timestamp,
+        DefaultSignatureHeader: signTestPayload(
+            testSignatureSecrets["partner-2"], timestamp, body,
+        ),
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(
+        gm.Equal(`{"event":"invoice.paid","key_id":"partner-2"}`),
+    )
+
+    // One of several signatures matching (ex: during rotation) is enough.
+    rr = webhookRequest(server, body, map[string]string{
+        DefaultSignatureKeyIdHeader: "partner-1",
+        DefaultSignatureTimestampHeader: timestamp,
+        DefaultSignatureHeader: "sha256=00ff, " + signTestPayload(
+            testSignatureSecrets["partner-1"], timestamp, body,
+        ),
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+}
+
+func TestRequestSignatureRejected(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newWebhookServer(t, g)
+    body := `{"event":"invoice.paid"}`
+    timestamp := strconv.FormatInt(testSignatureNow.Unix(), 10)
+    stale := strconv.FormatInt(
+        testSignatureNow.Add(-10 * time.Minute).Unix(), 10,
+    )
+    secret := testSignatureSecrets["partner-1"]
+
+    cases := []struct{
+        headers map[string]string
+        reason string
+    }{
+        {
+            map[string]string{
+                DefaultSignatureKeyIdHeader: "partner-1",
+                DefaultSignatureTimestampHeader: timestamp,
+            },
+            "missing signature",
+        },
+        {
+            map[string]string{
+                DefaultSignatureKeyIdHeader: "partner-1",
+                DefaultSignatureHeader: signTestPayload(secret, "", body),
+            },
+            "missing timestamp",
+        },
+        {
+            map[string]string{
+                DefaultSignatureKeyIdHeader: "partner-1",
+                DefaultSignatureTimestampHeader: stale,
+                DefaultSignatureHeader: signTestPayload(secret, stale, body),
+            },
+            "timestamp outside of replay window",
+        },
+        {
+            map[string]string{
+                DefaultSignatureKeyIdHeader: "unknown",
+                DefaultSignatureTimestampHeader: timestamp,
+                DefaultSignatureHeader: signTestPayload(
+                    secret, timestamp, body,
+                ),
+            },
+            "unknown key",
+        },
+        {
+            map[string]string{
+                DefaultSignatureKeyIdHeader: "partner-2",
+                DefaultSignatureTimestampHeader: timestamp,
+                DefaultSignatureHeader: signTestPayload(
+                    secret, timestamp, body,
+                ),
+            },
+            "signature mismatch",
+        },
+    }
+    for _, testCase := range cases {
+        rr := webhookRequest(server, body, testCase.headers)
+        g.Expect(signatureRejection(g, rr)).To(gm.Equal(testCase.reason))
+    }
+
+    // Tampering with the body invalidates the signature.
+    rr := webhookRequest(server, `{"event":"invoice.void"}`, map[string]string{
+        DefaultSignatureKeyIdHeader: "partner-1",
+        DefaultSignatureTimestampHeader: timestamp,
+        DefaultSignatureHeader: signTestPayload(secret, timestamp, body),
+    })
+    g.Expect(signatureRejection(g, rr)).To(gm.Equal("signature mismatch"))
+}
+
+func TestRequestSignatureCustomHeaders(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newWebhookServer(
+        t,
+        g,
+        WithSignatureHeader("X-Hub-Signature-256"),
+        WithSignatureReplayWindow(0),
+        WithSignedPayload(func(_ string, body []byte) []byte {
+            return body
+        }),
+    )
+    body := `{"event":"push"}`
+
+    mac := hmac.New(sha256.New, testSignatureSecrets["partner-1"])
+    mac.Write([]byte(body))
+    // NOTE: There's no key ID header so the lookup gets an empty key ID.
+    testSignatureSecrets[""] = testSignatureSecrets["partner-1"]
+    defer delete(testSignatureSecrets, "")
+
+    rr := webhookRequest(server, body, map[string]string{
+        "X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal(`{"event":"push","key_id":""}`))
+}
+
+func TestRequestSignatureUnsupportedAlgorithm(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    g.Expect(func() {
+        VerifyRequestSignatures(
+            StaticSignatureSecret([]byte("secret")),
+            WithSignatureAlgorithm("md5"),
+        )
+    }).To(gm.Panic())
+}
@@ -3,11 +3,11 @@
 package responses
 
 import (
-    "encoding/json"
-    "fmt"
     "context"
     "net/http"
     "net/textproto"
+    "strings"
+    "time"
 
     "github.com/pkg/errors"
 
@@ -23,15 +23,16 @@ type Builder struct {
     headers map[string][]string
     statusCode int
     encodingType EncodingType
+    encodingExplicit bool
     contentType string
-}
-
-func (self *Builder) finalizeJSONBody() ([]byte, error) {
-    bytes, err := json.Marshal(self.bodyData)
-    if err != nil {
-        return nil, err
-    }
-    return bytes, nil
+    negotiate bool
+    acceptHeader string
+    stream StreamFunc
+    flushInterval time.Duration
+    hijack HijackFunc
+    errorRenderer ErrorRenderer
+    // errorBody is set once the body has been replaced by rendered errors.
+    errorBody bool
 }
 
 func (self *Builder) applyAdditionals(
@@ -50,8 +51,96 @@ func (self *Builder) applyAdditionals(
     return nil
 }
 
+// shouldNegotiate checks if the encoding should be negotiated against the
+// requestor's Accept header. Any explicit choice of encoding or content type
+// takes precedence over negotiation and bodies that are already encoded
+// (strings and bytes) can't be negotiated.
+func (self Builder) shouldNegotiate(httpHeaders http.Header) bool {
+    if !self.negotiate || self.encodingExplicit || self.contentType != "" {
+        return false
+    }
+    switch self.bodyData.(type) {
+    case string, []byte:
+        return false
+    }
+    if _, ok := httpHeaders[ContentTypeHeader]; ok {
+        return false
+    }
+    if _, ok := self.bodyData.(ContentTyper); ok {
+        return false
+    }
+
+    return true
+}
+
+// notAcceptable generates a response for when none of the encodings are
+// acceptable to the requestor.
+func (self Builder) notAcceptable() *Data {
+    self.negotiate = false
+    self.contentType = ""
+
+    headers := make(map[string][]string, len(self.headers))
+    for key, values := range self.headers {
+        if key != ContentTypeHeader {
+            headers[key] = values
+        }
+    }
+    self.headers = headers
+    self.renderErrors(
+        http.StatusNotAcceptable,
+        []neterr.CodedError{neterr.NotAcceptableError},
+    )
+
+    data := self.prepare(nil, false)
+    AddVary(data.Headers, "Accept")
+
+    return data
+}
+
+// renderErrors replaces the body with the provided errors as rendered by the
+// builder's ErrorRenderer, falling back to the one in its context. The errors
+// are localized first if there are translations in the context.
+func (self *Builder) renderErrors(
+    statusCode int, codedErrors []neterr.CodedError,
+) {
+    self.statusCode = statusCode
+    self.stream = nil
+    self.hijack = nil
+    self.errorBody = true
+
+    codedErrors, locale, localizable := localizeErrors(self.ctx, codedErrors)
+    if localizable {
+        AddVary(http.Header(self.headers), "Accept-Language")
+    }
+    if locale != "" {
+        self.SetHeader(ContentLanguageHeader, locale)
+    }
+
+    renderer := self.errorRenderer
+    if renderer == nil {
+        renderer = ContextErrorRenderer(self.ctx)
+    }
+    renderer.RenderErrors(self.ctx, self, statusCode, codedErrors)
+}
+
+// AddVary adds a field to the Vary header unless it's already present.
+func AddVary(headers http.Header, value string) {
+    for _, existing := range headers[VaryHeader] {
+        for _, field := range strings.Split(existing, ",") {
+            if strings.EqualFold(strings.TrimSpace(field), value) {
+                return
+            }
+        }
+    }
+    headers.Add(VaryHeader, value)
+}
+
+// prepare generates the Data for the builder. If requireAcceptable is true
+// and no encoding is acceptable to the requestor a 406 is generated instead,
+// otherwise the builder's encoding is used as a fallback.
 func (self Builder) prepare(
     additionals []AdditionalAttribute,
+    requireAcceptable bool,
 ) *Data {
     err := self.applyAdditionals(additionals)
     if err != nil {
@@ -68,6 +157,48 @@ func (self Builder) prepare(
         httpHeaders[properKey] = value
     }
 
+    if self.hijack != nil {
+        return &Data{
+            Headers: httpHeaders,
+            StatusCode: self.statusCode,
+            Hijack: self.hijack,
+        }
+    }
+
+    if self.stream != nil {
+        if _, ok := httpHeaders[ContentTypeHeader]; !ok {
+            contentType := self.contentType
+            if contentType == "" {
+                contentType = StreamContentType
+            }
+            httpHeaders.Set(ContentTypeHeader, contentType)
+        }
+
+        return &Data{
+            Headers: httpHeaders,
+            StatusCode: self.statusCode,
+            Stream: self.stream,
+            FlushInterval: self.flushInterval,
+        }
+    }
+
+    if self.shouldNegotiate(httpHeaders) {
+        AddVary(httpHeaders, "Accept")
+        if self.errorBody {
+            self.encodingType = negotiateErrorEncoding(
+                self.acceptHeader, self.encodingType,
+            )
+        } else {
+            negotiated, ok := NegotiateEncoding(
+                self.acceptHeader, self.encodingType,
+            )
+            if !ok && requireAcceptable {
+                return self.notAcceptable()
+            }
+            self.encodingType = negotiated
+        }
+    }
+
     if _, ok := httpHeaders[ContentTypeHeader]; !ok {
         if ct, ok := self.bodyData.(ContentTyper); ok {
             httpHeaders.Set(
@@ -93,21 +224,16 @@ func (self Builder) prepare(
         body = []byte(bodyString)
     } else if bodyBytes, ok := self.bodyData.([]byte); ok {
         body = bodyBytes
+    } else if self.encodingType == UnsetEncoding {
+        err = errors.New(
+            "Encoding type not set, unsure how to marshal content.",
+        )
+    } else if encoder, ok := EncoderForEncoding(self.encodingType); ok {
+        body, err = encoder.Encode(self.bodyData)
     } else {
-        switch self.encodingType {
-        case JSONEncoding:
-            body, err = self.finalizeJSONBody()
-        case TextPlainEncoding:
-            body = []byte(fmt.Sprint(self.bodyData))
-        case UnsetEncoding:
-            err = errors.New(
-                "Encoding type not set, unsure how to marshal content.",
-            )
-        default:
-            err = errors.Errorf(
-                "Unknown encodingType: %#+v", self.encodingType,
-            )
-        }
+        err = errors.Errorf(
+            "Unknown encodingType: %#+v", self.encodingType,
+        )
     }
 
     return &Data{
@@ -130,6 +256,23 @@ func (self *Builder) SetBody(data ...interface{}) {
     }
 }
 
+// SetStream sets the body to be streamed by the provided StreamFunc instead of
+// being encoded all at once.
+func (self *Builder) SetStream(stream StreamFunc) {
+    self.stream = stream
+}
+
+// SetHijack sets a HijackFunc which takes over the response instead of a
+// body being written.
+func (self *Builder) SetHijack(hijack HijackFunc) {
+    self.hijack = hijack
+}
+
+// SetFlushInterval sets how often a streamed body is flushed to the client.
+func (self *Builder) SetFlushInterval(interval time.Duration) {
+    self.flushInterval = interval
+}
+
 // ReplaceHeaders replaces all the existing headers with the provided headers.
 func (self *Builder) ReplaceHeaders(headers map[string][]string) {
     self.headers = make(map[string][]string, len(headers))
@@ -187,14 +330,35 @@ func (self *Builder) AddHeader(key, value string, otherValues ...string) {
     }
 }
 
+// Header gets the first value of the header with the provided key or an empty
+// string if it isn't set.
+func (self Builder) Header(key string) string {
+    values := self.headers[textproto.CanonicalMIMEHeaderKey(key)]
+    if len(values) == 0 {
+        return ""
+    }
+
+    return values[0]
+}
+
 // SetStatus sets the status for the response.
 func (self *Builder) SetStatus(statusCode int) {
     self.statusCode = statusCode
 }
 
-// SetEncoding sets the encoding for the response.
+// SetEncoding sets the encoding for the response. An explicitly set encoding
+// takes precedence over content negotiation.
 func (self *Builder) SetEncoding(encoding EncodingType) {
     self.encodingType = encoding
+    self.encodingExplicit = true
+}
+
+// SetNegotiation enables content negotiation against the provided Accept
+// header. The chosen encoding will be picked out of the registered encodings
+// with the builder's encoding being preferred.
+func (self *Builder) SetNegotiation(acceptHeader string) {
+    self.negotiate = true
+    self.acceptHeader = acceptHeader
 }
 
 // SetContentType sets the content type for the response.
@@ -209,21 +373,24 @@ func (self *Builder) Abort(
     otherErrors ...neterr.CodedError,
 ) Data {
     codedErrors := append([]neterr.CodedError{codedError}, otherErrors...)
-    self.statusCode = statusCode
     // NOTE: This overrides the already set body, I think this is the best
     //       approach in this situation to prevent data leakage but it may be
     //       debatable.
-    self.bodyData = map[string]interface{}{
-        "errors": codedErrors,
-    }
+    self.renderErrors(statusCode, codedErrors)
 
-    return *self.prepare(nil)
+    return *self.prepare(nil, false)
 }
 
 // Finish finishes the builder generating a Data struct to return as a
 // response.
 func (self *Builder) Finish(additionals ...AdditionalAttribute) Data {
-    return *self.prepare(additionals)
+    return *self.prepare(additionals, true)
+}
+
+// SetErrorRenderer sets the ErrorRenderer used when aborting. It takes
+// precedence over any ErrorRenderer in the builder's context.
+func (self *Builder) SetErrorRenderer(renderer ErrorRenderer) {
+    self.errorRenderer = renderer
 }
 
 // ChangeContext changes the existing context on the builder to the
@@ -232,6 +399,18 @@ func (self *Builder) ChangeContext(ctx context.Context) {
     self.ctx = ctx
 }
 
+// Clone returns a copy of the builder that can be changed without affecting
+// the original.
+func (self *Builder) Clone() *Builder {
+    cloned := *self
+    cloned.headers = make(map[string][]string, len(self.headers))
+    for key, values := range self.headers {
+        cloned.headers[key] = append([]string{}, values...)
+    }
+
+    return &cloned
+}
+
 // NewBuilder generates a new builder to help generate a response Data struct.
 func NewBuilder(
     ctx context.Context,
@@ -260,22 +439,13 @@ func Abort(
     otherErrors ...neterr.CodedError,
 ) Data {
     codedErrors := append([]neterr.CodedError{codedError}, otherErrors...)
-    builder, err := NewBuilder(
-        ctx,
-        encoding,
-        Status(statusCode),
-        Body(map[string]interface{}{
-            "errors": codedErrors,
-        }),
-        Headers(map[string][]string{
-            ContentTypeHeader: []string{JSONContentType},
-        }),
-    )
+    builder, err := NewBuilder(ctx, encoding)
     if err != nil {
         return Data{unexpectedError: err}
     }
+    builder.renderErrors(statusCode, codedErrors)
 
-    return *builder.prepare(nil)
+    return *builder.prepare(nil, false)
 }
 
 // Respond is a shortcut for generating a response Data struct via a builder
@@ -297,5 +467,5 @@ func Respond(
             ),
         }
     }
-    return *builder.prepare(nil)
+    return *builder.prepare(nil, true)
 }
@@ -1,6 +1,8 @@
 package responses
 
 import (
+    "io"
+    "time"
 )
 
 // AdditionalAttribute is an attribute added to a builder either after
@@ -16,6 +18,39 @@ func Body(data ...interface{}) AdditionalAttribute {
     }
 }
 
+// BodyStream sets the body to be streamed by the provided StreamFunc.
+func BodyStream(stream StreamFunc) AdditionalAttribute {
+    return func(rb *Builder) error {
+        rb.SetStream(stream)
+        return nil
+    }
+}
+
+// BodyReader sets the body to be streamed from the provided reader.
+func BodyReader(reader io.Reader) AdditionalAttribute {
+    return func(rb *Builder) error {
+        rb.SetStream(ReaderStream(reader))
+        return nil
+    }
+}
+
+// Hijack sets a HijackFunc which takes over the response once the headers
+// have been set.
+func Hijack(hijack HijackFunc) AdditionalAttribute {
+    return func(rb *Builder) error {
+        rb.SetHijack(hijack)
+        return nil
+    }
+}
+
+// FlushInterval sets how often a streamed body is flushed to the client.
+func FlushInterval(interval time.Duration) AdditionalAttribute {
+    return func(rb *Builder) error {
+        rb.SetFlushInterval(interval)
+        return nil
+    }
+}
+
 // Headers will merge provided headers with the existing headers. Keys that
 // exist both in the existing headers and provided headers will use the value
 // provided by the new headers over the existing headers.
@@ -50,6 +85,14 @@ func Encoding(encodingType EncodingType) AdditionalAttribute {
     }
 }
 
+// Negotiate enables content negotiation against the provided Accept header.
+func Negotiate(acceptHeader string) AdditionalAttribute {
+    return func(rb *Builder) error {
+        rb.SetNegotiation(acceptHeader)
+        return nil
+    }
+}
+
 func OverrideContentType(contentType string) AdditionalAttribute {
     return func(rb *Builder) error {
         rb.SetContentType(contentType)
@@ -240,6 +240,33 @@ func TestBuilderAddHeaderExisting(t *testing.T) {
     g.Expect(testHeader).To(gm.ConsistOf("Foo", "Bar"))
 }
 
+func TestBuilderClone(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Headers(map[string][]string{
+            "Test-Header": []string{"Foo"},
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    cloned := builder.Clone()
+    cloned.AddHeader("Test-Header", "Bar")
+    cloned.SetHeader("Other-Header", "Baz")
+    cloned.SetStatus(http.StatusCreated)
+
+    responseData := builder.Finish()
+    g.Expect(responseData.StatusCode).ToNot(gm.Equal(http.StatusCreated))
+    g.Expect(responseData.Headers["Test-Header"]).To(gm.ConsistOf("Foo"))
+    g.Expect(responseData.Headers).ToNot(gm.HaveKey("Other-Header"))
+
+    clonedData := cloned.Finish()
+    g.Expect(clonedData.StatusCode).To(gm.Equal(http.StatusCreated))
+    g.Expect(clonedData.Headers["Test-Header"]).To(gm.ConsistOf("Foo", "Bar"))
+}
+
 func TestBuilderAdditionals(t *testing.T) {
     g := gm.NewGomegaWithT(t)
 
@@ -8,10 +8,18 @@ import (
 // formatter.
 var ContentTypeHeader = textproto.CanonicalMIMEHeaderKey("Content-Type")
 
+// VaryHeader is the Vary header ran through the STD library formatter.
+var VaryHeader = textproto.CanonicalMIMEHeaderKey("Vary")
+
 // ContentType is a representation of a content type header value.
 type ContentType string
 
 const (
     TextPlainUTF8ContentType = "text/plain; charset=utf-8"
     JSONContentType = "application/json; charset=utf-8"
+    XMLContentType = "application/xml; charset=utf-8"
+    YAMLContentType = "application/yaml; charset=utf-8"
+    CSVContentType = "text/csv; charset=utf-8"
+    MessagePackContentType = "application/x-msgpack"
+    StreamContentType = "application/octet-stream"
 )
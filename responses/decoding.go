@@ -0,0 +1,88 @@
+package responses
+
+import (
+    "encoding"
+
+    "github.com/pkg/errors"
+)
+
+// Decoder is capable of unmarshalling a request body encoded with an
+// EncodingType into a target value.
+type Decoder interface {
+    Decode(data []byte, target interface{}) error
+}
+
+// DecoderFunc is a helper for using a plain function as a Decoder.
+type DecoderFunc func(data []byte, target interface{}) error
+
+// Decode calls the underlying function.
+func (self DecoderFunc) Decode(data []byte, target interface{}) error {
+    return self(data, target)
+}
+
+// RegisterDecoder registers a Decoder for an already registered EncodingType.
+func RegisterDecoder(encodingType EncodingType, decoder Decoder) error {
+    if decoder == nil {
+        return errors.Errorf(
+            "Decoder provided for encodingType %d is nil", encodingType,
+        )
+    }
+
+    encodingRegistry.Lock()
+    defer encodingRegistry.Unlock()
+
+    registered, ok := encodingRegistry.encodings[encodingType]
+    if !ok {
+        return errors.Errorf(
+            "Can't register a decoder for unregistered encodingType %d",
+            encodingType,
+        )
+    }
+    registered.decoder = decoder
+    encodingRegistry.encodings[encodingType] = registered
+
+    return nil
+}
+
+// DecoderForEncoding retrieves the Decoder registered for the EncodingType.
+func DecoderForEncoding(encodingType EncodingType) (Decoder, bool) {
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    registered, ok := encodingRegistry.encodings[encodingType]
+    if !ok || registered.decoder == nil {
+        return nil, false
+    }
+
+    return registered.decoder, true
+}
+
+// DecoderForContentType retrieves the Decoder for a Content-Type header
+// value.
+func DecoderForContentType(contentType string) (Decoder, bool) {
+    encodingType, ok := EncodingForMediaType(contentType)
+    if !ok {
+        return nil, false
+    }
+
+    return DecoderForEncoding(encodingType)
+}
+
+func decodeTextPlain(data []byte, target interface{}) error {
+    switch v := target.(type) {
+    case *string:
+        *v = string(data)
+    case *[]byte:
+        *v = append((*v)[:0], data...)
+    case encoding.TextUnmarshaler:
+        return v.UnmarshalText(data)
+    default:
+        return errors.Errorf(
+            "Can't decode text/plain into a '%T', expected a *string," +
+                " *[]byte or encoding.TextUnmarshaler",
+            target,
+        )
+    }
+
+    return nil
+}
@@ -0,0 +1,375 @@
+package responses
+
+import (
+    "bytes"
+    "encoding"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/pkg/errors"
+)
+
+// csvValueColumn is the header used for a list of plain values.
+var csvValueColumn = "value"
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// csvField is an exported struct field and the column it maps to.
+type csvField struct {
+    column string
+    index []int
+}
+
+// csvFields finds the columns for a struct type. The `csv` tag is used to
+// rename (or with "-" skip) a field.
+func csvFields(structType reflect.Type) []csvField {
+    var fields []csvField
+    for i := 0; i < structType.NumField(); i++ {
+        field := structType.Field(i)
+        if field.PkgPath != "" {
+            continue
+        }
+
+        column := field.Name
+        if tag, ok := field.Tag.Lookup("csv"); ok {
+            tagName := strings.Split(tag, ",")[0]
+            if tagName == "-" {
+                continue
+            } else if tagName != "" {
+                column = tagName
+            }
+        }
+
+        fields = append(fields, csvField{column: column, index: field.Index})
+    }
+
+    return fields
+}
+
+func indirect(value reflect.Value) reflect.Value {
+    for value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
+        if value.IsNil() {
+            return reflect.Value{}
+        }
+        value = value.Elem()
+    }
+
+    return value
+}
+
+// csvCell formats a single value as a CSV cell. Nested structures are
+// written as JSON.
+func csvCell(value reflect.Value) (string, error) {
+    if value.IsValid() && value.Type().Implements(textMarshalerType) {
+        if value.Kind() == reflect.Ptr && value.IsNil() {
+            return "", nil
+        }
+        text, err := value.Interface().(encoding.TextMarshaler).MarshalText()
+        return string(text), err
+    }
+
+    value = indirect(value)
+    if !value.IsValid() {
+        return "", nil
+    }
+    if stringer, ok := value.Interface().(fmt.Stringer); ok {
+        return stringer.String(), nil
+    }
+
+    switch value.Kind() {
+    case reflect.String:
+        return value.String(), nil
+    case reflect.Map, reflect.Struct, reflect.Array:
+        marshalled, err := json.Marshal(value.Interface())
+        return string(marshalled), err
+    case reflect.Slice:
+        if value.Type().Elem().Kind() == reflect.Uint8 {
+            return string(value.Bytes()), nil
+        }
+        marshalled, err := json.Marshal(value.Interface())
+        return string(marshalled), err
+    }
+
+    return fmt.Sprint(value.Interface()), nil
+}
+
+// csvRows converts the data into a header and rows. Slices of structs, maps or
+// plain values are supported, a lone struct or map is written as a single
+// row.
+func csvRows(data interface{}) ([]string, [][]string, error) {
+    if records, ok := data.([][]string); ok {
+        if len(records) == 0 {
+            return nil, nil, nil
+        }
+        return records[0], records[1:], nil
+    }
+
+    value := indirect(reflect.ValueOf(data))
+    if !value.IsValid() {
+        return nil, nil, nil
+    }
+
+    var items []reflect.Value
+    if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+        for i := 0; i < value.Len(); i++ {
+            items = append(items, indirect(value.Index(i)))
+        }
+    } else {
+        items = []reflect.Value{value}
+    }
+    if len(items) == 0 {
+        return nil, nil, nil
+    }
+
+    first := items[0]
+    for _, item := range items {
+        if item.IsValid() {
+            first = item
+            break
+        }
+    }
+
+    var header []string
+    var rows [][]string
+    switch first.Kind() {
+    case reflect.Struct:
+        fields := csvFields(first.Type())
+        for _, field := range fields {
+            header = append(header, field.column)
+        }
+        for _, item := range items {
+            row := make([]string, len(fields))
+            if item.IsValid() {
+                if item.Type() != first.Type() {
+                    return nil, nil, errors.Errorf(
+                        "CSV rows must all be the same type, got '%s' and" +
+                            " '%s'",
+                        first.Type(),
+                        item.Type(),
+                    )
+                }
+                for i, field := range fields {
+                    cell, err := csvCell(item.FieldByIndex(field.index))
+                    if err != nil {
+                        return nil, nil, err
+                    }
+                    row[i] = cell
+                }
+            }
+            rows = append(rows, row)
+        }
+    case reflect.Map:
+        seen := make(map[string]bool)
+        for _, item := range items {
+            if !item.IsValid() {
+                continue
+            }
+            if item.Kind() != reflect.Map ||
+                item.Type().Key().Kind() != reflect.String {
+                return nil, nil, errors.Errorf(
+                    "CSV rows must all be maps with string keys, got '%s'",
+                    item.Type(),
+                )
+            }
+            for _, key := range item.MapKeys() {
+                column := fmt.Sprint(key.Interface())
+                if !seen[column] {
+                    seen[column] = true
+                    header = append(header, column)
+                }
+            }
+        }
+        sort.Strings(header)
+        for _, item := range items {
+            row := make([]string, len(header))
+            if item.IsValid() {
+                for i, column := range header {
+                    key := reflect.ValueOf(column).Convert(
+                        item.Type().Key(),
+                    )
+                    cell, err := csvCell(item.MapIndex(key))
+                    if err != nil {
+                        return nil, nil, err
+                    }
+                    row[i] = cell
+                }
+            }
+            rows = append(rows, row)
+        }
+    default:
+        header = []string{csvValueColumn}
+        for _, item := range items {
+            cell, err := csvCell(item)
+            if err != nil {
+                return nil, nil, err
+            }
+            rows = append(rows, []string{cell})
+        }
+    }
+
+    return header, rows, nil
+}
+
+// encodeCSV writes the data as CSV with a header row.
+func encodeCSV(data interface{}) ([]byte, error) {
+    header, rows, err := csvRows(data)
+    if err != nil {
+        return nil, errors.Wrap(err, "Error while converting body to CSV")
+    }
+
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    if header != nil {
+        err = writer.Write(header)
+        if err != nil {
+            return nil, err
+        }
+    }
+    err = writer.WriteAll(rows)
+    if err != nil {
+        return nil, err
+    }
+
+    return buf.Bytes(), nil
+}
+
+// setCSVCell sets a struct field from a CSV cell.
+func setCSVCell(field reflect.Value, cell string) error {
+    if field.Kind() == reflect.Ptr {
+        if cell == "" {
+            return nil
+        }
+        field.Set(reflect.New(field.Type().Elem()))
+        field = field.Elem()
+    }
+
+    fieldPtr := field.Addr()
+    unmarshaler, ok := fieldPtr.Interface().(encoding.TextUnmarshaler)
+    if ok {
+        return unmarshaler.UnmarshalText([]byte(cell))
+    }
+
+    var err error
+    switch field.Kind() {
+    case reflect.String:
+        field.SetString(cell)
+    case reflect.Bool:
+        var parsed bool
+        parsed, err = strconv.ParseBool(cell)
+        field.SetBool(parsed)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+        reflect.Int64:
+        var parsed int64
+        parsed, err = strconv.ParseInt(cell, 10, field.Type().Bits())
+        field.SetInt(parsed)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+        reflect.Uint64:
+        var parsed uint64
+        parsed, err = strconv.ParseUint(cell, 10, field.Type().Bits())
+        field.SetUint(parsed)
+    case reflect.Float32, reflect.Float64:
+        var parsed float64
+        parsed, err = strconv.ParseFloat(cell, field.Type().Bits())
+        field.SetFloat(parsed)
+    default:
+        if cell == "" {
+            return nil
+        }
+        err = json.Unmarshal([]byte(cell), fieldPtr.Interface())
+    }
+
+    return err
+}
+
+// decodeCSV reads CSV with a header row into a *[][]string,
+// *[]map[string]string or a pointer to a slice of structs.
+func decodeCSV(data []byte, target interface{}) error {
+    records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+    if err != nil {
+        return errors.Wrap(err, "Error while reading CSV")
+    }
+
+    if recordsTarget, ok := target.(*[][]string); ok {
+        *recordsTarget = records
+        return nil
+    }
+
+    targetValue := reflect.ValueOf(target)
+    if targetValue.Kind() != reflect.Ptr ||
+        targetValue.Elem().Kind() != reflect.Slice {
+        return errors.Errorf(
+            "Can't decode CSV into a '%T', expected a pointer to a slice",
+            target,
+        )
+    }
+    sliceValue := targetValue.Elem()
+    sliceValue.Set(reflect.MakeSlice(sliceValue.Type(), 0, len(records)))
+    if len(records) == 0 {
+        return nil
+    }
+
+    header, rows := records[0], records[1:]
+    elemType := sliceValue.Type().Elem()
+    isPtr := elemType.Kind() == reflect.Ptr
+    if isPtr {
+        elemType = elemType.Elem()
+    }
+
+    for rowNumber, row := range rows {
+        item := reflect.New(elemType).Elem()
+        switch elemType.Kind() {
+        case reflect.Struct:
+            columns := make(map[string][]int)
+            for _, field := range csvFields(elemType) {
+                columns[strings.ToLower(field.column)] = field.index
+            }
+            for i, column := range header {
+                index, ok := columns[strings.ToLower(column)]
+                if !ok || i >= len(row) {
+                    continue
+                }
+                err := setCSVCell(item.FieldByIndex(index), row[i])
+                if err != nil {
+                    return errors.Wrapf(
+                        err,
+                        "Error while decoding column '%s' of row %d",
+                        column,
+                        rowNumber+1,
+                    )
+                }
+            }
+        case reflect.Map:
+            if elemType.Key().Kind() != reflect.String ||
+                elemType.Elem().Kind() != reflect.String {
+                return errors.Errorf(
+                    "Can't decode CSV rows into a '%s'", elemType,
+                )
+            }
+            item.Set(reflect.MakeMap(elemType))
+            for i, column := range header {
+                if i < len(row) {
+                    item.SetMapIndex(
+                        reflect.ValueOf(column).Convert(elemType.Key()),
+                        reflect.ValueOf(row[i]).Convert(elemType.Elem()),
+                    )
+                }
+            }
+        default:
+            return errors.Errorf(
+                "Can't decode CSV rows into a '%s'", elemType,
+            )
+        }
+
+        if isPtr {
+            item = item.Addr()
+        }
+        sliceValue.Set(reflect.Append(sliceValue, item))
+    }
+
+    return nil
+}
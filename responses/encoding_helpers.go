@@ -1,15 +1,343 @@
 package responses
 
 import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/pkg/errors"
+    "gopkg.in/yaml.v2"
 )
 
-var defaultEncodingContentTypeMap = map[EncodingType]ContentType{
-    JSONEncoding: JSONContentType,
-    TextPlainEncoding: TextPlainUTF8ContentType,
+// Encoder is capable of marshalling a response body for an EncodingType.
+type Encoder interface {
+    Encode(data interface{}) ([]byte, error)
+}
+
+// EncoderFunc is a helper for using a plain function as an Encoder.
+type EncoderFunc func(data interface{}) ([]byte, error)
+
+// Encode calls the underlying function.
+func (self EncoderFunc) Encode(data interface{}) ([]byte, error) {
+    return self(data)
+}
+
+// customEncodingStart is the first EncodingType handed out to encoders
+// registered by media type. It leaves plenty of room for built-in types.
+const customEncodingStart EncodingType = 1000
+
+type registeredEncoding struct {
+    contentType ContentType
+    aliases []string
+    encoder Encoder
+    decoder Decoder
+    // structured is true for encodings that can represent nested data such
+    // as a list of errors.
+    structured bool
+}
+
+// mediaTypes returns the media types this encoding answers to.
+func (self registeredEncoding) mediaTypes() []string {
+    return append(
+        []string{mediaTypeOf(string(self.contentType))}, self.aliases...,
+    )
+}
+
+var encodingRegistry = struct {
+    sync.RWMutex
+    encodings map[EncodingType]registeredEncoding
+    nextCustom EncodingType
+}{
+    encodings: make(map[EncodingType]registeredEncoding),
+    nextCustom: customEncodingStart,
+}
+
+func init() {
+    builtins := []struct{
+        encodingType EncodingType
+        contentType ContentType
+        encoder Encoder
+        decoder Decoder
+        aliases []string
+        structured bool
+    }{
+        {
+            JSONEncoding,
+            JSONContentType,
+            EncoderFunc(json.Marshal),
+            DecoderFunc(json.Unmarshal),
+            nil,
+            true,
+        },
+        {
+            TextPlainEncoding,
+            TextPlainUTF8ContentType,
+            EncoderFunc(encodeTextPlain),
+            DecoderFunc(decodeTextPlain),
+            nil,
+            false,
+        },
+        {
+            XMLEncoding,
+            XMLContentType,
+            EncoderFunc(encodeXML),
+            DecoderFunc(xml.Unmarshal),
+            []string{"text/xml"},
+            true,
+        },
+        {
+            YAMLEncoding,
+            YAMLContentType,
+            EncoderFunc(yaml.Marshal),
+            DecoderFunc(yaml.Unmarshal),
+            []string{"application/x-yaml", "text/yaml", "text/vnd.yaml"},
+            true,
+        },
+        {
+            CSVEncoding,
+            CSVContentType,
+            EncoderFunc(encodeCSV),
+            DecoderFunc(decodeCSV),
+            nil,
+            false,
+        },
+        {
+            MessagePackEncoding,
+            MessagePackContentType,
+            EncoderFunc(encodeMessagePack),
+            DecoderFunc(decodeMessagePack),
+            []string{"application/msgpack", "application/vnd.msgpack"},
+            true,
+        },
+    }
+
+    for _, builtin := range builtins {
+        encodingRegistry.encodings[builtin.encodingType] = registeredEncoding{
+            contentType: builtin.contentType,
+            aliases: builtin.aliases,
+            encoder: builtin.encoder,
+            decoder: builtin.decoder,
+            structured: builtin.structured,
+        }
+    }
+}
+
+func encodeTextPlain(data interface{}) ([]byte, error) {
+    return []byte(fmt.Sprint(data)), nil
+}
+
+func mediaTypeOf(contentType string) string {
+    mainType, subType, _ := splitMediaType(contentType)
+    if mainType == "" {
+        return ""
+    }
+
+    return mainType + "/" + subType
+}
+
+// RegisterEncoder registers an Encoder for the provided EncodingType or media
+// type (a string or ContentType) and returns the EncodingType it can be
+// referred to by. This can be used to override a built-in encoder or to add a
+// new one. An EncodingType must already be registered; a media type that
+// isn't is given a new EncodingType with the media type (parameters such as
+// charset included) as its Content-Type.
+func RegisterEncoder(
+    target interface{}, encoder Encoder,
+) (EncodingType, error) {
+    if encoder == nil {
+        return UnsetEncoding, errors.Errorf(
+            "Encoder provided for %v is nil", target,
+        )
+    }
+
+    encodingRegistry.Lock()
+    defer encodingRegistry.Unlock()
+
+    switch target := target.(type) {
+    case EncodingType:
+        registered, ok := encodingRegistry.encodings[target]
+        if !ok {
+            return UnsetEncoding, errors.Errorf(
+                "Can't register an encoder for unregistered encodingType " +
+                    "%d, register it by media type instead",
+                target,
+            )
+        }
+        registered.encoder = encoder
+        encodingRegistry.encodings[target] = registered
+
+        return target, nil
+    case ContentType:
+        return registerMediaTypeEncoder(string(target), encoder)
+    case string:
+        return registerMediaTypeEncoder(target, encoder)
+    }
+
+    return UnsetEncoding, errors.Errorf(
+        "Can't register an encoder for %T, expected an EncodingType or a " +
+            "media type",
+        target,
+    )
+}
+
+// registerMediaTypeEncoder registers the encoder for the media type, the
+// registry must already be locked for writing.
+func registerMediaTypeEncoder(
+    contentType string, encoder Encoder,
+) (EncodingType, error) {
+    mediaType := mediaTypeOf(contentType)
+    if mediaType == "" {
+        return UnsetEncoding, errors.Errorf(
+            "Invalid content type '%s'", contentType,
+        )
+    }
+
+    encodingType, ok := encodingForMediaType(mediaType)
+    if !ok {
+        encodingType = encodingRegistry.nextCustom
+        encodingRegistry.nextCustom++
+    }
+    registered := encodingRegistry.encodings[encodingType]
+    registered.contentType = ContentType(contentType)
+    registered.encoder = encoder
+    encodingRegistry.encodings[encodingType] = registered
+
+    return encodingType, nil
+}
+
+// EncoderForEncoding retrieves the Encoder registered for the EncodingType.
+func EncoderForEncoding(encodingType EncodingType) (Encoder, bool) {
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    registered, ok := encodingRegistry.encodings[encodingType]
+    return registered.encoder, ok
+}
+
+// EncodingForMediaType finds the EncodingType registered for the media type
+// provided (parameters such as charset are ignored). Aliases of an encoding
+// are matched as well.
+func EncodingForMediaType(mediaType string) (EncodingType, bool) {
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    return encodingForMediaType(mediaType)
+}
+
+// encodingForMediaType finds the EncodingType for the media type, the
+// registry must already be locked. Registering keeps media types unique to
+// one EncodingType so the map's order doesn't matter.
+func encodingForMediaType(mediaType string) (EncodingType, bool) {
+    mediaType = mediaTypeOf(mediaType)
+    for encodingType, registered := range encodingRegistry.encodings {
+        for _, registeredType := range registered.mediaTypes() {
+            if strings.EqualFold(registeredType, mediaType) {
+                return encodingType, true
+            }
+        }
+    }
+
+    return UnsetEncoding, false
+}
+
+// RegisterMediaTypeAlias adds another media type that the EncodingType
+// answers to for content negotiation and decoding (ex: text/xml for XML). A
+// media type can only belong to one EncodingType.
+func RegisterMediaTypeAlias(encodingType EncodingType, alias string) error {
+    mediaType := mediaTypeOf(alias)
+    if mediaType == "" {
+        return errors.Errorf("Invalid media type alias '%s'", alias)
+    }
+
+    encodingRegistry.Lock()
+    defer encodingRegistry.Unlock()
+
+    registered, ok := encodingRegistry.encodings[encodingType]
+    if !ok {
+        return errors.Errorf(
+            "Can't alias unregistered encodingType %d", encodingType,
+        )
+    }
+    existing, taken := encodingForMediaType(mediaType)
+    if taken {
+        if existing == encodingType {
+            return nil
+        }
+        return errors.Errorf(
+            "Media type '%s' is already registered for encodingType %d",
+            mediaType,
+            existing,
+        )
+    }
+    registered.aliases = append(registered.aliases, mediaType)
+    encodingRegistry.encodings[encodingType] = registered
+
+    return nil
+}
+
+// mediaTypesForEncoding returns all the media types an EncodingType answers
+// to, starting with its content type.
+func mediaTypesForEncoding(encodingType EncodingType) []string {
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    registered, ok := encodingRegistry.encodings[encodingType]
+    if !ok {
+        return nil
+    }
+
+    return registered.mediaTypes()
+}
+
+// RegisteredEncodings returns all the EncodingTypes with an encoder in
+// ascending order.
+func RegisteredEncodings() []EncodingType {
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    encodingTypes := make(
+        []EncodingType, 0, len(encodingRegistry.encodings),
+    )
+    for encodingType := range encodingRegistry.encodings {
+        encodingTypes = append(encodingTypes, encodingType)
+    }
+    sort.Slice(encodingTypes, func(i, j int) bool {
+        return encodingTypes[i] < encodingTypes[j]
+    })
+
+    return encodingTypes
+}
+
+// isStructuredEncoding checks if the EncodingType can represent nested data.
+// Encodings registered by media type aren't assumed to.
+func isStructuredEncoding(encodingType EncodingType) bool {
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    return encodingRegistry.encodings[encodingType].structured
+}
+
+// structuredEncodings returns the registered EncodingTypes that can represent
+// nested data in ascending order.
+func structuredEncodings() []EncodingType {
+    var encodingTypes []EncodingType
+    for _, encodingType := range RegisteredEncodings() {
+        if isStructuredEncoding(encodingType) {
+            encodingTypes = append(encodingTypes, encodingType)
+        }
+    }
+
+    return encodingTypes
 }
 
 // DefaultContentTypeForEncoding will return the actual encoding-type string
 // for use in a Content-Type header.
 func DefaultContentTypeForEncoding(encodingType EncodingType) string {
-    return string(defaultEncodingContentTypeMap[encodingType])
+    encodingRegistry.RLock()
+    defer encodingRegistry.RUnlock()
+
+    return string(encodingRegistry.encodings[encodingType].contentType)
 }
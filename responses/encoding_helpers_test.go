@@ -0,0 +1,173 @@
+package responses
+
+import (
+    "context"
+    "strings"
+    "sync"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+)
+
+// unregisterEncoding removes an encoding registered while testing.
+func unregisterEncoding(encodingType EncodingType) {
+    encodingRegistry.Lock()
+    defer encodingRegistry.Unlock()
+
+    delete(encodingRegistry.encodings, encodingType)
+}
+
+func TestRegisterEncoderMediaType(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoder := EncoderFunc(func(data interface{}) ([]byte, error) {
+        return []byte(strings.ToUpper(data.(map[string]string)["foo"])), nil
+    })
+    encodingType, err := RegisterEncoder(
+        "application/vnd.test.shout", encoder,
+    )
+    g.Expect(err).To(gm.BeNil())
+    defer unregisterEncoding(encodingType)
+    g.Expect(encodingType).To(gm.BeNumerically(">=", customEncodingStart))
+
+    sameType, err := RegisterEncoder(
+        ContentType("application/vnd.test.shout; charset=utf-8"), encoder,
+    )
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(sameType).To(gm.Equal(encodingType))
+
+    found, ok := EncodingForMediaType("application/vnd.test.shout")
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(found).To(gm.Equal(encodingType))
+    g.Expect(RegisteredEncodings()).To(gm.ContainElement(encodingType))
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Body(map[string]string{"foo": "bar"}),
+        Negotiate("application/vnd.test.shout"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish()
+
+    g.Expect(responseData.Error()).To(gm.BeNil())
+    g.Expect(string(responseData.Body)).To(gm.Equal("BAR"))
+    g.Expect(responseData.Headers["Content-Type"]).To(gm.ConsistOf(
+        "application/vnd.test.shout; charset=utf-8",
+    ))
+}
+
+func TestRegisterEncoderOverride(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    original, ok := EncoderForEncoding(TextPlainEncoding)
+    g.Expect(ok).To(gm.BeTrue())
+    defer func() {
+        _, err := RegisterEncoder(TextPlainEncoding, original)
+        g.Expect(err).To(gm.BeNil())
+    }()
+
+    encodingType, err := RegisterEncoder(
+        TextPlainEncoding,
+        EncoderFunc(func(interface{}) ([]byte, error) {
+            return []byte("overridden"), nil
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(encodingType).To(gm.Equal(TextPlainEncoding))
+
+    responseData := Respond(
+        context.Background(),
+        TextPlainEncoding,
+        200,
+        Body(1),
+    )
+
+    g.Expect(string(responseData.Body)).To(gm.Equal("overridden"))
+    g.Expect(DefaultContentTypeForEncoding(TextPlainEncoding)).To(
+        gm.Equal(TextPlainUTF8ContentType),
+    )
+}
+
+func TestRegisterEncoderConcurrently(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoder := EncoderFunc(func(interface{}) ([]byte, error) {
+        return nil, nil
+    })
+    results := make(chan EncodingType, 10)
+    var wg sync.WaitGroup
+    for i := 0; i < cap(results); i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            encodingType, err := RegisterEncoder(
+                "application/vnd.test.concurrent", encoder,
+            )
+            g.Expect(err).To(gm.BeNil())
+            results <- encodingType
+        }()
+    }
+    wg.Wait()
+    close(results)
+
+    first := <-results
+    defer unregisterEncoding(first)
+    for encodingType := range results {
+        g.Expect(encodingType).To(gm.Equal(first))
+    }
+}
+
+func TestRegisterMediaTypeAliasDuplicate(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encodingType, err := RegisterEncoder(
+        "application/vnd.test.alias",
+        EncoderFunc(func(interface{}) ([]byte, error) {
+            return nil, nil
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    defer unregisterEncoding(encodingType)
+
+    err = RegisterMediaTypeAlias(encodingType, "text/xml")
+    g.Expect(err).To(gm.MatchError(gm.ContainSubstring(
+        "Media type 'text/xml' is already registered",
+    )))
+    found, ok := EncodingForMediaType("text/xml")
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(found).To(gm.Equal(XMLEncoding))
+
+    g.Expect(RegisterMediaTypeAlias(
+        encodingType, "application/vnd.test.other",
+    )).To(gm.Succeed())
+    g.Expect(RegisterMediaTypeAlias(
+        encodingType, "application/vnd.test.other",
+    )).To(gm.Succeed())
+    g.Expect(mediaTypesForEncoding(encodingType)).To(gm.Equal([]string{
+        "application/vnd.test.alias", "application/vnd.test.other",
+    }))
+}
+
+func TestRegisterEncoderInvalid(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    _, err := RegisterEncoder(UnsetEncoding, EncoderFunc(nil))
+    g.Expect(err).To(gm.HaveOccurred())
+
+    _, err = RegisterEncoder(EncodingType(4242), EncoderFunc(nil))
+    g.Expect(err).To(gm.MatchError(
+        "Can't register an encoder for unregistered encodingType 4242, " +
+            "register it by media type instead",
+    ))
+
+    _, err = RegisterEncoder("not a media type", EncoderFunc(nil))
+    g.Expect(err).To(gm.HaveOccurred())
+
+    _, err = RegisterEncoder(42, EncoderFunc(nil))
+    g.Expect(err).To(gm.HaveOccurred())
+
+    _, err = RegisterEncoder(JSONEncoding, nil)
+    g.Expect(err).To(gm.HaveOccurred())
+}
@@ -0,0 +1,31 @@
+package responses
+
+import (
+    "bytes"
+
+    "github.com/vmihailenco/msgpack/v5"
+)
+
+// messagePackStructTag is the struct tag used for MessagePack field names.
+// NOTE: We use the JSON tags so MessagePack bodies line up with their JSON
+//       counterparts without having to tag everything twice.
+var messagePackStructTag = "json"
+
+func encodeMessagePack(data interface{}) ([]byte, error) {
+    var buf bytes.Buffer
+    encoder := msgpack.NewEncoder(&buf)
+    encoder.SetCustomStructTag(messagePackStructTag)
+    err := encoder.Encode(data)
+    if err != nil {
+        return nil, err
+    }
+
+    return buf.Bytes(), nil
+}
+
+func decodeMessagePack(data []byte, target interface{}) error {
+    decoder := msgpack.NewDecoder(bytes.NewReader(data))
+    decoder.SetCustomStructTag(messagePackStructTag)
+
+    return decoder.Decode(target)
+}
@@ -3,11 +3,16 @@ package responses
 import (
 )
 
-// EncodingType is a way of encoding a response data.
+// EncodingType is a way of encoding a response data. The built-in types are
+// listed below; more can be added via RegisterEncoder.
 type EncodingType int
 
 const (
     UnsetEncoding EncodingType = iota
     JSONEncoding
     TextPlainEncoding
+    XMLEncoding
+    YAMLEncoding
+    CSVEncoding
+    MessagePackEncoding
 )
@@ -0,0 +1,158 @@
+package responses
+
+import (
+    "bytes"
+    "encoding/xml"
+    "fmt"
+    "reflect"
+    "sort"
+    "unicode"
+)
+
+// xmlRootElement is the element that bodies without a natural XML name (maps
+// and slices) are wrapped in.
+var xmlRootElement = "response"
+
+// xmlItemElement is the element each entry of a slice is written as.
+var xmlItemElement = "item"
+
+// xmlEntryElement is the element map entries whose keys aren't valid XML
+// names (ex: "first name" or "1st") are written as with the key in its key
+// attribute.
+var xmlEntryElement = "entry"
+
+var xmlMarshalerType = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+
+// encodeXML marshals the data to XML. The standard library can't marshal maps
+// so they (and any slices containing them) are walked manually with each key
+// becoming an element.
+func encodeXML(data interface{}) ([]byte, error) {
+    value := reflect.ValueOf(data)
+    if !xmlNeedsWalk(value) {
+        return xml.Marshal(data)
+    }
+
+    var buf bytes.Buffer
+    encoder := xml.NewEncoder(&buf)
+    err := writeXMLValue(encoder, xmlElement(xmlRootElement), value)
+    if err != nil {
+        return nil, err
+    }
+    err = encoder.Flush()
+    if err != nil {
+        return nil, err
+    }
+
+    return buf.Bytes(), nil
+}
+
+// xmlNeedsWalk checks if the value is something the standard library won't
+// be able to marshal on its own.
+func xmlNeedsWalk(value reflect.Value) bool {
+    if !value.IsValid() {
+        return false
+    }
+    for value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
+        if value.IsNil() || value.Type().Implements(xmlMarshalerType) {
+            return false
+        }
+        value = value.Elem()
+    }
+    if value.Type().Implements(xmlMarshalerType) {
+        return false
+    }
+
+    switch value.Kind() {
+    case reflect.Map:
+        return true
+    case reflect.Slice, reflect.Array:
+        return value.Type().Elem().Kind() != reflect.Uint8
+    }
+
+    return false
+}
+
+// isXMLName checks if the name can be used as an element name as is.
+func isXMLName(name string) bool {
+    if name == "" {
+        return false
+    }
+    for i, char := range name {
+        if char == '_' || unicode.IsLetter(char) {
+            continue
+        }
+        if i > 0 && (char == '-' || char == '.' || unicode.IsDigit(char)) {
+            continue
+        }
+
+        return false
+    }
+
+    return true
+}
+
+func xmlElement(name string) xml.StartElement {
+    return xml.StartElement{Name: xml.Name{Local: name}}
+}
+
+// xmlMapEntry generates the element for a map entry, keys that aren't valid
+// XML names are put in an attribute instead.
+func xmlMapEntry(key string) xml.StartElement {
+    if isXMLName(key) {
+        return xmlElement(key)
+    }
+
+    start := xmlElement(xmlEntryElement)
+    start.Attr = []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}
+
+    return start
+}
+
+func writeXMLValue(
+    encoder *xml.Encoder, start xml.StartElement, value reflect.Value,
+) error {
+    if !xmlNeedsWalk(value) {
+        if !value.IsValid() {
+            return encoder.EncodeElement("", start)
+        }
+        return encoder.EncodeElement(value.Interface(), start)
+    }
+
+    for value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
+        value = value.Elem()
+    }
+
+    err := encoder.EncodeToken(start)
+    if err != nil {
+        return err
+    }
+
+    if value.Kind() == reflect.Map {
+        keys := value.MapKeys()
+        sort.Slice(keys, func(i, j int) bool {
+            return fmt.Sprint(keys[i].Interface()) <
+                fmt.Sprint(keys[j].Interface())
+        })
+        for _, key := range keys {
+            err = writeXMLValue(
+                encoder,
+                xmlMapEntry(fmt.Sprint(key.Interface())),
+                value.MapIndex(key),
+            )
+            if err != nil {
+                return err
+            }
+        }
+    } else {
+        for i := 0; i < value.Len(); i++ {
+            err = writeXMLValue(
+                encoder, xmlElement(xmlItemElement), value.Index(i),
+            )
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    return encoder.EncodeToken(start.End())
+}
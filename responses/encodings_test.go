@@ -0,0 +1,231 @@
+package responses
+
+import (
+    "context"
+    "net/http"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+)
+
+type encodingTestRow struct {
+    Name string `json:"name" yaml:"name" xml:"name" csv:"name"`
+    Count int `json:"count" yaml:"count" xml:"count" csv:"count"`
+    Tags []string `json:"tags" yaml:"tags" xml:"tags" csv:"tags"`
+    Hidden string `json:"-" yaml:"-" xml:"-" csv:"-"`
+}
+
+var encodingTestRows = []encodingTestRow{
+    {Name: "foo", Count: 1, Tags: []string{"a", "b"}},
+    {Name: "bar, baz", Count: 2},
+}
+
+func encodeWith(
+    g *gm.GomegaWithT, encodingType EncodingType, data interface{},
+) Data {
+    builder, err := NewBuilder(
+        context.Background(),
+        encodingType,
+        Body(data),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish()
+    g.Expect(responseData.Error()).To(gm.BeNil())
+
+    return responseData
+}
+
+func TestXMLEncoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := encodeWith(g, XMLEncoding, map[string]interface{}{
+        "rows": encodingTestRows[:1],
+        "total": 1,
+    })
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        `<response><rows><item><name>foo</name><count>1</count>` +
+            `<tags>a</tags><tags>b</tags></item></rows>` +
+            `<total>1</total></response>`,
+    ))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(XMLContentType),
+    )
+
+    var decoded encodingTestRow
+    decoder, ok := DecoderForContentType("text/xml")
+    g.Expect(ok).To(gm.BeTrue())
+    err := decoder.Decode(
+        []byte(`<row><name>foo</name><count>3</count></row>`), &decoded,
+    )
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded.Count).To(gm.Equal(3))
+}
+
+func TestXMLEncodingInvalidNames(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := encodeWith(g, XMLEncoding, map[string]interface{}{
+        "first name": "alice",
+        "1st": true,
+        "a<b": map[string]int{"ok": 1},
+        "valid-name.2": "yes",
+    })
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        `<response><entry key="1st">true</entry>` +
+            `<entry key="a&lt;b"><ok>1</ok></entry>` +
+            `<entry key="first name">alice</entry>` +
+            `<valid-name.2>yes</valid-name.2></response>`,
+    ))
+}
+
+func TestXMLEncodingAbort(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := Abort(
+        context.Background(),
+        XMLEncoding,
+        http.StatusConflict,
+        neterr.NewCodedError(1, "Conflict"),
+    )
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        `<response><errors><item><code>1</code><message>Conflict</message>` +
+            `</item></errors></response>`,
+    ))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(XMLContentType),
+    )
+}
+
+func TestYAMLEncoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := encodeWith(g, YAMLEncoding, encodingTestRows[0])
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        "name: foo\ncount: 1\ntags:\n- a\n- b\n",
+    ))
+
+    var decoded encodingTestRow
+    decoder, ok := DecoderForContentType("application/x-yaml")
+    g.Expect(ok).To(gm.BeTrue())
+    err := decoder.Decode(responseData.Body, &decoded)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded).To(gm.Equal(encodingTestRows[0]))
+}
+
+func TestCSVEncoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := encodeWith(g, CSVEncoding, encodingTestRows)
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        "name,count,tags\n" +
+            "foo,1,\"[\"\"a\"\",\"\"b\"\"]\"\n" +
+            "\"bar, baz\",2,null\n",
+    ))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(CSVContentType),
+    )
+
+    var decoded []encodingTestRow
+    decoder, ok := DecoderForEncoding(CSVEncoding)
+    g.Expect(ok).To(gm.BeTrue())
+    err := decoder.Decode(responseData.Body, &decoded)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded).To(gm.Equal(encodingTestRows))
+}
+
+func TestCSVEncodingMaps(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := encodeWith(g, CSVEncoding, []map[string]interface{}{
+        {"b": 1, "a": "x"},
+        {"c": true},
+    })
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        "a,b,c\nx,1,\n,,true\n",
+    ))
+
+    var decoded []map[string]string
+    err := decodeCSV(responseData.Body, &decoded)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded).To(gm.Equal([]map[string]string{
+        {"a": "x", "b": "1", "c": ""},
+        {"a": "", "b": "", "c": "true"},
+    }))
+}
+
+func TestCSVDecodingNamedMapTypes(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    type column string
+    type cell string
+
+    var decoded []map[column]cell
+    err := decodeCSV([]byte("a,b\nx,1\n"), &decoded)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded).To(gm.Equal([]map[column]cell{
+        {"a": "x", "b": "1"},
+    }))
+}
+
+func TestCSVDecodingBadTarget(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var decoded int
+    err := decodeCSV([]byte("a\n1\n"), &decoded)
+    g.Expect(err).To(gm.HaveOccurred())
+}
+
+func TestMessagePackEncoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := encodeWith(g, MessagePackEncoding, encodingTestRows[0])
+
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(MessagePackContentType),
+    )
+
+    var decoded map[string]interface{}
+    decoder, ok := DecoderForContentType("application/msgpack")
+    g.Expect(ok).To(gm.BeTrue())
+    err := decoder.Decode(responseData.Body, &decoded)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded).To(gm.HaveKeyWithValue("name", "foo"))
+    g.Expect(decoded).ToNot(gm.HaveKey("Hidden"))
+}
+
+func TestMessagePackEncodingAbort(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    responseData := Abort(
+        context.Background(),
+        MessagePackEncoding,
+        http.StatusConflict,
+        neterr.NewCodedError(1, "Conflict"),
+    )
+
+    var decoded struct {
+        Errors []neterr.CodedError `json:"errors"`
+    }
+    err := decodeMessagePack(responseData.Body, &decoded)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(decoded.Errors).To(gm.HaveLen(1))
+    g.Expect(decoded.Errors[0].Code()).To(gm.Equal(1))
+    g.Expect(decoded.Errors[0].Message()).To(gm.Equal("Conflict"))
+}
+
+func TestNegotiationAliases(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoding, ok := NegotiateEncoding("text/yaml", JSONEncoding)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(encoding).To(gm.Equal(YAMLEncoding))
+}
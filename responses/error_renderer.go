@@ -0,0 +1,159 @@
+package responses
+
+import (
+    "context"
+    "net/http"
+
+    "github.com/daihasso/vial/neterr"
+)
+
+// contextKey keeps the keys this package stores values under from colliding
+// with anyone else's.
+type contextKey string
+
+// errorRendererContextKey is the context key an ErrorRenderer is looked up
+// under when a Builder (or Abort) generates a failure response.
+const errorRendererContextKey contextKey = "vial.responses.error_renderer"
+
+// ProblemJSONContentType is the content type for RFC 7807 problem details.
+const ProblemJSONContentType = "application/problem+json"
+
+// ErrorRenderer renders CodedErrors into the body of a failure response by
+// setting the body (and optionally the encoding & content type) on the
+// provided builder.
+type ErrorRenderer interface {
+    RenderErrors(
+        ctx context.Context,
+        builder *Builder,
+        statusCode int,
+        codedErrors []neterr.CodedError,
+    )
+}
+
+// ErrorRendererFunc is a function that implements ErrorRenderer.
+type ErrorRendererFunc func(
+    context.Context, *Builder, int, []neterr.CodedError,
+)
+
+// RenderErrors calls the function.
+func (self ErrorRendererFunc) RenderErrors(
+    ctx context.Context,
+    builder *Builder,
+    statusCode int,
+    codedErrors []neterr.CodedError,
+) {
+    self(ctx, builder, statusCode, codedErrors)
+}
+
+// ErrorListRenderer is the default ErrorRenderer. It renders errors as
+// `{"errors": [{"code": ..., "message": ...}]}` in the builder's encoding.
+type ErrorListRenderer struct{}
+
+// RenderErrors sets the builder's body to the list of errors.
+func (ErrorListRenderer) RenderErrors(
+    _ context.Context,
+    builder *Builder,
+    _ int,
+    codedErrors []neterr.CodedError,
+) {
+    builder.SetBody(map[string]interface{}{
+        "errors": codedErrors,
+    })
+}
+
+// DefaultErrorRenderer is the ErrorRenderer used when none is set on the
+// builder or its context.
+var DefaultErrorRenderer ErrorRenderer = ErrorListRenderer{}
+
+// ProblemRenderer is an ErrorRenderer which renders errors as RFC 7807
+// problem details (`application/problem+json`). The first error provides the
+// detail and code, all of them are listed under errors when there are several.
+type ProblemRenderer struct {
+    // TypeURI generates the problem type for an error, if it is nil or
+    // returns an empty string "about:blank" is used.
+    TypeURI func(neterr.CodedError) string
+
+    // Instance generates the URI reference identifying this occurrence of
+    // the problem. It's omitted if nil or empty.
+    Instance func(context.Context) string
+
+    // Extensions adds extra members to the problem details. They never
+    // override the standard members.
+    Extensions func(
+        context.Context, []neterr.CodedError,
+    ) map[string]interface{}
+}
+
+// Problem generates the problem details for the provided errors.
+func (self ProblemRenderer) Problem(
+    ctx context.Context, statusCode int, codedErrors []neterr.CodedError,
+) map[string]interface{} {
+    problem := make(map[string]interface{})
+    if self.Extensions != nil {
+        for key, value := range self.Extensions(ctx, codedErrors) {
+            problem[key] = value
+        }
+    }
+
+    problemType := ""
+    if len(codedErrors) != 0 {
+        first := codedErrors[0]
+        if self.TypeURI != nil {
+            problemType = self.TypeURI(first)
+        }
+        problem["detail"] = first.Message()
+        problem["code"] = first.Code()
+        if len(codedErrors) > 1 {
+            problem["errors"] = codedErrors
+        }
+    }
+    if problemType == "" {
+        problemType = "about:blank"
+    }
+    problem["type"] = problemType
+    problem["title"] = http.StatusText(statusCode)
+    problem["status"] = statusCode
+
+    if self.Instance != nil {
+        if instance := self.Instance(ctx); instance != "" {
+            problem["instance"] = instance
+        }
+    }
+
+    return problem
+}
+
+// RenderErrors sets the builder's body to the problem details and forces the
+// problem+json content type.
+func (self ProblemRenderer) RenderErrors(
+    ctx context.Context,
+    builder *Builder,
+    statusCode int,
+    codedErrors []neterr.CodedError,
+) {
+    delete(builder.headers, ContentTypeHeader)
+    builder.SetEncoding(JSONEncoding)
+    builder.SetContentType(ProblemJSONContentType)
+    builder.SetBody(self.Problem(ctx, statusCode, codedErrors))
+}
+
+// ContextWithErrorRenderer creates a new context with the provided
+// ErrorRenderer inserted into it.
+func ContextWithErrorRenderer(
+    ctx context.Context, renderer ErrorRenderer,
+) context.Context {
+    return context.WithValue(ctx, errorRendererContextKey, renderer)
+}
+
+// ContextErrorRenderer retrieves the ErrorRenderer stored in the provided
+// context or the DefaultErrorRenderer if there isn't one.
+func ContextErrorRenderer(ctx context.Context) ErrorRenderer {
+    if ctx != nil {
+        renderer, ok := ctx.Value(errorRendererContextKey).(ErrorRenderer)
+        if ok && renderer != nil {
+            return renderer
+        }
+    }
+
+    return DefaultErrorRenderer
+}
@@ -0,0 +1,108 @@
+package responses
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+)
+
+func TestProblemRendererAbort(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    renderer := ProblemRenderer{
+        TypeURI: func(codedError neterr.CodedError) string {
+            return "https://errors.example.com/" +
+                strconv.Itoa(codedError.Code())
+        },
+        Instance: func(context.Context) string {
+            return "abc-123"
+        },
+        Extensions: func(
+            context.Context, []neterr.CodedError,
+        ) map[string]interface{} {
+            return map[string]interface{}{
+                "retryable": false,
+                "status": 200,
+            }
+        },
+    }
+
+    builder, err := NewBuilder(
+        ContextWithErrorRenderer(context.Background(), renderer),
+        XMLEncoding,
+        AddHeader(ContentTypeHeader, "application/xml"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Abort(
+        http.StatusConflict,
+        neterr.NewCodedError(3, "Already exists"),
+        neterr.NewCodedError(4, "Really"),
+    )
+    g.Expect(responseData.Error()).To(gm.BeNil())
+    g.Expect(responseData.StatusCode).To(gm.Equal(http.StatusConflict))
+    g.Expect(http.Header(responseData.Headers).Get(ContentTypeHeader)).To(
+        gm.Equal(ProblemJSONContentType),
+    )
+
+    var problem map[string]interface{}
+    g.Expect(json.Unmarshal(responseData.Body, &problem)).To(gm.BeNil())
+    g.Expect(problem).To(gm.And(
+        gm.HaveKeyWithValue("type", "https://errors.example.com/3"),
+        gm.HaveKeyWithValue("title", "Conflict"),
+        gm.HaveKeyWithValue("status", float64(http.StatusConflict)),
+        gm.HaveKeyWithValue("detail", "Already exists"),
+        gm.HaveKeyWithValue("instance", "abc-123"),
+        gm.HaveKeyWithValue("code", float64(3)),
+        gm.HaveKeyWithValue("retryable", false),
+    ))
+    g.Expect(problem["errors"]).To(gm.HaveLen(2))
+}
+
+func TestProblemRendererPackageAbort(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    expectedBodyBytes := []byte(
+        `{"code":1,"detail":"Nope","status":404,"title":"Not Found",` +
+            `"type":"about:blank"}`,
+    )
+
+    responseData := Abort(
+        ContextWithErrorRenderer(context.Background(), ProblemRenderer{}),
+        JSONEncoding,
+        http.StatusNotFound,
+        neterr.NewCodedError(1, "Nope"),
+    )
+
+    g.Expect(responseData.Body).To(gm.Equal(expectedBodyBytes))
+}
+
+func TestBuilderErrorRendererOverride(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        ContextWithErrorRenderer(context.Background(), ProblemRenderer{}),
+        JSONEncoding,
+    )
+    g.Expect(err).To(gm.BeNil())
+    builder.SetErrorRenderer(ErrorRendererFunc(func(
+        _ context.Context, b *Builder, _ int, _ []neterr.CodedError,
+    ) {
+        b.SetBody("custom")
+    }))
+
+    responseData := builder.Abort(
+        http.StatusTeapot, neterr.NewCodedError(1, "Nope"),
+    )
+
+    g.Expect(string(responseData.Body)).To(gm.Equal("custom"))
+    g.Expect(http.Header(responseData.Headers).Get(ContentTypeHeader)).To(
+        gm.Equal(JSONContentType),
+    )
+}
@@ -0,0 +1,66 @@
+package responses
+
+import (
+    "context"
+
+    "github.com/daihasso/vial/neterr"
+)
+
+// localizationContextKey is the context key the translations and the
+// requestor's Accept-Language are looked up under when errors are rendered.
+const localizationContextKey contextKey = "vial.responses.localization"
+
+// ContentLanguageHeader is the header that states the locale of the body.
+const ContentLanguageHeader = "Content-Language"
+
+type localization struct {
+    translations *neterr.Translations
+    acceptLanguage string
+}
+
+// ContextWithLocalization creates a new context with the provided
+// translations and Accept-Language header inserted into it. CodedErrors with
+// a message key are localized with them when a builder in the context aborts.
+func ContextWithLocalization(
+    ctx context.Context,
+    translations *neterr.Translations,
+    acceptLanguage string,
+) context.Context {
+    return context.WithValue(ctx, localizationContextKey, localization{
+        translations: translations,
+        acceptLanguage: acceptLanguage,
+    })
+}
+
+// localizeErrors localizes the errors using the translations in the context
+// if there are any. The locale of the first localized error is returned
+// along with whether any of the errors could be localized at all.
+func localizeErrors(
+    ctx context.Context, codedErrors []neterr.CodedError,
+) ([]neterr.CodedError, string, bool) {
+    if ctx == nil {
+        return codedErrors, "", false
+    }
+    localizer, ok := ctx.Value(localizationContextKey).(localization)
+    if !ok || localizer.translations == nil {
+        return codedErrors, "", false
+    }
+
+    localizable := false
+    contentLanguage := ""
+    localized := make([]neterr.CodedError, len(codedErrors))
+    for i, codedError := range codedErrors {
+        if codedError.MessageKey() != "" {
+            localizable = true
+        }
+        var locale string
+        localized[i], locale = localizer.translations.Localize(
+            codedError, localizer.acceptLanguage,
+        )
+        if contentLanguage == "" {
+            contentLanguage = locale
+        }
+    }
+
+    return localized, contentLanguage, localizable
+}
@@ -0,0 +1,190 @@
+package responses
+
+import (
+    "mime"
+    "strconv"
+    "strings"
+)
+
+// mediaRange is a single parsed entry from an Accept header.
+type mediaRange struct {
+    mainType,
+    subType string
+    params map[string]string
+    quality float64
+}
+
+// specificity ranks how specific a media range is so the most specific match
+// wins as described in RFC 7231 section 5.3.2.
+func (self mediaRange) specificity() int {
+    if self.mainType == "*" {
+        return 0
+    } else if self.subType == "*" {
+        return 1
+    }
+
+    return 2 + len(self.params)
+}
+
+// matches checks if the provided media type (and its parameters) fall within
+// this media range.
+func (self mediaRange) matches(
+    mainType, subType string, params map[string]string,
+) bool {
+    if self.mainType != "*" && self.mainType != mainType {
+        return false
+    }
+    if self.subType != "*" && self.subType != subType {
+        return false
+    }
+    for key, value := range self.params {
+        if !strings.EqualFold(params[key], value) {
+            return false
+        }
+    }
+
+    return true
+}
+
+func splitMediaType(mediaType string) (string, string, map[string]string) {
+    parsed, params, err := mime.ParseMediaType(mediaType)
+    if err != nil {
+        return "", "", nil
+    }
+    parts := strings.SplitN(parsed, "/", 2)
+    if len(parts) != 2 {
+        return parts[0], "", params
+    }
+
+    return parts[0], parts[1], params
+}
+
+// parseAccept parses an Accept header into its media ranges. Malformed ranges
+// are ignored.
+func parseAccept(header string) []mediaRange {
+    var ranges []mediaRange
+    for _, rawRange := range strings.Split(header, ",") {
+        rawRange = strings.TrimSpace(rawRange)
+        if rawRange == "" {
+            continue
+        }
+
+        mainType, subType, params := splitMediaType(rawRange)
+        if mainType == "" || subType == "" {
+            continue
+        }
+
+        quality := 1.0
+        if rawQuality, ok := params["q"]; ok {
+            parsedQuality, err := strconv.ParseFloat(rawQuality, 64)
+            if err != nil || parsedQuality < 0 || parsedQuality > 1 {
+                continue
+            }
+            quality = parsedQuality
+            delete(params, "q")
+        }
+        // NOTE: Charset is handled by the encoder itself, we don't want it
+        //       to prevent a match.
+        delete(params, "charset")
+
+        ranges = append(ranges, mediaRange{
+            mainType: mainType,
+            subType: subType,
+            params: params,
+            quality: quality,
+        })
+    }
+
+    return ranges
+}
+
+// qualityFor finds the quality the requestor assigned to the provided
+// content type. The most specific matching range is used.
+func qualityFor(ranges []mediaRange, contentType string) float64 {
+    mainType, subType, params := splitMediaType(contentType)
+    delete(params, "charset")
+
+    bestSpecificity := -1
+    quality := 0.0
+    for _, acceptable := range ranges {
+        if !acceptable.matches(mainType, subType, params) {
+            continue
+        }
+        specificity := acceptable.specificity()
+        if specificity > bestSpecificity {
+            bestSpecificity = specificity
+            quality = acceptable.quality
+        }
+    }
+
+    return quality
+}
+
+// NegotiateEncoding picks the best EncodingType for the provided Accept
+// header out of the registered encodings. The preferred encoding wins any
+// ties. If the header is empty or unparsable the preferred encoding is
+// returned. The bool return is false if nothing acceptable could be found.
+func NegotiateEncoding(
+    acceptHeader string, preferred EncodingType,
+) (EncodingType, bool) {
+    return negotiateAmong(acceptHeader, preferred, RegisteredEncodings())
+}
+
+// negotiateErrorEncoding picks the EncodingType for a body of errors. Only
+// the structured encodings are negotiated since the others (ex: text/plain)
+// can't represent the errors, JSON is used if the preferred encoding isn't
+// structured and nothing else is acceptable.
+func negotiateErrorEncoding(
+    acceptHeader string, preferred EncodingType,
+) EncodingType {
+    if !isStructuredEncoding(preferred) {
+        preferred = JSONEncoding
+    }
+    negotiated, _ := negotiateAmong(
+        acceptHeader, preferred, structuredEncodings(),
+    )
+
+    return negotiated
+}
+
+// negotiateAmong picks the best of the provided encodings for the Accept
+// header, see NegotiateEncoding.
+func negotiateAmong(
+    acceptHeader string, preferred EncodingType, encodings []EncodingType,
+) (EncodingType, bool) {
+    ranges := parseAccept(acceptHeader)
+    if len(ranges) == 0 {
+        return preferred, true
+    }
+
+    candidates := make([]EncodingType, 0, len(encodings))
+    for _, encodingType := range encodings {
+        if encodingType == preferred {
+            candidates = append([]EncodingType{preferred}, candidates...)
+        } else {
+            candidates = append(candidates, encodingType)
+        }
+    }
+
+    bestEncoding := UnsetEncoding
+    bestQuality := 0.0
+    for _, encodingType := range candidates {
+        quality := 0.0
+        for _, mediaType := range mediaTypesForEncoding(encodingType) {
+            mediaQuality := qualityFor(ranges, mediaType)
+            if mediaQuality > quality {
+                quality = mediaQuality
+            }
+        }
+        if quality > bestQuality {
+            bestQuality = quality
+            bestEncoding = encodingType
+        }
+    }
+
+    if bestEncoding == UnsetEncoding {
+        return preferred, false
+    }
+
+    return bestEncoding, true
+}
@@ -0,0 +1,209 @@
+package responses
+
+import (
+    "context"
+    "net/http"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+)
+
+func TestNegotiateEncodingEmpty(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoding, ok := NegotiateEncoding("", JSONEncoding)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(encoding).To(gm.Equal(JSONEncoding))
+}
+
+func TestNegotiateEncodingExact(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoding, ok := NegotiateEncoding("text/plain", JSONEncoding)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(encoding).To(gm.Equal(TextPlainEncoding))
+}
+
+func TestNegotiateEncodingQuality(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoding, ok := NegotiateEncoding(
+        "application/json;q=0.5, text/plain;q=0.8", JSONEncoding,
+    )
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(encoding).To(gm.Equal(TextPlainEncoding))
+}
+
+func TestNegotiateEncodingWildcardPrefersDefault(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoding, ok := NegotiateEncoding("*/*", TextPlainEncoding)
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(encoding).To(gm.Equal(TextPlainEncoding))
+}
+
+func TestNegotiateEncodingSpecificOverridesWildcard(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    encoding, ok := NegotiateEncoding(
+        "*/*;q=0.1, application/json;q=0", JSONEncoding,
+    )
+    g.Expect(ok).To(gm.BeTrue())
+    g.Expect(encoding).To(gm.Equal(TextPlainEncoding))
+}
+
+func TestNegotiateEncodingNothingAcceptable(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    _, ok := NegotiateEncoding("image/png", JSONEncoding)
+    g.Expect(ok).To(gm.BeFalse())
+}
+
+func TestBuilderNegotiation(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Body([]string{"foo"}),
+        Negotiate("text/plain"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish()
+
+    g.Expect(string(responseData.Body)).To(gm.Equal("[foo]"))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(TextPlainUTF8ContentType),
+    )
+    g.Expect(responseData.Headers["Vary"]).To(gm.ConsistOf("Accept"))
+}
+
+func TestBuilderNegotiationExplicitEncoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Body([]string{"foo"}),
+        Negotiate("text/plain"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish(Encoding(JSONEncoding))
+
+    g.Expect(string(responseData.Body)).To(gm.Equal(`["foo"]`))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(JSONContentType),
+    )
+}
+
+func TestBuilderNegotiationNotAcceptable(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Body([]string{"foo"}),
+        Status(http.StatusOK),
+        Negotiate("image/png"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish()
+
+    g.Expect(responseData.StatusCode).To(gm.Equal(http.StatusNotAcceptable))
+    g.Expect(string(responseData.Body)).To(gm.Equal(
+        `{"errors":[{"code":5,"message":"None of the requested content` +
+            ` types can be produced.","vial_error":true}]}`,
+    ))
+    g.Expect(responseData.Headers["Vary"]).To(gm.ConsistOf("Accept"))
+}
+
+func TestBuilderAbortNegotiationFallsBack(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Negotiate("image/png"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Abort(
+        http.StatusConflict,
+        neterr.NewCodedError(0, "Expected failure while testing"),
+    )
+
+    g.Expect(responseData.StatusCode).To(gm.Equal(http.StatusConflict))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(JSONContentType),
+    )
+}
+
+func TestBuilderAbortKeepsStructuredEncoding(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    abort := func(preferred EncodingType, accept string) Data {
+        builder, err := NewBuilder(
+            context.Background(), preferred, Negotiate(accept),
+        )
+        g.Expect(err).To(gm.BeNil())
+
+        return builder.Abort(
+            http.StatusConflict,
+            neterr.NewCodedError(0, "Expected failure while testing"),
+        )
+    }
+
+    responseData := abort(JSONEncoding, "text/plain")
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(JSONContentType),
+    )
+    g.Expect(string(responseData.Body)).To(gm.HavePrefix(`{"errors":`))
+
+    responseData = abort(TextPlainEncoding, "")
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(JSONContentType),
+    )
+
+    responseData = abort(JSONEncoding, "text/csv, application/xml;q=0.5")
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(XMLContentType),
+    )
+}
+
+func TestBuilderNegotiationSkipsEncodedBodies(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Body(`{"a":1}`),
+        Status(http.StatusOK),
+        Negotiate("application/xml"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish()
+    g.Expect(string(responseData.Body)).To(gm.Equal(`{"a":1}`))
+    g.Expect(responseData.Headers["Content-Type"]).To(
+        gm.ConsistOf(JSONContentType),
+    )
+
+    builder, err = NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Body([]byte(`{"a":1}`)),
+        Status(http.StatusOK),
+        Negotiate("image/png"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData = builder.Finish()
+    g.Expect(responseData.StatusCode).To(gm.Equal(http.StatusOK))
+    g.Expect(string(responseData.Body)).To(gm.Equal(`{"a":1}`))
+    g.Expect(responseData.Headers["Vary"]).To(gm.BeEmpty())
+}
@@ -2,16 +2,32 @@ package responses
 
 import (
     "net/http"
+    "time"
 
     "github.com/pkg/errors"
 )
 
+// HijackFunc takes over the response once the headers have been set. The
+// headers are present on the ResponseWriter but nothing has been written; the
+// func is responsible for writing the status (or hijacking the connection).
+type HijackFunc func(w http.ResponseWriter) error
+
 // Data is a representation of the route transaction's response.
 type Data struct {
     Headers map[string][]string
     Body []byte
     StatusCode int
 
+    // Stream, if set, is used to write the body instead of Body. The
+    // response is flushed every FlushInterval (or after every write if it's
+    // FlushImmediately) while streaming.
+    Stream StreamFunc
+    FlushInterval time.Duration
+
+    // Hijack, if set, takes over the connection once the headers have been
+    // set instead of writing a status and body (ex: a protocol upgrade).
+    Hijack HijackFunc
+
     // unexpectedError is an error that's incidental (ex: an error that
     // happened while forming the ResponseData itself). It will trigger an
     // Internal Service Error (500).
@@ -23,6 +39,19 @@ func (self Data) Write(w http.ResponseWriter) error {
     for key, value := range self.Headers {
         w.Header()[key] = value
     }
+
+    if self.Hijack != nil {
+        err := self.Hijack(w)
+        if err != nil {
+            return errors.Wrap(err, "Error while handling hijacked response")
+        }
+        return nil
+    }
+
+    if self.Stream != nil {
+        return self.writeStream(w)
+    }
+
     w.WriteHeader(self.StatusCode)
 
     // FIXME: Temporary fix for empty bodies to solve:
@@ -40,6 +69,29 @@ func (self Data) Write(w http.ResponseWriter) error {
     return nil
 }
 
+func (self Data) writeStream(w http.ResponseWriter) error {
+    // NOTE: The length of a stream usually isn't known up front so the
+    //       response is chunked unless a Content-Length was provided.
+    w.WriteHeader(self.StatusCode)
+
+    err := runStream(self.Stream, newStreamWriter(w, self.FlushInterval))
+    if err != nil {
+        return errors.Wrap(err, "Error while streaming response data")
+    }
+
+    return nil
+}
+
+// IsStream checks if the response body is streamed.
+func (self Data) IsStream() bool {
+    return self.Stream != nil
+}
+
+// IsHijacked checks if the response takes over the connection.
+func (self Data) IsHijacked() bool {
+    return self.Hijack != nil
+}
+
 // Error returns and unexpected errors that may have happened.
 func (self Data) Error() error {
     return self.unexpectedError
@@ -48,6 +100,8 @@ func (self Data) Error() error {
 // ErrorResponse returns a response Data from an error. This is for fatal
 // abortions, generally avoid this in favor of returning a neterr.CodedError
 // with abort instead for better client experience.
+// If the error wraps a neterr.CodedError the server responds with it (and
+// its status) instead of a generic Internal Server Error.
 func ErrorResponse(err error) Data {
     return Data{unexpectedError: err}
 }
@@ -0,0 +1,185 @@
+package responses
+
+import (
+    "io"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/pkg/errors"
+)
+
+// StreamFunc writes a streaming response body. It is called after the status
+// and headers have been written.
+type StreamFunc func(w *StreamWriter) error
+
+// FlushImmediately can be used as a flush interval to flush after every
+// write.
+const FlushImmediately time.Duration = -1
+
+// DefaultFlushInterval is the flush interval used for streams that don't
+// specify one.
+var DefaultFlushInterval = 100 * time.Millisecond
+
+// StreamWriter is the writer handed to a StreamFunc. Written data is flushed
+// to the client on the configured cadence; unless a Content-Length header was
+// set the response is sent using chunked transfer-encoding.
+type StreamWriter struct {
+    writer io.Writer
+    flusher http.Flusher
+    flushInterval time.Duration
+
+    lock sync.Mutex
+    dirty bool
+    done chan struct{}
+    stopped sync.WaitGroup
+}
+
+// Write writes the data to the response.
+func (self *StreamWriter) Write(p []byte) (int, error) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    n, err := self.writer.Write(p)
+    self.dirty = true
+    if err == nil && self.flushInterval < 0 {
+        self.flushLocked()
+    }
+
+    return n, err
+}
+
+// Flush sends any buffered data to the client immediately.
+func (self *StreamWriter) Flush() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.flushLocked()
+}
+
+func (self *StreamWriter) flushLocked() {
+    if self.dirty && self.flusher != nil {
+        self.flusher.Flush()
+    }
+    self.dirty = false
+}
+
+func (self *StreamWriter) flushPeriodically() {
+    defer self.stopped.Done()
+
+    ticker := time.NewTicker(self.flushInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            self.Flush()
+        case <-self.done:
+            return
+        }
+    }
+}
+
+// close stops the periodic flushing and flushes anything remaining.
+func (self *StreamWriter) close() {
+    close(self.done)
+    self.stopped.Wait()
+    self.Flush()
+}
+
+// runStream runs the stream, closing the writer afterwards even if the stream
+// panics so the periodic flushing doesn't outlive the response.
+func runStream(stream StreamFunc, w *StreamWriter) error {
+    defer w.close()
+
+    return stream(w)
+}
+
+func newStreamWriter(
+    w http.ResponseWriter, flushInterval time.Duration,
+) *StreamWriter {
+    flusher, _ := w.(http.Flusher)
+    return newStreamWriterFor(w, flusher, flushInterval)
+}
+
+func newStreamWriterFor(
+    w io.Writer, flusher http.Flusher, flushInterval time.Duration,
+) *StreamWriter {
+    if flushInterval == 0 {
+        flushInterval = DefaultFlushInterval
+    }
+
+    streamWriter := &StreamWriter{
+        writer: w,
+        flusher: flusher,
+        flushInterval: flushInterval,
+        done: make(chan struct{}),
+    }
+    if flushInterval > 0 {
+        streamWriter.stopped.Add(1)
+        go streamWriter.flushPeriodically()
+    }
+
+    return streamWriter
+}
+
+// ReaderStream creates a StreamFunc which copies the contents of the reader
+// into the response. If the reader is also an io.Closer it is closed once
+// the copy finishes.
+func ReaderStream(reader io.Reader) StreamFunc {
+    return func(w *StreamWriter) error {
+        if closer, ok := reader.(io.Closer); ok {
+            defer closer.Close()
+        }
+
+        _, err := io.Copy(w, reader)
+        if err != nil {
+            return errors.Wrap(err, "Error while streaming reader to response")
+        }
+
+        return nil
+    }
+}
+
+// flushFunc adapts a function to an http.Flusher.
+type flushFunc func()
+
+func (self flushFunc) Flush() {
+    self()
+}
+
+// WrapStream wraps a StreamFunc so everything it writes passes through the
+// writer created by wrap (ex: a compressor) before reaching the response. If
+// the wrapping writer has a Flush method it's flushed along with the
+// response.
+func WrapStream(
+    stream StreamFunc, wrap func(io.Writer) (io.WriteCloser, error),
+) StreamFunc {
+    return func(w *StreamWriter) error {
+        wrapped, err := wrap(w)
+        if err != nil {
+            return errors.Wrap(err, "Error while wrapping stream")
+        }
+
+        flusher := flushFunc(w.Flush)
+        if wrappedFlusher, ok := wrapped.(interface{ Flush() error }); ok {
+            flusher = func() {
+                wrappedFlusher.Flush()
+                w.Flush()
+            }
+        }
+        err = runStream(
+            stream, newStreamWriterFor(wrapped, flusher, w.flushInterval),
+        )
+        closeErr := wrapped.Close()
+        if err != nil {
+            return err
+        }
+        if closeErr != nil {
+            return errors.Wrap(closeErr, "Error while closing wrapped stream")
+        }
+        w.Flush()
+
+        return nil
+    }
+}
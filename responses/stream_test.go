@@ -0,0 +1,134 @@
+package responses
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+)
+
+func TestBuilderBodyReader(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+    rr := httptest.NewRecorder()
+
+    builder, err := NewBuilder(
+        context.Background(),
+        JSONEncoding,
+        Status(http.StatusOK),
+        BodyReader(strings.NewReader("streamed body")),
+        Negotiate("application/json"),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    responseData := builder.Finish()
+    g.Expect(responseData.IsStream()).To(gm.BeTrue())
+    g.Expect(responseData.Body).To(gm.BeNil())
+
+    err = responseData.Write(rr)
+    g.Expect(err).To(gm.BeNil())
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal("streamed body"))
+    g.Expect(rr.Header().Get("Content-Type")).To(
+        gm.Equal(StreamContentType),
+    )
+    g.Expect(rr.Flushed).To(gm.BeTrue())
+}
+
+func TestBuilderBodyStreamFlushImmediately(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+    rr := httptest.NewRecorder()
+
+    var flushedWrites []bool
+    responseData := Respond(
+        context.Background(),
+        JSONEncoding,
+        http.StatusOK,
+        OverrideContentType("text/csv"),
+        FlushInterval(FlushImmediately),
+        BodyStream(func(w *StreamWriter) error {
+            for i := 0; i < 3; i++ {
+                rr.Flushed = false
+                _, err := fmt.Fprintf(w, "%d\n", i)
+                if err != nil {
+                    return err
+                }
+                flushedWrites = append(flushedWrites, rr.Flushed)
+            }
+            return nil
+        }),
+    )
+
+    err := responseData.Write(rr)
+    g.Expect(err).To(gm.BeNil())
+
+    g.Expect(rr.Body.String()).To(gm.Equal("0\n1\n2\n"))
+    g.Expect(rr.Header().Get("Content-Type")).To(gm.Equal("text/csv"))
+    g.Expect(flushedWrites).To(gm.Equal([]bool{true, true, true}))
+}
+
+func TestBuilderBodyStreamError(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+    rr := httptest.NewRecorder()
+
+    responseData := Respond(
+        context.Background(),
+        JSONEncoding,
+        http.StatusOK,
+        BodyStream(func(w *StreamWriter) error {
+            return errors.New("Expected failure!")
+        }),
+    )
+
+    err := responseData.Write(rr)
+    g.Expect(err).To(gm.MatchError(
+        "Error while streaming response data: Expected failure!",
+    ))
+}
+
+func TestBuilderBodyStreamPanicStopsFlushing(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    var streamWriters []*StreamWriter
+    stream := func(w *StreamWriter) error {
+        streamWriters = append(streamWriters, w)
+        panic("Expected panic!")
+    }
+    wrap := func(w io.Writer) (io.WriteCloser, error) {
+        return nopWriteCloser{w}, nil
+    }
+
+    for _, streamFunc := range []StreamFunc{stream, WrapStream(stream, wrap)} {
+        responseData := Respond(
+            context.Background(),
+            JSONEncoding,
+            http.StatusOK,
+            FlushInterval(10 * time.Millisecond),
+            BodyStream(streamFunc),
+        )
+
+        g.Expect(func() {
+            responseData.Write(httptest.NewRecorder())
+        }).To(gm.Panic())
+    }
+
+    g.Expect(streamWriters).To(gm.HaveLen(2))
+    for _, streamWriter := range streamWriters {
+        g.Expect(streamWriter.done).To(gm.BeClosed())
+    }
+}
+
+type nopWriteCloser struct {
+    io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+    return nil
+}
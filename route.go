@@ -24,6 +24,16 @@ type Route struct {
     Base     string
 }
 
+// OpenAPIPath is the route in the form OpenAPI uses for paths with each
+// parameter as {name}.
+func (r Route) OpenAPIPath() string {
+    return parameterRegex.ReplaceAllStringFunc(
+        r.original, func(match string) string {
+            return "{" + variableParse.FindStringSubmatch(match)[2] + "}"
+        },
+    )
+}
+
 // Matches will check if a url matches the route definition.
 func (r Route) Matches(url string) bool {
     return r.matcher.MatchString(url)
@@ -49,6 +49,7 @@ func minimalRouteControllerCaller(
 type RouteControllerHelper struct {
     route Route
     methodCallers map[RequestMethod]RouteControllerCaller
+    options routeOptions
 }
 
 // AllMethods returns all the methods the RouteControllerCaller responds to.
@@ -0,0 +1,76 @@
+package vial
+
+import (
+    "strings"
+)
+
+// RouteGroup adds controllers to a server under a common path prefix and
+// wraps all of them with the group's middleware.
+type RouteGroup struct {
+    server *Server
+    prefix string
+    middleware []Middleware
+}
+
+// joinRoutePath joins a prefix and a path keeping any trailing slash on the
+// path (which matters for the route's base).
+func joinRoutePath(prefix, path string) string {
+    prefix = strings.TrimRight(prefix, "/")
+    path = strings.TrimLeft(path, "/")
+    if path == "" {
+        if prefix == "" {
+            return "/"
+        }
+        return prefix
+    }
+
+    return prefix + "/" + path
+}
+
+// Group creates a RouteGroup for adding controllers under the provided path
+// prefix wrapped with the provided middleware(s).
+func (s *Server) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+    return &RouteGroup{
+        server: s,
+        prefix: joinRoutePath("", prefix),
+        middleware: middlewares,
+    }
+}
+
+// Group creates a nested RouteGroup. Its prefix is appended to this group's
+// and its middleware is wrapped by this group's.
+func (self *RouteGroup) Group(
+    prefix string, middlewares ...Middleware,
+) *RouteGroup {
+    allMiddleware := append(
+        append([]Middleware{}, self.middleware...), middlewares...,
+    )
+
+    return &RouteGroup{
+        server: self.server,
+        prefix: joinRoutePath(self.prefix, prefix),
+        middleware: allMiddleware,
+    }
+}
+
+// Prefix returns the path prefix for the group.
+func (self RouteGroup) Prefix() string {
+    return self.prefix
+}
+
+// AddController adds a controller to the server at the group's prefix joined
+// with the provided path. See Server.AddController for the details; the
+// group's middleware wraps any added with WithMiddleware.
+func (self *RouteGroup) AddController(
+    path string,
+    rc RouteController,
+    otherRCs ...RouteController,
+) error {
+    allOthers := append(
+        []RouteController{WithMiddleware(self.middleware...)}, otherRCs...,
+    )
+
+    return self.server.AddController(
+        joinRoutePath(self.prefix, path), rc, allOthers...,
+    )
+}
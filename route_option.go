@@ -0,0 +1,66 @@
+package vial
+
+import (
+    "time"
+)
+
+// routeOptions are the settings for a single route added with AddController.
+type routeOptions struct {
+    versionLookup VersionLookup
+    middleware []Middleware
+    authorization *authorizationRequirements
+    rateLimiters []*RateLimiter
+    timeout *time.Duration
+}
+
+// RouteOption is an option for a route. RouteOptions can be passed to
+// AddController alongside the RouteControllers for the route.
+type RouteOption func(*routeOptions)
+
+// WithVersionLookup registers a lookup for the current version of the
+// resource behind a route. It's used to enforce If-Match and
+// If-Unmodified-Since (with a 412) on unsafe methods and to answer
+// If-None-Match and If-Modified-Since (with a 304) on safe methods before the
+// controller is called.
+func WithVersionLookup(lookup VersionLookup) RouteOption {
+    return func(options *routeOptions) {
+        options.versionLookup = lookup
+    }
+}
+
+// WithMiddleware wraps the route's controllers with the provided
+// middleware(s). The first middleware provided is the outermost.
+func WithMiddleware(middlewares ...Middleware) RouteOption {
+    return func(options *routeOptions) {
+        options.middleware = append(options.middleware, middlewares...)
+    }
+}
+
+// WithTimeout sets how long the route's controllers have to handle a request
+// overriding the server's handler timeout. The controller's context is
+// cancelled at the deadline and a neterr.HandlerTimeoutError is responded
+// with if it hasn't finished. A timeout of zero or less disables it (ex: for
+// a route that streams its response).
+func WithTimeout(timeout time.Duration) RouteOption {
+    return func(options *routeOptions) {
+        options.timeout = &timeout
+    }
+}
+
+// splitRouteOptions separates RouteOptions from the RouteControllers provided
+// to AddController.
+func splitRouteOptions(
+    routeControllers []RouteController,
+) ([]RouteController, *routeOptions) {
+    options := &routeOptions{}
+    var controllers []RouteController
+    for _, rc := range routeControllers {
+        if option, ok := rc.(RouteOption); ok {
+            option(options)
+        } else {
+            controllers = append(controllers, rc)
+        }
+    }
+
+    return controllers, options
+}
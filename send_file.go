@@ -0,0 +1,461 @@
+package vial
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+    "os"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// sniffLength is how much of a file is used to detect its content type when
+// it can't be determined from the name.
+const sniffLength = 512
+
+type sendFileOptions struct {
+    disposition string
+    contentType string
+}
+
+// SendFileOption is an option for sending a file.
+type SendFileOption func(*sendFileOptions)
+
+// AsAttachment sends the file with an attachment Content-Disposition so it's
+// downloaded rather than displayed.
+func AsAttachment() SendFileOption {
+    return func(options *sendFileOptions) {
+        options.disposition = "attachment"
+    }
+}
+
+// AsInline sends the file with an inline Content-Disposition so it's
+// displayed if possible. This is the default.
+func AsInline() SendFileOption {
+    return func(options *sendFileOptions) {
+        options.disposition = "inline"
+    }
+}
+
+// WithFileContentType sets the content type of the file instead of it being
+// detected from the name or contents.
+func WithFileContentType(contentType string) SendFileOption {
+    return func(options *sendFileOptions) {
+        options.contentType = contentType
+    }
+}
+
+// byteRange is a range of a file starting at start and spanning length
+// bytes.
+type byteRange struct {
+    start int64
+    length int64
+}
+
+func (self byteRange) contentRange(size int64) string {
+    return fmt.Sprintf(
+        "bytes %d-%d/%d", self.start, self.start+self.length-1, size,
+    )
+}
+
+// errRangeNotSatisfiable is returned by parseRange when none of the ranges
+// overlap the file.
+var errRangeNotSatisfiable = errors.New("Range not satisfiable")
+
+// parseRange parses a Range header for a file of the provided size. Nil is
+// returned for a malformed header so that it's ignored.
+func parseRange(header string, size int64) ([]byteRange, error) {
+    const prefix = "bytes="
+    if !strings.HasPrefix(header, prefix) {
+        return nil, nil
+    }
+
+    var ranges []byteRange
+    for _, spec := range strings.Split(header[len(prefix):], ",") {
+        spec = strings.TrimSpace(spec)
+        if spec == "" {
+            continue
+        }
+        dash := strings.Index(spec, "-")
+        if dash < 0 {
+            return nil, nil
+        }
+        startString := strings.TrimSpace(spec[:dash])
+        endString := strings.TrimSpace(spec[dash+1:])
+
+        var r byteRange
+        if startString == "" {
+            // NOTE: A suffix range, the last N bytes of the file.
+            suffix, err := strconv.ParseInt(endString, 10, 64)
+            if err != nil || suffix < 0 {
+                return nil, nil
+            }
+            if suffix == 0 {
+                continue
+            }
+            if suffix > size {
+                suffix = size
+            }
+            r = byteRange{start: size - suffix, length: suffix}
+        } else {
+            start, err := strconv.ParseInt(startString, 10, 64)
+            if err != nil || start < 0 {
+                return nil, nil
+            }
+            end := size - 1
+            if endString != "" {
+                end, err = strconv.ParseInt(endString, 10, 64)
+                if err != nil || end < start {
+                    return nil, nil
+                }
+                if end >= size {
+                    end = size - 1
+                }
+            }
+            if start >= size {
+                continue
+            }
+            r = byteRange{start: start, length: end - start + 1}
+        }
+        ranges = append(ranges, r)
+    }
+
+    if len(ranges) == 0 {
+        return nil, errRangeNotSatisfiable
+    }
+
+    return ranges, nil
+}
+
+// openFileSource opens the source for SendFile. Paths are read using the
+// server's PathReader.
+func (self *Transactor) openFileSource(
+    source interface{},
+) (io.ReadSeeker, error) {
+    switch v := source.(type) {
+    case io.ReadSeeker:
+        return v, nil
+    case string:
+        server, ok := self.Request.Context().Value(ServerContextKey).(*Server)
+        if !ok || server.PathReader == nil {
+            return nil, errors.New(
+                "Server is not in request context so the file can't be read",
+            )
+        }
+        reader, err := server.PathReader.Read(v)
+        if err != nil {
+            return nil, errors.Wrapf(err, "Error while reading file '%s'", v)
+        }
+        if readSeeker, ok := reader.(io.ReadSeeker); ok {
+            return readSeeker, nil
+        }
+        contents, err := ioutil.ReadAll(reader)
+        if err != nil {
+            return nil, errors.Wrapf(err, "Error while reading file '%s'", v)
+        }
+        return bytes.NewReader(contents), nil
+    }
+
+    return nil, errors.Errorf(
+        "SendFile expects a path or an io.ReadSeeker but got '%T'", source,
+    )
+}
+
+// truncatedModTime is the modification time at the precision HTTP dates
+// support.
+func truncatedModTime(modTime time.Time) time.Time {
+    return modTime.Truncate(time.Second)
+}
+
+// filePreconditionStatus checks the conditional request headers against the
+// file and returns the status to respond with if one of them fails or 0 if
+// the file should be sent.
+func (self *Transactor) filePreconditionStatus(modTime time.Time) int {
+    header := self.Request.Header
+    version := &ResourceVersion{
+        ETag: self.Builder.Header(ETagHeader),
+        ModTime: modTime,
+    }
+
+    if preconditionFailed(header, version) {
+        return http.StatusPreconditionFailed
+    }
+
+    method := self.Request.Method
+    if method != http.MethodGet && method != http.MethodHead {
+        return 0
+    }
+    if notModified(header, version.ETag, modTime) {
+        return http.StatusNotModified
+    }
+
+    return 0
+}
+
+// ifRangeMatches checks if the If-Range header (if any) still matches the
+// file so the requested range can be served.
+func (self *Transactor) ifRangeMatches(modTime time.Time) bool {
+    ifRange := self.Request.Header.Get("If-Range")
+    if ifRange == "" {
+        return true
+    }
+    if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+        return etagMatches(ifRange, self.Builder.Header(ETagHeader), true)
+    }
+    if modTime.IsZero() {
+        return false
+    }
+    rangeTime, err := http.ParseTime(ifRange)
+
+    return err == nil && truncatedModTime(modTime).Equal(rangeTime)
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (self *countingWriter) Write(p []byte) (int, error) {
+    *self += countingWriter(len(p))
+    return len(p), nil
+}
+
+func byteRangePartHeader(
+    r byteRange, contentType string, size int64,
+) textproto.MIMEHeader {
+    return textproto.MIMEHeader{
+        "Content-Range": {r.contentRange(size)},
+        "Content-Type": {contentType},
+    }
+}
+
+// multipartByteRanges generates a StreamFunc which writes the ranges as a
+// multipart/byteranges body along with the boundary and length of the body.
+func multipartByteRanges(
+    content io.ReadSeeker, ranges []byteRange, contentType string, size int64,
+) (responses.StreamFunc, string, int64) {
+    var length countingWriter
+    counter := multipart.NewWriter(&length)
+    for _, r := range ranges {
+        counter.CreatePart(byteRangePartHeader(r, contentType, size))
+        length += countingWriter(r.length)
+    }
+    counter.Close()
+    boundary := counter.Boundary()
+
+    stream := func(w *responses.StreamWriter) error {
+        writer := multipart.NewWriter(w)
+        err := writer.SetBoundary(boundary)
+        if err != nil {
+            return err
+        }
+        for _, r := range ranges {
+            part, err := writer.CreatePart(
+                byteRangePartHeader(r, contentType, size),
+            )
+            if err != nil {
+                return err
+            }
+            err = copyFileRange(part, content, r)
+            if err != nil {
+                return err
+            }
+        }
+
+        return writer.Close()
+    }
+
+    return stream, boundary, int64(length)
+}
+
+func copyFileRange(w io.Writer, content io.ReadSeeker, r byteRange) error {
+    _, err := content.Seek(r.start, io.SeekStart)
+    if err != nil {
+        return errors.Wrap(err, "Error while seeking in file")
+    }
+    _, err = io.CopyN(w, content, r.length)
+    if err != nil {
+        return errors.Wrap(err, "Error while copying file to response")
+    }
+
+    return nil
+}
+
+func closeFileSource(content io.ReadSeeker) {
+    if closer, ok := content.(io.Closer); ok {
+        closer.Close()
+    }
+}
+
+// closeFileSourceOnce creates a func that closes the source the first time
+// it's called. The source is also closed once the request has been responded
+// to in case the stream that would close it never runs (ex: the response is
+// replaced by a middleware or a timeout).
+func (self *Transactor) closeFileSourceOnce(content io.ReadSeeker) func() {
+    var once sync.Once
+    closeContent := func() {
+        once.Do(func() {
+            closeFileSource(content)
+        })
+    }
+    cleanupWithRequest(self.Context(), closeContent)
+
+    return closeContent
+}
+
+// SendFile responds with the contents of a file. The source can either be a
+// path, which is read using the server's PathReader, or an io.ReadSeeker. The
+// name is used for the Content-Disposition and to detect the content type;
+// if it's empty the base of the path is used. A zero modTime disables the
+// Last-Modified header.
+//
+// Range requests (including multiple ranges), If-Range and the conditional
+// request headers are handled automatically. If the source is an io.Closer
+// it is closed once the file has been sent or, if it never is, once the
+// request has been responded to.
+func (self *Transactor) SendFile(
+    source interface{},
+    name string,
+    modTime time.Time,
+    options ...SendFileOption,
+) responses.Data {
+    fileOptions := &sendFileOptions{disposition: "inline"}
+    for _, option := range options {
+        option(fileOptions)
+    }
+
+    content, err := self.openFileSource(source)
+    if err != nil {
+        if os.IsNotExist(errors.Cause(err)) {
+            return self.Abort(http.StatusNotFound, neterr.FileNotFoundError)
+        }
+        return responses.ErrorResponse(err)
+    }
+    if filePath, ok := source.(string); ok && name == "" {
+        name = path.Base(filepath.ToSlash(filePath))
+    }
+
+    size, err := content.Seek(0, io.SeekEnd)
+    if err == nil {
+        _, err = content.Seek(0, io.SeekStart)
+    }
+    if err != nil {
+        closeFileSource(content)
+        return responses.ErrorResponse(
+            errors.Wrap(err, "Error while finding the size of the file"),
+        )
+    }
+
+    contentType := fileOptions.contentType
+    if contentType == "" {
+        contentType = mime.TypeByExtension(path.Ext(name))
+    }
+    if contentType == "" {
+        sniffed := make([]byte, sniffLength)
+        n, _ := io.ReadFull(content, sniffed)
+        contentType = http.DetectContentType(sniffed[:n])
+        _, err = content.Seek(0, io.SeekStart)
+        if err != nil {
+            closeFileSource(content)
+            return responses.ErrorResponse(
+                errors.Wrap(err, "Error while seeking in file"),
+            )
+        }
+    }
+
+    self.Builder.SetHeader("Accept-Ranges", "bytes")
+    if !modTime.IsZero() {
+        self.Builder.SetHeader(
+            "Last-Modified", modTime.UTC().Format(http.TimeFormat),
+        )
+    }
+    if name != "" {
+        disposition := mime.FormatMediaType(
+            fileOptions.disposition, map[string]string{"filename": name},
+        )
+        if disposition == "" {
+            disposition = fileOptions.disposition
+        }
+        self.Builder.SetHeader("Content-Disposition", disposition)
+    }
+
+    if status := self.filePreconditionStatus(modTime); status != 0 {
+        closeFileSource(content)
+        self.Builder.SetContentType(contentType)
+        return self.Respond(status, responses.Body([]byte(nil)))
+    }
+
+    ranges := []byteRange{{start: 0, length: size}}
+    statusCode := http.StatusOK
+    rangeHeader := self.Request.Header.Get("Range")
+    if rangeHeader != "" && self.ifRangeMatches(modTime) {
+        requested, err := parseRange(rangeHeader, size)
+        if err == errRangeNotSatisfiable {
+            closeFileSource(content)
+            self.Builder.SetHeader(
+                "Content-Range", fmt.Sprintf("bytes */%d", size),
+            )
+            return self.Abort(
+                http.StatusRequestedRangeNotSatisfiable,
+                neterr.RangeNotSatisfiableError,
+            )
+        }
+
+        var requestedLength int64
+        for _, r := range requested {
+            requestedLength += r.length
+        }
+        // NOTE: Ranges that add up to more than the file itself are most
+        //       likely abusive, just send the whole file instead.
+        if requested != nil && requestedLength <= size {
+            ranges = requested
+            statusCode = http.StatusPartialContent
+        }
+    }
+
+    var stream responses.StreamFunc
+    var length int64
+    if len(ranges) == 1 {
+        r := ranges[0]
+        if statusCode == http.StatusPartialContent {
+            self.Builder.SetHeader("Content-Range", r.contentRange(size))
+        }
+        self.Builder.SetHeader(responses.ContentTypeHeader, contentType)
+        stream = func(w *responses.StreamWriter) error {
+            return copyFileRange(w, content, r)
+        }
+        length = r.length
+    } else {
+        var boundary string
+        stream, boundary, length = multipartByteRanges(
+            content, ranges, contentType, size,
+        )
+        self.Builder.SetHeader(
+            responses.ContentTypeHeader,
+            "multipart/byteranges; boundary=" + boundary,
+        )
+    }
+    self.Builder.SetHeader("Content-Length", strconv.FormatInt(length, 10))
+
+    closeContent := self.closeFileSourceOnce(content)
+
+    return self.Respond(
+        statusCode,
+        responses.BodyStream(func(w *responses.StreamWriter) error {
+            defer closeContent()
+            return stream(w)
+        }),
+    )
+}
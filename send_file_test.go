@@ -0,0 +1,318 @@
+package vial
+
+import (
+    "context"
+    "io/ioutil"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+)
+
+var testFileContents = "0123456789abcdefghij"
+var testFileModTime = time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func sendTestFile(
+    t *testing.T,
+    g *gm.GomegaWithT,
+    headers map[string]string,
+    send func(*Transactor) responses.Data,
+) *httptest.ResponseRecorder {
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+
+    err = server.AddController("/file", FuncHandler("get", send))
+    g.Expect(err).To(gm.BeNil())
+
+    req, err := http.NewRequest("GET", "/file", nil)
+    g.Expect(err).To(gm.BeNil())
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+    rr := httptest.NewRecorder()
+
+    server.muxer.ServeHTTP(rr, req)
+
+    return rr
+}
+
+func sendTestReader(transactor *Transactor) responses.Data {
+    return transactor.SendFile(
+        strings.NewReader(testFileContents), "report.txt", testFileModTime,
+    )
+}
+
+func TestParseRange(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    ranges, err := parseRange("bytes=0-4, 10-, -3", 20)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(ranges).To(gm.Equal([]byteRange{
+        {start: 0, length: 5},
+        {start: 10, length: 10},
+        {start: 17, length: 3},
+    }))
+
+    ranges, err = parseRange("bytes=5-100", 20)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(ranges).To(gm.Equal([]byteRange{{start: 5, length: 15}}))
+
+    ranges, err = parseRange("bytes=9-3", 20)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(ranges).To(gm.BeNil())
+
+    ranges, err = parseRange("lines=1-2", 20)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(ranges).To(gm.BeNil())
+
+    _, err = parseRange("bytes=30-40", 20)
+    g.Expect(err).To(gm.Equal(errRangeNotSatisfiable))
+}
+
+func TestSendFileFromPath(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    dir, err := ioutil.TempDir("", "vial-send-file")
+    g.Expect(err).To(gm.BeNil())
+    defer os.RemoveAll(dir)
+    filePath := filepath.Join(dir, "data.json")
+    err = ioutil.WriteFile(filePath, []byte(`{"a":1}`), 0600)
+    g.Expect(err).To(gm.BeNil())
+
+    rr := sendTestFile(
+        t,
+        g,
+        nil,
+        func(transactor *Transactor) responses.Data {
+            return transactor.SendFile(
+                filePath, "", testFileModTime, AsAttachment(),
+            )
+        },
+    )
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal(`{"a":1}`))
+    g.Expect(rr.Header().Get("Content-Type")).To(
+        gm.Equal(mime.TypeByExtension(".json")),
+    )
+    g.Expect(rr.Header().Get("Content-Length")).To(gm.Equal("7"))
+    g.Expect(rr.Header().Get("Content-Disposition")).To(
+        gm.Equal(`attachment; filename=data.json`),
+    )
+    g.Expect(rr.Header().Get("Last-Modified")).To(
+        gm.Equal("Fri, 01 Mar 2019 12:00:00 GMT"),
+    )
+    g.Expect(rr.Header().Get("Accept-Ranges")).To(gm.Equal("bytes"))
+    g.Expect(rr.Header().Get(SequenceIdHeader)).ToNot(gm.BeEmpty())
+}
+
+func TestSendFileMissing(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rr := sendTestFile(
+        t,
+        g,
+        nil,
+        func(transactor *Transactor) responses.Data {
+            return transactor.SendFile(
+                "/does/not/exist.txt", "", time.Time{},
+            )
+        },
+    )
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotFound))
+}
+
+func TestSendFileSingleRange(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rr := sendTestFile(
+        t, g, map[string]string{"Range": "bytes=2-5"}, sendTestReader,
+    )
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusPartialContent))
+    g.Expect(rr.Body.String()).To(gm.Equal("2345"))
+    g.Expect(rr.Header().Get("Content-Range")).To(gm.Equal("bytes 2-5/20"))
+    g.Expect(rr.Header().Get("Content-Length")).To(gm.Equal("4"))
+    g.Expect(rr.Header().Get("Content-Disposition")).To(
+        gm.Equal("inline; filename=report.txt"),
+    )
+}
+
+func TestSendFileMultipleRanges(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rr := sendTestFile(
+        t, g, map[string]string{"Range": "bytes=0-1,-2"}, sendTestReader,
+    )
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusPartialContent))
+    mediaType, params, err := mime.ParseMediaType(
+        rr.Header().Get("Content-Type"),
+    )
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(mediaType).To(gm.Equal("multipart/byteranges"))
+    g.Expect(rr.Header().Get("Content-Length")).To(
+        gm.Equal(strconv.Itoa(rr.Body.Len())),
+    )
+
+    reader := multipart.NewReader(rr.Body, params["boundary"])
+    var parts []string
+    var contentRanges []string
+    for {
+        part, err := reader.NextPart()
+        if err != nil {
+            break
+        }
+        contents, err := ioutil.ReadAll(part)
+        g.Expect(err).To(gm.BeNil())
+        parts = append(parts, string(contents))
+        contentRanges = append(
+            contentRanges, part.Header.Get("Content-Range"),
+        )
+    }
+    g.Expect(parts).To(gm.Equal([]string{"01", "ij"}))
+    g.Expect(contentRanges).To(gm.Equal(
+        []string{"bytes 0-1/20", "bytes 18-19/20"},
+    ))
+}
+
+func TestSendFileIfRange(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rr := sendTestFile(
+        t,
+        g,
+        map[string]string{
+            "Range": "bytes=2-5",
+            "If-Range": testFileModTime.Format(http.TimeFormat),
+        },
+        sendTestReader,
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusPartialContent))
+
+    rr = sendTestFile(
+        t,
+        g,
+        map[string]string{
+            "Range": "bytes=2-5",
+            "If-Range": testFileModTime.Add(-time.Hour).Format(
+                http.TimeFormat,
+            ),
+        },
+        sendTestReader,
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal(testFileContents))
+}
+
+func TestSendFileRangeNotSatisfiable(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rr := sendTestFile(
+        t, g, map[string]string{"Range": "bytes=50-"}, sendTestReader,
+    )
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusRequestedRangeNotSatisfiable))
+    g.Expect(rr.Header().Get("Content-Range")).To(gm.Equal("bytes */20"))
+}
+
+func TestSendFileConditional(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    rr := sendTestFile(
+        t,
+        g,
+        map[string]string{
+            "If-Modified-Since": testFileModTime.Format(http.TimeFormat),
+        },
+        sendTestReader,
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotModified))
+    g.Expect(rr.Body.Len()).To(gm.BeZero())
+
+    rr = sendTestFile(
+        t,
+        g,
+        map[string]string{
+            "If-Modified-Since": testFileModTime.Add(-time.Hour).Format(
+                http.TimeFormat,
+            ),
+        },
+        sendTestReader,
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+
+    rr = sendTestFile(
+        t,
+        g,
+        map[string]string{
+            "If-Unmodified-Since": testFileModTime.Add(-time.Hour).Format(
+                http.TimeFormat,
+            ),
+        },
+        sendTestReader,
+    )
+    g.Expect(rr.Code).To(gm.Equal(http.StatusPreconditionFailed))
+}
+
+type closeCountingReader struct {
+    *strings.Reader
+    closes int
+}
+
+func (self *closeCountingReader) Close() error {
+    self.closes++
+    return nil
+}
+
+func TestSendFileClosedWhenReplaced(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+
+    var sources []*closeCountingReader
+    send := func(transactor *Transactor) responses.Data {
+        source := &closeCountingReader{
+            Reader: strings.NewReader(testFileContents),
+        }
+        sources = append(sources, source)
+        return transactor.SendFile(source, "report.txt", testFileModTime)
+    }
+    replace := func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            next(ctx, transactor)
+            return transactor.Respond(http.StatusTeapot)
+        }
+    }
+    err = server.AddController("/file", FuncHandler("get", send))
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/replaced", FuncHandler("get", send), WithMiddleware(replace),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    for _, path := range []string{"/file", "/replaced"} {
+        req, err := http.NewRequest("GET", path, nil)
+        g.Expect(err).To(gm.BeNil())
+        server.muxer.ServeHTTP(httptest.NewRecorder(), req)
+    }
+
+    g.Expect(sources).To(gm.HaveLen(2))
+    for _, source := range sources {
+        g.Expect(source.closes).To(gm.Equal(1))
+    }
+}
@@ -13,6 +13,7 @@ import (
     "crypto/tls"
     "io"
     "io/ioutil"
+    "time"
 
     "github.com/pkg/errors"
     "github.com/daihasso/slogging"
@@ -34,8 +35,18 @@ type Server struct {
     pathRouteControllerHelpers map[string][]*RouteControllerHelper
     preActionMiddleware []PreMiddleWare
     postActionMiddleware []PostMiddleWare
+    middleware []Middleware
+    finallyMiddleware []FinallyMiddleWare
+    authenticators []namedAuthenticator
     internalServer *http.Server
     defaultEncoding responses.EncodingType
+    streamFlushInterval time.Duration
+    handlerTimeout time.Duration
+    compression compressionSettings
+    errorRenderer responses.ErrorRenderer
+    translations *neterr.Translations
+    accessLog *accessLogger
+    debug bool
     encryptionEnabled bool
 }
 
@@ -78,6 +89,7 @@ func createGoServer(
     port int,
     muxer *http.ServeMux,
     tlsConfig *tls.Config,
+    timeouts serverTimeouts,
     logger *logging.Logger,
 ) *http.Server {
     handleProfiling(muxer)
@@ -85,6 +97,10 @@ func createGoServer(
         Addr: fmt.Sprintf("%s:%s", host, strconv.Itoa(port)),
         Handler: muxer,
         TLSConfig: tlsConfig,
+        ReadTimeout: timeouts.read,
+        ReadHeaderTimeout: timeouts.readHeader,
+        WriteTimeout: timeouts.write,
+        IdleTimeout: timeouts.idle,
         ErrorLog: log.New(
             logging.NewPseudoWriter(logging.ERROR, logger), "", 0,
         ),
@@ -100,6 +116,8 @@ func createGoServer(
 //        func(context.Context, *Transactor) responses.Data
 //    or
 //        func(*Transactor) responses.Data
+// RouteOptions (ex: WithVersionLookup) can be provided alongside the
+// RouteControllers to configure the route.
 func (s *Server) AddController(
     path string,
     rc RouteController,
@@ -117,13 +135,25 @@ func (s *Server) AddController(
     if err != nil {
         return errors.Wrap(err, "Error while parsing route provided")
     }
-    allRouteControllers := append([]RouteController{rc}, otherRCs...)
+    allRouteControllers, options := splitRouteOptions(
+        append([]RouteController{rc}, otherRCs...),
+    )
+    err = s.checkAuthorizationRequirements(options.authorization)
+    if err != nil {
+        return errors.Wrapf(err, "Error while adding route '%s'", path)
+    }
+    for _, limiter := range options.rateLimiters {
+        if limiter.name == "" {
+            limiter.name = path
+        }
+    }
     methodCallers, urlForMap := MethodsForRouteController(
         path, allRouteControllers...,
     )
     routeControllerHelper := RouteControllerHelper{
         route: route,
         methodCallers: methodCallers,
+        options: *options,
     }
 
     for k, v := range urlForMap {
@@ -267,6 +297,11 @@ func (self Server) respondToMethod(
             break
         }
     }
+    matched := rchs[0]
+    if rchSet {
+        matched = rch
+    }
+    setRequestRoute(r.Context(), matched.route.original)
     if !rchSet {
         if reqMethod == MethodOPTIONS {
             rcc = func(
@@ -318,6 +353,7 @@ func (self Server) respondToMethod(
                 responses.Headers(map[string][]string{
                     "Allow": []string{allowedMethods},
                 }),
+                responses.Negotiate(r.Header.Get("Accept")),
             )
             return resp.Abort(
                 http.StatusMethodNotAllowed,
@@ -340,6 +376,7 @@ func (self Server) respondToMethod(
                 SequenceIdHeader,
                 sequenceId.String(),
             ),
+            responses.Negotiate(r.Header.Get("Accept")),
         )
         if err != nil {
             return responses.ErrorResponse(err)
@@ -349,51 +386,77 @@ func (self Server) respondToMethod(
             neterr.RouteNotSetupError,
         )
     }
+    if self.compression.decompressRequests {
+        data := self.compression.decompressRequest(r, self.defaultEncoding)
+        if data != nil {
+            return *data
+        }
+    }
+
     transactor, err := NewTransactor(
-        r, w, pathVariables, self.config, self.Logger, self.defaultEncoding,
+        r,
+        w,
+        pathVariables,
+        self.config,
+        self.Logger,
+        self.defaultEncoding,
+        WithStreamFlushInterval(self.streamFlushInterval),
     )
     if err != nil {
         self.Logger.Exception(err, "Error while creating Transactor.")
         return responses.ErrorResponse(err)
     }
+    setRequestTransactor(r.Context(), transactor)
 
-    for _, middleware := range self.preActionMiddleware {
-        data, newCtx, err := middleware(r.Context(), transactor)
-        if err != nil {
-            self.Logger.Exception(err, "Error in pre-action middleware.")
-            return responses.ErrorResponse(err)
-        }
-        if data != nil {
-            // If we have data from our middleware return early with it.
-            return *data
-        }
-        if newCtx != nil {
-            transactor.ChangeContext(*newCtx)
-            r = &transactor.Request.Request
-        }
-    }
+    handler := func(
+        ctx context.Context, transactor *Transactor,
+    ) responses.Data {
+        self.Logger.Debug("Handling HTTP request.", logging.Extras{
+            "path": transactor.Request.URL.Path,
+            "method": transactor.Request.Method,
+            "sequence_id": transactor.SequenceId(),
+        })
 
-    self.Logger.Debug("Handling HTTP request.", logging.Extras{
-        "path": transactor.Request.URL.Path,
-        "method": transactor.Request.Method,
-        "sequence_id": transactor.SequenceId(),
-    })
+        if lookup := rch.options.versionLookup; rchSet && lookup != nil {
+            data := checkVersionPreconditions(lookup, transactor)
+            if data != nil {
+                return *data
+            }
+        }
 
-    response := rcc(transactor.Context(), transactor)
+        return rcc(ctx, transactor)
+    }
 
-    for _, middleware := range self.postActionMiddleware {
-        data, err := middleware(transactor.Context(), transactor, response)
-        if err != nil {
-            self.Logger.Exception(err, "Error in pre-action middleware.")
-            return responses.ErrorResponse(err)
-        }
-        if data != nil {
-            // If we have data to return early with it.
-            return *data
+    // NOTE: The pre & post-action middleware are adapted into the chain
+    //       between the server's middleware and the route's so that a
+    //       short-circuiting pre-action middleware still passes through the
+    //       server's middleware.
+    middlewares := append([]Middleware{}, self.middleware...)
+    timeout := self.handlerTimeout
+    if rchSet && rch.options.timeout != nil {
+        timeout = *rch.options.timeout
+    }
+    middlewares = append(middlewares, self.deadlineMiddleware(timeout))
+    if len(self.authenticators) != 0 {
+        var requirements *authorizationRequirements
+        if rchSet {
+            requirements = rch.options.authorization
         }
+        middlewares = append(
+            middlewares, self.authenticationMiddleware(requirements),
+        )
     }
-
-    transactor.Logger.Close()
+    middlewares = append(
+        middlewares,
+        PreActionMiddleware(self.preActionMiddleware...),
+        PostActionMiddleware(self.postActionMiddleware...),
+    )
+    if rchSet {
+        middlewares = append(middlewares, rch.options.middleware...)
+    }
+    response := chainMiddleware(handler, middlewares...)(
+        transactor.Context(), transactor,
+    )
 
     return response
 }
@@ -427,6 +490,19 @@ func handleRequestId(ctx context.Context) context.Context {
     )
 }
 
+// internalServerErrorData generates the response for an unexpected error.
+func internalServerErrorData() responses.Data {
+    return responses.Data{
+        Headers: map[string][]string{
+            responses.ContentTypeHeader: []string{
+                responses.TextPlainUTF8ContentType,
+            },
+        },
+        Body: []byte("Internal Server Error"),
+        StatusCode: http.StatusInternalServerError,
+    }
+}
+
 type requestHandlerFunc func(
     w http.ResponseWriter, r *http.Request,
 ) responses.Data
@@ -435,19 +511,27 @@ func responseProcessor(
     handlerFunc requestHandlerFunc, server *Server,
 ) func(w http.ResponseWriter, r *http.Request) {
     return func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        var accessWriter *accessLogWriter
+        if server.accessLog != nil {
+            accessWriter = &accessLogWriter{ResponseWriter: w}
+            w = accessWriter
+        }
         var sequenceId string
         var ctx context.Context
+        var finalData responses.Data
+        var finalErr error
+        responseStarted := false
+        state := &requestState{}
         defer func() {
             if rawErr := recover(); rawErr != nil {
-                newErr := errors.New(fmt.Sprintf("%+v", rawErr))
-                if err, ok := rawErr.(error); ok {
-                    newErr = errors.WithStack(err)
-                }
-                server.Logger.Exception(
-                    newErr, "Panic while handling controller.",
+                finalData, finalErr = server.recoverPanic(
+                    w, r, sequenceId, rawErr, responseStarted,
                 )
-                w.WriteHeader(http.StatusInternalServerError)
-                fmt.Fprint(w, "Internal Server Error")
+            }
+            server.runFinally(r, state, finalData, finalErr)
+            if accessWriter != nil {
+                server.logAccess(r, accessWriter, state, start, sequenceId)
             }
         }()
 
@@ -465,9 +549,27 @@ func responseProcessor(
         ctx, sequenceId = handleSequenceId(r)
         ctx = handleRequestId(ctx)
         ctx = context.WithValue(ctx, ServerLoggerContextKey, server.Logger)
+        if server.errorRenderer != nil {
+            ctx = responses.ContextWithErrorRenderer(
+                ctx, server.errorRenderer,
+            )
+        }
+        if server.translations != nil {
+            ctx = responses.ContextWithLocalization(
+                ctx, server.translations, r.Header.Get("Accept-Language"),
+            )
+        }
+        ctx = contextWithRequestState(ctx, state)
         r = r.WithContext(ctx)
 
         responseData := handlerFunc(w, r)
+        if unexpectedErr := responseData.Error(); unexpectedErr != nil {
+            finalErr = unexpectedErr
+            codedData, ok := server.codedErrorResponse(r, unexpectedErr)
+            if ok {
+                responseData = codedData
+            }
+        }
         if unexpectedErr := responseData.Error(); unexpectedErr != nil {
             if unexpectedErr.Error() != "" {
                 server.Logger.Exception(
@@ -478,18 +580,92 @@ func responseProcessor(
                     },
                 )
             }
-            w.WriteHeader(http.StatusInternalServerError)
-            fmt.Fprint(w, "Internal Server Error")
+            finalData = internalServerErrorData()
+            responseStarted = true
+            err := finalData.Write(w)
+            if err != nil {
+                server.Logger.Exception(
+                    err,
+                    "Error while writing response.",
+                    logging.Extras{
+                        "sequence_id": sequenceId,
+                    },
+                )
+            }
             return
         }
 
+        if server.compression.enabled {
+            responseData = server.compression.compressResponse(
+                r, responseData, server.Logger,
+            )
+        }
+
+        finalData = responseData
+        responseStarted = true
         err := responseData.Write(w)
         if err != nil {
-            panic(err)
+            finalErr = err
+            // NOTE: The status has already been sent at this point so the
+            //       best we can do is make note of it.
+            server.Logger.Exception(
+                err,
+                "Error while writing response.",
+                logging.Extras{
+                    "sequence_id": sequenceId,
+                },
+            )
         }
     }
 }
 
+// codedErrorResponse generates a failure response for an unexpected error
+// which wraps a neterr.CodedError using the CodedError's status (or a 500 if
+// it doesn't have one).
+func (self Server) codedErrorResponse(
+    r *http.Request, err error,
+) (responses.Data, bool) {
+    codedError, ok := neterr.AsCodedError(err)
+    if !ok {
+        return responses.Data{}, false
+    }
+
+    status := codedError.Status()
+    if status == 0 {
+        status = http.StatusInternalServerError
+    }
+
+    sequenceId, _ := ContextSequenceId(r.Context())
+    extras := logging.Extras{
+        "sequence_id": sequenceId,
+        "status": status,
+    }
+    if status >= http.StatusInternalServerError {
+        self.Logger.Exception(err, "Coded error in controller route.", extras)
+    } else {
+        self.Logger.Debug(
+            fmt.Sprintf("Coded error in controller route: %s", err), extras,
+        )
+    }
+
+    additionals := []responses.AdditionalAttribute{
+        responses.Negotiate(r.Header.Get("Accept")),
+    }
+    if sequenceId != nil {
+        additionals = append(additionals, responses.AddHeader(
+            SequenceIdHeader, sequenceId.String(),
+        ))
+    }
+    builder, builderErr := responses.NewBuilder(
+        r.Context(), self.defaultEncoding, additionals...,
+    )
+    if builderErr != nil {
+        return responses.ErrorResponse(builderErr), true
+    }
+
+    return builder.Abort(status, codedError), true
+}
+
 func (s *Server) defaultMultiRouteControllerWrapper(
     basePath string,
 ) func(http.ResponseWriter, *http.Request) {
@@ -507,7 +683,13 @@ func (s *Server) defaultMultiRouteControllerWrapper(
                 },
             )
         }
-        s.Logger.Info("HTTP request made.", logging.Extras{
+        // NOTE: The access log already records every request once it's
+        //       been responded to.
+        logRequest := s.Logger.Info
+        if s.accessLog != nil {
+            logRequest = s.Logger.Debug
+        }
+        logRequest("HTTP request made.", logging.Extras{
             "path": r.URL.Path,
             "requestor": r.RemoteAddr,
             "method": r.Method,
@@ -525,6 +707,7 @@ func (s *Server) defaultMultiRouteControllerWrapper(
                     SequenceIdHeader,
                     sequenceId.String(),
                 ),
+                responses.Negotiate(r.Header.Get("Accept")),
             )
             if err != nil {
                 return responses.ErrorResponse(err)
@@ -554,6 +737,18 @@ func (self *Server) AddPostActionMiddleware(middleware ...PostMiddleWare) {
     self.postActionMiddleware = append(self.postActionMiddleware, middleware...)
 }
 
+// AddMiddleware wraps every route on the server with the provided
+// middleware(s).
+func (self *Server) AddMiddleware(middleware ...Middleware) {
+    self.middleware = append(self.middleware, middleware...)
+}
+
+// AddFinallyMiddleware runs the provided middleware(s) after every request
+// has been responded to, however the response came about.
+func (self *Server) AddFinallyMiddleware(middleware ...FinallyMiddleWare) {
+    self.finallyMiddleware = append(self.finallyMiddleware, middleware...)
+}
+
 func (self *Server) AddPreActionMiddleware(middleware ...PreMiddleWare) {
     self.preActionMiddleware = append(self.preActionMiddleware, middleware...)
 }
@@ -682,11 +877,13 @@ func newServer(options []ServerOption) (*Server, error) {
 
     muxer := http.NewServeMux()
 
+    timeouts := serverTimeoutsFromConfig(config)
     goServer := createGoServer(
         config.Host,
         config.Port,
         muxer,
         tlsCfg,
+        timeouts,
         logger,
     )
 
@@ -698,6 +895,14 @@ func newServer(options []ServerOption) (*Server, error) {
     }
 
 
+    compression := compressionSettingsFromConfig(config)
+    compression.enabled = compression.enabled || svOpts.compressionEnabled
+    compression.decompressRequests = compression.decompressRequests ||
+        svOpts.decompressionEnabled
+    for _, option := range svOpts.compressionOptions {
+        option(compression)
+    }
+
     server := &Server{
         PathReader: svOpts.pathReader,
         Logger: logger,
@@ -708,11 +913,24 @@ func newServer(options []ServerOption) (*Server, error) {
         pathRouteControllerHelpers: make(map[string][]*RouteControllerHelper),
         preActionMiddleware: preActionMiddleware,
         postActionMiddleware: postActionMiddleware,
+        middleware: svOpts.middleware,
+        finallyMiddleware: svOpts.finallyMiddleware,
+        authenticators: svOpts.authenticators,
         internalServer: goServer,
         defaultEncoding: defaultEncoding,
+        streamFlushInterval: svOpts.streamFlushInterval,
+        handlerTimeout: timeouts.handler,
+        compression: compression.withDefaults(),
+        errorRenderer: svOpts.errorRenderer,
+        translations: svOpts.translations,
+        debug: svOpts.debug || config.Debug,
         encryptionEnabled: useEncryption,
     }
 
+    if svOpts.accessLog != nil {
+        server.accessLog = &accessLogger{options: svOpts.accessLog}
+    }
+
     for _, mod := range svOpts.serverMods {
         err := mod(server)
         if err != nil {
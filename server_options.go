@@ -2,12 +2,14 @@ package vial
 
 import (
     "io"
+    "time"
 
     "github.com/pkg/errors"
     "github.com/daihasso/slogging"
     "github.com/daihasso/tote"
     "github.com/daihasso/peechee"
 
+    "github.com/daihasso/vial/neterr"
     "github.com/daihasso/vial/responses"
 )
 
@@ -16,10 +18,21 @@ type serverModifier func(*Server) error
 type serverOptions struct {
     preActionMiddleware []PreMiddleWare
     postActionMiddleware []PostMiddleWare
+    middleware []Middleware
+    finallyMiddleware []FinallyMiddleWare
+    authenticators []namedAuthenticator
     config *Config
     logger *logging.Logger
     pathReader *peechee.PathReader
     defaultEncoding responses.EncodingType
+    streamFlushInterval time.Duration
+    compressionEnabled,
+    decompressionEnabled bool
+    compressionOptions []CompressionOption
+    errorRenderer responses.ErrorRenderer
+    translations *neterr.Translations
+    accessLog *accessLogOptions
+    debug bool
 
     tlsCertData,
     tlsKeyData io.Reader
@@ -61,6 +74,50 @@ func AddPostActionMiddleware(middlewares ...PostMiddleWare) ServerOption {
     }
 }
 
+// AddFinallyMiddleware runs the provided middleware(s) after every request
+// has been responded to, however the response came about (including 404s,
+// 405s, errors and panics).
+func AddFinallyMiddleware(middlewares ...FinallyMiddleWare) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.finallyMiddleware = append(
+            svOpts.finallyMiddleware, middlewares...,
+        )
+
+        return nil
+    }
+}
+
+// AddAuthenticator registers an Authenticator under the provided name (ex:
+// "bearer" or "apiKey") which is used in RequireAuthentication and as the
+// OpenAPI security scheme name. Authenticators are tried in the order they're
+// added until one authenticates the request.
+func AddAuthenticator(name string, authenticator Authenticator) ServerOption {
+    return func(svOpts *serverOptions) error {
+        for _, named := range svOpts.authenticators {
+            if named.name == name {
+                return errors.Errorf(
+                    "Authenticator '%s' is already registered", name,
+                )
+            }
+        }
+        svOpts.authenticators = append(
+            svOpts.authenticators, namedAuthenticator{name, authenticator},
+        )
+
+        return nil
+    }
+}
+
+// AddMiddleware wraps every route on the server with the provided
+// middleware(s). The first middleware provided is the outermost.
+func AddMiddleware(middlewares ...Middleware) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.middleware = append(svOpts.middleware, middlewares...)
+
+        return nil
+    }
+}
+
 // AddConfig sets the server config to the provided config.
 func AddConfig(config *Config) ServerOption {
     return func(svOpts *serverOptions) error {
@@ -201,9 +258,15 @@ func AddDefaultHealthRoute() ServerOption {
     }
 }
 
-// SetDefaultEncoding will set the server-wide default encoding scheme.
+// SetDefaultEncoding will set the server-wide default encoding scheme. The
+// encoding must have an encoder registered in the responses package.
 func SetDefaultEncoding(encodingType responses.EncodingType) ServerOption {
     return func(svOpts *serverOptions) error {
+        if _, ok := responses.EncoderForEncoding(encodingType); !ok {
+            return errors.Errorf(
+                "No encoder registered for encoding type %d", encodingType,
+            )
+        }
         svOpts.defaultEncoding = encodingType
 
         return nil
@@ -218,3 +281,80 @@ func SetPathReader(pathReader *peechee.PathReader) ServerOption {
         return nil
     }
 }
+
+// SetStreamFlushInterval sets how often streamed response bodies are flushed
+// to the client by default. Use responses.FlushImmediately to flush after
+// every write.
+func SetStreamFlushInterval(interval time.Duration) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.streamFlushInterval = interval
+
+        return nil
+    }
+}
+
+// EnableCompression compresses responses for requestors that accept it. Any
+// settings from the config are used unless overridden by the options.
+func EnableCompression(options ...CompressionOption) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.compressionEnabled = true
+        svOpts.compressionOptions = append(
+            svOpts.compressionOptions, options...,
+        )
+
+        return nil
+    }
+}
+
+// EnableRequestDecompression transparently decompresses request bodies sent
+// with a Content-Encoding (ex: gzip). Any settings from the config are used
+// unless overridden by the options.
+func EnableRequestDecompression(options ...CompressionOption) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.decompressionEnabled = true
+        svOpts.compressionOptions = append(
+            svOpts.compressionOptions, options...,
+        )
+
+        return nil
+    }
+}
+
+// SetErrorRenderer sets how errors are rendered for every failure response
+// the server generates (ex: Transactor.Abort or a 404 for an unknown route).
+// The default renders `{"errors": [...]}` in the negotiated encoding.
+func SetErrorRenderer(renderer responses.ErrorRenderer) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.errorRenderer = renderer
+
+        return nil
+    }
+}
+
+// UseProblemDetails renders all failure responses as RFC 7807 problem
+// details with the Sequence ID as the instance.
+func UseProblemDetails() ServerOption {
+    return SetErrorRenderer(ProblemDetailsRenderer())
+}
+
+// SetTranslations localizes the messages of CodedErrors with a message key
+// using the requestor's Accept-Language whenever an error response is
+// rendered.
+func SetTranslations(translations *neterr.Translations) ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.translations = translations
+
+        return nil
+    }
+}
+
+// EnableDebugMode includes debugging information (like the stack of a panic)
+// in error responses. This is meant for local development only, never enable
+// it in production.
+func EnableDebugMode() ServerOption {
+    return func(svOpts *serverOptions) error {
+        svOpts.debug = true
+
+        return nil
+    }
+}
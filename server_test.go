@@ -1,6 +1,7 @@
 package vial
 
 import (
+    "compress/flate"
     "context"
     "io/ioutil"
     "math/rand"
@@ -14,6 +15,7 @@ import (
 
     "github.com/daihasso/slogging"
     gm "github.com/onsi/gomega"
+    "github.com/pkg/errors"
 
     "github.com/daihasso/vial/responses"
     "github.com/daihasso/vial/neterr"
@@ -116,6 +118,19 @@ func setupLogging(t *testing.T, g *gm.GomegaWithT) *logging.Logger {
     return logger
 }
 
+// newTestServer creates a server logging to the test with the provided
+// options.
+func newTestServer(
+    t *testing.T, g *gm.GomegaWithT, options ...ServerOption,
+) *Server {
+    server, err := NewServer(append(
+        []ServerOption{AddCustomLogger(setupLogging(t, g))}, options...,
+    )...)
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
 type testWriter struct {
     t *testing.T
 }
@@ -672,6 +687,32 @@ vial:
     g.Expect(server.GetConfig().Host).To(gm.Equal("127.0.0.1"))
 }
 
+func TestServerConfigFileCompression(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    configYaml := `
+vial:
+  compression:
+    enabled: true
+    level: 0
+`
+    tempConfigFile, err := ioutil.TempFile("", "config.yaml")
+    g.Expect(err).To(gm.BeNil())
+    defer os.Remove(tempConfigFile.Name())
+    err = ioutil.WriteFile(tempConfigFile.Name(), []byte(configYaml), 0644)
+    g.Expect(err).To(gm.BeNil())
+    t.Setenv("TOTE_VIAL_COMPRESSION_MINIMUMSIZE", "0")
+
+    server, err := NewServerDefault(
+        AddCustomLogger(setupLogging(t, g)),
+        AddConfigFromFile(tempConfigFile.Name()),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    g.Expect(*server.compression.minimumSize).To(gm.Equal(0))
+    g.Expect(*server.compression.level).To(gm.Equal(flate.NoCompression))
+}
+
 func TestServerLoggerContext(t *testing.T) {
     g := gm.NewGomegaWithT(t)
 
@@ -925,3 +966,218 @@ func TestUrlForVariableSubWrongKey(t *testing.T) {
     g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
     g.Expect(rr.Body.String()).To(gm.Equal("/test/<int:foo>"))
 }
+
+func TestServerContentNegotiation(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    logger := setupLogging(t, g)
+
+    req, err := http.NewRequest("GET", "/health", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept", "application/json;q=0.2, text/plain")
+
+    rr := httptest.NewRecorder()
+    server, err := NewServerDefault(AddCustomLogger(logger))
+    g.Expect(err).To(gm.BeNil())
+
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal("map[healthy:true]"))
+    g.Expect(rr.Header().Get("Content-Type")).To(
+        gm.Equal(responses.TextPlainUTF8ContentType),
+    )
+    g.Expect(rr.Header().Get("Vary")).To(gm.Equal("Accept"))
+}
+
+func TestServerContentNegotiationNotAcceptable(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    logger := setupLogging(t, g)
+
+    req, err := http.NewRequest("GET", "/health", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept", "image/png")
+
+    rr := httptest.NewRecorder()
+    server, err := NewServerDefault(AddCustomLogger(logger))
+    g.Expect(err).To(gm.BeNil())
+
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotAcceptable))
+    g.Expect(rr.Header().Get("Sequence-Id")).ToNot(gm.BeEmpty())
+}
+
+func TestServerDefaultEncodingUnregistered(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    logger := setupLogging(t, g)
+
+    _, err := NewServer(
+        AddCustomLogger(logger),
+        SetDefaultEncoding(responses.EncodingType(4243)),
+    )
+    g.Expect(err).To(gm.HaveOccurred())
+}
+
+func TestServerStreamingResponse(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    logger := setupLogging(t, g)
+
+    req, err := http.NewRequest("GET", "/export", nil)
+    g.Expect(err).To(gm.BeNil())
+
+    rr := httptest.NewRecorder()
+    server, err := NewServer(
+        AddCustomLogger(logger),
+        SetStreamFlushInterval(responses.FlushImmediately),
+        AddPostActionMiddleware(func(
+            _ context.Context,
+            transactor *Transactor,
+            existingResp responses.Data,
+        ) (*responses.Data, error) {
+            existingResp.Headers["X-Post-Action"] = []string{"ran"}
+            return &existingResp, nil
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    err = server.AddController(
+        "/export",
+        FuncHandler(
+            "get",
+            func(transactor *Transactor) responses.Data {
+                return transactor.Respond(
+                    200,
+                    responses.BodyStream(
+                        func(w *responses.StreamWriter) error {
+                            _, err := w.Write([]byte("line 1\nline 2\n"))
+                            return err
+                        },
+                    ),
+                )
+            },
+        ),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.Equal("line 1\nline 2\n"))
+    g.Expect(rr.Flushed).To(gm.BeTrue())
+    g.Expect(rr.Header().Get("X-Post-Action")).To(gm.Equal("ran"))
+    g.Expect(rr.Header().Get("Sequence-Id")).ToNot(gm.BeEmpty())
+}
+
+func TestServerCodedErrorResponse(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    logger := setupLogging(t, g)
+
+    notFound := neterr.NewCodedError(404001, "Thing not found").WithStatus(
+        http.StatusNotFound,
+    )
+    server, err := NewServer(
+        AddPreActionMiddleware(
+            func(_ context.Context, transactor *Transactor) (
+                *responses.Data, *context.Context, error,
+            ) {
+                if transactor.Request.Header.Get("Authorization") == "" {
+                    return nil, nil, neterr.NewCodedError(
+                        401001, "Missing credentials",
+                    ).WithStatus(http.StatusUnauthorized)
+                }
+                return nil, nil, nil
+            },
+        ),
+        AddCustomLogger(logger),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return responses.ErrorResponse(errors.Wrap(
+                notFound.WithDetail("id", 5).Wrap(errors.New("no rows")),
+                "Error while getting thing",
+            ))
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    req, err := http.NewRequest("GET", "/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Authorization", "Bearer x")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNotFound))
+    g.Expect(rr.Header().Get(SequenceIdHeader)).ToNot(gm.BeEmpty())
+    g.Expect(rr.Body.String()).To(gm.Equal(
+        `{"errors":[{"code":404001,"message":"Thing not found",` +
+            `"details":{"id":5}}]}`,
+    ))
+
+    req, err = http.NewRequest("GET", "/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusUnauthorized))
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring("401001"))
+}
+
+func TestServerLocalizedErrors(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    logger := setupLogging(t, g)
+
+    translations := neterr.NewTranslations("en")
+    err := translations.Add("es", map[string]string{
+        "thing.taken": "El nombre {{.name}} ya existe.",
+    })
+    g.Expect(err).To(gm.BeNil())
+
+    server, err := NewServer(
+        SetTranslations(translations), AddCustomLogger(logger),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/thing",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            return transactor.Abort(
+                http.StatusConflict,
+                neterr.NewCodedError(
+                    409001, "That name is taken.",
+                ).WithMessageKey("thing.taken").WithDetail("name", "foo"),
+            )
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    req, err := http.NewRequest("GET", "/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    req.Header.Set("Accept-Language", "es-MX, en;q=0.5")
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusConflict))
+    g.Expect(rr.Header().Get("Content-Language")).To(gm.Equal("es"))
+    g.Expect(rr.Header().Get("Vary")).To(gm.Equal("Accept-Language"))
+    g.Expect(rr.Body.String()).To(gm.Equal(
+        `{"errors":[{"code":409001,"message":"El nombre foo ya existe.",` +
+            `"details":{"name":"foo"}}]}`,
+    ))
+
+    req, err = http.NewRequest("GET", "/thing", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr = httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req)
+
+    g.Expect(rr.Header().Get("Content-Language")).To(gm.BeEmpty())
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring(
+        `"message":"That name is taken."`,
+    ))
+}
@@ -0,0 +1,263 @@
+package vial
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "runtime/debug"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// RequestDeadlineHeader is the header a requestor can send an RFC 3339
+// deadline in. It can only tighten the deadline a controller is given, never
+// loosen it.
+const RequestDeadlineHeader = "X-Request-Deadline"
+
+// Defaults for the server timeouts that are on unless configured otherwise.
+// Read and write timeouts are off by default since they'd cut off slow
+// uploads and streamed responses.
+var (
+    DefaultReadHeaderTimeout = 10 * time.Second
+    DefaultIdleTimeout = 2 * time.Minute
+)
+
+// serverTimeouts are the timeouts from the Timeouts section of the config.
+type serverTimeouts struct {
+    read,
+    readHeader,
+    write,
+    idle,
+    handler time.Duration
+}
+
+// configTimeout converts a config value in seconds to a timeout. Zero uses
+// the fallback and less than zero disables the timeout.
+func configTimeout(seconds int, fallback time.Duration) time.Duration {
+    if seconds < 0 {
+        return 0
+    }
+    if seconds == 0 {
+        return fallback
+    }
+
+    return time.Duration(seconds) * time.Second
+}
+
+func serverTimeoutsFromConfig(config *Config) serverTimeouts {
+    return serverTimeouts{
+        read: configTimeout(config.Timeouts.ReadSeconds, 0),
+        readHeader: configTimeout(
+            config.Timeouts.ReadHeaderSeconds, DefaultReadHeaderTimeout,
+        ),
+        write: configTimeout(config.Timeouts.WriteSeconds, 0),
+        idle: configTimeout(config.Timeouts.IdleSeconds, DefaultIdleTimeout),
+        handler: configTimeout(config.Timeouts.HandlerSeconds, 0),
+    }
+}
+
+// requestDeadline gets the deadline the requestor sent (if they sent a valid
+// one).
+func requestDeadline(request *http.Request) (time.Time, bool) {
+    value := request.Header.Get(RequestDeadlineHeader)
+    if value == "" {
+        return time.Time{}, false
+    }
+    deadline, err := time.Parse(time.RFC3339Nano, value)
+    if err != nil {
+        return time.Time{}, false
+    }
+
+    return deadline, true
+}
+
+// timedOut responds to a request that wasn't handled before its deadline. A
+// new builder is used since the controller may still be using the
+// Transactor's.
+func (self Server) timedOut(
+    ctx context.Context,
+    request *http.Request,
+    logger *logging.Logger,
+    requestorDeadline bool,
+) responses.Data {
+    var sequenceId string
+    if contextSequenceId, err := ContextSequenceId(ctx); err == nil {
+        sequenceId = contextSequenceId.String()
+    }
+    logger.Warn(
+        "Request timed out.",
+        logging.Extras{
+            "path": request.URL.Path,
+            "method": request.Method,
+            "sequence_id": sequenceId,
+            "requestor_deadline": requestorDeadline,
+        },
+    )
+
+    additionals := []responses.AdditionalAttribute{
+        responses.Negotiate(request.Header.Get("Accept")),
+    }
+    if sequenceId != "" {
+        additionals = append(
+            additionals, responses.AddHeader(SequenceIdHeader, sequenceId),
+        )
+    }
+    builder, err := responses.NewBuilder(
+        ctx, self.defaultEncoding, additionals...,
+    )
+    if err != nil {
+        return responses.ErrorResponse(err)
+    }
+    if requestorDeadline {
+        return builder.Abort(
+            http.StatusGatewayTimeout, neterr.DeadlineExceededError,
+        )
+    }
+
+    return builder.Abort(
+        http.StatusServiceUnavailable, neterr.HandlerTimeoutError,
+    )
+}
+
+// handlerPanic carries a panic from the goroutine a controller was run on so
+// it can be recovered like any other, stack included.
+type handlerPanic struct {
+    value interface{}
+    stack []byte
+}
+
+// controllerOutcome is how a controller run on its own goroutine finished.
+type controllerOutcome struct {
+    data responses.Data
+    panicked *handlerPanic
+}
+
+// detachTransactor copies the Transactor (its Builder and request included)
+// so a controller run on its own goroutine doesn't share it with the rest of
+// the request.
+func detachTransactor(
+    transactor *Transactor, ctx context.Context,
+) *Transactor {
+    detached := *transactor
+    detached.Builder = transactor.Builder.Clone()
+    detached.ChangeContext(ctx)
+
+    return &detached
+}
+
+// deadlineMiddleware gives the controller a context with a deadline from the
+// route's timeout or the requestor's deadline header, whichever is sooner.
+// The controller is run on its own goroutine, with its own copy of the
+// Transactor, so the timeout error is responded with as soon as the deadline
+// passes, even if the controller doesn't watch its context. Whatever the
+// controller returns after the deadline is discarded and any panic is only
+// logged. Upgrade requests (ex: WebSockets) are run inline, and only
+// answered for once the controller returns, since a late hijack would race
+// the timeout response.
+func (self Server) deadlineMiddleware(timeout time.Duration) Middleware {
+    return func(next RouteControllerCaller) RouteControllerCaller {
+        return func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            now := time.Now()
+            var deadline time.Time
+            if timeout > 0 {
+                deadline = now.Add(timeout)
+            }
+            request := &transactor.Request.Request
+            logger := transactor.Logger
+            requestorDeadline, ok := requestDeadline(request)
+            fromRequestor := ok &&
+                (deadline.IsZero() || requestorDeadline.Before(deadline))
+            if fromRequestor {
+                deadline = requestorDeadline
+            }
+            if deadline.IsZero() {
+                return next(ctx, transactor)
+            }
+            if !deadline.After(now) {
+                return self.timedOut(ctx, request, logger, fromRequestor)
+            }
+
+            ctx, cancel := context.WithDeadline(ctx, deadline)
+            if !cleanupWithRequest(ctx, cancel) {
+                defer cancel()
+            }
+            if headerHasToken(request.Header, "Connection", "upgrade") {
+                transactor.ChangeContext(ctx)
+                data := next(ctx, transactor)
+                if ctx.Err() == context.DeadlineExceeded {
+                    return self.timedOut(ctx, request, logger, fromRequestor)
+                }
+                return data
+            }
+
+            detached := detachTransactor(transactor, ctx)
+            outcomes := make(chan controllerOutcome, 1)
+            go func() {
+                defer func() {
+                    if rawErr := recover(); rawErr != nil {
+                        outcomes <- controllerOutcome{
+                            panicked: &handlerPanic{
+                                value: rawErr,
+                                stack: debug.Stack(),
+                            },
+                        }
+                    }
+                }()
+
+                outcomes <- controllerOutcome{data: next(ctx, detached)}
+            }()
+
+            // NOTE: A timer is used rather than the context since the
+            //       context is also cancelled when the requestor goes away,
+            //       which isn't a timeout.
+            timer := time.NewTimer(deadline.Sub(now))
+            defer timer.Stop()
+            select {
+            case outcome := <-outcomes:
+                if outcome.panicked != nil {
+                    panic(*outcome.panicked)
+                }
+                if ctx.Err() == context.DeadlineExceeded {
+                    return self.timedOut(ctx, request, logger, fromRequestor)
+                }
+                *transactor = *detached
+                return outcome.data
+            case <-timer.C:
+                finished := startLateController(ctx)
+                go func() {
+                    defer finished()
+                    self.discardLateOutcome(outcomes, request)
+                }()
+                return self.timedOut(ctx, request, logger, fromRequestor)
+            }
+        }
+    }
+}
+
+// discardLateOutcome waits for a controller that's past its deadline to
+// finish, logging it if it panicked.
+func (self Server) discardLateOutcome(
+    outcomes <-chan controllerOutcome, request *http.Request,
+) {
+    outcome := <-outcomes
+    if outcome.panicked == nil {
+        return
+    }
+
+    self.Logger.Exception(
+        errors.New(fmt.Sprintf("%+v", outcome.panicked.value)),
+        "Panic in controller after its deadline.",
+        logging.Extras{
+            "path": request.URL.Path,
+            "method": request.Method,
+            "stack": string(outcome.panicked.stack),
+        },
+    )
+}
@@ -0,0 +1,295 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+func TestServerTimeoutsFromConfig(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server, err := NewServer(AddCustomLogger(setupLogging(t, g)))
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(server.internalServer.ReadHeaderTimeout).To(
+        gm.Equal(DefaultReadHeaderTimeout),
+    )
+    g.Expect(server.internalServer.IdleTimeout).To(
+        gm.Equal(DefaultIdleTimeout),
+    )
+    g.Expect(server.internalServer.ReadTimeout).To(gm.BeZero())
+    g.Expect(server.internalServer.WriteTimeout).To(gm.BeZero())
+
+    config := newConfig()
+    config.Timeouts.ReadSeconds = 30
+    config.Timeouts.WriteSeconds = 60
+    config.Timeouts.IdleSeconds = -1
+    server, err = NewServer(
+        AddCustomLogger(setupLogging(t, g)), AddConfig(config),
+    )
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(server.internalServer.ReadTimeout).To(
+        gm.Equal(30 * time.Second),
+    )
+    g.Expect(server.internalServer.WriteTimeout).To(gm.Equal(time.Minute))
+    g.Expect(server.internalServer.IdleTimeout).To(gm.BeZero())
+}
+
+func newTimeoutServer(t *testing.T, g *gm.GomegaWithT) *Server {
+    config := newConfig()
+    config.Timeouts.HandlerSeconds = 60
+
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)), AddConfig(config),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    waitForDeadline := func(
+        ctx context.Context, transactor *Transactor,
+    ) responses.Data {
+        <-ctx.Done()
+        return transactor.Respond(http.StatusNoContent)
+    }
+    err = server.AddController(
+        "/slow",
+        FuncHandler("get", waitForDeadline),
+        WithTimeout(20 * time.Millisecond),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/ignoring",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            time.Sleep(time.Second)
+            return transactor.Respond(http.StatusNoContent)
+        }),
+        WithTimeout(20 * time.Millisecond),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/panicking",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            panic("Expected panic!")
+        }),
+        WithTimeout(time.Second),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/deadline",
+        FuncHandler("get", func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            deadline, ok := ctx.Deadline()
+            return transactor.Respond(
+                http.StatusOK,
+                responses.Body(map[string]interface{}{
+                    "has_deadline": ok,
+                    "deadline": deadline.UTC().Format(time.RFC3339),
+                }),
+            )
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    err = server.AddController(
+        "/unbounded",
+        FuncHandler("get", func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            _, ok := ctx.Deadline()
+            g.Expect(ok).To(gm.BeFalse())
+            return transactor.Respond(http.StatusNoContent)
+        }),
+        WithTimeout(0),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return server
+}
+
+func TestRouteTimeout(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newTimeoutServer(t, g)
+
+    rr := authRequest(server, "/slow", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.HandlerTimeoutError.Code()),
+    )
+
+    // Controllers that ignore their context are answered for at the
+    // deadline.
+    start := time.Now()
+    rr = authRequest(server, "/ignoring", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(time.Since(start)).To(
+        gm.BeNumerically("<", 500 * time.Millisecond),
+    )
+    g.Expect(rr.Header().Get(SequenceIdHeader)).ToNot(gm.BeEmpty())
+
+    rr = authRequest(server, "/panicking", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusInternalServerError))
+    g.Expect(jwtErrorCode(g, rr)).To(gm.Equal(neterr.PanicError.Code()))
+
+    rr = authRequest(server, "/unbounded", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+
+    // The server's handler timeout applies to every other route.
+    rr = authRequest(server, "/deadline", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(gm.ContainSubstring(`"has_deadline":true`))
+}
+
+func TestRequestDeadlineHeader(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newTimeoutServer(t, g)
+
+    deadline := time.Now().Add(10 * time.Second).UTC().Format(time.RFC3339)
+    rr := authRequest(server, "/deadline", map[string]string{
+        RequestDeadlineHeader: deadline,
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusOK))
+    g.Expect(rr.Body.String()).To(
+        gm.ContainSubstring(`"deadline":"` + deadline + `"`),
+    )
+
+    // A later deadline can't loosen the route's timeout.
+    rr = authRequest(server, "/slow", map[string]string{
+        RequestDeadlineHeader: time.Now().Add(time.Hour).Format(
+            time.RFC3339,
+        ),
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+
+    rr = authRequest(server, "/slow", map[string]string{
+        RequestDeadlineHeader: time.Now().Add(5 * time.Millisecond).Format(
+            time.RFC3339Nano,
+        ),
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusGatewayTimeout))
+
+    rr = authRequest(server, "/unbounded", map[string]string{
+        RequestDeadlineHeader: "tomorrow",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+
+    rr = authRequest(server, "/deadline", map[string]string{
+        RequestDeadlineHeader: time.Now().Add(-time.Second).Format(
+            time.RFC3339Nano,
+        ),
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusGatewayTimeout))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.DeadlineExceededError.Code()),
+    )
+}
+
+func TestRouteTimeoutLateController(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    limiter := NewConcurrencyLimiter(1)
+    lateHeaders := make(chan string, 1)
+    server, err := NewServer(
+        AddCustomLogger(setupLogging(t, g)),
+        AddMiddleware(limiter.Middleware()),
+        AddFinallyMiddleware(func(
+            _ *http.Request, transactor *Transactor, _ responses.Data, _ error,
+        ) {
+            if transactor != nil {
+                lateHeaders <- transactor.Builder.Header("X-Late")
+            }
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+    release := make(chan struct{})
+    finished := make(chan struct{})
+    err = server.AddController(
+        "/late",
+        FuncHandler("get", func(transactor *Transactor) responses.Data {
+            <-release
+            transactor.Builder.SetHeader("X-Late", "true")
+            transactor.ChangeContext(context.Background())
+            close(finished)
+            return transactor.Respond(http.StatusNoContent)
+        }),
+        WithTimeout(20 * time.Millisecond),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    rr := authRequest(server, "/late", nil)
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(<-lateHeaders).To(gm.BeEmpty())
+
+    // The controller keeps its slot until it actually returns.
+    g.Expect(limiter.InFlight()).To(gm.Equal(1))
+    rr = authRequest(server, "/late", nil)
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.ServiceOverloadedError.Code()),
+    )
+
+    close(release)
+    <-finished
+    g.Eventually(limiter.InFlight).Should(gm.Equal(0))
+}
+
+func TestRouteTimeoutRequestorGone(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newTimeoutServer(t, g)
+    err := server.AddController(
+        "/cancellable",
+        FuncHandler("get", func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            <-ctx.Done()
+            g.Expect(ctx.Err()).To(gm.Equal(context.Canceled))
+            return transactor.Respond(http.StatusNoContent)
+        }),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    req, err := http.NewRequest("GET", "/cancellable", nil)
+    g.Expect(err).To(gm.BeNil())
+    rr := httptest.NewRecorder()
+    server.muxer.ServeHTTP(rr, req.WithContext(ctx))
+
+    g.Expect(rr.Code).To(gm.Equal(http.StatusNoContent))
+}
+
+func TestRouteTimeoutUpgradeInline(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    server := newTimeoutServer(t, g)
+    err := server.AddController(
+        "/upgrade",
+        FuncHandler("get", func(
+            ctx context.Context, transactor *Transactor,
+        ) responses.Data {
+            <-ctx.Done()
+            time.Sleep(20 * time.Millisecond)
+            return transactor.Respond(http.StatusNoContent)
+        }),
+        WithTimeout(20 * time.Millisecond),
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    // Upgrade requests aren't answered for at the deadline so a late hijack
+    // can't race the timeout response.
+    rr := authRequest(server, "/upgrade", map[string]string{
+        "Connection": "Upgrade",
+        "Upgrade": "websocket",
+    })
+    g.Expect(rr.Code).To(gm.Equal(http.StatusServiceUnavailable))
+    g.Expect(jwtErrorCode(g, rr)).To(
+        gm.Equal(neterr.HandlerTimeoutError.Code()),
+    )
+}
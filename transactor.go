@@ -5,7 +5,6 @@ import (
     "context"
     "encoding/json"
     "fmt"
-    "io"
     "io/ioutil"
     "net/http"
 
@@ -28,6 +27,20 @@ type Transactor struct {
     Config *Config
     Logger *logging.Logger
     Request *InboundRequest
+
+    defaultEncoding responses.EncodingType
+    requestBody []byte
+    requestBodyRead bool
+}
+
+// unsupportedMediaTypeError is the root error returned by Bind when the
+// request's Content-Type has no decoder.
+var unsupportedMediaTypeError = errors.New("Unsupported media type")
+
+// IsUnsupportedMediaType checks if an error from Bind was caused by the
+// request's Content-Type not having a registered decoder.
+func IsUnsupportedMediaType(err error) bool {
+    return errors.Cause(err) == unsupportedMediaTypeError
 }
 
 func marshalItem(item interface{}) (string, error) {
@@ -98,23 +111,79 @@ func (t *Transactor) SetHeader(key string, content interface{}) error {
     return nil
 }
 
-// RequestBodyString return the request body as a string.
-func (i *Transactor) RequestBodyString() (string, error) {
-    // TODO: Check performance on this, maybe read and store the data as a
-    // string and act upon that string instead of duplicating the read every
-    // time.
-    var buf bytes.Buffer
+// RequestBody returns the raw request body. The body is only read once and is
+// put back on the request afterwards so it can be re-read.
+func (self *Transactor) RequestBody() ([]byte, error) {
+    if self.requestBodyRead {
+        self.resetRequestBody()
+        return self.requestBody, nil
+    }
+
+    if self.Request.Body != nil {
+        body, err := ioutil.ReadAll(self.Request.Body)
+        if err != nil {
+            return nil, errors.Wrap(err, "Error while reading request body")
+        }
+        err = self.Request.Body.Close()
+        if err != nil {
+            return nil, errors.Wrap(err, "Error while closing request body")
+        }
+        self.requestBody = body
+    }
+    self.requestBodyRead = true
+    self.resetRequestBody()
 
-    req := i.Request
-    bodyReader := req.Body
-    bodyTeeReader := io.TeeReader(bodyReader, &buf)
+    return self.requestBody, nil
+}
 
-    bodyString, err := ioutil.ReadAll(bodyTeeReader)
+func (self *Transactor) resetRequestBody() {
+    self.Request.Body = ioutil.NopCloser(bytes.NewReader(self.requestBody))
+}
+
+// RequestBodyString return the request body as a string.
+func (self *Transactor) RequestBodyString() (string, error) {
+    body, err := self.RequestBody()
     if err != nil {
         return "", err
     }
 
-    return string(bodyString), nil
+    return string(body), nil
+}
+
+// Bind decodes the request body into target using the decoder registered for
+// the request's Content-Type. If the request has no Content-Type the server's
+// default encoding is assumed. Use IsUnsupportedMediaType to check if the
+// Content-Type couldn't be handled.
+func (self *Transactor) Bind(target interface{}) error {
+    var (
+        decoder responses.Decoder
+        ok bool
+    )
+    contentType := self.Request.Header.Get(responses.ContentTypeHeader)
+    if contentType == "" {
+        decoder, ok = responses.DecoderForEncoding(self.defaultEncoding)
+    } else {
+        decoder, ok = responses.DecoderForContentType(contentType)
+    }
+    if !ok {
+        return errors.Wrapf(
+            unsupportedMediaTypeError,
+            "No decoder registered for content type '%s'",
+            contentType,
+        )
+    }
+
+    body, err := self.RequestBody()
+    if err != nil {
+        return err
+    }
+
+    err = decoder.Decode(body, target)
+    if err != nil {
+        return errors.Wrap(err, "Error while decoding request body")
+    }
+
+    return nil
 }
 
 // CopyHeadersFromResponse will copy the headers from a client response into
@@ -145,10 +214,6 @@ func (self Transactor) Abort(
     codedErr neterr.CodedError,
     otherErrors ...neterr.CodedError,
 ) responses.Data {
-    err := self.SetHeader("Content-Type", responses.JSONContentType)
-    if err != nil {
-        return responses.ErrorResponse(err)
-    }
     return self.Builder.Abort(statusCode, codedErr, otherErrors...)
 }
 
@@ -232,6 +297,7 @@ func NewTransactor(
         existingContext,
         encodingType,
         responses.AddHeader(SequenceIdHeader, sequenceId.String()),
+        responses.Negotiate(request.Header.Get("Accept")),
     )
     if err != nil {
         return nil, errors.Wrap(
@@ -248,6 +314,8 @@ func NewTransactor(
         Config: config,
         Request: newRequest,
         Logger: nil,
+
+        defaultEncoding: encodingType,
     }
 
     loggerWithSequenceId, err := logging.CloneLogger(
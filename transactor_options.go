@@ -2,6 +2,7 @@ package vial
 
 import (
     "context"
+    "time"
 )
 
 // TransctorOption is an option for a Transactor.
@@ -16,3 +17,13 @@ func WithExistingContext(existingCtx context.Context) TransactorOption {
         return nil
     }
 }
+
+// WithStreamFlushInterval sets the default interval streamed response bodies
+// are flushed on.
+func WithStreamFlushInterval(interval time.Duration) TransactorOption {
+    return func(transactor *Transactor) error {
+        transactor.Builder.SetFlushInterval(interval)
+
+        return nil
+    }
+}
@@ -0,0 +1,94 @@
+package vial
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+)
+
+type bindTestBody struct {
+    Name string `json:"name" xml:"name" yaml:"name"`
+}
+
+func newBindTransactor(
+    t *testing.T, g *gm.GomegaWithT, contentType, body string,
+) *Transactor {
+    req, err := http.NewRequest("POST", "/bind", strings.NewReader(body))
+    g.Expect(err).To(gm.BeNil())
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    ctx, _ := handleSequenceId(req)
+    ctx = handleRequestId(ctx)
+    req = req.WithContext(ctx)
+
+    transactor, err := NewTransactor(
+        req,
+        httptest.NewRecorder(),
+        PathParams{},
+        newConfig(),
+        setupLogging(t, g),
+        responses.JSONEncoding,
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    return transactor
+}
+
+func TestTransactorBind(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    cases := map[string]string{
+        "application/json": `{"name":"json"}`,
+        "application/xml": `<body><name>xml</name></body>`,
+        "application/yaml": "name: yaml\n",
+        "": `{"name":"default"}`,
+    }
+    for contentType, body := range cases {
+        transactor := newBindTransactor(t, g, contentType, body)
+
+        var bound bindTestBody
+        err := transactor.Bind(&bound)
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(bound.Name).ToNot(gm.BeEmpty())
+
+        bodyString, err := transactor.RequestBodyString()
+        g.Expect(err).To(gm.BeNil())
+        g.Expect(bodyString).To(gm.Equal(body))
+    }
+}
+
+func TestTransactorBindUnsupported(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    transactor := newBindTransactor(t, g, "image/png", "")
+
+    var bound bindTestBody
+    err := transactor.Bind(&bound)
+    g.Expect(err).To(gm.HaveOccurred())
+    g.Expect(IsUnsupportedMediaType(err)).To(gm.BeTrue())
+}
+
+func TestTransactorRequestBodyRereadable(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    transactor := newBindTransactor(t, g, "text/plain", "hello")
+    transactor.ChangeContext(context.WithValue(
+        transactor.Context(), "foo", "bar",
+    ))
+
+    var bound string
+    err := transactor.Bind(&bound)
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(bound).To(gm.Equal("hello"))
+
+    body, err := transactor.RequestBody()
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(string(body)).To(gm.Equal("hello"))
+}
@@ -0,0 +1,267 @@
+package vial
+
+import (
+    "context"
+    "crypto/sha1" //#nosec G505
+    "encoding/base64"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/daihasso/slogging"
+    "github.com/pkg/errors"
+
+    "github.com/daihasso/vial/neterr"
+    "github.com/daihasso/vial/responses"
+)
+
+// webSocketGUID is the GUID RFC 6455 uses to derive the accept key.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketVersion is the only version of the WebSocket protocol supported.
+const webSocketVersion = "13"
+
+// DefaultWebSocketMaxMessageSize is the largest message that will be read
+// from a WebSocket unless WithMaxMessageSize is provided.
+var DefaultWebSocketMaxMessageSize int64 = 1 << 20
+
+// WebSocketHandlerFunc handles an upgraded WebSocket connection. The
+// connection is closed once it returns.
+type WebSocketHandlerFunc func(*WebSocketConn) error
+
+type webSocketOptions struct {
+    maxMessageSize int64
+    pingInterval time.Duration
+    subprotocols []string
+    checkOrigin func(*http.Request) bool
+}
+
+// WebSocketOption is an option for a WebSocket upgrade.
+type WebSocketOption func(*webSocketOptions)
+
+// WithMaxMessageSize sets the largest message (in bytes) that will be read
+// from the peer. Larger messages close the connection with
+// WebSocketCloseMessageTooBig.
+func WithMaxMessageSize(size int64) WebSocketOption {
+    return func(options *webSocketOptions) {
+        options.maxMessageSize = size
+    }
+}
+
+// WithPingInterval sends a ping to the peer on the provided interval to keep
+// the connection alive.
+func WithPingInterval(interval time.Duration) WebSocketOption {
+    return func(options *webSocketOptions) {
+        options.pingInterval = interval
+    }
+}
+
+// WithSubprotocols sets the subprotocols the server supports in order of
+// preference. The first one the requestor also supports is selected.
+func WithSubprotocols(subprotocols ...string) WebSocketOption {
+    return func(options *webSocketOptions) {
+        options.subprotocols = subprotocols
+    }
+}
+
+// WithOriginCheck replaces the default origin check which only allows
+// requests without an Origin header or from the same host.
+func WithOriginCheck(checkOrigin func(*http.Request) bool) WebSocketOption {
+    return func(options *webSocketOptions) {
+        options.checkOrigin = checkOrigin
+    }
+}
+
+func sameOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    originUrl, err := url.Parse(origin)
+    if err != nil {
+        return false
+    }
+
+    return strings.EqualFold(originUrl.Host, r.Host)
+}
+
+// headerHasToken checks if any of the comma separated values of a header
+// match the token.
+func headerHasToken(header http.Header, key, token string) bool {
+    for _, value := range header[http.CanonicalHeaderKey(key)] {
+        for _, part := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(part), token) {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+func webSocketAcceptKey(key string) string {
+    hash := sha1.New() //#nosec G401
+    hash.Write([]byte(key + webSocketGUID))
+    return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+func selectSubprotocol(r *http.Request, supported []string) string {
+    var requested []string
+    for _, value := range r.Header["Sec-Websocket-Protocol"] {
+        for _, part := range strings.Split(value, ",") {
+            requested = append(requested, strings.TrimSpace(part))
+        }
+    }
+    for _, subprotocol := range supported {
+        for _, candidate := range requested {
+            if candidate == subprotocol {
+                return subprotocol
+            }
+        }
+    }
+
+    return ""
+}
+
+// UpgradeWebSocket upgrades the request to a WebSocket and hands the
+// connection to the handler. If the request isn't a valid WebSocket upgrade
+// a failure response is returned instead.
+func (self *Transactor) UpgradeWebSocket(
+    handler WebSocketHandlerFunc, options ...WebSocketOption,
+) responses.Data {
+    wsOptions := &webSocketOptions{
+        maxMessageSize: DefaultWebSocketMaxMessageSize,
+        checkOrigin: sameOrigin,
+    }
+    for _, option := range options {
+        option(wsOptions)
+    }
+
+    r := &self.Request.Request
+    if r.Method != http.MethodGet ||
+        !headerHasToken(r.Header, "Connection", "upgrade") ||
+        !headerHasToken(r.Header, "Upgrade", "websocket") {
+        return self.Abort(
+            http.StatusBadRequest, neterr.WebSocketUpgradeError,
+        )
+    }
+    if r.Header.Get("Sec-WebSocket-Version") != webSocketVersion {
+        self.Builder.SetHeader("Sec-WebSocket-Version", webSocketVersion)
+        return self.Abort(
+            http.StatusUpgradeRequired, neterr.WebSocketUpgradeError,
+        )
+    }
+    key := r.Header.Get("Sec-WebSocket-Key")
+    decodedKey, err := base64.StdEncoding.DecodeString(key)
+    if err != nil || len(decodedKey) != 16 {
+        return self.Abort(
+            http.StatusBadRequest, neterr.WebSocketUpgradeError,
+        )
+    }
+    if !wsOptions.checkOrigin(r) {
+        return self.Abort(
+            http.StatusForbidden, neterr.WebSocketOriginNotAllowedError,
+        )
+    }
+
+    subprotocol := selectSubprotocol(r, wsOptions.subprotocols)
+    self.Builder.SetHeader("Upgrade", "websocket")
+    self.Builder.SetHeader("Connection", "Upgrade")
+    self.Builder.SetHeader("Sec-WebSocket-Accept", webSocketAcceptKey(key))
+    if subprotocol != "" {
+        self.Builder.SetHeader("Sec-WebSocket-Protocol", subprotocol)
+    }
+
+    return self.Respond(
+        http.StatusSwitchingProtocols,
+        responses.Hijack(func(w http.ResponseWriter) error {
+            return self.serveWebSocket(w, handler, subprotocol, wsOptions)
+        }),
+    )
+}
+
+func (self *Transactor) serveWebSocket(
+    w http.ResponseWriter,
+    handler WebSocketHandlerFunc,
+    subprotocol string,
+    options *webSocketOptions,
+) error {
+    hijacker, ok := w.(http.Hijacker)
+    if !ok {
+        w.WriteHeader(http.StatusInternalServerError)
+        fmt.Fprint(w, "Internal Server Error")
+        return errors.New(
+            "ResponseWriter does not support hijacking the connection",
+        )
+    }
+
+    header := w.Header().Clone()
+    netConn, buffered, err := hijacker.Hijack()
+    if err != nil {
+        return errors.Wrap(err, "Error while hijacking connection")
+    }
+    defer netConn.Close()
+
+    // NOTE: Any deadline set by the server for the request no longer makes
+    //       sense for a long lived connection.
+    err = netConn.SetDeadline(time.Time{})
+    if err != nil {
+        return errors.Wrap(err, "Error while clearing connection deadline")
+    }
+
+    buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+    header.Write(buffered)
+    buffered.WriteString("\r\n")
+    err = buffered.Flush()
+    if err != nil {
+        return errors.Wrap(err, "Error while writing WebSocket handshake")
+    }
+
+    conn := &WebSocketConn{
+        Transactor: self,
+        Logger: self.Logger,
+        Subprotocol: subprotocol,
+        conn: netConn,
+        reader: buffered.Reader,
+        maxMessageSize: options.maxMessageSize,
+    }
+    conn.Logger.Debug("WebSocket connection opened.", logging.Extras{
+        "subprotocol": subprotocol,
+    })
+
+    if options.pingInterval > 0 {
+        done := make(chan struct{})
+        defer close(done)
+        go conn.pingPeriodically(options.pingInterval, done)
+    }
+
+    err = handler(conn)
+    if err != nil && !IsWebSocketCloseError(err) {
+        conn.Logger.Exception(err, "Error in WebSocket handler.")
+        conn.Close(WebSocketCloseInternalError, "")
+    } else {
+        conn.Close(WebSocketCloseNormal, "")
+    }
+    conn.Logger.Debug("WebSocket connection closed.")
+
+    return nil
+}
+
+// WebSocketHandler wraps a WebSocketHandlerFunc so it can be added as a
+// controller. It responds to GET requests by upgrading them; pre-action
+// middleware runs before the upgrade happens.
+func WebSocketHandler(
+    handler WebSocketHandlerFunc, options ...WebSocketOption,
+) methodControllerFunc {
+    caller := func(_ context.Context, transactor *Transactor) responses.Data {
+        return transactor.UpgradeWebSocket(handler, options...)
+    }
+
+    return func() (
+        []RequestMethod, RouteControllerCaller, RouteControllerFunc, error,
+    ) {
+        return []RequestMethod{MethodGET}, caller, handler, nil
+    }
+}
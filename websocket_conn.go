@@ -0,0 +1,428 @@
+package vial
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "net"
+    "sync"
+    "time"
+    "unicode/utf8"
+
+    "github.com/daihasso/slogging"
+    "github.com/google/uuid"
+    "github.com/pkg/errors"
+)
+
+// WebSocketMessageType is the type of a WebSocket data message.
+type WebSocketMessageType int
+
+// The WebSocket data message types.
+const (
+    TextMessage WebSocketMessageType = 1
+    BinaryMessage WebSocketMessageType = 2
+)
+
+// WebSocket frame opcodes as defined by RFC 6455.
+const (
+    wsOpContinuation = 0x0
+    wsOpText = 0x1
+    wsOpBinary = 0x2
+    wsOpClose = 0x8
+    wsOpPing = 0x9
+    wsOpPong = 0xa
+)
+
+// wsMaxControlPayload is the largest payload a control frame may carry.
+const wsMaxControlPayload = 125
+
+// WebSocket close codes as defined by RFC 6455.
+const (
+    WebSocketCloseNormal = 1000
+    WebSocketCloseGoingAway = 1001
+    WebSocketCloseProtocolError = 1002
+    WebSocketCloseUnsupportedData = 1003
+    WebSocketCloseNoStatus = 1005
+    WebSocketCloseAbnormal = 1006
+    WebSocketCloseInvalidPayload = 1007
+    WebSocketClosePolicyViolation = 1008
+    WebSocketCloseMessageTooBig = 1009
+    WebSocketCloseMandatoryExtension = 1010
+    WebSocketCloseInternalError = 1011
+)
+
+// ErrWebSocketClosed is returned when writing to a WebSocket after a close
+// frame has been sent.
+var ErrWebSocketClosed = errors.New("WebSocket connection is closed")
+
+// WebSocketCloseError is returned by ReadMessage once the connection has been
+// closed either by the peer or because the peer violated the protocol.
+type WebSocketCloseError struct {
+    Code int
+    Reason string
+}
+
+func (self *WebSocketCloseError) Error() string {
+    return fmt.Sprintf(
+        "WebSocket closed with code %d: %s", self.Code, self.Reason,
+    )
+}
+
+// IsWebSocketCloseError checks if the error is a WebSocketCloseError with
+// one of the provided codes or any code if none are provided.
+func IsWebSocketCloseError(err error, codes ...int) bool {
+    closeErr, ok := errors.Cause(err).(*WebSocketCloseError)
+    if !ok {
+        return false
+    }
+    if len(codes) == 0 {
+        return true
+    }
+    for _, code := range codes {
+        if closeErr.Code == code {
+            return true
+        }
+    }
+
+    return false
+}
+
+// validReceivedCloseCode checks if a close code is allowed to be sent over
+// the wire.
+func validReceivedCloseCode(code int) bool {
+    switch {
+    case code >= 1000 && code <= 1003:
+        return true
+    case code >= 1007 && code <= 1011:
+        return true
+    case code >= 3000 && code <= 4999:
+        return true
+    }
+
+    return false
+}
+
+// WebSocketConn is an upgraded WebSocket connection. The Transactor for the
+// request that was upgraded is carried along so its context, sequence ID and
+// logger can be used for the lifetime of the connection.
+type WebSocketConn struct {
+    Transactor *Transactor
+    Logger *logging.Logger
+    Subprotocol string
+
+    conn net.Conn
+    reader *bufio.Reader
+    maxMessageSize int64
+
+    writeLock sync.Mutex
+    closeSent bool
+}
+
+// Context returns the context of the request that was upgraded.
+func (self *WebSocketConn) Context() context.Context {
+    return self.Transactor.Context()
+}
+
+// SequenceId returns the Sequence ID of the request that was upgraded.
+func (self *WebSocketConn) SequenceId() *uuid.UUID {
+    return self.Transactor.SequenceId()
+}
+
+// RemoteAddr returns the address of the peer.
+func (self *WebSocketConn) RemoteAddr() net.Addr {
+    return self.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline for the next read from the peer.
+func (self *WebSocketConn) SetReadDeadline(t time.Time) error {
+    return self.conn.SetReadDeadline(t)
+}
+
+// wsFrame is a single frame read from the peer.
+type wsFrame struct {
+    fin bool
+    opcode byte
+    payload []byte
+}
+
+// readFrame reads the next frame. The remaining argument is how many more
+// bytes the message currently being read may hold.
+func (self *WebSocketConn) readFrame(remaining int64) (*wsFrame, error) {
+    var header [2]byte
+    if _, err := io.ReadFull(self.reader, header[:]); err != nil {
+        return nil, err
+    }
+
+    frame := &wsFrame{
+        fin: header[0]&0x80 != 0,
+        opcode: header[0]&0x0f,
+    }
+    masked := header[1]&0x80 != 0
+    length := uint64(header[1]&0x7f)
+
+    if header[0]&0x70 != 0 {
+        return nil, self.fail(
+            WebSocketCloseProtocolError, "Reserved bits must not be set",
+        )
+    }
+    if !masked {
+        return nil, self.fail(
+            WebSocketCloseProtocolError, "Client frames must be masked",
+        )
+    }
+
+    switch length {
+    case 126:
+        var extended [2]byte
+        if _, err := io.ReadFull(self.reader, extended[:]); err != nil {
+            return nil, err
+        }
+        length = uint64(binary.BigEndian.Uint16(extended[:]))
+    case 127:
+        var extended [8]byte
+        if _, err := io.ReadFull(self.reader, extended[:]); err != nil {
+            return nil, err
+        }
+        length = binary.BigEndian.Uint64(extended[:])
+        if length&(1<<63) != 0 {
+            return nil, self.fail(
+                WebSocketCloseProtocolError, "Invalid payload length",
+            )
+        }
+    }
+
+    if frame.opcode >= wsOpClose {
+        if !frame.fin || length > wsMaxControlPayload {
+            return nil, self.fail(
+                WebSocketCloseProtocolError,
+                "Control frames must not be fragmented or exceed 125 bytes",
+            )
+        }
+    } else if length > uint64(remaining) {
+        return nil, self.fail(
+            WebSocketCloseMessageTooBig, "Message exceeds the size limit",
+        )
+    }
+
+    var mask [4]byte
+    if _, err := io.ReadFull(self.reader, mask[:]); err != nil {
+        return nil, err
+    }
+    frame.payload = make([]byte, length)
+    if _, err := io.ReadFull(self.reader, frame.payload); err != nil {
+        return nil, err
+    }
+    for i := range frame.payload {
+        frame.payload[i] ^= mask[i%4]
+    }
+
+    return frame, nil
+}
+
+// ReadMessage reads the next data message from the peer. Pings are answered
+// and pongs are discarded automatically while reading. Once the peer closes
+// the connection (or violates the protocol) a *WebSocketCloseError is
+// returned.
+func (self *WebSocketConn) ReadMessage() (
+    WebSocketMessageType, []byte, error,
+) {
+    var (
+        messageType WebSocketMessageType
+        message []byte
+        inMessage bool
+    )
+    for {
+        frame, err := self.readFrame(
+            self.maxMessageSize - int64(len(message)),
+        )
+        if err != nil {
+            return 0, nil, err
+        }
+
+        switch frame.opcode {
+        case wsOpPing:
+            err = self.writeFrame(wsOpPong, frame.payload)
+            if err != nil && err != ErrWebSocketClosed {
+                return 0, nil, err
+            }
+            continue
+        case wsOpPong:
+            continue
+        case wsOpClose:
+            return 0, nil, self.handleClose(frame.payload)
+        case wsOpText, wsOpBinary:
+            if inMessage {
+                return 0, nil, self.fail(
+                    WebSocketCloseProtocolError,
+                    "Expected a continuation frame",
+                )
+            }
+            inMessage = true
+            messageType = WebSocketMessageType(frame.opcode)
+        case wsOpContinuation:
+            if !inMessage {
+                return 0, nil, self.fail(
+                    WebSocketCloseProtocolError,
+                    "Unexpected continuation frame",
+                )
+            }
+        default:
+            return 0, nil, self.fail(
+                WebSocketCloseProtocolError,
+                fmt.Sprintf("Unknown opcode %d", frame.opcode),
+            )
+        }
+
+        message = append(message, frame.payload...)
+        if !frame.fin {
+            continue
+        }
+        if messageType == TextMessage && !utf8.Valid(message) {
+            return 0, nil, self.fail(
+                WebSocketCloseInvalidPayload,
+                "Text messages must be valid UTF-8",
+            )
+        }
+
+        return messageType, message, nil
+    }
+}
+
+// handleClose answers a close frame from the peer and reports it as a
+// WebSocketCloseError.
+func (self *WebSocketConn) handleClose(payload []byte) error {
+    if len(payload) == 0 {
+        self.Close(WebSocketCloseNormal, "")
+        return &WebSocketCloseError{Code: WebSocketCloseNoStatus}
+    }
+
+    if len(payload) < 2 {
+        return self.fail(
+            WebSocketCloseProtocolError, "Invalid close frame",
+        )
+    }
+    code := int(binary.BigEndian.Uint16(payload))
+    reason := payload[2:]
+    if !validReceivedCloseCode(code) || !utf8.Valid(reason) {
+        return self.fail(
+            WebSocketCloseProtocolError, "Invalid close frame",
+        )
+    }
+
+    self.Close(code, "")
+    return &WebSocketCloseError{Code: code, Reason: string(reason)}
+}
+
+// fail closes the connection because of a protocol violation.
+func (self *WebSocketConn) fail(code int, reason string) error {
+    self.Logger.Debug("Failing WebSocket connection.", logging.Extras{
+        "code": code,
+        "reason": reason,
+    })
+    self.Close(code, reason)
+
+    return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+func (self *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+    self.writeLock.Lock()
+    defer self.writeLock.Unlock()
+
+    if self.closeSent {
+        return ErrWebSocketClosed
+    }
+    if opcode == wsOpClose {
+        self.closeSent = true
+    }
+
+    frame := make([]byte, 0, len(payload)+10)
+    frame = append(frame, 0x80|opcode)
+    switch length := len(payload); {
+    case length <= wsMaxControlPayload:
+        frame = append(frame, byte(length))
+    case length <= 0xffff:
+        frame = append(frame, 126, byte(length>>8), byte(length))
+    default:
+        var extended [8]byte
+        binary.BigEndian.PutUint64(extended[:], uint64(length))
+        frame = append(frame, 127)
+        frame = append(frame, extended[:]...)
+    }
+    frame = append(frame, payload...)
+
+    _, err := self.conn.Write(frame)
+    if err != nil {
+        return errors.Wrap(err, "Error while writing WebSocket frame")
+    }
+
+    return nil
+}
+
+// WriteMessage sends a data message to the peer.
+func (self *WebSocketConn) WriteMessage(
+    messageType WebSocketMessageType, data []byte,
+) error {
+    if messageType != TextMessage && messageType != BinaryMessage {
+        return errors.Errorf("Unknown WebSocket message type %d", messageType)
+    }
+
+    return self.writeFrame(byte(messageType), data)
+}
+
+// WriteText sends a text message to the peer.
+func (self *WebSocketConn) WriteText(text string) error {
+    return self.WriteMessage(TextMessage, []byte(text))
+}
+
+// Ping sends a ping to the peer. The payload may be at most 125 bytes.
+func (self *WebSocketConn) Ping(payload []byte) error {
+    if len(payload) > wsMaxControlPayload {
+        return errors.New("Ping payload must not exceed 125 bytes")
+    }
+
+    return self.writeFrame(wsOpPing, payload)
+}
+
+// Close sends a close frame with the provided code and reason to the peer.
+// No more messages can be written afterwards; the connection itself is closed
+// once the handler returns.
+func (self *WebSocketConn) Close(code int, reason string) error {
+    // NOTE: The code takes 2 of the 125 bytes a control frame can carry.
+    if len(reason) > wsMaxControlPayload-2 {
+        cut := wsMaxControlPayload - 2
+        for cut > 0 && !utf8.RuneStart(reason[cut]) {
+            cut--
+        }
+        reason = reason[:cut]
+    }
+    payload := make([]byte, 2, 2+len(reason))
+    binary.BigEndian.PutUint16(payload, uint16(code))
+    payload = append(payload, reason...)
+
+    err := self.writeFrame(wsOpClose, payload)
+    if err == ErrWebSocketClosed {
+        return nil
+    }
+
+    return err
+}
+
+func (self *WebSocketConn) pingPeriodically(
+    interval time.Duration, done chan struct{},
+) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            if err := self.Ping(nil); err != nil {
+                return
+            }
+        case <-done:
+            return
+        }
+    }
+}
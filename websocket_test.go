@@ -0,0 +1,301 @@
+package vial
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    gm "github.com/onsi/gomega"
+
+    "github.com/daihasso/vial/responses"
+    "github.com/daihasso/vial/neterr"
+)
+
+var testWebSocketKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func newWebSocketTestServer(
+    t *testing.T,
+    g *gm.GomegaWithT,
+    handler WebSocketHandlerFunc,
+    serverOptions []ServerOption,
+    options ...WebSocketOption,
+) *httptest.Server {
+    serverOptions = append(
+        []ServerOption{AddCustomLogger(setupLogging(t, g))},
+        serverOptions...,
+    )
+    server, err := NewServer(serverOptions...)
+    g.Expect(err).To(gm.BeNil())
+
+    err = server.AddController("/ws", WebSocketHandler(handler, options...))
+    g.Expect(err).To(gm.BeNil())
+
+    return httptest.NewServer(server.muxer)
+}
+
+func dialWebSocket(
+    g *gm.GomegaWithT, testServer *httptest.Server, extraHeaders string,
+) (net.Conn, *bufio.Reader, *http.Response) {
+    conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+    g.Expect(err).To(gm.BeNil())
+
+    _, err = io.WriteString(
+        conn,
+        "GET /ws HTTP/1.1\r\n" +
+            "Host: " + testServer.Listener.Addr().String() + "\r\n" +
+            "Upgrade: websocket\r\n" +
+            "Connection: Upgrade\r\n" +
+            "Sec-WebSocket-Key: " + testWebSocketKey + "\r\n" +
+            extraHeaders +
+            "\r\n",
+    )
+    g.Expect(err).To(gm.BeNil())
+
+    reader := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(reader, nil)
+    g.Expect(err).To(gm.BeNil())
+
+    return conn, reader, resp
+}
+
+func writeClientFrame(
+    g *gm.GomegaWithT, conn net.Conn, fin bool, opcode byte, payload []byte,
+) {
+    first := opcode
+    if fin {
+        first |= 0x80
+    }
+    frame := []byte{first}
+    if len(payload) <= 125 {
+        frame = append(frame, 0x80|byte(len(payload)))
+    } else {
+        frame = append(frame, 0x80|126, 0, 0)
+        binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+    }
+    mask := []byte{1, 2, 3, 4}
+    frame = append(frame, mask...)
+    for i, b := range payload {
+        frame = append(frame, b^mask[i%4])
+    }
+
+    _, err := conn.Write(frame)
+    g.Expect(err).To(gm.BeNil())
+}
+
+func readServerFrame(
+    g *gm.GomegaWithT, reader *bufio.Reader,
+) (byte, []byte) {
+    var header [2]byte
+    _, err := io.ReadFull(reader, header[:])
+    g.Expect(err).To(gm.BeNil())
+    g.Expect(header[1] & 0x80).To(gm.BeZero())
+
+    length := int(header[1] & 0x7f)
+    if length == 126 {
+        var extended [2]byte
+        _, err = io.ReadFull(reader, extended[:])
+        g.Expect(err).To(gm.BeNil())
+        length = int(binary.BigEndian.Uint16(extended[:]))
+    }
+    payload := make([]byte, length)
+    _, err = io.ReadFull(reader, payload)
+    g.Expect(err).To(gm.BeNil())
+
+    return header[0] & 0x0f, payload
+}
+
+func echoWebSocket(conn *WebSocketConn) error {
+    for {
+        messageType, message, err := conn.ReadMessage()
+        if err != nil {
+            return err
+        }
+        err = conn.WriteMessage(messageType, message)
+        if err != nil {
+            return err
+        }
+    }
+}
+
+func TestWebSocketEcho(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    sequenceIds := make(chan string, 1)
+    testServer := newWebSocketTestServer(
+        t,
+        g,
+        func(conn *WebSocketConn) error {
+            sequenceIds <- conn.SequenceId().String()
+            return echoWebSocket(conn)
+        },
+        nil,
+        WithSubprotocols("chat"),
+    )
+    defer testServer.Close()
+
+    conn, reader, resp := dialWebSocket(
+        g,
+        testServer,
+        "Sec-WebSocket-Version: 13\r\n" +
+            "Sec-WebSocket-Protocol: other, chat\r\n",
+    )
+    defer conn.Close()
+
+    g.Expect(resp.StatusCode).To(gm.Equal(http.StatusSwitchingProtocols))
+    g.Expect(resp.Header.Get("Sec-WebSocket-Accept")).To(
+        gm.Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="),
+    )
+    g.Expect(resp.Header.Get("Sec-WebSocket-Protocol")).To(gm.Equal("chat"))
+    g.Expect(resp.Header.Get(SequenceIdHeader)).ToNot(gm.BeEmpty())
+
+    // A fragmented text message with a ping in between.
+    writeClientFrame(g, conn, false, wsOpText, []byte("hel"))
+    writeClientFrame(g, conn, true, wsOpPing, []byte("are you there"))
+    writeClientFrame(g, conn, true, wsOpContinuation, []byte("lo"))
+
+    opcode, payload := readServerFrame(g, reader)
+    g.Expect(opcode).To(gm.Equal(byte(wsOpPong)))
+    g.Expect(string(payload)).To(gm.Equal("are you there"))
+
+    opcode, payload = readServerFrame(g, reader)
+    g.Expect(opcode).To(gm.Equal(byte(wsOpText)))
+    g.Expect(string(payload)).To(gm.Equal("hello"))
+
+    binaryMessage := []byte(strings.Repeat("b", 300))
+    writeClientFrame(g, conn, true, wsOpBinary, binaryMessage)
+    opcode, payload = readServerFrame(g, reader)
+    g.Expect(opcode).To(gm.Equal(byte(wsOpBinary)))
+    g.Expect(payload).To(gm.Equal(binaryMessage))
+
+    writeClientFrame(g, conn, true, wsOpClose, []byte{0x03, 0xe9})
+    opcode, payload = readServerFrame(g, reader)
+    g.Expect(opcode).To(gm.Equal(byte(wsOpClose)))
+    g.Expect(binary.BigEndian.Uint16(payload)).To(
+        gm.Equal(uint16(WebSocketCloseGoingAway)),
+    )
+    g.Expect(<-sequenceIds).To(gm.Equal(resp.Header.Get(SequenceIdHeader)))
+}
+
+func TestWebSocketMessageTooBig(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    handlerErrs := make(chan error, 1)
+    testServer := newWebSocketTestServer(
+        t,
+        g,
+        func(conn *WebSocketConn) error {
+            _, _, err := conn.ReadMessage()
+            handlerErrs <- err
+            return err
+        },
+        nil,
+        WithMaxMessageSize(4),
+    )
+    defer testServer.Close()
+
+    conn, reader, resp := dialWebSocket(
+        g, testServer, "Sec-WebSocket-Version: 13\r\n",
+    )
+    defer conn.Close()
+    g.Expect(resp.StatusCode).To(gm.Equal(http.StatusSwitchingProtocols))
+
+    writeClientFrame(g, conn, false, wsOpText, []byte("abc"))
+    writeClientFrame(g, conn, true, wsOpContinuation, []byte("def"))
+
+    opcode, payload := readServerFrame(g, reader)
+    g.Expect(opcode).To(gm.Equal(byte(wsOpClose)))
+    g.Expect(binary.BigEndian.Uint16(payload)).To(
+        gm.Equal(uint16(WebSocketCloseMessageTooBig)),
+    )
+    g.Expect(IsWebSocketCloseError(
+        <-handlerErrs, WebSocketCloseMessageTooBig,
+    )).To(gm.BeTrue())
+}
+
+func TestWebSocketUnmaskedFrame(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    testServer := newWebSocketTestServer(t, g, echoWebSocket, nil)
+    defer testServer.Close()
+
+    conn, reader, _ := dialWebSocket(
+        g, testServer, "Sec-WebSocket-Version: 13\r\n",
+    )
+    defer conn.Close()
+
+    _, err := conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+    g.Expect(err).To(gm.BeNil())
+
+    opcode, payload := readServerFrame(g, reader)
+    g.Expect(opcode).To(gm.Equal(byte(wsOpClose)))
+    g.Expect(binary.BigEndian.Uint16(payload)).To(
+        gm.Equal(uint16(WebSocketCloseProtocolError)),
+    )
+}
+
+func TestWebSocketBadHandshake(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    testServer := newWebSocketTestServer(t, g, echoWebSocket, nil)
+    defer testServer.Close()
+
+    conn, _, resp := dialWebSocket(
+        g, testServer, "Sec-WebSocket-Version: 8\r\n",
+    )
+    conn.Close()
+    g.Expect(resp.StatusCode).To(gm.Equal(http.StatusUpgradeRequired))
+    g.Expect(resp.Header.Get("Sec-WebSocket-Version")).To(gm.Equal("13"))
+
+    conn, _, resp = dialWebSocket(
+        g,
+        testServer,
+        "Sec-WebSocket-Version: 13\r\nOrigin: http://evil.example\r\n",
+    )
+    conn.Close()
+    g.Expect(resp.StatusCode).To(gm.Equal(http.StatusForbidden))
+
+    resp, err := http.Get(testServer.URL + "/ws")
+    g.Expect(err).To(gm.BeNil())
+    resp.Body.Close()
+    g.Expect(resp.StatusCode).To(gm.Equal(http.StatusBadRequest))
+}
+
+func TestWebSocketPreActionMiddlewareRunsBeforeUpgrade(t *testing.T) {
+    g := gm.NewGomegaWithT(t)
+
+    handlerCalled := make(chan bool, 1)
+    testServer := newWebSocketTestServer(
+        t,
+        g,
+        func(conn *WebSocketConn) error {
+            handlerCalled <- true
+            return nil
+        },
+        []ServerOption{
+            AddPreActionMiddleware(func(
+                _ context.Context, transactor *Transactor,
+            ) (*responses.Data, *context.Context, error) {
+                data := transactor.Abort(
+                    http.StatusUnauthorized,
+                    neterr.NewCodedError(100, "Not authorized."),
+                )
+                return &data, nil, nil
+            }),
+        },
+    )
+    defer testServer.Close()
+
+    conn, _, resp := dialWebSocket(
+        g, testServer, "Sec-WebSocket-Version: 13\r\n",
+    )
+    conn.Close()
+
+    g.Expect(resp.StatusCode).To(gm.Equal(http.StatusUnauthorized))
+    g.Expect(handlerCalled).To(gm.BeEmpty())
+}